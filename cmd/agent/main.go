@@ -33,11 +33,16 @@ func main() {
 	}
 
 	metricCollection := agent.NewMetricCollector(metricsCount)
-	client, err := selectSenderClient(opts.ClientType, opts.EndpointAddr, "", opts.Key, opts.CryptoKey)
+	client, err := selectSenderClient(opts, "")
 	if err != nil {
 		log.Fatal(err)
 	}
+	// gRPC-клиент держит открытый поток отправки метрик, его нужно закрыть штатно
+	if closer, ok := client.(interface{ Close() }); ok {
+		defer closer.Close()
+	}
 	a := agent.NewAgent(metricCollection, client, opts)
+	a.SetBuildVersion(buildVersion)
 	showBuildInfo("Build version: ", buildVersion)
 	showBuildInfo("Build date: ", buildDate)
 	showBuildInfo("Build commit: ", buildCommit)
@@ -57,12 +62,16 @@ func showBuildInfo(caption string, v string) {
 	}
 }
 
-func selectSenderClient(clientType string, addr string, contentType string, hashKey string, cryptoKey string) (agent.Sender, error) {
-	switch clientType {
+func selectSenderClient(opts *agent.Options, contentType string) (agent.Sender, error) {
+	switch opts.ClientType {
 	case "http":
-		return httpclient.NewClient("http://"+addr+"/update/", contentType, hashKey, cryptoKey), nil
+		scheme := "http://"
+		if opts.TLSEnabled() {
+			scheme = "https://"
+		}
+		return httpclient.NewClient(scheme+opts.EndpointAddr, contentType, opts)
 	case "grpc":
-		return grpcclient.NewClient(addr, hashKey)
+		return grpcclient.NewClient(opts)
 	default:
 		return nil, errors.New("не найден указанный тип клиента")
 	}
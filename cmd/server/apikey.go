@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/audit"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
+)
+
+const apiKeyUsage = `управление API-ключами сервера:
+  server apikey create -scopes read,write [-tenant name]
+  server apikey revoke <id>
+  server apikey list
+
+хранилище ключей задается флагами -api-keys и -d или переменными окружения API_KEYS и DATABASE_DSN,
+журнал аудита - флагом -audit-log или переменной окружения AUDIT_LOG`
+
+// runAPIKeyCommand, выполняет подкоманду управления API-ключами.
+func runAPIKeyCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New(apiKeyUsage)
+	}
+
+	fs := flag.NewFlagSet("apikey "+args[0], flag.ContinueOnError)
+	location := fs.String("api-keys", os.Getenv("API_KEYS"), "path to API keys file or 'db'")
+	dsn := fs.String("d", os.Getenv("DATABASE_DSN"), "database connection DSN")
+	scopes := fs.String("scopes", string(auth.ScopeWrite), "comma separated scopes: read, write, admin")
+	tenant := fs.String("tenant", "", "tenant of the key")
+	auditLog := fs.String("audit-log", os.Getenv("AUDIT_LOG"), "path to audit log file or 'db'")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if *location == "" {
+		return errors.New("не задано хранилище API-ключей (-api-keys)")
+	}
+
+	ctx := context.Background()
+	store, err := auth.OpenStore(ctx, *location, *dsn)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть хранилище API-ключей, %w", err)
+	}
+
+	var sink audit.Sink
+	if *auditLog != "" {
+		sink, err = audit.OpenSink(ctx, *auditLog, *dsn)
+		if err != nil {
+			return fmt.Errorf("не удалось открыть журнал аудита, %w", err)
+		}
+		defer sink.Close()
+	}
+	ctx = audit.WithCaller(ctx, audit.Caller{Transport: audit.TransportCLI})
+
+	switch args[0] {
+	case "create":
+		parsed, err := auth.ParseScopes(*scopes)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		secret, key, err := auth.Generate(parsed, *tenant)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		rec := audit.NewRecord(ctx, audit.OpAPIKeyCreate)
+		rec.KeyID = key.ID
+		rec.Tenant = key.Tenant
+		rec.Details = fmt.Sprintf("scopes: %v", key.Scopes)
+		if err := audit.Log(ctx, sink, rec); err != nil {
+			return fmt.Errorf("ключ не создан, %w", err)
+		}
+
+		if err := store.Create(ctx, key); err != nil {
+			return fmt.Errorf("не удалось сохранить ключ, %w", err)
+		}
+
+		fmt.Printf("id: %s\nkey: %s\n", key.ID, secret)
+		fmt.Println("сохраните ключ: повторно получить его не получится")
+	case "revoke":
+		if fs.NArg() < 1 {
+			return errors.New("не указан идентификатор ключа")
+		}
+
+		rec := audit.NewRecord(ctx, audit.OpAPIKeyRevoke)
+		rec.KeyID = fs.Arg(0)
+		if err := audit.Log(ctx, sink, rec); err != nil {
+			return fmt.Errorf("ключ не отозван, %w", err)
+		}
+
+		if err := store.Revoke(ctx, fs.Arg(0)); err != nil {
+			return fmt.Errorf("не удалось отозвать ключ, %w", err)
+		}
+
+		fmt.Printf("ключ %s отозван\n", fs.Arg(0))
+	case "list":
+		keys, err := store.List(ctx)
+		if err != nil {
+			return fmt.Errorf("не удалось получить список ключей, %w", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSCOPES\tTENANT\tCREATED\tREVOKED")
+		for _, k := range keys {
+			revoked := "-"
+			if k.RevokedAt != nil {
+				revoked = k.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%v\t%s\t%s\t%s\n", k.ID, k.Scopes, k.Tenant, k.CreatedAt.Format(time.RFC3339), revoked)
+		}
+
+		return w.Flush()
+	default:
+		return errors.New(apiKeyUsage)
+	}
+
+	return nil
+}
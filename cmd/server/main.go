@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"os/signal"
 	"syscall"
 
@@ -20,17 +21,36 @@ var (
 	buildCommit  string = "N/A"
 )
 
-func serverFactory(serverType string, hashKey string, trustedSubnet string) (server.IServer, error) {
-	if serverType == "http" {
+func serverFactory(opts *server.Options) (server.IServer, error) {
+	if opts.ServerType == "http" {
 		return server.NewHTTPServer(), nil
 	}
-	if serverType == "grpc" {
-		return server.NewGRPCServer(trustedSubnet, hashKey), nil
+	if opts.ServerType == "grpc" {
+		return server.NewGRPCServer(opts)
 	}
 	return nil, errors.New("не удалось определить тип запускаемого сервера")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := runAPIKeyCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		if err := runSnapshotCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	opts := server.ReadOptions()
 	err := logger.InitLogger(opts.LogLevel)
 	if err != nil {
@@ -38,7 +58,7 @@ func main() {
 	}
 	logger.Log.Info(*opts)
 
-	serverType, err := serverFactory(opts.ServerType, opts.Key, opts.TrustedSubnet)
+	serverType, err := serverFactory(opts)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
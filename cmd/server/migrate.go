@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/migrations"
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+)
+
+const migrateUsage = `миграции схемы БД:
+  server migrate up
+  server migrate down [-steps n]
+  server migrate to <version>
+  server migrate status
+
+подключение к БД задается флагом -d или переменной окружения DATABASE_DSN`
+
+// runMigrateCommand, выполняет подкоманду миграций схемы БД.
+func runMigrateCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New(migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dsn := fs.String("d", os.Getenv("DATABASE_DSN"), "database connection DSN")
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if *dsn == "" {
+		return errors.New("не задано подключение к БД (-d)")
+	}
+	if storage.IsSQLiteDSN(*dsn) {
+		return errors.New("миграции выполняются только для PostgreSQL, таблицы SQLite создаются при запуске сервера")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, *dsn)
+	if err != nil {
+		return fmt.Errorf("ошибка получения соединения из пула, %w", err)
+	}
+	defer pool.Close()
+
+	m, err := migrations.New(pool)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	switch args[0] {
+	case "up":
+		if err := m.Up(ctx); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	case "down":
+		if err := m.Down(ctx, *steps); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	case "to":
+		if fs.NArg() < 1 {
+			return errors.New("не указана версия схемы")
+		}
+
+		version, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("неверная версия схемы %q", fs.Arg(0))
+		}
+
+		if err := m.To(ctx, version); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	case "status":
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return printMigrationStatus(ctx, m)
+}
+
+// printMigrationStatus, выводит список миграций и время их применения.
+func printMigrationStatus(ctx context.Context, m *migrations.Migrator) error {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, st := range states {
+		applied := "-"
+		if !st.AppliedAt.IsZero() {
+			applied = st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", st.Version, st.Name, applied)
+	}
+
+	return w.Flush()
+}
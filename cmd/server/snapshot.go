@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+)
+
+const snapshotUsage = `снимки метрик файлового хранилища:
+  server snapshot convert [-format json|proto] [-compress none|gzip] <src> <dst>
+
+формат исходного снимка определяется по его заголовку, dst может совпадать с src`
+
+// runSnapshotCommand, выполняет подкоманду работы со снимками метрик.
+func runSnapshotCommand(args []string) error {
+	if len(args) < 1 || args[0] != "convert" {
+		return errors.New(snapshotUsage)
+	}
+
+	fs := flag.NewFlagSet("snapshot convert", flag.ContinueOnError)
+	encoding := fs.String("format", string(storage.EncodingProto), "target snapshot encoding: json, proto")
+	compression := fs.String("compress", string(storage.CompressionNone), "target snapshot compression: none, gzip")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if fs.NArg() != 2 {
+		return errors.New(snapshotUsage)
+	}
+
+	format, err := storage.ParseSnapshotFormat(*encoding, *compression)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(1)
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	from, err := storage.ConvertSnapshot(src, dst, format)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	fmt.Printf("%s (%s, %s, %d байт) -> %s (%s, %s, %d байт)\n",
+		src, from.Encoding, from.Compression, srcInfo.Size(),
+		dst, format.Encoding, format.Compression, dstInfo.Size())
+
+	return nil
+}
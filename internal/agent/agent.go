@@ -2,16 +2,20 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"math/rand"
+	"os"
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
 	"github.com/ShvetsovYura/metrics-collector/internal/util"
 )
 
@@ -22,6 +26,17 @@ func MakeGaugeMetricItem(name string, val float64) MetricItem {
 type Sender interface {
 	Send(item MetricItem, currentIP string) error
 }
+
+// BatchSender, клиент, умеющий отправлять все метрики интервала одним набором
+// через постоянное соединение с сервером.
+type BatchSender interface {
+	SendBatch(items []MetricItem, currentIP string) error
+}
+
+// Registrar, клиент, умеющий сообщать серверу сведения об агенте.
+type Registrar interface {
+	Register(info models.AgentInfo, currentIP string) error
+}
 type Setter interface {
 	SetItem(m MetricItem)
 }
@@ -38,11 +53,13 @@ type Storer interface {
 
 // Agent: структура, для работы с метриками
 type Agent struct {
-	mx         sync.RWMutex
-	collection Storer
-	options    *Options
-	sender     Sender
-	ip         string
+	mx             sync.RWMutex
+	collection     Storer
+	options        *Options
+	sender         Sender
+	ip             string
+	version        string
+	throttledUntil atomic.Int64 // до какого момента (unix nano) сервер просил не отправлять запросы
 }
 
 // NewAgent: инициализация нового экземляра агента сбора метрик
@@ -62,13 +79,19 @@ func NewAgent(metricCollector Storer, metricSender Sender, options *Options) *Ag
 	}
 }
 
+// SetBuildVersion: версия сборки, которую агент сообщает серверу
+func (a *Agent) SetBuildVersion(version string) {
+	a.version = version
+}
+
 // Run: запуск агента сбора метрик
 func (a *Agent) Run(ctx context.Context) {
 	wg := &sync.WaitGroup{}
-	wg.Add(2)
+	wg.Add(3)
 
 	go a.runCollectMetrics(ctx, wg) // сбор метрик
 	go a.runSendMetrics(ctx, wg)    // отправка метрик
+	go a.runHeartbeat(ctx, wg)      // отправка сведений об агенте
 
 	logger.Log.Info("процессы агента запущены")
 	wg.Wait()
@@ -125,6 +148,13 @@ func (a *Agent) runSendMetrics(ctx context.Context, wg *sync.WaitGroup) {
 		case <-sendTicker.C:
 			logger.Log.Debug("start send")
 
+			if batcher, ok := a.sender.(BatchSender); ok {
+				if err := a.sendBatch(batcher); err != nil {
+					logger.Log.Warnf("не удалось отправить метрики: %s", err.Error())
+				}
+				continue
+			}
+
 			var workers int
 
 			if a.options.RateLimit == 0 {
@@ -156,14 +186,135 @@ func (a *Agent) runSendMetrics(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
+func (a *Agent) runHeartbeat(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	registrar, ok := a.sender.(Registrar)
+	if !ok {
+		logger.Log.Info("клиент не поддерживает регистрацию агента")
+		return
+	}
+
+	heartbeatTicker := time.NewTicker(a.options.Heartbeat)
+	defer heartbeatTicker.Stop()
+
+	info := a.identity()
+	for {
+		a.waitThrottle()
+		if err := registrar.Register(info, a.ip); err != nil {
+			var retryErr *RetryAfterError
+			if errors.As(err, &retryErr) {
+				a.throttle(retryErr.Delay)
+			}
+			logger.Log.Warnf("не удалось отправить сведения об агенте: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("остановка отправки сведений об агенте...")
+			return
+		case <-heartbeatTicker.C:
+		}
+	}
+}
+
+// identity: сведения об агенте, передаваемые серверу
+func (a *Agent) identity() models.AgentInfo {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Log.Warnf("не удалось получить имя хоста: %s", err.Error())
+	}
+
+	return models.AgentInfo{
+		ID:         a.options.ID(),
+		Hostname:   hostname,
+		Version:    a.version,
+		IP:         a.ip,
+		Collectors: []string{RuntimeCollector, SystemCollector},
+	}
+}
+
 func (a *Agent) senderWorker(metricsCh <-chan MetricItem) {
 	for m := range metricsCh {
-		if err := a.sender.Send(m, a.ip); err != nil {
+		if err := a.send(m); err != nil {
 			logger.Log.Warnf("не удалось отправить метрику: %s", m)
 		}
 	}
 }
 
+// maxThrottledRetries, сколько раз агент повторяет отправку метрики, отклоненной из-за ограничения частоты.
+const maxThrottledRetries = 3
+
+// send, отправляет метрику. Если сервер ограничил частоту запросов, все отправители
+// ждут указанное сервером время, после чего отправка повторяется.
+func (a *Agent) send(m MetricItem) error {
+	for attempt := 0; ; attempt++ {
+		a.waitThrottle()
+
+		err := a.sender.Send(m, a.ip)
+
+		var retryErr *RetryAfterError
+		if !errors.As(err, &retryErr) || attempt >= maxThrottledRetries {
+			return err
+		}
+
+		a.throttle(retryErr.Delay)
+	}
+}
+
+// sendBatch, отправляет все собранные метрики одним набором. Если сервер ограничил
+// частоту запросов, отправка повторяется после паузы, как и для отдельных метрик.
+func (a *Agent) sendBatch(batcher BatchSender) error {
+	a.mx.RLock()
+	items := make([]MetricItem, 0, a.collection.Count())
+	next := a.collection.Items()
+	for {
+		val, hasNext := next()
+		if val.ID != "" {
+			items = append(items, val)
+		}
+		if !hasNext {
+			break
+		}
+	}
+	a.mx.RUnlock()
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		a.waitThrottle()
+
+		err := batcher.SendBatch(items, a.ip)
+
+		var retryErr *RetryAfterError
+		if !errors.As(err, &retryErr) || attempt >= maxThrottledRetries {
+			return err
+		}
+
+		a.throttle(retryErr.Delay)
+	}
+}
+
+// throttle, приостанавливает отправку запросов на delay.
+func (a *Agent) throttle(delay time.Duration) {
+	until := time.Now().Add(delay).UnixNano()
+	for {
+		cur := a.throttledUntil.Load()
+		if cur >= until || a.throttledUntil.CompareAndSwap(cur, until) {
+			return
+		}
+	}
+}
+
+// waitThrottle, ждет окончания паузы, которую запросил сервер.
+func (a *Agent) waitThrottle() {
+	if wait := time.Until(time.Unix(0, a.throttledUntil.Load())); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
 func multiplexChannels(ctx context.Context, channels ...chan MetricItem) chan MetricItem {
 	resultCh := make(chan MetricItem)
 	wg := &sync.WaitGroup{}
@@ -199,3 +199,45 @@ func TestAgent_runCollectMetrics(t *testing.T) {
 		})
 	}
 }
+
+// throttledSender, первые throttled запросов отклоняет с RetryAfterError.
+type throttledSender struct {
+	mx        sync.Mutex
+	throttled int
+	calls     []time.Time
+}
+
+func (c *throttledSender) Send(item MetricItem, currentIP string) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.calls = append(c.calls, time.Now())
+	if len(c.calls) <= c.throttled {
+		return &RetryAfterError{Delay: 30 * time.Millisecond}
+	}
+	return nil
+}
+
+func TestAgent_sendThrottled(t *testing.T) {
+	tests := []struct {
+		name      string
+		throttled int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "retry after pause", throttled: 1, wantCalls: 2},
+		{name: "give up after retries", throttled: 10, wantCalls: maxThrottledRetries + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &throttledSender{throttled: tt.throttled}
+			a := &Agent{sender: sender, options: &Options{}}
+
+			err := a.send(MakeGaugeMetricItem("Alloc", 1))
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Len(t, sender.calls, tt.wantCalls)
+			assert.GreaterOrEqual(t, sender.calls[1].Sub(sender.calls[0]), 30*time.Millisecond)
+		})
+	}
+}
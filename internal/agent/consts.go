@@ -6,4 +6,6 @@ const (
 	GaugeTypeName      string = "gauge"            // константа для имении метрики gauge
 	CounterTypeName    string = "counter"          // констатна имени для метрики counter
 	DefaultContentType string = "application/json" // константа для заголовка типа контента по-умолчиню
+	RuntimeCollector   string = "runtime"          // сборщик метрик runtime Go
+	SystemCollector    string = "system"           // сборщик метрик памяти и CPU хоста
 )
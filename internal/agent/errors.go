@@ -0,0 +1,15 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetryAfterError, сервер ограничил частоту запросов агента и просит повторить запрос через Delay.
+type RetryAfterError struct {
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("сервер ограничил частоту запросов, повтор через %s", e.Delay)
+}
@@ -2,38 +2,115 @@ package grpcclient
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
+	"github.com/ShvetsovYura/metrics-collector/internal"
 	"github.com/ShvetsovYura/metrics-collector/internal/agent"
+	"github.com/ShvetsovYura/metrics-collector/internal/agent/grpc_client/interceptors"
+	"github.com/ShvetsovYura/metrics-collector/internal/cryptocodec"
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
-	"github.com/ShvetsovYura/metrics-collector/internal/util"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"github.com/ShvetsovYura/metrics-collector/internal/tlsconfig"
 	pb "github.com/ShvetsovYura/metrics-collector/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/protobuf/proto"
+	"google.golang.org/grpc/status"
 )
 
 type GRPCClient struct {
 	conn    *grpc.ClientConn
 	client  pb.MetricsClient
-	hashKey string
+	tenant  string
+	apiKey  string
+	agentID string
+
+	// поток отправки метрик, открытый между интервалами отправки
+	streamMx     sync.Mutex
+	stream       grpc.BidiStreamingClient[pb.MetricsChunk, pb.ChunkAck]
+	cancelStream context.CancelFunc
+	seq          uint64
+	ackTimeout   time.Duration
+
+	// идентификаторы порций: batchPrefix-batch/номер порции, при повторной отправке не меняются
+	batchPrefix string
+	batch       uint64
 }
 
-func NewClient(addr string, hashKey string) (*GRPCClient, error) {
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewClient(opt *agent.Options) (*GRPCClient, error) {
+	creds := insecure.NewCredentials()
+	if opt.TLSEnabled() {
+		tlsConfig, err := tlsconfig.ClientConfig(opt.TLSCA, opt.TLSCert, opt.TLSKey, opt.EndpointAddr)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось настроить TLS GRPC клиента %w", err)
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(interceptors.HashInterceptorWrapper(opt.Key)),
+		grpc.WithStreamInterceptor(interceptors.HashStreamInterceptorWrapper(opt.Key)),
+		// проверка соединения: поток, открытый между интервалами отправки, не зависает
+		// на полуоткрытом соединении
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	}
+	if opt.CryptoKey != "" {
+		codec, err := cryptocodec.NewClientCodec(opt.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось инициализировать шифрование GRPC клиента %w", err)
+		}
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.ForceCodec(codec)))
+	}
+
+	// префикс отличает порции разных запусков агента
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("не удалось сформировать идентификатор порций %w", err)
+	}
+
+	conn, err := grpc.NewClient(opt.EndpointAddr, dialOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("не удалось инициализировать GRPC клиент %w", err)
 	}
 	return &GRPCClient{
-		conn:    conn,
-		client:  pb.NewMetricsClient(conn),
-		hashKey: hashKey,
+		conn:        conn,
+		client:      pb.NewMetricsClient(conn),
+		tenant:      opt.Tenant,
+		apiKey:      opt.APIKey,
+		agentID:     opt.ID(),
+		ackTimeout:  ackTimeoutDef,
+		batchPrefix: hex.EncodeToString(prefix),
 	}, nil
 }
 
 func (g *GRPCClient) Close() {
+	g.streamMx.Lock()
+	if g.stream != nil {
+		// сервер получает конец потока и завершает его штатно
+		if err := g.stream.CloseSend(); err == nil {
+			_, _ = g.stream.Recv()
+		}
+	}
+	g.closeStream()
+	g.streamMx.Unlock()
+
 	g.conn.Close()
 }
 
@@ -47,26 +124,238 @@ func (g *GRPCClient) Send(item agent.MetricItem, currentIP string) error {
 		Delta: item.Delta,
 	}
 
-	md := metadata.New(map[string]string{})
-	if g.hashKey != "" {
-		msgData, err := proto.Marshal(&msg)
-		if err != nil {
-			return err
-		}
-		md.Append("HashSHA256", util.Hash(msgData, g.hashKey))
-	}
-
-	md.Append("X-Real-IP", currentIP)
-	md.Append("x-real-ip")
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	ctx := g.outgoingContext(currentIP)
 
 	logger.Log.Debug("before send")
 	resp, err := g.client.UpdateMetric(ctx, &msg,
 		grpc.Header(&respHeaders), grpc.UseCompressor(gzip.Name))
 	logger.Log.Debug("after send %v", resp)
 	if err != nil {
-		return fmt.Errorf("не удалось отправить метрики, %w", err)
+		return fmt.Errorf("не удалось отправить метрики, %w", retryAfter(err))
 	}
 	logger.Log.Debug("end send metric")
 	return nil
 }
+
+// Параметры отправки метрик через поток.
+const (
+	chunkSize         = 100                    // метрик в одной порции
+	maxReconnects     = 3                      // попыток переоткрыть поток для отправки порции
+	reconnectBackoff  = 200 * time.Millisecond // пауза перед первой повторной попыткой, далее удваивается
+	reconnectMaxPause = 5 * time.Second
+	ackTimeoutDef     = 10 * time.Second // ожидание подтверждения порции, затем поток переоткрывается
+	keepaliveTime     = 30 * time.Second // пауза без обмена, после которой соединение проверяется
+	keepaliveTimeout  = 10 * time.Second // ожидание ответа на проверку соединения
+)
+
+// SendBatch, отправляет метрики порциями через поток StreamMetrics и ждет подтверждения каждой порции.
+// Поток остается открытым между вызовами, при обрыве он открывается заново и порция отправляется
+// повторно с тем же идентификатором: сервер не применяет сохраненную порцию второй раз.
+func (g *GRPCClient) SendBatch(items []agent.MetricItem, currentIP string) error {
+	g.streamMx.Lock()
+	defer g.streamMx.Unlock()
+
+	g.batch++
+	for start := 0; start < len(items); start += chunkSize {
+		end := start + chunkSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		chunkID := fmt.Sprintf("%s-%d/%d", g.batchPrefix, g.batch, start/chunkSize)
+		if err := g.sendChunk(chunkID, items[start:end], currentIP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendChunk, отправляет одну порцию метрик, при ошибке потока переоткрывает его с паузой.
+func (g *GRPCClient) sendChunk(chunkID string, items []agent.MetricItem, currentIP string) error {
+	chunk := &pb.MetricsChunk{ChunkId: chunkID, Metrics: make([]*pb.Metric, 0, len(items))}
+	for _, item := range items {
+		chunk.Metrics = append(chunk.Metrics, &pb.Metric{Id: item.ID, Mtype: item.MType, Value: item.Value, Delta: item.Delta})
+	}
+
+	var err error
+	pause := reconnectBackoff
+	for attempt := 0; attempt <= maxReconnects; attempt++ {
+		if attempt > 0 {
+			logger.Log.Infof("повторное подключение потока метрик через %s: %s", pause, err.Error())
+			time.Sleep(pause)
+			pause = min(pause*2, reconnectMaxPause)
+		}
+
+		if g.stream == nil {
+			if err = g.openStream(currentIP); err != nil {
+				continue
+			}
+		}
+
+		var ack *pb.ChunkAck
+		if ack, err = g.exchange(chunk); err != nil {
+			g.closeStream()
+
+			// сервер ограничил частоту или отклонил поток: повтор не поможет,
+			// паузу по RetryInfo выдерживает агент
+			if !reconnectable(err) {
+				return fmt.Errorf("не удалось отправить метрики, %w", retryAfter(err))
+			}
+			continue
+		}
+
+		if code := codes.Code(ack.GetCode()); code != codes.OK {
+			return fmt.Errorf("не удалось отправить метрики, %w", retryAfter(ackError(ack)))
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("не удалось отправить метрики через поток, %w", err)
+}
+
+// exchange, отправляет порцию и ждет подтверждения с ее номером не дольше ackTimeout.
+// Если подтверждения нет, поток отменяется и возвращается DeadlineExceeded: порция
+// отправляется повторно в новом потоке, иначе зависший сервер остановил бы отправку метрик.
+func (g *GRPCClient) exchange(chunk *pb.MetricsChunk) (*pb.ChunkAck, error) {
+	g.seq++
+	chunk.Seq = g.seq
+
+	timer := time.AfterFunc(g.ackTimeout, g.cancelStream)
+	ack, err := g.send(chunk)
+	if !timer.Stop() {
+		return nil, status.Errorf(codes.DeadlineExceeded, "нет подтверждения порции %d за %s", chunk.Seq, g.ackTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ack.GetSeq() != chunk.Seq {
+		return nil, fmt.Errorf("подтверждение порции %d вместо %d", ack.GetSeq(), chunk.Seq)
+	}
+
+	return ack, nil
+}
+
+// ackError, ошибка из подтверждения порции. Пауза retry_after передается деталью RetryInfo.
+func ackError(ack *pb.ChunkAck) error {
+	st := status.New(codes.Code(ack.GetCode()), ack.GetError())
+	if ack.GetRetryAfter() != nil {
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: ack.GetRetryAfter()}); err == nil {
+			st = detailed
+		}
+	}
+
+	return st.Err()
+}
+
+// send, отправляет порцию и принимает подтверждение.
+func (g *GRPCClient) send(chunk *pb.MetricsChunk) (*pb.ChunkAck, error) {
+	if err := g.stream.Send(chunk); err != nil {
+		if errors.Is(err, io.EOF) {
+			// поток закрыт сервером, причина - в статусе, который возвращает Recv
+			_, err = g.stream.Recv()
+		}
+		return nil, err
+	}
+
+	return g.stream.Recv()
+}
+
+// reconnectable, можно ли повторить отправку порции в новом потоке.
+func reconnectable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Canceled, codes.Aborted, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// openStream, открывает поток отправки метрик с метаданными агента.
+func (g *GRPCClient) openStream(currentIP string) error {
+	ctx, cancel := context.WithCancel(g.outgoingContext(currentIP))
+
+	stream, err := g.client.StreamMetrics(ctx, grpc.UseCompressor(gzip.Name))
+	if err != nil {
+		cancel()
+		return fmt.Errorf("не удалось открыть поток метрик, %w", err)
+	}
+
+	g.stream = stream
+	g.cancelStream = cancel
+
+	return nil
+}
+
+// closeStream, закрывает текущий поток отправки метрик.
+func (g *GRPCClient) closeStream() {
+	if g.cancelStream != nil {
+		g.cancelStream()
+	}
+	g.stream = nil
+	g.cancelStream = nil
+}
+
+// Register, отправляет на сервер сведения об агенте.
+func (g *GRPCClient) Register(info models.AgentInfo, currentIP string) error {
+	msg := pb.RegisterAgentRequest{
+		Agent: &pb.AgentInfo{
+			Id:         info.ID,
+			Hostname:   info.Hostname,
+			Version:    info.Version,
+			Ip:         info.IP,
+			Collectors: info.Collectors,
+		},
+	}
+
+	ctx := g.outgoingContext(currentIP)
+
+	_, err := g.client.RegisterAgent(ctx, &msg, grpc.UseCompressor(gzip.Name))
+	if err != nil {
+		return fmt.Errorf("не удалось отправить сведения об агенте, %w", retryAfter(err))
+	}
+	return nil
+}
+
+// outgoingContext, формирует контекст запроса с метаданными: ip и идентификатор агента, тенант и API-ключ.
+// Подпись сообщения добавляет перехватчик HashInterceptorWrapper.
+func (g *GRPCClient) outgoingContext(currentIP string) context.Context {
+	md := metadata.New(map[string]string{})
+	md.Append("X-Real-IP", currentIP)
+	if g.tenant != "" {
+		md.Append(tenant.Header, g.tenant)
+	}
+	if g.apiKey != "" {
+		md.Append(internal.APIKeyHeader, g.apiKey)
+	}
+	if g.agentID != "" {
+		md.Append(internal.AgentIDHeader, g.agentID)
+	}
+	return metadata.NewOutgoingContext(context.Background(), md)
+}
+
+// defaultRetryAfter, пауза, если в RetryInfo не указана задержка.
+const defaultRetryAfter = time.Second
+
+// retryAfter, преобразует ответ ResourceExhausted с деталью RetryInfo в agent.RetryAfterError.
+// ResourceExhausted без RetryInfo - не ограничение частоты, ошибка возвращается как есть.
+func retryAfter(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return err
+	}
+
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			delay := defaultRetryAfter
+			if info.GetRetryDelay() != nil {
+				delay = info.GetRetryDelay().AsDuration()
+			}
+
+			return &agent.RetryAfterError{Delay: delay}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,223 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/agent"
+	"github.com/ShvetsovYura/metrics-collector/internal/handlers"
+	"github.com/ShvetsovYura/metrics-collector/internal/ratelimit"
+	"github.com/ShvetsovYura/metrics-collector/internal/server/interceptors"
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+)
+
+// startServer, запускает gRPC-сервер метрик с проверкой подписи потоков на адресе addr.
+func startServer(t *testing.T, addr string, store handlers.Storage) (*grpc.Server, string) {
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(grpc.ChainStreamInterceptor(interceptors.HashStreamInterceptorWrapper("ring:v1:secret")))
+	pb.RegisterMetricsServer(srv, handlers.NewMetricServer(store, nil))
+	go srv.Serve(listener)
+
+	return srv, listener.Addr().String()
+}
+
+func TestGRPCClient_SendBatch(t *testing.T) {
+	mem := storage.NewMemory(200)
+	srv, addr := startServer(t, "127.0.0.1:0", mem)
+
+	client, err := NewClient(&agent.Options{EndpointAddr: addr, Key: "ring:v1:secret"})
+	require.NoError(t, err)
+	defer client.Close()
+
+	// больше одной порции: каждая подтверждается отдельно
+	items := make([]agent.MetricItem, 0, chunkSize+10)
+	for i := 0; i < chunkSize+10; i++ {
+		items = append(items, agent.MakeGaugeMetricItem(fmt.Sprintf("Gauge%d", i), float64(i)))
+	}
+	items = append(items, agent.MetricItem{ID: "PollCount", MType: agent.CounterTypeName, Delta: 2})
+
+	require.NoError(t, client.SendBatch(items, "127.0.0.1"))
+	assert.Equal(t, uint64(2), client.seq)
+	g, err := mem.GetGauge(context.Background(), fmt.Sprintf("Gauge%d", chunkSize+5))
+	require.NoError(t, err)
+	assert.Equal(t, float64(chunkSize+5), *g.GetRawValue())
+
+	// поток переживает перезапуск сервера: порция отправляется в новом потоке
+	srv.Stop()
+	srv, _ = startServer(t, addr, mem)
+	defer srv.Stop()
+
+	require.NoError(t, client.SendBatch(items[len(items)-1:], "127.0.0.1"))
+	c, err := mem.GetCounter(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *c.GetRawValue())
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := NewClient(&agent.Options{EndpointAddr: addr, Key: "ring:v1:other"})
+		require.NoError(t, err)
+		defer other.Close()
+
+		err = other.SendBatch(items[:1], "127.0.0.1")
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestGRPCClient_ResendChunk(t *testing.T) {
+	mem := storage.NewMemory(10)
+	srv, addr := startServer(t, "127.0.0.1:0", mem)
+	defer srv.Stop()
+
+	client, err := NewClient(&agent.Options{EndpointAddr: addr, Key: "ring:v1:secret", AgentID: "agent-1"})
+	require.NoError(t, err)
+	defer client.Close()
+
+	chunk := func(id string) *pb.MetricsChunk {
+		return &pb.MetricsChunk{ChunkId: id, Metrics: []*pb.Metric{{Id: "PollCount", Mtype: agent.CounterTypeName, Delta: 2}}}
+	}
+
+	require.NoError(t, client.openStream("127.0.0.1"))
+	ack, err := client.exchange(chunk("run-1/0"))
+	require.NoError(t, err)
+	assert.False(t, ack.GetDuplicate())
+
+	// подтверждение потеряно: порция отправляется повторно в новом потоке с тем же идентификатором
+	client.closeStream()
+	require.NoError(t, client.openStream("127.0.0.1"))
+	ack, err = client.exchange(chunk("run-1/0"))
+	require.NoError(t, err)
+	assert.True(t, ack.GetDuplicate())
+
+	c, err := mem.GetCounter(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *c.GetRawValue(), "повторная порция не применяется")
+
+	ack, err = client.exchange(chunk("run-1/1"))
+	require.NoError(t, err)
+	assert.False(t, ack.GetDuplicate())
+	c, err = mem.GetCounter(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *c.GetRawValue())
+}
+
+func TestGRPCClient_StreamRateLimit(t *testing.T) {
+	resolver, err := validator.NewSubnetFilter("", "")
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer(grpc.ChainStreamInterceptor(
+		interceptors.HashStreamInterceptorWrapper("ring:v1:secret"),
+		interceptors.RateLimitStreamInterceptorWrapper(ratelimit.NewLimiter(0.01, 1), ratelimit.ByIP, resolver),
+	))
+	mem := storage.NewMemory(10)
+	pb.RegisterMetricsServer(srv, handlers.NewMetricServer(mem, nil))
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	client, err := NewClient(&agent.Options{EndpointAddr: listener.Addr().String(), Key: "ring:v1:secret"})
+	require.NoError(t, err)
+	defer client.Close()
+
+	items := []agent.MetricItem{{ID: "PollCount", MType: agent.CounterTypeName, Delta: 2}}
+	require.NoError(t, client.SendBatch(items, "127.0.0.1"))
+
+	// лимит проверяется для каждой порции, а не только при открытии потока
+	err = client.SendBatch(items, "127.0.0.1")
+	var ra *agent.RetryAfterError
+	require.True(t, errors.As(err, &ra), err)
+	assert.Greater(t, ra.Delay, time.Duration(0))
+	assert.NotNil(t, client.stream, "поток остается открытым")
+
+	c, err := mem.GetCounter(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *c.GetRawValue(), "порция сверх лимита не применяется")
+}
+
+// stallingServer, сервер, который не подтверждает порции в первом потоке.
+type stallingServer struct {
+	pb.UnimplementedMetricsServer
+	streams atomic.Int32
+}
+
+func (s *stallingServer) StreamMetrics(stream grpc.BidiStreamingServer[pb.MetricsChunk, pb.ChunkAck]) error {
+	stall := s.streams.Add(1) == 1
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if stall {
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		}
+		if err := stream.Send(&pb.ChunkAck{Seq: chunk.GetSeq()}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestGRPCClient_AckTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	stalling := &stallingServer{}
+	pb.RegisterMetricsServer(srv, stalling)
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	client, err := NewClient(&agent.Options{EndpointAddr: listener.Addr().String()})
+	require.NoError(t, err)
+	defer client.Close()
+	client.ackTimeout = 100 * time.Millisecond
+
+	// подтверждения нет: поток отменяется, порция отправляется в новом потоке
+	items := []agent.MetricItem{{ID: "PollCount", MType: agent.CounterTypeName, Delta: 2}}
+	require.NoError(t, client.SendBatch(items, "127.0.0.1"))
+	assert.Equal(t, int32(2), stalling.streams.Load())
+}
+
+func TestRetryAfter(t *testing.T) {
+	limited, err := status.New(codes.ResourceExhausted, "limit").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(3 * time.Second)})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		err       error
+		wantDelay time.Duration
+	}{
+		{name: "rate limit", err: limited.Err(), wantDelay: 3 * time.Second},
+		{name: "resource exhausted without retry info", err: status.Error(codes.ResourceExhausted, "quota")},
+		{name: "quota", err: status.Error(codes.FailedPrecondition, "quota")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ra *agent.RetryAfterError
+			got := retryAfter(tt.err)
+			if tt.wantDelay == 0 {
+				assert.False(t, errors.As(got, &ra))
+				assert.Equal(t, tt.err, got)
+				return
+			}
+			require.True(t, errors.As(got, &ra))
+			assert.Equal(t, tt.wantDelay, ra.Delay)
+		})
+	}
+}
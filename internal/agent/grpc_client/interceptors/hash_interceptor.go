@@ -2,21 +2,108 @@ package interceptors
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
-	"github.com/ShvetsovYura/metrics-collector/internal/util"
+	"github.com/ShvetsovYura/metrics-collector/internal/signature"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
 )
 
+// HashInterceptorWrapper, подписывает запрос HMAC-SHA256 основным ключом и проверяет подпись ответа сервера.
 func HashInterceptorWrapper(key string) grpc.UnaryClientInterceptor {
+	keys := signature.ParseKeys(key)
+
 	return func(ctx context.Context, method string, req any, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !keys.Enabled() {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
 		data, err := proto.Marshal(req.(proto.Message))
 		if err != nil {
+			return fmt.Errorf("не удалось сериализовать запрос, %w", err)
+		}
+
+		id, hash := keys.Sign(data)
+		outCtx := metadata.AppendToOutgoingContext(ctx, signature.Header, hash)
+		if id != "" {
+			outCtx = metadata.AppendToOutgoingContext(outCtx, signature.KeyIDHeader, id)
+		}
+
+		var header metadata.MD
+		if err := invoker(outCtx, method, req, reply, cc, append(opts, grpc.Header(&header))...); err != nil {
 			return err
 		}
-		hash := util.Hash(data, key)
-		outCtx := metadata.AppendToOutgoingContext(ctx, "HashSHA256", hash)
-		return invoker(outCtx, method, req, reply, cc, opts...)
+
+		respHash := header.Get(signature.Header)
+		if len(respHash) == 0 {
+			return nil
+		}
+
+		respData, err := proto.Marshal(reply.(proto.Message))
+		if err != nil {
+			return fmt.Errorf("не удалось сериализовать ответ, %w", err)
+		}
+
+		var respKeyID string
+		if ids := header.Get(signature.KeyIDHeader); len(ids) > 0 {
+			respKeyID = ids[0]
+		}
+
+		if _, err := keys.Verify(respData, respKeyID, respHash[0]); err != nil {
+			return fmt.Errorf("ответ сервера не прошел проверку подписи, %w", err)
+		}
+
+		return nil
+	}
+}
+
+// HashStreamInterceptorWrapper, подписывает сообщения потока основным ключом и проверяет
+// подписи ответов сервера. Подписываются только сообщения с полями hash и key_id.
+func HashStreamInterceptorWrapper(key string) grpc.StreamClientInterceptor {
+	keys := signature.ParseKeys(key)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil || !keys.Enabled() {
+			return cs, err
+		}
+
+		return &signedClientStream{ClientStream: cs, keys: keys}, nil
+	}
+}
+
+// signedClientStream, поток с подписью исходящих и проверкой входящих сообщений.
+type signedClientStream struct {
+	grpc.ClientStream
+	keys *signature.Keys
+}
+
+func (s *signedClientStream) SendMsg(m any) error {
+	if msg, ok := m.(proto.Message); ok && signature.Signable(msg) {
+		if err := s.keys.SignMessage("", msg); err != nil {
+			return fmt.Errorf("не удалось подписать сообщение, %w", err)
+		}
 	}
+
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *signedClientStream) RecvMsg(m any) error {
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	msg, ok := m.(proto.Message)
+	if !ok || !signature.Signable(msg) {
+		return nil
+	}
+
+	// неподписанный ответ принимается, как и в обычных вызовах
+	if _, err := s.keys.VerifyMessage(msg); err != nil && !errors.Is(err, signature.ErrMissing) {
+		return fmt.Errorf("ответ сервера не прошел проверку подписи, %w", err)
+	}
+
+	return nil
 }
@@ -7,44 +7,97 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/ShvetsovYura/metrics-collector/internal"
 	"github.com/ShvetsovYura/metrics-collector/internal/agent"
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/signature"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"github.com/ShvetsovYura/metrics-collector/internal/tlsconfig"
 	"github.com/ShvetsovYura/metrics-collector/internal/util"
 )
 
 type MetricHTTPClient struct {
 	client        http.Client
 	url           string
+	agentsURL     string
 	contentType   string
-	hashKey       string
+	keys          *signature.Keys
 	publicKeyPath string
+	tenant        string
+	apiKey        string
+	agentID       string
 }
 
-func NewClient(url string, contentType string, hashKey string, publicKeyPath string) *MetricHTTPClient {
+// NewClient, создает http-клиент отправки метрик на сервер по адресу baseURL (например, http://localhost:8080).
+func NewClient(baseURL string, contentType string, opt *agent.Options) (*MetricHTTPClient, error) {
+	client := http.Client{}
+	if opt.TLSEnabled() {
+		tlsConfig, err := tlsconfig.ClientConfig(opt.TLSCA, opt.TLSCert, opt.TLSKey, opt.EndpointAddr)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось настроить TLS http клиента %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
 	return &MetricHTTPClient{
-		client:        http.Client{},
-		url:           url,
+		client:        client,
+		url:           baseURL + "/update/",
+		agentsURL:     baseURL + "/agents/",
 		contentType:   contentType,
-		hashKey:       hashKey,
-		publicKeyPath: publicKeyPath,
-	}
+		keys:          signature.ParseKeys(opt.Key),
+		publicKeyPath: opt.CryptoKey,
+		tenant:        opt.Tenant,
+		apiKey:        opt.APIKey,
+		agentID:       opt.ID(),
+	}, nil
 }
 
 func (c *MetricHTTPClient) Send(item agent.MetricItem, currentIP string) error {
-	var buf bytes.Buffer
-	var headers = http.Header{}
-	var data_ []byte
-
 	data, err := json.Marshal(item)
 	if err != nil {
 		return fmt.Errorf("ошибка json %w", err)
 	}
 
+	return c.post(c.url, data, currentIP)
+}
+
+// Register, отправляет на сервер сведения об агенте.
+func (c *MetricHTTPClient) Register(info models.AgentInfo, currentIP string) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("ошибка json %w", err)
+	}
+
+	return c.post(c.agentsURL, data, currentIP)
+}
+
+func (c *MetricHTTPClient) post(url string, data []byte, currentIP string) error {
+	var buf bytes.Buffer
+	var headers = http.Header{}
+	var data_ []byte
+
 	headers.Add("Content-Type", c.contentType)
 
 	headers.Add("X-Real-IP", currentIP)
 
+	if c.tenant != "" {
+		headers.Add(tenant.Header, c.tenant)
+	}
+
+	if c.apiKey != "" {
+		headers.Add(internal.APIKeyHeader, c.apiKey)
+	}
+
+	if c.agentID != "" {
+		headers.Add(internal.AgentIDHeader, c.agentID)
+	}
+
 	if c.publicKeyPath != "" {
 		var errEncrypt error
 		data_, errEncrypt = util.EncryptData(data, c.publicKeyPath)
@@ -79,12 +132,15 @@ func (c *MetricHTTPClient) Send(item agent.MetricItem, currentIP string) error {
 		}
 	}
 
-	if c.hashKey != "" {
-		hash := util.Hash(buf.Bytes(), c.hashKey)
-		headers.Add("HashSHA256", hash)
+	if c.keys.Enabled() {
+		keyID, hash := c.keys.Sign(buf.Bytes())
+		headers.Add(signature.Header, hash)
+		if keyID != "" {
+			headers.Add(signature.KeyIDHeader, keyID)
+		}
 	}
 
-	req, err := http.NewRequest("POST", c.url, &buf)
+	req, err := http.NewRequest("POST", url, &buf)
 	if err != nil {
 		return fmt.Errorf("ошибка создания web запроса для отправки метрик, %w", err)
 	}
@@ -103,5 +159,32 @@ func (c *MetricHTTPClient) Send(item agent.MetricItem, currentIP string) error {
 		}
 	}()
 
+	// пауза - только если сервер ограничил частоту и сказал, когда повторить
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if value := resp.Header.Get("Retry-After"); value != "" {
+			return &agent.RetryAfterError{Delay: retryAfter(value)}
+		}
+
+		return fmt.Errorf("сервер отклонил запрос, %s", resp.Status)
+	}
+
 	return nil
 }
+
+// defaultRetryAfter, пауза, если значение Retry-After не удалось разобрать.
+const defaultRetryAfter = time.Second
+
+// retryAfter, разбирает заголовок Retry-After: число секунд или дата.
+func retryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+
+	return defaultRetryAfter
+}
@@ -1,16 +1,20 @@
 package httpclient
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/agent"
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/signature"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMetricHttpClient_Send(t *testing.T) {
@@ -41,7 +45,7 @@ func TestMetricHttpClient_Send(t *testing.T) {
 		client        http.Client
 		url           string
 		contentType   string
-		hashKey       string
+		keys          *signature.Keys
 		publicKeyPath string
 	}
 	type args struct {
@@ -60,7 +64,7 @@ func TestMetricHttpClient_Send(t *testing.T) {
 				client:        *tu.Client(),
 				url:           tu.URL,
 				contentType:   "application/json",
-				hashKey:       "",
+				keys:          nil,
 				publicKeyPath: "",
 			},
 			args: args{
@@ -76,7 +80,7 @@ func TestMetricHttpClient_Send(t *testing.T) {
 				client:        tt.fields.client,
 				url:           tt.fields.url,
 				contentType:   tt.fields.contentType,
-				hashKey:       tt.fields.hashKey,
+				keys:          tt.fields.keys,
 				publicKeyPath: tt.fields.publicKeyPath,
 			}
 			old := os.Stdout
@@ -96,3 +100,37 @@ func TestMetricHttpClient_Send(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricHTTPClient_TooManyRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantDelay  time.Duration
+	}{
+		{name: "rate limit", retryAfter: "7", wantDelay: 7 * time.Second},
+		{name: "without retry-after"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer tu.Close()
+
+			c := &MetricHTTPClient{client: *tu.Client(), url: tu.URL, contentType: "application/json"}
+			err := c.Send(agent.MakeGaugeMetricItem("MemFree", 1), "")
+			require.Error(t, err)
+
+			var ra *agent.RetryAfterError
+			if tt.wantDelay == 0 {
+				assert.False(t, errors.As(err, &ra))
+				return
+			}
+			require.True(t, errors.As(err, &ra))
+			assert.Equal(t, tt.wantDelay, ra.Delay)
+		})
+	}
+}
@@ -20,6 +20,7 @@ const (
 	ReportIntervalDef = time.Duration(2 * time.Second)
 	PollIntervalDef   = time.Duration(2 * time.Second)
 	LogLevelDef       = "debug"
+	HeartbeatDef      = time.Duration(10 * time.Second)
 )
 
 // AgentOptoins хранит информацию настроек запуска
@@ -33,6 +34,31 @@ type Options struct {
 	RateLimit      int           `env:"RATE_LIMIT"`                             // RateLimit: сколько одновременно можно выполнять отправку метрик на сервер
 	CryptoKey      string        `env:"CRYPTO_KEY" json:"crypto_key"`           // CryptoKey: путь до файла с публичным ключом
 	LogLevel       string        `json:"log_level"`
+	AgentID        string        `env:"AGENT_ID" json:"agent_id"`                     // AgentID: идентификатор агента, по умолчанию - имя хоста
+	Heartbeat      time.Duration `env:"HEARTBEAT_INTERVAL" json:"heartbeat_interval"` // Heartbeat: интервал отправки сведений об агенте на сервер
+	Tenant         string        `env:"TENANT" json:"tenant"`                         // Tenant: пространство имен метрик на сервере
+	APIKey         string        `env:"API_KEY" json:"api_key"`                       // APIKey: ключ доступа к API сервера
+	TLS            bool          `env:"TLS" json:"tls"`                               // TLS: подключаться к серверу по TLS
+	TLSCA          string        `env:"TLS_CA" json:"tls_ca"`                         // TLSCA: путь до CA для проверки сертификата сервера, включает TLS
+	TLSCert        string        `env:"TLS_CERT" json:"tls_cert"`                     // TLSCert: путь до клиентского сертификата (mTLS)
+	TLSKey         string        `env:"TLS_KEY" json:"tls_key"`                       // TLSKey: путь до ключа клиентского сертификата
+}
+
+// redacted, значение секрета в логах.
+const redacted = "***"
+
+// String, настройки для логов: ключ подписи и API-ключ скрыты.
+func (o Options) String() string {
+	type plain Options // без метода String
+	p := plain(o)
+	if p.Key != "" {
+		p.Key = redacted
+	}
+	if p.APIKey != "" {
+		p.APIKey = redacted
+	}
+
+	return fmt.Sprintf("%+v", p)
 }
 
 func ReadOptions() *Options {
@@ -58,6 +84,7 @@ func (o *Options) UnmarshalJSON(data []byte) error {
 		*OptionsAlias
 		ReportInterval string `json:"report_interval"`
 		PollInterval   string `json:"poll_interval"`
+		Heartbeat      string `json:"heartbeat_interval"`
 	}{
 		OptionsAlias: (*OptionsAlias)(o),
 	}
@@ -73,6 +100,12 @@ func (o *Options) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return fmt.Errorf("ошибка преобразования поля PollInterval %w", err)
 	}
+	if optionsValue.Heartbeat != "" {
+		o.Heartbeat, err = time.ParseDuration(optionsValue.Heartbeat)
+		if err != nil {
+			return fmt.Errorf("ошибка преобразования поля Heartbeat %w", err)
+		}
+	}
 	return nil
 }
 
@@ -109,6 +142,28 @@ func (o *Options) applyDefaultParams() {
 	if o.LogLevel == "" {
 		o.LogLevel = LogLevelDef
 	}
+	if o.Heartbeat == 0 {
+		o.Heartbeat = HeartbeatDef
+	}
+}
+
+// ID, идентификатор агента: заданный в настройках или имя хоста.
+func (o *Options) ID() string {
+	if o.AgentID != "" {
+		return o.AgentID
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Log.Warnf("не удалось получить имя хоста: %s", err.Error())
+	}
+
+	return hostname
+}
+
+// TLSEnabled, подключается ли агент к серверу по TLS.
+func (o *Options) TLSEnabled() bool {
+	return o.TLS || o.TLSCA != "" || o.TLSCert != ""
 }
 
 // ParseArgs  парсит входные аргументы в структуру AgentOptions
@@ -119,9 +174,17 @@ func (o *Options) parseArgs() {
 	flag.StringVar(&o.EndpointAddr, "a", "", "server endpoint address")
 	flag.DurationVar(&o.PollInterval, "p", 0, "metrics gather interval")
 	flag.DurationVar(&o.ReportInterval, "r", 0, "interval send metrics to server")
-	flag.StringVar(&o.Key, "k", "", "hash key")
+	flag.StringVar(&o.Key, "k", "", "hmac key, ring:id:key to send key id")
 	flag.IntVar(&o.RateLimit, "l", 0, "limit concurent")
 	flag.StringVar(&o.CryptoKey, "crypto-key", "", "path to public key")
+	flag.StringVar(&o.AgentID, "id", "", "agent identifier, hostname by default")
+	flag.DurationVar(&o.Heartbeat, "heartbeat", 0, "interval send agent info to server")
+	flag.StringVar(&o.Tenant, "tenant", "", "metrics namespace on server")
+	flag.StringVar(&o.APIKey, "api-key", "", "server API key")
+	flag.BoolVar(&o.TLS, "tls", false, "connect to server over TLS")
+	flag.StringVar(&o.TLSCA, "tls-ca", "", "path to CA bundle to verify server certificate")
+	flag.StringVar(&o.TLSCert, "tls-cert", "", "path to client TLS certificate (mTLS)")
+	flag.StringVar(&o.TLSKey, "tls-key", "", "path to client TLS certificate key")
 
 	flag.Parse()
 	logger.Log.Infof("flags: %v", *o)
@@ -182,4 +245,28 @@ func reassignOptions(curOpt *Options, tempOpt *Options) {
 	if curOpt.LogLevel == "" && tempOpt.LogLevel != "" {
 		curOpt.LogLevel = tempOpt.LogLevel
 	}
+	if curOpt.AgentID == "" && tempOpt.AgentID != "" {
+		curOpt.AgentID = tempOpt.AgentID
+	}
+	if curOpt.Heartbeat == 0 && tempOpt.Heartbeat != 0 {
+		curOpt.Heartbeat = tempOpt.Heartbeat
+	}
+	if curOpt.Tenant == "" && tempOpt.Tenant != "" {
+		curOpt.Tenant = tempOpt.Tenant
+	}
+	if curOpt.APIKey == "" && tempOpt.APIKey != "" {
+		curOpt.APIKey = tempOpt.APIKey
+	}
+	if !curOpt.TLS && tempOpt.TLS {
+		curOpt.TLS = tempOpt.TLS
+	}
+	if curOpt.TLSCA == "" && tempOpt.TLSCA != "" {
+		curOpt.TLSCA = tempOpt.TLSCA
+	}
+	if curOpt.TLSCert == "" && tempOpt.TLSCert != "" {
+		curOpt.TLSCert = tempOpt.TLSCert
+	}
+	if curOpt.TLSKey == "" && tempOpt.TLSKey != "" {
+		curOpt.TLSKey = tempOpt.TLSKey
+	}
 }
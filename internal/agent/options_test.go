@@ -1,8 +1,10 @@
 package agent
 
 import (
+	"fmt"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,11 +19,14 @@ func TestReadOptions(t *testing.T) {
 	pathToConfig := path.Join(basePath, "test-agent-config.json")
 
 	want := &Options{
+		ClientType:     ClientTypeDef,
 		PollInterval:   time.Duration(1 * time.Second),
 		ReportInterval: time.Duration(300 * time.Millisecond),
 		CryptoKey:      "abracadabra.pem",
 		EndpointAddr:   "localhost:9876",
 		LogLevel:       "debug",
+		AgentID:        "agent-1",
+		Heartbeat:      HeartbeatDef,
 	}
 	errSetEnv := os.Setenv("CONFIG", pathToConfig)
 	assert.NoError(t, errSetEnv)
@@ -29,3 +34,13 @@ func TestReadOptions(t *testing.T) {
 	assert.Equal(t, *want, *opt)
 
 }
+
+func TestOptions_String(t *testing.T) {
+	opt := Options{EndpointAddr: "localhost:8080", Key: "hmac-secret", APIKey: "api-secret"}
+
+	got := opt.String()
+	assert.Contains(t, got, "localhost:8080")
+	assert.False(t, strings.Contains(got, "hmac-secret") || strings.Contains(got, "api-secret"), got)
+	assert.Equal(t, got, fmt.Sprintf("%v", opt))
+	assert.Equal(t, "hmac-secret", opt.Key, "исходные настройки не меняются")
+}
@@ -0,0 +1,118 @@
+// Журнал аудита: кто, когда и что изменил. Журнал только дополняется:
+// записи пишутся в json lines файл или в таблицу БД до выполнения операции,
+// результат изменения метрик - отдельной записью после него.
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// Операции, попадающие в журнал.
+const (
+	OpUpdate       = "update"        // изменение одной метрики
+	OpBatch        = "batch"         // изменение метрики в составе пакета
+	OpAdmin        = "admin"         // обращение к административному api
+	OpAPIKeyCreate = "apikey_create" // создание API-ключа
+	OpAPIKeyRevoke = "apikey_revoke" // отзыв API-ключа
+	OpResult       = "result"        // результат изменения метрик, записанного ранее с тем же CallID
+)
+
+// Результаты изменения метрик.
+const (
+	ResultOK    = "ok"    // изменение сохранено
+	ResultError = "error" // изменение отклонено, текст ошибки - в Details
+)
+
+// Транспорт, по которому пришел запрос.
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+	TransportCLI  = "cli"
+)
+
+// Record, запись журнала аудита.
+type Record struct {
+	Time       time.Time `json:"time"`
+	Op         string    `json:"op"`
+	Transport  string    `json:"transport,omitempty"`
+	IP         string    `json:"ip,omitempty"`       // адрес клиента
+	AgentID    string    `json:"agent_id,omitempty"` // идентификатор агента
+	KeyID      string    `json:"key_id,omitempty"`   // идентификатор API-ключа
+	Tenant     string    `json:"tenant,omitempty"`
+	Metric     string    `json:"metric,omitempty"`
+	MType      string    `json:"type,omitempty"`
+	Value      *float64  `json:"value,omitempty"`       // новое значение gauge
+	Delta      *int64    `json:"delta,omitempty"`       // приращение counter
+	OldValue   *float64  `json:"old_value,omitempty"`   // значение gauge до изменения, если метрика была
+	OldCounter *int64    `json:"old_counter,omitempty"` // значение counter до изменения, если метрика была
+	CallID     string    `json:"call_id,omitempty"`     // общий для записей одного изменения и его результата
+	Result     string    `json:"result,omitempty"`      // результат изменения для OpResult
+	Details    string    `json:"details,omitempty"`
+}
+
+// Sink, место записи журнала.
+type Sink interface {
+	Write(ctx context.Context, rec Record) error
+	Close() error
+}
+
+// Caller, сведения о клиенте, выполняющем запрос.
+type Caller struct {
+	Transport string
+	IP        string
+	AgentID   string
+}
+
+type ctxKey struct{}
+
+// WithCaller, возвращает контекст со сведениями о клиенте.
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, ctxKey{}, caller)
+}
+
+// CallerFromContext, возвращает сведения о клиенте из контекста.
+func CallerFromContext(ctx context.Context) Caller {
+	caller, _ := ctx.Value(ctxKey{}).(Caller)
+	return caller
+}
+
+// NewRecord, создает запись операции op, заполняя сведения о клиенте, API-ключе и тенанте из контекста.
+func NewRecord(ctx context.Context, op string) Record {
+	caller := CallerFromContext(ctx)
+	rec := Record{
+		Time:      time.Now().UTC(),
+		Op:        op,
+		Transport: caller.Transport,
+		IP:        caller.IP,
+		AgentID:   caller.AgentID,
+		Tenant:    tenant.FromContext(ctx),
+	}
+
+	if key, ok := auth.FromContext(ctx); ok {
+		rec.KeyID = key.ID
+	}
+
+	return rec
+}
+
+// DBLocation, значение настройки расположения журнала, при котором он пишется в БД.
+const DBLocation = "db"
+
+// OpenSink, открывает журнал: таблицу в БД (location = "db") или json lines файл по пути location.
+func OpenSink(ctx context.Context, location string, dsn string) (Sink, error) {
+	if location != DBLocation {
+		return NewFileSink(location)
+	}
+
+	if dsn == "" {
+		return nil, errors.New("для журнала аудита в БД не задана строка подключения")
+	}
+
+	return NewDBSink(ctx, dsn)
+}
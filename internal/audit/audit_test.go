@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	require.NoError(t, scanner.Err())
+
+	return records
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestStorage_Audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+
+	s := NewStorage(storage.NewMemory(10), sink)
+
+	ctx := tenant.WithTenant(context.Background(), "team-a")
+	ctx = WithCaller(ctx, Caller{Transport: TransportHTTP, IP: "10.0.0.5", AgentID: "agent-1"})
+
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 1.5))
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 2))
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 3))
+	require.NoError(t, s.SaveCountersBatch(ctx, map[string]models.Counter{"PollCount": 2}))
+	require.NoError(t, s.SaveGaugesBatch(ctx, map[string]models.Gauge{"B": 1, "A": 2}))
+	require.NoError(t, sink.Close())
+
+	tests := []struct {
+		op         string
+		metric     string
+		mtype      string
+		value      *float64
+		delta      *int64
+		oldValue   *float64
+		oldCounter *int64
+		result     string
+	}{
+		{op: OpUpdate, metric: "Alloc", mtype: gaugeType, value: ptr(1.5)},
+		{op: OpResult, result: ResultOK},
+		{op: OpUpdate, metric: "Alloc", mtype: gaugeType, value: ptr(2.0), oldValue: ptr(1.5)},
+		{op: OpResult, result: ResultOK},
+		{op: OpUpdate, metric: "PollCount", mtype: counterType, delta: ptr[int64](3)},
+		{op: OpResult, result: ResultOK},
+		{op: OpBatch, metric: "PollCount", mtype: counterType, delta: ptr[int64](2), oldCounter: ptr[int64](3)},
+		{op: OpResult, result: ResultOK},
+		{op: OpBatch, metric: "A", mtype: gaugeType, value: ptr(2.0)},
+		{op: OpBatch, metric: "B", mtype: gaugeType, value: ptr(1.0)},
+		{op: OpResult, result: ResultOK},
+	}
+
+	records := readRecords(t, path)
+	require.Len(t, records, len(tests))
+
+	for i, tt := range tests {
+		rec := records[i]
+		assert.Equal(t, tt.op, rec.Op)
+		assert.Equal(t, tt.metric, rec.Metric)
+		assert.Equal(t, tt.mtype, rec.MType)
+		assert.Equal(t, tt.value, rec.Value)
+		assert.Equal(t, tt.delta, rec.Delta)
+		assert.Equal(t, tt.oldValue, rec.OldValue)
+		assert.Equal(t, tt.oldCounter, rec.OldCounter)
+		assert.Equal(t, tt.result, rec.Result)
+		assert.NotEmpty(t, rec.CallID)
+		assert.Equal(t, "team-a", rec.Tenant)
+		assert.Equal(t, "10.0.0.5", rec.IP)
+		assert.Equal(t, "agent-1", rec.AgentID)
+		assert.Equal(t, TransportHTTP, rec.Transport)
+		assert.False(t, rec.Time.IsZero())
+	}
+
+	// записи пакета и его результат связаны общим идентификатором
+	assert.Equal(t, records[8].CallID, records[10].CallID)
+	assert.Equal(t, records[9].CallID, records[10].CallID)
+	assert.NotEqual(t, records[0].CallID, records[2].CallID)
+}
+
+// rejectingStorage, хранилище, отклоняющее изменения counter, как квота.
+type rejectingStorage struct {
+	Storage
+}
+
+func (rejectingStorage) SetCounter(context.Context, string, int64) error {
+	return errors.New("превышена квота")
+}
+
+func TestStorage_AuditRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+
+	s := NewStorage(rejectingStorage{Storage: storage.NewMemory(10)}, sink)
+	assert.Error(t, s.SetCounter(context.Background(), "PollCount", 1))
+	require.NoError(t, sink.Close())
+
+	records := readRecords(t, path)
+	require.Len(t, records, 2)
+	assert.Equal(t, OpUpdate, records[0].Op)
+	assert.Equal(t, OpResult, records[1].Op)
+	assert.Equal(t, records[0].CallID, records[1].CallID)
+	assert.Equal(t, ResultError, records[1].Result)
+	assert.Equal(t, "превышена квота", records[1].Details)
+}
+
+type failingSink struct{}
+
+func (failingSink) Write(context.Context, Record) error {
+	return errors.New("диск заполнен")
+}
+
+func (failingSink) Close() error { return nil }
+
+func TestStorage_SinkFailure(t *testing.T) {
+	mem := storage.NewMemory(10)
+	s := NewStorage(mem, failingSink{})
+	ctx := context.Background()
+
+	// изменение, не записанное в журнал, не сохраняется
+	assert.Error(t, s.SetCounter(ctx, "PollCount", 1))
+	assert.Error(t, s.SaveBatch(ctx, map[string]models.Gauge{"Alloc": 1}, map[string]models.Counter{"PollCount": 1}))
+
+	_, err := mem.GetCounter(ctx, "PollCount")
+	assert.Error(t, err)
+	_, err = mem.GetGauge(ctx, "Alloc")
+	assert.Error(t, err)
+}
+
+func TestFileSink_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	for _, op := range []string{OpAPIKeyCreate, OpAPIKeyRevoke} {
+		sink, err := NewFileSink(path)
+		require.NoError(t, err)
+		require.NoError(t, sink.Write(context.Background(), NewRecord(context.Background(), op)))
+		require.NoError(t, sink.Close())
+	}
+
+	// повторное открытие не затирает прежние записи
+	records := readRecords(t, path)
+	require.Len(t, records, 2)
+	assert.Equal(t, OpAPIKeyCreate, records[0].Op)
+	assert.Equal(t, OpAPIKeyRevoke, records[1].Op)
+}
@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/migrations"
+)
+
+// DBSink, пишет журнал аудита в таблицу audit_log.
+type DBSink struct {
+	pool *pgxpool.Pool
+}
+
+// NewDBSink, подключается к БД и применяет миграции схемы (таблица audit_log).
+func NewDBSink(ctx context.Context, connString string) (*DBSink, error) {
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения соединения из пула, %w", err)
+	}
+
+	if err := migrations.Up(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ошибка миграции схемы БД, %w", err)
+	}
+
+	return &DBSink{pool: pool}, nil
+}
+
+func (s *DBSink) Write(ctx context.Context, rec Record) error {
+	stmt, args, err := sq.Insert("audit_log").
+		Columns("ts", "op", "transport", "ip", "agent_id", "key_id", "tenant", "metric", "mtype", "value", "delta",
+			"old_value", "old_counter", "call_id", "result", "details").
+		Values(rec.Time, rec.Op, rec.Transport, rec.IP, rec.AgentID, rec.KeyID, rec.Tenant, rec.Metric, rec.MType, rec.Value, rec.Delta,
+			rec.OldValue, rec.OldCounter, rec.CallID, rec.Result, rec.Details).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if _, err := s.pool.Exec(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита, %w", err)
+	}
+
+	return nil
+}
+
+func (s *DBSink) Close() error {
+	s.pool.Close()
+	return nil
+}
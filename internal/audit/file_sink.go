@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink, пишет журнал аудита в json lines файл, открытый только на дозапись.
+type FileSink struct {
+	mx sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink, открывает (или создает) файл журнала.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть журнал аудита, %w", err)
+	}
+
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Write(_ context.Context, rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	data = append(data, '\n')
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	// запись одним вызовом, чтобы строки не перемешивались
+	if _, err := s.f.Write(data); err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита, %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.f.Close()
+}
@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+)
+
+// Типы метрик в записях журнала.
+const (
+	gaugeType   = "gauge"
+	counterType = "counter"
+)
+
+// Storage, интерфейс хранилища метрик (совпадает с handlers.Storage).
+type Storage interface {
+	GetGauge(ctx context.Context, name string) (models.Gauge, error)
+	GetCounter(ctx context.Context, name string) (models.Counter, error)
+	Ping(ctx context.Context) error
+	ToList(ctx context.Context) ([]string, error)
+	Items(ctx context.Context) ([]models.MetricInfo, error)
+	SetGauge(ctx context.Context, name string, val float64) error
+	SetCounter(ctx context.Context, name string, val int64) error
+	SaveGaugesBatch(context.Context, map[string]models.Gauge) error
+	SaveCountersBatch(context.Context, map[string]models.Counter) error
+	SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error
+}
+
+// AuditedStorage, хранилище, записывающее каждое изменение метрики в журнал аудита:
+// прежнее значение метрики и новое значение gauge или приращение counter из запроса.
+//
+// Записи изменения пишутся до записи в хранилище: если журнал недоступен, изменение
+// не сохраняется и возвращается ошибка, так что неучтенных в журнале изменений не бывает.
+// После записи в хранилище пишется запись OpResult с тем же CallID: ok или error
+// (например, изменение отклонено квотой), по ней отклоненные изменения отличаются от сохраненных.
+type AuditedStorage struct {
+	Storage
+	sink Sink
+}
+
+// NewStorage, оборачивает хранилище журналированием изменений.
+func NewStorage(inner Storage, sink Sink) *AuditedStorage {
+	return &AuditedStorage{Storage: inner, sink: sink}
+}
+
+func (s *AuditedStorage) SetGauge(ctx context.Context, name string, val float64) error {
+	return s.audited(ctx, OpUpdate, map[string]models.Gauge{name: models.Gauge(val)}, nil, func() error {
+		return s.Storage.SetGauge(ctx, name, val)
+	})
+}
+
+func (s *AuditedStorage) SetCounter(ctx context.Context, name string, val int64) error {
+	return s.audited(ctx, OpUpdate, nil, map[string]models.Counter{name: models.Counter(val)}, func() error {
+		return s.Storage.SetCounter(ctx, name, val)
+	})
+}
+
+func (s *AuditedStorage) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	return s.audited(ctx, OpBatch, gauges, nil, func() error {
+		return s.Storage.SaveGaugesBatch(ctx, gauges)
+	})
+}
+
+func (s *AuditedStorage) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	return s.audited(ctx, OpBatch, nil, counters, func() error {
+		return s.Storage.SaveCountersBatch(ctx, counters)
+	})
+}
+
+func (s *AuditedStorage) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	return s.audited(ctx, OpBatch, gauges, counters, func() error {
+		return s.Storage.SaveBatch(ctx, gauges, counters)
+	})
+}
+
+// audited, записывает изменения в журнал, выполняет apply и записывает его результат.
+// Если результат записать не удалось, ошибка только логируется: изменение уже выполнено,
+// и повтор запроса клиентом применил бы приращения counter дважды.
+func (s *AuditedStorage) audited(ctx context.Context, op string, gauges map[string]models.Gauge, counters map[string]models.Counter, apply func() error) error {
+	callID, err := newCallID()
+	if err != nil {
+		return fmt.Errorf("изменение не записано в журнал аудита, %w", err)
+	}
+
+	if err := s.logGauges(ctx, op, callID, gauges); err != nil {
+		return err
+	}
+	if err := s.logCounters(ctx, op, callID, counters); err != nil {
+		return err
+	}
+
+	applyErr := apply()
+
+	rec := NewRecord(ctx, OpResult)
+	rec.CallID = callID
+	rec.Result = ResultOK
+	if applyErr != nil {
+		rec.Result = ResultError
+		rec.Details = applyErr.Error()
+	}
+	if err := s.sink.Write(ctx, rec); err != nil {
+		logger.Log.Errorf("результат изменения %s не записан в журнал аудита, %s", callID, err.Error())
+	}
+
+	return applyErr
+}
+
+func (s *AuditedStorage) logGauges(ctx context.Context, op string, callID string, gauges map[string]models.Gauge) error {
+	for _, name := range util.SortedKeys(gauges) {
+		v := float64(gauges[name])
+		rec := NewRecord(ctx, op)
+		rec.CallID = callID
+		rec.MType = gaugeType
+		rec.Metric = name
+		rec.Value = &v
+		if old, err := s.Storage.GetGauge(ctx, name); err == nil {
+			o := float64(old)
+			rec.OldValue = &o
+		}
+
+		if err := s.sink.Write(ctx, rec); err != nil {
+			return fmt.Errorf("изменение не записано в журнал аудита, %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *AuditedStorage) logCounters(ctx context.Context, op string, callID string, counters map[string]models.Counter) error {
+	for _, name := range util.SortedKeys(counters) {
+		d := int64(counters[name])
+		rec := NewRecord(ctx, op)
+		rec.CallID = callID
+		rec.MType = counterType
+		rec.Metric = name
+		rec.Delta = &d
+		if old, err := s.Storage.GetCounter(ctx, name); err == nil {
+			o := int64(old)
+			rec.OldCounter = &o
+		}
+
+		if err := s.sink.Write(ctx, rec); err != nil {
+			return fmt.Errorf("изменение не записано в журнал аудита, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// newCallID, случайный идентификатор изменения.
+func newCallID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("ошибка формирования идентификатора, %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Log, записывает в журнал произвольную операцию. Журнал не задан - ничего не делает.
+func Log(ctx context.Context, sink Sink, rec Record) error {
+	if sink == nil {
+		return nil
+	}
+
+	if err := sink.Write(ctx, rec); err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// Аутентификация клиентов по API-ключам с областями доступа (scopes).
+// Сервер хранит только sha256-хэши ключей: в файле или в таблице БД.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scope, область доступа API-ключа.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"  // чтение метрик
+	ScopeWrite Scope = "write" // запись метрик
+	ScopeAdmin Scope = "admin" // администрирование, включает чтение и запись
+)
+
+const keyPrefix = "mck_"
+
+var (
+	// ErrUnauthorized, ключ не передан, неизвестен или отозван.
+	ErrUnauthorized = errors.New("неверный API-ключ")
+	// ErrForbidden, у ключа нет нужной области доступа.
+	ErrForbidden = errors.New("недостаточно прав")
+	// ErrNotFound, ключ не найден в хранилище.
+	ErrNotFound = errors.New("API-ключ не найден")
+	// ErrUnknownScope, неизвестная область доступа.
+	ErrUnknownScope = errors.New("неизвестная область доступа")
+)
+
+// Key, сведения об API-ключе. Сам ключ не хранится, только его хэш.
+type Key struct {
+	ID        string     `json:"id"`
+	Hash      string     `json:"hash"`
+	Scopes    []Scope    `json:"scopes"`
+	Tenant    string     `json:"tenant,omitempty"` // тенант, в который пишет/из которого читает ключ
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Allows, проверяет, разрешена ли ключу область доступа.
+func (k Key) Allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Store, хранилище API-ключей.
+type Store interface {
+	Create(ctx context.Context, key Key) error
+	Revoke(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Key, error)
+	FindByHash(ctx context.Context, hash string) (Key, error)
+}
+
+// ParseScopes, разбирает список областей доступа через запятую.
+func ParseScopes(value string) ([]Scope, error) {
+	var scopes []Scope
+
+	for _, v := range strings.Split(value, ",") {
+		s := Scope(strings.TrimSpace(v))
+		switch s {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+			scopes = append(scopes, s)
+		case "":
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownScope, s)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: список пуст", ErrUnknownScope)
+	}
+
+	return scopes, nil
+}
+
+// HashKey, вычисляет хэш API-ключа для хранения и поиска.
+func HashKey(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// Generate, создает новый API-ключ. Секрет возвращается один раз и нигде не сохраняется.
+func Generate(scopes []Scope, tenant string) (string, Key, error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", Key{}, fmt.Errorf("ошибка генерации ключа, %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", Key{}, fmt.Errorf("ошибка генерации ключа, %w", err)
+	}
+
+	key := Key{
+		ID:        hex.EncodeToString(id),
+		Scopes:    scopes,
+		Tenant:    tenant,
+		CreatedAt: time.Now().UTC(),
+	}
+	value := keyPrefix + key.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = HashKey(value)
+
+	return value, key, nil
+}
+
+// Authenticate, находит действующий ключ и проверяет его область доступа.
+func Authenticate(ctx context.Context, store Store, secret string, scope Scope) (Key, error) {
+	if secret == "" {
+		return Key{}, ErrUnauthorized
+	}
+
+	key, err := store.FindByHash(ctx, HashKey(secret))
+	if errors.Is(err, ErrNotFound) {
+		return Key{}, ErrUnauthorized
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("ошибка поиска API-ключа, %w", err)
+	}
+
+	if key.RevokedAt != nil {
+		return Key{}, ErrUnauthorized
+	}
+
+	if !key.Allows(scope) {
+		return key, ErrForbidden
+	}
+
+	return key, nil
+}
+
+type ctxKey struct{}
+
+// WithKey, возвращает контекст с ключом, которым аутентифицирован запрос.
+func WithKey(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, ctxKey{}, key)
+}
+
+// FromContext, возвращает ключ, которым аутентифицирован запрос.
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(ctxKey{}).(Key)
+	return key, ok
+}
+
+// DBLocation, значение настройки расположения ключей, при котором они хранятся в БД.
+const DBLocation = "db"
+
+// OpenStore, открывает хранилище ключей: таблицу в БД (location = "db") или json-файл по пути location.
+func OpenStore(ctx context.Context, location string, dsn string) (Store, error) {
+	if location != DBLocation {
+		return NewFileStore(location), nil
+	}
+
+	if dsn == "" {
+		return nil, errors.New("для хранения API-ключей в БД не задана строка подключения")
+	}
+
+	return NewDBStore(ctx, dsn)
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []Scope
+		wantErr error
+	}{
+		{name: "single", value: "read", want: []Scope{ScopeRead}},
+		{name: "several with spaces", value: "read, write", want: []Scope{ScopeRead, ScopeWrite}},
+		{name: "unknown", value: "read,root", wantErr: ErrUnknownScope},
+		{name: "empty", value: "", wantErr: ErrUnknownScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScopes(tt.value)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(filepath.Join(t.TempDir(), "keys.json"))
+
+	writer, writerKey, err := Generate([]Scope{ScopeWrite}, "team-a")
+	require.NoError(t, err)
+	require.NoError(t, store.Create(ctx, writerKey))
+
+	admin, adminKey, err := Generate([]Scope{ScopeAdmin}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Create(ctx, adminKey))
+
+	revoked, revokedKey, err := Generate([]Scope{ScopeRead}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Create(ctx, revokedKey))
+	require.NoError(t, store.Revoke(ctx, revokedKey.ID))
+
+	tests := []struct {
+		name    string
+		secret  string
+		scope   Scope
+		wantID  string
+		wantErr error
+	}{
+		{name: "write key writes", secret: writer, scope: ScopeWrite, wantID: writerKey.ID},
+		{name: "write key can not read", secret: writer, scope: ScopeRead, wantID: writerKey.ID, wantErr: ErrForbidden},
+		{name: "admin implies read", secret: admin, scope: ScopeRead, wantID: adminKey.ID},
+		{name: "revoked key", secret: revoked, scope: ScopeRead, wantErr: ErrUnauthorized},
+		{name: "unknown key", secret: "mck_unknown", scope: ScopeRead, wantErr: ErrUnauthorized},
+		{name: "no key", scope: ScopeRead, wantErr: ErrUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Authenticate(ctx, store, tt.secret, tt.scope)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantID, key.ID)
+		})
+	}
+}
+
+func TestFileStore_Reload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	server := NewFileStore(path)
+	keys, err := server.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// ключ, созданный другим процессом (CLI), виден без перезапуска
+	secret, key, err := Generate([]Scope{ScopeRead}, "")
+	require.NoError(t, err)
+	require.NoError(t, NewFileStore(path).Create(ctx, key))
+
+	found, err := Authenticate(ctx, server, secret, ScopeRead)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/migrations"
+)
+
+// DBStore, хранит API-ключи в таблице api_keys.
+type DBStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewDBStore, подключается к БД и применяет миграции схемы (таблица api_keys).
+func NewDBStore(ctx context.Context, connString string) (*DBStore, error) {
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения соединения из пула, %w", err)
+	}
+
+	if err := migrations.Up(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ошибка миграции схемы БД, %w", err)
+	}
+
+	return &DBStore{pool: pool}, nil
+}
+
+// Close, закрывает соединения с БД.
+func (s *DBStore) Close() {
+	s.pool.Close()
+}
+
+func (s *DBStore) Create(ctx context.Context, key Key) error {
+	stmt, args, err := sq.Insert("api_keys").
+		Columns("id", "hash", "scopes", "tenant", "created_at").
+		Values(key.ID, key.Hash, scopesToStrings(key.Scopes), key.Tenant, key.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if _, err := s.pool.Exec(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("ошибка выполнения запроса, %w", err)
+	}
+
+	return nil
+}
+
+func (s *DBStore) Revoke(ctx context.Context, id string) error {
+	stmt, args, err := sq.Update("api_keys").
+		Set("revoked_at", time.Now().UTC()).
+		Where(sq.Eq{"id": id, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	tag, err := s.pool.Exec(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка выполнения запроса, %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *DBStore) List(ctx context.Context) ([]Key, error) {
+	stmt, args, err := selectKeys().OrderBy("created_at").ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	rows, err := s.pool.Query(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+	defer rows.Close()
+
+	var keys []Key
+
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *DBStore) FindByHash(ctx context.Context, hash string) (Key, error) {
+	stmt, args, err := selectKeys().Where(sq.Eq{"hash": hash}).ToSql()
+	if err != nil {
+		return Key{}, fmt.Errorf("%w", err)
+	}
+
+	key, err := scanKey(s.pool.QueryRow(ctx, stmt, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Key{}, ErrNotFound
+	}
+
+	return key, err
+}
+
+func selectKeys() sq.SelectBuilder {
+	return sq.Select("id", "hash", "scopes", "tenant", "created_at", "revoked_at").
+		From("api_keys").
+		PlaceholderFormat(sq.Dollar)
+}
+
+func scanKey(row pgx.Row) (Key, error) {
+	var (
+		key    Key
+		scopes []string
+	)
+
+	err := row.Scan(&key.ID, &key.Hash, &scopes, &key.Tenant, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return Key{}, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	for _, s := range scopes {
+		key.Scopes = append(key.Scopes, Scope(s))
+	}
+
+	return key, nil
+}
+
+func scopesToStrings(scopes []Scope) []string {
+	list := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		list = append(list, string(s))
+	}
+
+	return list
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore, хранит API-ключи в json-файле. Файл перечитывается при изменении,
+// поэтому ключи, созданные через CLI, начинают действовать без перезапуска сервера.
+type FileStore struct {
+	mx      sync.Mutex
+	path    string
+	modTime time.Time
+	keys    []Key
+}
+
+// NewFileStore, создает файловое хранилище API-ключей.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Create(_ context.Context, key Key) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	s.keys = append(s.keys, key)
+
+	return s.save()
+}
+
+func (s *FileStore) Revoke(_ context.Context, id string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for i := range s.keys {
+		if s.keys[i].ID == id {
+			now := time.Now().UTC()
+			s.keys[i].RevokedAt = &now
+
+			return s.save()
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (s *FileStore) List(_ context.Context) ([]Key, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return append([]Key(nil), s.keys...), nil
+}
+
+func (s *FileStore) FindByHash(_ context.Context, hash string) (Key, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err := s.load(); err != nil {
+		return Key{}, err
+	}
+
+	for _, k := range s.keys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+
+	return Key{}, ErrNotFound
+}
+
+// load, перечитывает файл, если он изменился с момента последнего чтения.
+func (s *FileStore) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.keys = nil
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла ключей, %w", err)
+	}
+
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла ключей, %w", err)
+	}
+
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("ошибка разбора файла ключей, %w", err)
+	}
+
+	s.keys = keys
+	s.modTime = info.ModTime()
+
+	return nil
+}
+
+// save, атомарно перезаписывает файл ключей.
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("ошибка записи файла ключей, %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ошибка записи файла ключей, %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ошибка записи файла ключей, %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("ошибка записи файла ключей, %w", err)
+	}
+
+	// после переименования файл перечитается при следующем обращении
+	s.modTime = time.Time{}
+
+	return nil
+}
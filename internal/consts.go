@@ -6,4 +6,6 @@ const (
 	MetricTypePathParam  string = "mType"
 	MetricNamePathParam  string = "mName"
 	MetricValuePathParam string = "mVal"
+	APIKeyHeader         string = "X-API-Key"  // заголовок (и ключ метаданных gRPC) с API-ключом клиента
+	AgentIDHeader        string = "X-Agent-ID" // заголовок (и ключ метаданных gRPC) с идентификатором агента
 )
@@ -0,0 +1,112 @@
+// Кодек gRPC с шифрованием сообщений агента: запросы сериализуются в protobuf
+// и упаковываются в конверт util.Encrypt. Ответы сервера передаются открыто,
+// так как у агента есть только публичный ключ.
+
+package cryptocodec
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+)
+
+// Name, название кодека (content-subtype gRPC).
+const Name = "encproto"
+
+// ErrNotEncrypted, сервер ожидает зашифрованное сообщение.
+var ErrNotEncrypted = errors.New("сообщение не зашифровано")
+
+// ClientCodec, кодек агента: шифрует исходящие запросы.
+type ClientCodec struct {
+	key *rsa.PublicKey
+}
+
+// NewClientCodec, создает кодек агента с публичным ключом из файла publicKeyPath.
+func NewClientCodec(publicKeyPath string) (*ClientCodec, error) {
+	key, err := util.LoadPublicKey(publicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить публичный ключ, %w", err)
+	}
+
+	return &ClientCodec{key: key}, nil
+}
+
+func (c *ClientCodec) Marshal(v any) ([]byte, error) {
+	data, err := marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.Encrypt(data, c.key)
+}
+
+func (c *ClientCodec) Unmarshal(data []byte, v any) error {
+	return unmarshal(data, v)
+}
+
+func (c *ClientCodec) Name() string {
+	return Name
+}
+
+// ServerCodec, кодек сервера: расшифровывает входящие запросы.
+type ServerCodec struct {
+	key *rsa.PrivateKey
+}
+
+// NewServerCodec, создает кодек сервера с приватным ключом из файла privateKeyPath.
+func NewServerCodec(privateKeyPath string) (*ServerCodec, error) {
+	key, err := util.LoadPrivateKey(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить приватный ключ, %w", err)
+	}
+
+	return &ServerCodec{key: key}, nil
+}
+
+func (c *ServerCodec) Marshal(v any) ([]byte, error) {
+	return marshal(v)
+}
+
+// Unmarshal, расшифровывает запрос. Пустые сообщения (запросы без полей) допускаются открытыми.
+func (c *ServerCodec) Unmarshal(data []byte, v any) error {
+	if len(data) == 0 {
+		return unmarshal(data, v)
+	}
+
+	if !util.IsEnvelope(data) {
+		return ErrNotEncrypted
+	}
+
+	plain, err := util.Decrypt(data, c.key)
+	if err != nil {
+		return fmt.Errorf("не удалось расшифровать сообщение, %w", err)
+	}
+
+	return unmarshal(plain, v)
+}
+
+func (c *ServerCodec) Name() string {
+	return Name
+}
+
+func marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("ожидается proto.Message, получен %T", v)
+	}
+
+	return proto.Marshal(msg)
+}
+
+func unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("ожидается proto.Message, получен %T", v)
+	}
+
+	return proto.Unmarshal(data, msg)
+}
@@ -0,0 +1,38 @@
+package cryptocodec
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+)
+
+func TestCodec(t *testing.T) {
+	client, err := NewClientCodec(filepath.Join("..", "..", "testdata", "public.pem"))
+	require.NoError(t, err)
+	server, err := NewServerCodec(filepath.Join("..", "..", "testdata", "private.pem"))
+	require.NoError(t, err)
+
+	req := &pb.UpdateMetricRequest{Id: "Alloc", Mtype: "gauge", Value: 1.5}
+
+	data, err := client.Marshal(req)
+	require.NoError(t, err)
+
+	plain, err := proto.Marshal(req)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Alloc")
+
+	var got pb.UpdateMetricRequest
+	require.NoError(t, server.Unmarshal(data, &got))
+	assert.True(t, proto.Equal(req, &got))
+
+	// открытое сообщение сервер не принимает
+	assert.ErrorIs(t, server.Unmarshal(plain, &got), ErrNotEncrypted)
+
+	// пустой запрос допускается без шифрования
+	assert.NoError(t, server.Unmarshal(nil, &pb.ListAgentsRequest{}))
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/middlewares"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// AgentHeartbeatHandler, принимает сведения об агенте (регистрация и heartbeat).
+func AgentHeartbeatHandler(reg AgentRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var info models.AgentInfo
+
+		defer func() {
+			closeErr := r.Body.Close()
+			if closeErr != nil {
+				logger.Log.Errorf("Ошибка при закрытии тела запроса, %s ", closeErr.Error())
+			}
+		}()
+
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			http.Error(w, err.Error(), middlewares.BodyErrorStatus(err, http.StatusBadRequest))
+
+			return
+		}
+
+		if err := reg.Heartbeat(info); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// AgentListHandler, возвращает список известных агентов со временем последнего heartbeat и статусом.
+func AgentListHandler(reg AgentRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(reg.List())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		_, err = w.Write(data)
+		if err != nil {
+			logger.Log.Errorf("Ошибка записи ответа, %s", err.Error())
+		}
+	}
+}
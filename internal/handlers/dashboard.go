@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// Размеры графика истории значений (в единицах viewBox).
+const (
+	sparkWidth  = 120
+	sparkHeight = 24
+)
+
+//go:embed web/templates web/static
+var webFS embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(webFS, "web/templates/dashboard.html"))
+
+// HistoryReader, интерфейс получения истории значений метрики для графиков дашборда.
+type HistoryReader interface {
+	History(ctx context.Context, mtype string, name string) []models.Point
+}
+
+type dashboardRow struct {
+	Name      string
+	MType     string
+	Value     string
+	UpdatedAt string
+	Sparkline string
+}
+
+type dashboardPage struct {
+	Tenant      string
+	Query       string
+	Type        string
+	Total       int
+	Rows        []dashboardRow
+	HasHistory  bool
+	SparkWidth  int
+	SparkHeight int
+}
+
+// staticHandler, отдает статические файлы дашборда.
+func staticHandler() http.Handler {
+	static, err := fs.Sub(webFS, "web/static")
+	if err != nil {
+		// каталог встроен в бинарник, ошибка возможна только при опечатке в пути
+		panic(err)
+	}
+
+	return http.FileServer(http.FS(static))
+}
+
+// MetricGetCurrentValuesHandler, html-страница с текущими значениями метрик тенанта.
+// Поддерживает фильтры: q - подстрока имени (без учета регистра), type - тип метрики.
+// Если передан history, для метрик выводятся графики последних значений.
+func MetricGetCurrentValuesHandler(m StorageReader, history HistoryReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		items, err := m.Items(ctx)
+		if err != nil {
+			logger.Log.Errorf("ошибка получения метрик, %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		page := dashboardPage{
+			Query:       strings.TrimSpace(r.URL.Query().Get("q")),
+			Type:        r.URL.Query().Get("type"),
+			Total:       len(items),
+			HasHistory:  history != nil,
+			SparkWidth:  sparkWidth,
+			SparkHeight: sparkHeight,
+		}
+		if name := tenant.FromContext(ctx); name != tenant.Default {
+			page.Tenant = name
+		}
+
+		query := strings.ToLower(page.Query)
+		for _, item := range items {
+			if page.Type != "" && item.MType != page.Type {
+				continue
+			}
+			if query != "" && !strings.Contains(strings.ToLower(item.Name), query) {
+				continue
+			}
+
+			row := dashboardRow{
+				Name:  item.Name,
+				MType: item.MType,
+				Value: formatValue(item),
+			}
+			if !item.UpdatedAt.IsZero() {
+				row.UpdatedAt = item.UpdatedAt.UTC().Format(time.DateTime)
+			}
+			if history != nil {
+				row.Sparkline = sparkline(history.History(ctx, item.MType, item.Name))
+			}
+
+			page.Rows = append(page.Rows, row)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		if err := dashboardTemplate.Execute(w, page); err != nil {
+			logger.Log.Errorf("Ошибка записи ответа, %s", err.Error())
+		}
+	}
+}
+
+// formatValue, строковое представление значения метрики, как в ответах /value.
+func formatValue(item models.MetricInfo) string {
+	if item.MType == internal.InCounterName {
+		return models.Counter(item.Value).ToString()
+	}
+
+	return models.Gauge(item.Value).ToString()
+}
+
+// sparkline, координаты ломаной для svg-графика значений. Меньше двух точек - без графика.
+func sparkline(points []models.Point) string {
+	if len(points) < 2 {
+		return ""
+	}
+
+	lo, hi := points[0].Value, points[0].Value
+	for _, p := range points[1:] {
+		lo = min(lo, p.Value)
+		hi = max(hi, p.Value)
+	}
+
+	var b strings.Builder
+	step := float64(sparkWidth) / float64(len(points)-1)
+	for i, p := range points {
+		// постоянное значение рисуется горизонтальной линией посередине
+		y := float64(sparkHeight) / 2
+		if hi > lo {
+			y = float64(sparkHeight) - (p.Value-lo)/(hi-lo)*float64(sparkHeight)
+		}
+
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(float64(i)*step, 'f', 1, 64))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(y, 'f', 1, 64))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+)
+
+// chunkDedupSizeDef, сколько последних сохраненных порций потока помнит сервер.
+const chunkDedupSizeDef = 4096
+
+// chunkDedup, недавно сохраненные порции потока StreamMetrics по ключу тенант/агент/порция.
+// Если поток оборвался до подтверждения, агент отправляет порцию повторно с тем же
+// идентификатором: сохраненная порция подтверждается без повторного применения, иначе
+// приращения counter учлись бы дважды. Помнятся последние size порций, после перезапуска
+// сервера повторы не распознаются.
+type chunkDedup struct {
+	mx      sync.Mutex
+	size    int
+	entries map[string]*chunkEntry
+	order   []string // ключи сохраненных порций по времени сохранения, кольцевой буфер
+	next    int
+}
+
+// chunkEntry, порция, сохранение которой начато.
+type chunkEntry struct {
+	done chan struct{} // закрывается по завершении сохранения
+	ok   bool          // порция сохранена, записывается до закрытия done
+}
+
+func newChunkDedup(size int) *chunkDedup {
+	return &chunkDedup{size: size, entries: make(map[string]*chunkEntry)}
+}
+
+// begin, начинает сохранение порции key. Если порция уже сохранена, возвращает duplicate = true.
+// Иначе возвращает entry, которую после сохранения нужно передать в finish. Если та же порция
+// сохраняется в другом потоке, ждет результата: повтор применяется, только если сохранение не удалось.
+func (d *chunkDedup) begin(ctx context.Context, key string) (entry *chunkEntry, duplicate bool, err error) {
+	for {
+		d.mx.Lock()
+		e, ok := d.entries[key]
+		if !ok {
+			e = &chunkEntry{done: make(chan struct{})}
+			d.entries[key] = e
+			d.mx.Unlock()
+
+			return e, false, nil
+		}
+		d.mx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-e.done:
+		}
+		if e.ok {
+			return nil, true, nil
+		}
+	}
+}
+
+// finish, завершает сохранение порции key. Несохраненная порция забывается и может быть применена повторно.
+func (d *chunkDedup) finish(key string, e *chunkEntry, ok bool) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	e.ok = ok
+	close(e.done)
+	if !ok {
+		delete(d.entries, key)
+		return
+	}
+
+	if len(d.order) < d.size {
+		d.order = append(d.order, key)
+		return
+	}
+	delete(d.entries, d.order[d.next])
+	d.order[d.next] = key
+	d.next = (d.next + 1) % d.size
+}
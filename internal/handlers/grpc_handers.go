@@ -4,20 +4,52 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/listing"
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/pubsub"
+	"github.com/ShvetsovYura/metrics-collector/internal/resilience"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
 	pb "github.com/ShvetsovYura/metrics-collector/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// storageErrorToStatus, преобразует ошибку записи в хранилище в статус gRPC. Превышение
+// квоты - не ограничение частоты (ResourceExhausted): повтор запроса не поможет.
+func storageErrorToStatus(err error) error {
+	if errors.Is(err, tenant.ErrQuotaExceeded) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, resilience.ErrUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
 type MetricServer struct {
 	pb.UnimplementedMetricsServer
 	metrics Storage
+	agents  AgentRegistry
+	hub     *pubsub.Hub
+	chunks  *chunkDedup
 }
 
-func NewMetricServer(store Storage) *MetricServer {
-	return &MetricServer{metrics: store}
+// NewMetricServer, создает gRPC-сервис метрик. Из opts используется шина обновлений (WithUpdatesHub) для Watch.
+func NewMetricServer(store Storage, agents AgentRegistry, opts ...RouterOption) *MetricServer {
+	cfg := &routerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return &MetricServer{metrics: store, agents: agents, hub: cfg.hub, chunks: newChunkDedup(chunkDedupSizeDef)}
 }
 
 // ListMetrics реализует интерфейс получения списка метрик.
@@ -32,6 +64,29 @@ func (s *MetricServer) ListMetricsValues(ctx context.Context, in *pb.ListMetrics
 	}, nil
 }
 
+// ListMetrics, страница списка метрик с фильтрацией по имени и типу и сортировкой.
+func (s *MetricServer) ListMetrics(ctx context.Context, in *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	items, err := s.metrics.Items(ctx)
+	if err != nil {
+		logger.Log.Errorf("ошибка получения метрик, %s", err.Error())
+		return nil, status.Error(codes.Internal, "ошибка получения метрик")
+	}
+
+	page, err := listing.Apply(items, listing.Query{
+		Prefix: in.GetPrefix(),
+		Regex:  in.GetRegex(),
+		Types:  in.GetTypes(),
+		Sort:   in.GetSort(),
+		Limit:  int(in.GetLimit()),
+		Offset: int(in.GetOffset()),
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return listResponseProto(page), nil
+}
+
 func (s *MetricServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
 	var response pb.UpdateMetricResponse
 	logger.Log.Debug("metric type %v", in.Mtype)
@@ -40,7 +95,7 @@ func (s *MetricServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequ
 		err := s.metrics.SetGauge(ctx, in.Id, float64(in.Value))
 		if err != nil {
 			logger.Log.Errorf("Ошибка установки значения для gauge: %s, значение: %f. %s", in.Id, in.Value, err.Error())
-
+			return nil, storageErrorToStatus(err)
 		}
 
 		currentVal, _ := s.metrics.GetGauge(ctx, in.Id)
@@ -55,6 +110,7 @@ func (s *MetricServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequ
 		err := s.metrics.SetCounter(ctx, in.Id, in.Delta)
 		if err != nil {
 			logger.Log.Errorf("Ошибка установки значения для gauge: %s, значение: %f. %s", in.Id, in.Value, err.Error())
+			return nil, storageErrorToStatus(err)
 		}
 
 		currentVal, _ := s.metrics.GetCounter(ctx, in.Id)
@@ -73,14 +129,81 @@ func (s *MetricServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequ
 }
 
 func (s *MetricServer) BatchUpdateMetrics(ctx context.Context, in *pb.BatchUpdateMtericsRequest) (*pb.BatchUpdateMetricsResponse, error) {
-	var response pb.BatchUpdateMetricsResponse
+	if err := s.saveMetrics(ctx, in.Metrics); err != nil {
+		return nil, err
+	}
+
+	return &pb.BatchUpdateMetricsResponse{}, nil
+}
+
+// StreamMetrics, принимает метрики агента порциями через открытый поток.
+// На каждую порцию отправляется подтверждение с ее номером и результатом сохранения:
+// ошибка сохранения порции не закрывает поток. Порция с уже сохраненным chunk_id
+// не применяется повторно и подтверждается с признаком duplicate.
+func (s *MetricServer) StreamMetrics(stream grpc.BidiStreamingServer[pb.MetricsChunk, pb.ChunkAck]) error {
+	ctx := stream.Context()
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
+		ack := &pb.ChunkAck{Seq: chunk.GetSeq()}
+		duplicate, err := s.saveChunk(ctx, chunk)
+		if err != nil {
+			st := status.Convert(err)
+			ack.Code = uint32(st.Code())
+			ack.Error = st.Message()
+		}
+		ack.Duplicate = duplicate
+
+		if err := stream.Send(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// saveChunk, сохраняет порцию потока, если порция с тем же chunk_id от агента еще не сохранена.
+// Порции без chunk_id сохраняются всегда.
+func (s *MetricServer) saveChunk(ctx context.Context, chunk *pb.MetricsChunk) (duplicate bool, err error) {
+	if chunk.GetChunkId() == "" {
+		return false, s.saveMetrics(ctx, chunk.GetMetrics())
+	}
+
+	var agentID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(internal.AgentIDHeader); len(v) > 0 {
+			agentID = v[0]
+		}
+	}
+	key := tenant.FromContext(ctx) + "\x00" + agentID + "\x00" + chunk.GetChunkId()
+
+	entry, duplicate, err := s.chunks.begin(ctx, key)
+	if err != nil {
+		return false, status.FromContextError(err).Err()
+	}
+	if duplicate {
+		return true, nil
+	}
+
+	err = s.saveMetrics(ctx, chunk.GetMetrics())
+	s.chunks.finish(key, entry, err == nil)
+
+	return false, err
+}
+
+// saveMetrics, сохраняет набор метрик: значения counter с одинаковым именем суммируются.
+func (s *MetricServer) saveMetrics(ctx context.Context, metrics []*pb.Metric) error {
 	var (
 		gauges   = make(map[string]models.Gauge, 100)
 		counters = make(map[string]models.Counter, 100)
 	)
 
-	for _, mdl := range in.Metrics {
+	for _, mdl := range metrics {
 		switch mdl.Mtype {
 		case internal.InGaugeName:
 			gauges[mdl.Id] = models.Gauge(mdl.Value)
@@ -92,19 +215,12 @@ func (s *MetricServer) BatchUpdateMetrics(ctx context.Context, in *pb.BatchUpdat
 			}
 		}
 	}
-	var err error
-	err = s.metrics.SaveCountersBatch(ctx, counters)
-	if err != nil {
-		logger.Log.Error(err.Error())
-	}
-
-	err = s.metrics.SaveGaugesBatch(ctx, gauges)
-	if err != nil {
+	if err := s.metrics.SaveBatch(ctx, gauges, counters); err != nil {
 		logger.Log.Error(err.Error())
+		return storageErrorToStatus(err)
 	}
-	response = pb.BatchUpdateMetricsResponse{}
 
-	return &response, nil
+	return nil
 }
 
 func (s *MetricServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
@@ -138,3 +254,50 @@ func (s *MetricServer) DBPing(ctx context.Context, in *pb.DbPingRequest) (*pb.Db
 	}
 	return &pb.DbPingResponse{}, nil
 }
+
+// RegisterAgent регистрирует агента или обновляет сведения о нем (heartbeat).
+func (s *MetricServer) RegisterAgent(ctx context.Context, in *pb.RegisterAgentRequest) (*pb.RegisterAgentResponse, error) {
+	if s.agents == nil {
+		return nil, status.Error(codes.Unimplemented, "реестр агентов не подключен")
+	}
+
+	a := in.GetAgent()
+	err := s.agents.Heartbeat(models.AgentInfo{
+		ID:         a.GetId(),
+		Hostname:   a.GetHostname(),
+		Version:    a.GetVersion(),
+		IP:         a.GetIp(),
+		Collectors: a.GetCollectors(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &pb.RegisterAgentResponse{}, nil
+}
+
+// ListAgents возвращает список известных агентов со временем последнего heartbeat и статусом.
+func (s *MetricServer) ListAgents(ctx context.Context, in *pb.ListAgentsRequest) (*pb.ListAgentsResponse, error) {
+	if s.agents == nil {
+		return nil, status.Error(codes.Unimplemented, "реестр агентов не подключен")
+	}
+
+	list := s.agents.List()
+	agents := make([]*pb.AgentStatus, 0, len(list))
+
+	for _, a := range list {
+		agents = append(agents, &pb.AgentStatus{
+			Info: &pb.AgentInfo{
+				Id:         a.ID,
+				Hostname:   a.Hostname,
+				Version:    a.Version,
+				Ip:         a.IP,
+				Collectors: a.Collectors,
+			},
+			LastSeen: timestamppb.New(a.LastSeen),
+			Status:   a.Status,
+		})
+	}
+
+	return &pb.ListAgentsResponse{Agents: agents}, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/pubsub"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+)
+
+// watchEvent, событие Watch со значением метрики.
+func watchEvent(item models.MetricInfo, cursor pubsub.Cursor) *pb.WatchEvent {
+	metric := &pb.Metric{Id: item.Name, Mtype: item.MType}
+	if item.MType == internal.InCounterName {
+		metric.Delta = int64(item.Value)
+	} else {
+		metric.Value = item.Value
+	}
+
+	event := &pb.WatchEvent{Metric: metric, Cursor: cursor.String()}
+	if !item.UpdatedAt.IsZero() {
+		event.UpdatedAt = timestamppb.New(item.UpdatedAt)
+	}
+
+	return event
+}
+
+// Watch, поток изменений метрик тенанта, подходящих под фильтр имен.
+//
+// Без курсора сначала отправляется снимок текущих значений (snapshot), затем событие synced
+// и далее изменения. С курсором прошлой подписки повторяются пропущенные изменения,
+// а если их уже нет в буфере сервера - отправляется снимок. Если клиент читает медленно
+// и обновления отбрасываются, отправляется новый снимок с числом пропущенных (dropped).
+func (s *MetricServer) Watch(in *pb.WatchRequest, stream grpc.ServerStreamingServer[pb.WatchEvent]) error {
+	if s.hub == nil {
+		return status.Error(codes.Unimplemented, "подписка на изменения не подключена")
+	}
+
+	patterns, err := pubsub.ParsePatterns(in.GetNames()...)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	from, err := pubsub.ParseCursor(in.GetCursor())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx := stream.Context()
+	sub, missed, resumed := s.hub.SubscribeFrom(tenant.FromContext(ctx), patterns, pubsub.DefaultBuffer, from)
+	defer sub.Close()
+
+	// sent, курсор последнего отправленного состояния: более старые обновления из очереди пропускаются
+	sent := sub.Start()
+	if resumed {
+		for _, u := range missed {
+			if err := stream.Send(watchEvent(u.MetricInfo, u.Cursor)); err != nil {
+				return err
+			}
+		}
+		if err := stream.Send(&pb.WatchEvent{Cursor: sent.String(), Synced: true}); err != nil {
+			return err
+		}
+	} else if err := s.sendSnapshot(stream, sub, sent, 0); err != nil {
+		return err
+	}
+
+	var dropped int64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case u, ok := <-sub.C():
+			if !ok {
+				return status.Error(codes.Unavailable, "сервер остановлен")
+			}
+
+			// очередь переполнялась: часть изменений потеряна, состояние восстанавливается снимком
+			if d := sub.Dropped(); d > dropped {
+				logger.Log.Infof("подписчик Watch не успевает читать, пропущено %d обновлений", d-dropped)
+				sent = s.hub.Cursor(tenant.FromContext(ctx))
+				if err := s.sendSnapshot(stream, sub, sent, uint64(d-dropped)); err != nil {
+					return err
+				}
+				dropped = d
+			}
+
+			if u.Cursor.Seq <= sent.Seq {
+				continue
+			}
+			if err := stream.Send(watchEvent(u.MetricInfo, u.Cursor)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendSnapshot, отправляет текущие значения метрик, подходящих под подписку,
+// и событие synced с курсором снимка и числом пропущенных обновлений.
+func (s *MetricServer) sendSnapshot(stream grpc.ServerStreamingServer[pb.WatchEvent], sub *pubsub.Subscription, cursor pubsub.Cursor, dropped uint64) error {
+	items, err := s.metrics.Items(stream.Context())
+	if err != nil {
+		logger.Log.Errorf("ошибка получения метрик, %s", err.Error())
+		return status.Error(codes.Internal, "ошибка получения метрик")
+	}
+
+	for _, item := range items {
+		if !sub.Match(item.Name) {
+			continue
+		}
+
+		event := watchEvent(item, cursor)
+		event.Snapshot = true
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	return stream.Send(&pb.WatchEvent{Cursor: cursor.String(), Synced: true, Dropped: dropped})
+}
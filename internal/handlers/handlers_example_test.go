@@ -9,15 +9,18 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/handlers"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/signature"
 	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
 )
 
 func ExampleDBPingHandler() {
 	s := storage.NewMemory(10)
-	routes := handlers.ServerRouter(s, "abc", "", "")
+	routes := handlers.ServerRouter(s, "abc", "", nil)
 	ts := httptest.NewServer(routes)
 
 	defer ts.Close()
@@ -45,7 +48,7 @@ func ExampleDBPingHandler() {
 
 func ExampleMetricBatchUpdateHandler() {
 	s := storage.NewMemory(10)
-	routes := handlers.ServerRouter(s, "key", "", "")
+	routes := handlers.ServerRouter(s, "key", "", nil)
 	ts := httptest.NewServer(routes)
 
 	defer ts.Close()
@@ -75,7 +78,9 @@ func ExampleMetricBatchUpdateHandler() {
 		log.Fatalf("не удалось преобразовать в json, %s", err.Error())
 	}
 
+	hash := util.Hash(body.Bytes(), "key")
 	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/updates/", &body)
+	req.Header.Set(signature.Header, hash)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -106,7 +111,7 @@ func ExampleMetricGetCurrentValuesHandler() {
 
 	_ = s.SetCounter(ctx, "count", 4)
 
-	routes := handlers.ServerRouter(s, "key", "", "")
+	routes := handlers.ServerRouter(s, "key", "", nil)
 	ts := httptest.NewServer(routes)
 
 	defer ts.Close()
@@ -126,9 +131,16 @@ func ExampleMetricGetCurrentValuesHandler() {
 	}()
 
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Println(string(body))
+	fmt.Println(resp.Header.Get("Content-Type"))
+	for _, name := range []string{"freeSpace", "maxLoad", "memTotal", "count"} {
+		fmt.Println(name, strings.Contains(string(body), name))
+	}
 	// Output:
-	// 9563738.322, 97.34, 345.43, 4
+	// text/html; charset=utf-8
+	// freeSpace true
+	// maxLoad true
+	// memTotal true
+	// count true
 }
 
 func ExampleMetricGetValueHandlerWithBody() {
@@ -140,7 +152,7 @@ func ExampleMetricGetValueHandlerWithBody() {
 		"maxLoad":   97.34,
 	}
 	s.SetGauges(ctx, gauges)
-	routes := handlers.ServerRouter(s, "key", "", "")
+	routes := handlers.ServerRouter(s, "key", "", nil)
 	ts := httptest.NewServer(routes)
 
 	defer ts.Close()
@@ -150,6 +162,7 @@ func ExampleMetricGetValueHandlerWithBody() {
 	})
 	reqBody := bytes.NewBuffer(reqBytes)
 	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/value/", reqBody)
+	req.Header.Set(signature.Header, util.Hash(reqBytes, "key"))
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -179,7 +192,7 @@ func ExampleMetricGetValueHandlerWithBody() {
 
 func ExampleMetricUpdateHandlerWithBody() {
 	s := storage.NewMemory(10)
-	r := handlers.ServerRouter(s, "key", "", "")
+	r := handlers.ServerRouter(s, "key", "", nil)
 	ts := httptest.NewServer(r)
 
 	defer ts.Close()
@@ -192,6 +205,7 @@ func ExampleMetricUpdateHandlerWithBody() {
 	})
 	reqBuf := bytes.NewBuffer(reqBytes)
 	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/update/", reqBuf)
+	req.Header.Set(signature.Header, util.Hash(reqBytes, "key"))
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -223,7 +237,7 @@ func ExampleMetricGetValueHandler() {
 		"usedSpace": 134672046.234,
 	}
 	s.SetGauges(ctx, gauges)
-	r := handlers.ServerRouter(s, "key", "", "")
+	r := handlers.ServerRouter(s, "key", "", nil)
 	ts := httptest.NewServer(r)
 
 	defer ts.Close()
@@ -254,12 +268,48 @@ func ExampleMetricGetValueHandler() {
 
 func ExampleMetricUpdateHandler() {
 	s := storage.NewMemory(10)
-	r := handlers.ServerRouter(s, "key", "", "")
+	r := handlers.ServerRouter(s, "key", "", nil)
+	ts := httptest.NewServer(r)
+
+	defer ts.Close()
+
+	path := "/update/gauge/allocMem/2139.43"
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, nil)
+	// у запроса без тела подписываются метод и путь
+	req.Header.Set(signature.Header, util.Hash(signature.RequestLine(http.MethodPost, path), "key"))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	defer func() {
+		err = resp.Body.Close()
+		if err != nil {
+			fmt.Printf("ошибка при закрытии тела запроса, %s", err.Error())
+		}
+	}()
+
+	fmt.Println(resp.Status)
+	// Output:
+	// 200 OK
+}
+
+func ExampleMetricListHandler() {
+	s := storage.NewMemory(10)
+	ctx := context.Background()
+	gauges := map[string]float64{
+		"allogMem":  3718.23,
+		"freeMem":   1528.30,
+		"usedSpace": 134672046.234,
+	}
+	s.SetGauges(ctx, gauges)
+	r := handlers.ServerRouter(s, "", "", nil)
 	ts := httptest.NewServer(r)
 
 	defer ts.Close()
 
-	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/update/gauge/allocMem/2139.43", nil)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/list?regex=Mem$&sort=-value&limit=1", nil)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -273,7 +323,21 @@ func ExampleMetricUpdateHandler() {
 		}
 	}()
 
+	var page struct {
+		Metrics    []models.MetricInfo `json:"metrics"`
+		Total      int                 `json:"total"`
+		NextOffset int                 `json:"next_offset"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		fmt.Println(err.Error())
+	}
+
 	fmt.Println(resp.Status)
+	fmt.Println(page.Total, page.NextOffset)
+	fmt.Println(page.Metrics[0].Name, page.Metrics[0].MType, page.Metrics[0].Value)
+
 	// Output:
 	// 200 OK
+	// 2 1
+	// allogMem gauge 3718.23
 }
@@ -1,25 +1,47 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/audit"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/pubsub"
+	"github.com/ShvetsovYura/metrics-collector/internal/ratelimit"
+	"github.com/ShvetsovYura/metrics-collector/internal/registry"
+	"github.com/ShvetsovYura/metrics-collector/internal/signature"
 	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
 )
 
 type wantGauge struct {
@@ -67,7 +89,7 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string, data []
 func TestMetricSetGaugeHandler(t *testing.T) {
 	mem := storage.NewMemory(40)
 	fs := storage.NewFile("tt.txt", mem, false, 0)
-	router := ServerRouter(fs, "", "", "")
+	router := ServerRouter(fs, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer ts.Close()
@@ -157,7 +179,7 @@ type wantCounter struct {
 
 func TestMetricSetCounterHandler(t *testing.T) {
 	m := storage.NewMemory(40)
-	router := ServerRouter(m, "", "", "")
+	router := ServerRouter(m, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer ts.Close()
@@ -239,7 +261,7 @@ func TestMetricGetValueHandler(t *testing.T) {
 		t.Fatalf("не удалось установить метрику, %s", err.Error())
 	}
 
-	router := ServerRouter(m, "", "", "")
+	router := ServerRouter(m, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer ts.Close()
@@ -288,7 +310,7 @@ func TestMetricGetAllValueHandler1(t *testing.T) {
 		t.Fatalf("не удалось установить метрику, %s", err.Error())
 	}
 
-	router := ServerRouter(m, "", "", "")
+	router := ServerRouter(m, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer ts.Close()
@@ -322,7 +344,7 @@ func TestMetricUpdateHandler(t *testing.T) {
 	fsPath := "/tmp/myFileStorage.txt"
 	fs := storage.NewFile(fsPath, mem, true, 0)
 
-	router := ServerRouter(fs, "", "", "")
+	router := ServerRouter(fs, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer func() {
@@ -420,7 +442,7 @@ func TestMetricValueHandler(t *testing.T) {
 		t.Fatalf("не удалось установить метрику, %s", err.Error())
 	}
 
-	router := ServerRouter(fs, "", "", "")
+	router := ServerRouter(fs, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer func() {
@@ -512,7 +534,7 @@ func TestMetricGetAllValueHandler(t *testing.T) {
 		t.Fatalf("не удалось установить метрику, %s", err.Error())
 	}
 
-	router := ServerRouter(fs, "", "", "")
+	router := ServerRouter(fs, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer func() {
@@ -552,7 +574,7 @@ func TestMetricGetAllValueHandler(t *testing.T) {
 
 func TestMetricBatchUpdateHandler(t *testing.T) {
 	mem := storage.NewMemory(40)
-	router := ServerRouter(mem, "", "", "")
+	router := ServerRouter(mem, "", "", nil)
 	ts := httptest.NewServer(router)
 
 	defer func() {
@@ -617,3 +639,641 @@ func TestMetricBatchUpdateHandler(t *testing.T) {
 		assert.Equal(t, test.wantStatus, resp.StatusCode)
 	}
 }
+
+func TestAgentHandlers(t *testing.T) {
+	mem := storage.NewMemory(40)
+	router := ServerRouter(mem, "", "", nil, WithAgentRegistry(registry.NewRegistry(time.Minute)))
+	ts := httptest.NewServer(router)
+
+	defer ts.Close()
+
+	info := models.AgentInfo{
+		ID:         "agent-1",
+		Hostname:   "host-1",
+		Version:    "0.19.0",
+		IP:         "10.0.0.5",
+		Collectors: []string{"runtime", "system"},
+	}
+	reqData, _ := json.Marshal(info)
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/agents/", reqData)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/agents/", []byte(`{"hostname":"no-id"}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/agents", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var agents []models.AgentStatus
+	require.NoError(t, json.Unmarshal([]byte(body), &agents))
+	require.Len(t, agents, 1)
+	assert.Equal(t, info, agents[0].AgentInfo)
+	assert.Equal(t, models.AgentOnline, agents[0].Status)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	ctx := context.Background()
+	keys := auth.NewFileStore(filepath.Join(t.TempDir(), "keys.json"))
+
+	reader, readerKey, err := auth.Generate([]auth.Scope{auth.ScopeRead}, "")
+	require.NoError(t, err)
+	require.NoError(t, keys.Create(ctx, readerKey))
+
+	writer, writerKey, err := auth.Generate([]auth.Scope{auth.ScopeWrite}, "")
+	require.NoError(t, err)
+	require.NoError(t, keys.Create(ctx, writerKey))
+
+	router := ServerRouter(storage.NewMemory(40), "", "", nil, WithAPIKeys(keys))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		apiKey string
+		want   int
+	}{
+		{name: "no key", method: http.MethodGet, path: "/value/gauge/g1", want: http.StatusUnauthorized},
+		{name: "unknown key", method: http.MethodGet, path: "/value/gauge/g1", apiKey: "mck_unknown", want: http.StatusUnauthorized},
+		{name: "reader can not write", method: http.MethodPost, path: "/update/gauge/g1/1.5", apiKey: reader, want: http.StatusForbidden},
+		{name: "writer writes", method: http.MethodPost, path: "/update/gauge/g1/1.5", apiKey: writer, want: http.StatusOK},
+		{name: "reader reads", method: http.MethodGet, path: "/value/gauge/g1", apiKey: reader, want: http.StatusOK},
+		{name: "writer can not read", method: http.MethodGet, path: "/value/gauge/g1", apiKey: writer, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set(internal.APIKeyHeader, tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	router := ServerRouter(storage.NewMemory(40), "ring:v2:new,v1:old", "", nil)
+	body := []byte(`{"id":"g1","type":"gauge","value":1.5}`)
+
+	tests := []struct {
+		name      string
+		keyID     string
+		hash      string
+		want      int
+		wantKeyID string
+	}{
+		{name: "unsigned", want: http.StatusBadRequest},
+		{name: "forged plain sha256", hash: util.Hash(body, ""), want: http.StatusBadRequest},
+		{name: "primary key", keyID: "v2", hash: util.Hash(body, "new"), want: http.StatusOK, wantKeyID: "v2"},
+		{name: "previous key", keyID: "v1", hash: util.Hash(body, "old"), want: http.StatusOK, wantKeyID: "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.hash != "" {
+				req.Header.Set(signature.Header, tt.hash)
+			}
+			if tt.keyID != "" {
+				req.Header.Set(signature.KeyIDHeader, tt.keyID)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			require.Equal(t, tt.want, rec.Code)
+
+			if tt.want == http.StatusOK {
+				// ответ подписан тем же ключом, что и запрос
+				assert.Equal(t, tt.wantKeyID, rec.Header().Get(signature.KeyIDHeader))
+				secret := map[string]string{"v1": "old", "v2": "new"}[tt.wantKeyID]
+				assert.Equal(t, util.Hash(rec.Body.Bytes(), secret), rec.Header().Get(signature.Header))
+			}
+		})
+	}
+}
+
+func TestSignedURLUpdate(t *testing.T) {
+	mem := storage.NewMemory(40)
+	router := ServerRouter(mem, "ring:v2:new,v1:old", "", nil)
+	path := "/update/gauge/g1/1.5"
+
+	tests := []struct {
+		name string
+		hash string
+		want int
+	}{
+		{name: "unsigned", want: http.StatusBadRequest},
+		{name: "signed other path", hash: util.Hash(signature.RequestLine(http.MethodPost, "/update/gauge/g1/2.5"), "new"), want: http.StatusBadRequest},
+		{name: "signed method and path", hash: util.Hash(signature.RequestLine(http.MethodPost, path), "new"), want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, path, nil)
+			if tt.hash != "" {
+				req.Header.Set(signature.Header, tt.hash)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	// чтение без тела подписывать не нужно
+	req := httptest.NewRequest(http.MethodGet, "/value/gauge/g1", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestEncryptedBatchUpdate(t *testing.T) {
+	mem := storage.NewMemory(40)
+	router := ServerRouter(mem, "", filepath.Join("..", "..", "testdata", "private.pem"), nil)
+
+	var metrics []models.MetricItem
+	for i := 0; i < 200; i++ {
+		v := float64(i)
+		metrics = append(metrics, models.MetricItem{ID: fmt.Sprintf("gauge%d", i), MType: "gauge", Value: &v})
+	}
+	body, err := json.Marshal(metrics)
+	require.NoError(t, err)
+
+	encrypted, err := util.EncryptData(body, filepath.Join("..", "..", "testdata", "public.pem"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(encrypted))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	val, err := mem.GetGauge(context.Background(), "gauge199")
+	require.NoError(t, err)
+	assert.Equal(t, models.Gauge(199), val)
+
+	// повреждённое сообщение
+	req = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(encrypted[:len(encrypted)-1]))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	filter, err := validator.NewSubnetFilter("10.0.0.0/8", "192.168.1.1")
+	require.NoError(t, err)
+	router := ServerRouter(storage.NewMemory(40), "", "", filter)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xRealIP    string
+		want       int
+	}{
+		{name: "client in subnet", remoteAddr: "10.0.0.5:4000", want: http.StatusOK},
+		{name: "spoofed x-real-ip", remoteAddr: "203.0.113.5:4000", xRealIP: "10.0.0.5", want: http.StatusForbidden},
+		{name: "trusted proxy", remoteAddr: "192.168.1.1:4000", xRealIP: "10.0.0.5", want: http.StatusOK},
+		{name: "trusted proxy, client outside subnet", remoteAddr: "192.168.1.1:4000", xRealIP: "203.0.113.5", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/gauge/g1/1", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xRealIP != "" {
+				req.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	keys := auth.NewFileStore(filepath.Join(t.TempDir(), "keys.json"))
+	writer, writerKey, err := auth.Generate([]auth.Scope{auth.ScopeWrite}, "")
+	require.NoError(t, err)
+	require.NoError(t, keys.Create(ctx, writerKey))
+
+	send := func(router http.Handler, apiKey string, agentID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/g1/1", nil)
+		if apiKey != "" {
+			req.Header.Set(internal.APIKeyHeader, apiKey)
+		}
+		req.Header.Set(internal.AgentIDHeader, agentID)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("by verified agent", func(t *testing.T) {
+		router := ServerRouter(storage.NewMemory(40), "", "", nil,
+			WithAPIKeys(keys), WithRateLimit(ratelimit.NewLimiter(0.1, 2), ratelimit.ByAgent))
+
+		assert.Equal(t, http.StatusOK, send(router, writer, "agent-1").Code)
+		assert.Equal(t, http.StatusOK, send(router, writer, "agent-1").Code)
+
+		rec := send(router, writer, "agent-1")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+
+		// другой агент с тем же ключом не ограничен
+		assert.Equal(t, http.StatusOK, send(router, writer, "agent-2").Code)
+	})
+
+	t.Run("unverified agent is limited by ip", func(t *testing.T) {
+		router := ServerRouter(storage.NewMemory(40), "", "", nil,
+			WithRateLimit(ratelimit.NewLimiter(0.1, 2), ratelimit.ByAgent))
+
+		// новый идентификатор агента в каждом запросе не дает новой корзины
+		assert.Equal(t, http.StatusOK, send(router, "", "agent-1").Code)
+		assert.Equal(t, http.StatusOK, send(router, "", "agent-2").Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(router, "", "agent-3").Code)
+	})
+}
+
+func TestBodyLimits(t *testing.T) {
+	mem := storage.NewMemory(40)
+	router := ServerRouter(mem, "", "", nil, WithBodyLimits(1024, 4096))
+
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		body []byte
+		gzip bool
+		want int
+	}{
+		{name: "batch", body: []byte(`[{"id":"c","type":"counter","delta":2},{"id":"c","type":"counter","delta":3}]`), want: http.StatusOK},
+		{name: "body too large", body: bytes.Repeat([]byte(" "), 2048), want: http.StatusRequestEntityTooLarge},
+		{name: "gzip bomb", body: gzipped(append([]byte("["), bytes.Repeat([]byte(" "), 100<<10)...)), gzip: true, want: http.StatusRequestEntityTooLarge},
+		{name: "gzipped batch", body: gzipped([]byte(`[{"id":"g","type":"gauge","value":1.5}]`)), gzip: true, want: http.StatusOK},
+		{name: "not an array", body: []byte(`{"id":"g","type":"gauge","value":1.5}`), want: http.StatusBadRequest},
+		{name: "metric without value", body: []byte(`[{"id":"g","type":"gauge"}]`), want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	// приращения одного счетчика в пакете суммируются
+	val, err := mem.GetCounter(context.Background(), "c")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(5), val)
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.NewFileSink(path)
+	require.NoError(t, err)
+
+	s := audit.NewStorage(storage.NewMemory(40), sink)
+	filter, err := validator.NewSubnetFilter("", "192.168.1.1")
+	require.NoError(t, err)
+	router := ServerRouter(s, "", "", filter, WithAuditSink(sink))
+
+	send := func(method, target string) {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "192.168.1.1:4000"
+		req.Header.Set("X-Forwarded-For", "10.0.0.5")
+		req.Header.Set(internal.AgentIDHeader, "agent-1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	send(http.MethodPost, "/update/counter/c1/2")
+	send(http.MethodPost, "/update/counter/c1/3")
+	send(http.MethodGet, "/debug/pprof/")
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 5)
+
+	var records []audit.Record
+	for _, line := range lines {
+		var rec audit.Record
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		records = append(records, rec)
+	}
+
+	assert.Equal(t, audit.OpUpdate, records[2].Op)
+	assert.Equal(t, "c1", records[2].Metric)
+	assert.Equal(t, int64(3), *records[2].Delta)
+	assert.Equal(t, int64(2), *records[2].OldCounter)
+	assert.Equal(t, "10.0.0.5", records[2].IP)
+	assert.Equal(t, "agent-1", records[2].AgentID)
+	assert.Equal(t, audit.TransportHTTP, records[2].Transport)
+
+	assert.Equal(t, audit.OpResult, records[3].Op)
+	assert.Equal(t, audit.ResultOK, records[3].Result)
+	assert.Equal(t, records[2].CallID, records[3].CallID)
+
+	assert.Equal(t, audit.OpAdmin, records[4].Op)
+	assert.Equal(t, "GET /debug/pprof/", records[4].Details)
+}
+
+type stubHistory map[string][]models.Point
+
+func (h stubHistory) History(_ context.Context, _ string, name string) []models.Point {
+	return h[name]
+}
+
+func TestDashboard(t *testing.T) {
+	mem := storage.NewMemory(40)
+	ctx := context.Background()
+	require.NoError(t, mem.SetGauge(ctx, "HeapAlloc", 1.5))
+	require.NoError(t, mem.SetGauge(ctx, "HeapSys", 7))
+	require.NoError(t, mem.SetCounter(ctx, "PollCount", 3))
+
+	now := time.Now()
+	history := stubHistory{"HeapAlloc": {{Time: now, Value: 1}, {Time: now, Value: 3}, {Time: now, Value: 2}}}
+	router := ServerRouter(mem, "", "", nil, WithHistory(history))
+
+	tests := []struct {
+		name    string
+		url     string
+		want    []string
+		notWant []string
+	}{
+		{name: "all metrics", url: "/", want: []string{"HeapAlloc", "HeapSys", "PollCount", "показано 3 из 3", `points="0.0,24.0 60.0,0.0 120.0,12.0"`}},
+		{name: "search by name", url: "/?q=heap", want: []string{"HeapAlloc", "HeapSys", "показано 2 из 3"}, notWant: []string{"PollCount"}},
+		{name: "filter by type", url: "/?type=counter", want: []string{"PollCount", "показано 1 из 3"}, notWant: []string{"HeapAlloc"}},
+		{name: "nothing found", url: "/?q=missing", want: []string{"Метрик не найдено"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+			for _, v := range tt.want {
+				assert.Contains(t, rec.Body.String(), v)
+			}
+			for _, v := range tt.notWant {
+				assert.NotContains(t, rec.Body.String(), v)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/dashboard.css", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/css")
+}
+
+func TestMetricStream(t *testing.T) {
+	hub := pubsub.NewHub(0)
+	mem := storage.NewMemory(40)
+	s := pubsub.NewStorage(mem, hub)
+	require.NoError(t, s.SetGauge(context.Background(), "HeapAlloc", 1.5))
+
+	// с ключом подписи: потоковый ответ отправляется без буферизации
+	ts := httptest.NewServer(ServerRouter(s, "key", "", nil, WithUpdatesHub(hub)))
+	defer ts.Close()
+	defer hub.Close()
+
+	update := func(url string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+url, nil)
+		require.NoError(t, err)
+		req.Header.Set(signature.Header, util.Hash(signature.RequestLine(http.MethodPost, url), "key"))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	t.Run("sse", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/stream?metric=Heap*")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		reader := bufio.NewReader(resp.Body)
+		next := func() models.MetricInfo {
+			for {
+				line, err := reader.ReadString('\n')
+				require.NoError(t, err)
+				if data, ok := strings.CutPrefix(line, "data: "); ok {
+					var item models.MetricInfo
+					require.NoError(t, json.Unmarshal([]byte(data), &item))
+					return item
+				}
+			}
+		}
+
+		// сначала текущее значение, затем изменения подходящих метрик
+		assert.Equal(t, 1.5, next().Value)
+		update("/update/counter/PollCount/1")
+		update("/update/gauge/HeapSys/7")
+		item := next()
+		assert.Equal(t, "HeapSys", item.Name)
+		assert.Equal(t, 7.0, item.Value)
+	})
+
+	t.Run("bad pattern", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/stream?metric=Heap[")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?metric=PollCount", nil)
+		require.NoError(t, err)
+		defer conn.CloseNow()
+
+		var item models.MetricInfo
+		require.NoError(t, wsjson.Read(ctx, conn, &item))
+		assert.Equal(t, "PollCount", item.Name)
+
+		// смена подписки применяется асинхронно, поэтому обновления отправляются до получения
+		require.NoError(t, wsjson.Write(ctx, conn, subscribeMessage{Metrics: []string{"Alloc"}}))
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				case <-time.After(10 * time.Millisecond):
+					update(fmt.Sprintf("/update/gauge/Alloc/%d", i))
+				}
+			}
+		}()
+
+		require.NoError(t, wsjson.Read(ctx, conn, &item))
+		assert.Equal(t, "Alloc", item.Name)
+		assert.Equal(t, "gauge", item.MType)
+	})
+}
+
+func TestWatch(t *testing.T) {
+	hub := pubsub.NewHub(pubsub.DefaultReplay)
+	s := pubsub.NewStorage(storage.NewMemory(40), hub)
+	ctx := context.Background()
+	require.NoError(t, s.SetGauge(ctx, "HeapAlloc", 1.5))
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 3))
+
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterMetricsServer(srv, NewMetricServer(s, nil, WithUpdatesHub(hub)))
+	go srv.Serve(listener)
+	defer srv.Stop()
+	defer hub.Close()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// снимок подходящих метрик, synced, затем изменения
+	watch, err := client.Watch(ctx, &pb.WatchRequest{Names: []string{"Heap*,PollCount"}})
+	require.NoError(t, err)
+
+	event, err := watch.Recv()
+	require.NoError(t, err)
+	assert.True(t, event.GetSnapshot())
+	assert.Equal(t, "HeapAlloc", event.GetMetric().GetId())
+	assert.Equal(t, 1.5, event.GetMetric().GetValue())
+	event, err = watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), event.GetMetric().GetDelta())
+	synced, err := watch.Recv()
+	require.NoError(t, err)
+	require.True(t, synced.GetSynced())
+
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 2))
+	require.NoError(t, s.SetGauge(ctx, "HeapSys", 7))
+	event, err = watch.Recv()
+	require.NoError(t, err)
+	assert.False(t, event.GetSnapshot())
+	assert.Equal(t, "HeapSys", event.GetMetric().GetId())
+	assert.Equal(t, 7.0, event.GetMetric().GetValue())
+	assert.NotNil(t, event.GetUpdatedAt())
+
+	// возобновление с курсора повторяет пропущенные изменения без снимка
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 2))
+	resumed, err := client.Watch(ctx, &pb.WatchRequest{Names: []string{"PollCount"}, Cursor: event.GetCursor()})
+	require.NoError(t, err)
+	event, err = resumed.Recv()
+	require.NoError(t, err)
+	assert.False(t, event.GetSnapshot())
+	assert.Equal(t, int64(5), event.GetMetric().GetDelta())
+	event, err = resumed.Recv()
+	require.NoError(t, err)
+	assert.True(t, event.GetSynced())
+
+	t.Run("bad cursor", func(t *testing.T) {
+		watch, err := client.Watch(ctx, &pb.WatchRequest{Cursor: "bad"})
+		require.NoError(t, err)
+		_, err = watch.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestMetricList(t *testing.T) {
+	s := storage.NewMemory(40)
+	ctx := context.Background()
+	require.NoError(t, s.SetGauge(ctx, "HeapAlloc", 1.5))
+	require.NoError(t, s.SetGauge(ctx, "HeapSys", 3))
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 2))
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 7))
+
+	ts := httptest.NewServer(ServerRouter(s, "", "", nil))
+	defer ts.Close()
+
+	t.Run("json", func(t *testing.T) {
+		resp, body := testRequest(t, ts, http.MethodGet, "/list?prefix=Heap&sort=-value&limit=1", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+		var got listResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &got))
+		assert.Equal(t, 2, got.Total)
+		assert.Equal(t, 1, got.NextOffset)
+		require.Len(t, got.Metrics, 1)
+		assert.Equal(t, "HeapSys", got.Metrics[0].Name)
+		assert.Equal(t, "gauge", got.Metrics[0].MType)
+		assert.Equal(t, 3.0, got.Metrics[0].Value)
+		assert.False(t, got.Metrics[0].UpdatedAt.IsZero())
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/list?type=counter", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", ProtobufContentType)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, ProtobufContentType, resp.Header.Get("Content-Type"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		var got pb.ListMetricsResponse
+		require.NoError(t, proto.Unmarshal(body, &got))
+		require.Len(t, got.GetMetrics(), 1)
+		assert.Equal(t, "PollCount", got.GetMetrics()[0].GetId())
+		assert.Equal(t, int64(7), got.GetMetrics()[0].GetDelta())
+	})
+
+	t.Run("bad query", func(t *testing.T) {
+		for _, query := range []string{"regex=Heap[", "type=histogram", "sort=size", "limit=many"} {
+			resp, _ := testRequest(t, ts, http.MethodGet, "/list?"+query, nil)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		server := NewMetricServer(s, nil)
+		got, err := server.ListMetrics(ctx, &pb.ListMetricsRequest{Regex: "Alloc$", Sort: "name"})
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), got.GetTotal())
+		assert.Equal(t, "Alloc", got.GetMetrics()[0].GetId())
+		assert.Equal(t, 2.0, got.GetMetrics()[0].GetValue())
+
+		_, err = server.ListMetrics(ctx, &pb.ListMetricsRequest{Types: []string{"histogram"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
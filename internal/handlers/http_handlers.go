@@ -3,20 +3,37 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 
 	"io"
 	"net/http"
-	"strings"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/ShvetsovYura/metrics-collector/internal"
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/middlewares"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/resilience"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
 	"github.com/ShvetsovYura/metrics-collector/internal/util"
 )
 
+// storageErrorStatus, http-статус ответа на ошибку записи в хранилище. Превышение квоты -
+// не ограничение частоты: повтор запроса не поможет, поэтому не 429.
+func storageErrorStatus(err error) int {
+	if errors.Is(err, tenant.ErrQuotaExceeded) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, resilience.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
 // MetricUpdateHandler, обновляет
 func MetricUpdateHandler(m StorageWriter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -37,7 +54,7 @@ func MetricUpdateHandler(m StorageWriter) http.HandlerFunc {
 			err = m.SetGauge(ctx, mName, parsedVal)
 			if err != nil {
 				logger.Log.Errorf("Ошибка установки значения для gauge: %s, значение: %f. %s", mName, parsedVal, err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(storageErrorStatus(err))
 
 				return
 			}
@@ -52,7 +69,7 @@ func MetricUpdateHandler(m StorageWriter) http.HandlerFunc {
 
 			err = m.SetCounter(ctx, mName, parsedVal)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(storageErrorStatus(err))
 
 				return
 			}
@@ -125,7 +142,7 @@ func MetricUpdateHandlerWithBody(m Storage) http.HandlerFunc {
 
 		b, readerErr := io.ReadAll(r.Body)
 		if readerErr != nil {
-			http.Error(w, readerErr.Error(), http.StatusBadRequest)
+			http.Error(w, readerErr.Error(), middlewares.BodyErrorStatus(readerErr, http.StatusBadRequest))
 
 			return
 		}
@@ -160,6 +177,9 @@ func MetricUpdateHandlerWithBody(m Storage) http.HandlerFunc {
 			err := m.SetGauge(ctx, e.ID, *e.Value)
 			if err != nil {
 				logger.Log.Errorf("Ошибка установки значения метрики gauge, %s", err.Error())
+				http.Error(w, err.Error(), storageErrorStatus(err))
+
+				return
 			}
 
 			val, _ := m.GetGauge(ctx, e.ID)
@@ -178,6 +198,9 @@ func MetricUpdateHandlerWithBody(m Storage) http.HandlerFunc {
 			setErr := m.SetCounter(ctx, e.ID, *e.Delta)
 			if setErr != nil {
 				logger.Log.Errorf("Ошибка установки значнеия в метрики, %s", setErr.Error())
+				http.Error(w, setErr.Error(), storageErrorStatus(setErr))
+
+				return
 			}
 
 			val, _ := m.GetCounter(ctx, e.ID)
@@ -226,7 +249,8 @@ func MetricGetValueHandlerWithBody(m StorageReader) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), middlewares.BodyErrorStatus(err, http.StatusBadRequest))
+			return
 		}
 
 		defer func() {
@@ -238,6 +262,7 @@ func MetricGetValueHandlerWithBody(m StorageReader) http.HandlerFunc {
 
 		if unmarshalErr := json.Unmarshal(buf.Bytes(), &entity); unmarshalErr != nil {
 			http.Error(w, unmarshalErr.Error(), http.StatusBadRequest)
+			return
 		}
 
 		if !util.Contains([]string{internal.InGaugeName, internal.InCounterName}, entity.MType) {
@@ -292,28 +317,6 @@ func MetricGetValueHandlerWithBody(m StorageReader) http.HandlerFunc {
 	}
 }
 
-func MetricGetCurrentValuesHandler(m StorageReader) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-
-		w.Header().Set("Content-Type", "text/html")
-
-		mList, err := m.ToList(ctx)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-
-			return
-		}
-
-		_, err = io.WriteString(w, strings.Join(mList, ", "))
-		if err != nil {
-			logger.Log.Errorf("Ошибка записи ответа, %s", err.Error())
-		}
-
-		w.WriteHeader(http.StatusOK)
-	}
-}
-
 func DBPingHandler(m StorageReader) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -329,19 +332,12 @@ func DBPingHandler(m StorageReader) http.HandlerFunc {
 	}
 }
 
+// MetricBatchUpdateHandler, сохраняет пакет метрик. Тело разбирается потоково,
+// по одной метрике, без чтения всего массива в память.
 func MetricBatchUpdateHandler(m StorageWriter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var metricModels []models.MetricItem
-
 		ctx := r.Context()
 
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-
-			return
-		}
-
 		defer func() {
 			closeErr := r.Body.Close()
 			if closeErr != nil {
@@ -349,42 +345,64 @@ func MetricBatchUpdateHandler(m StorageWriter) http.HandlerFunc {
 			}
 		}()
 
-		errParse := json.Unmarshal(body, &metricModels)
-		if errParse != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		gauges, counters, err := decodeBatch(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), middlewares.BodyErrorStatus(err, http.StatusBadRequest))
+
 			return
 		}
 
-		var (
-			gauges   = make(map[string]models.Gauge, 100)
-			counters = make(map[string]models.Counter, 100)
-		)
+		err = m.SaveBatch(ctx, gauges, counters)
+		if err != nil {
+			http.Error(w, err.Error(), storageErrorStatus(err))
 
-		for _, mdl := range metricModels {
-			switch mdl.MType {
-			case internal.InGaugeName:
-				gauges[mdl.ID] = models.Gauge(*mdl.Value)
-			case internal.InCounterName:
-				logger.Log.Infof("name: %s, val:%v", mdl.ID, *mdl.Delta)
-
-				if v, ok := counters[mdl.ID]; ok {
-					counters[mdl.ID] = v + models.Counter(*mdl.Delta)
-				} else {
-					counters[mdl.ID] = models.Counter(*mdl.Delta)
-				}
-			}
+			return
 		}
 
-		err = m.SaveCountersBatch(ctx, counters)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// errInvalidBatch, пакет метрик не является массивом корректных метрик.
+var errInvalidBatch = errors.New("ожидается массив метрик")
+
+// decodeBatch, потоково разбирает json-массив метрик: значения gauge перезаписываются,
+// приращения counter с одинаковым именем суммируются.
+func decodeBatch(body io.Reader) (map[string]models.Gauge, map[string]models.Counter, error) {
+	var (
+		gauges   = make(map[string]models.Gauge, 100)
+		counters = make(map[string]models.Counter, 100)
+	)
+
+	dec := json.NewDecoder(body)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, nil, errInvalidBatch
+	}
+
+	for dec.More() {
+		var mdl models.MetricItem
+		if err := dec.Decode(&mdl); err != nil {
+			return nil, nil, err
 		}
 
-		err = m.SaveGaugesBatch(ctx, gauges)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		switch {
+		case mdl.MType == internal.InGaugeName && mdl.Value != nil:
+			gauges[mdl.ID] = models.Gauge(*mdl.Value)
+		case mdl.MType == internal.InCounterName && mdl.Delta != nil:
+			counters[mdl.ID] += models.Counter(*mdl.Delta)
+		default:
+			return nil, nil, fmt.Errorf("%w: неверная метрика %q типа %q", errInvalidBatch, mdl.ID, mdl.MType)
 		}
+	}
 
-		w.WriteHeader(http.StatusOK)
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
 	}
+
+	return gauges, counters, nil
 }
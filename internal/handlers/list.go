@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/listing"
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+)
+
+// ProtobufContentType, тип содержимого ответа списка метрик в формате protobuf.
+const ProtobufContentType = "application/x-protobuf"
+
+// listResponse, ответ списка метрик в формате json.
+type listResponse struct {
+	Metrics    []models.MetricInfo `json:"metrics"`
+	Total      int                 `json:"total"`
+	NextOffset int                 `json:"next_offset,omitempty"`
+}
+
+// MetricListHandler, список метрик тенанта с именами, типами, значениями и временем обновления.
+// Параметры запроса: prefix, regex, type (можно несколько, через запятую), sort
+// (name, type, value, updated_at, "-" - по убыванию), limit и offset.
+// Ответ в формате json, с заголовком Accept: application/x-protobuf - в формате protobuf (ListMetricsResponse).
+func MetricListHandler(m StorageReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseListQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		items, err := m.Items(r.Context())
+		if err != nil {
+			logger.Log.Errorf("ошибка получения метрик, %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		page, err := listing.Apply(items, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var body []byte
+		if strings.Contains(r.Header.Get("Accept"), ProtobufContentType) {
+			w.Header().Set("Content-Type", ProtobufContentType)
+			body, err = proto.Marshal(listResponseProto(page))
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			body, err = json.Marshal(listResponse{Metrics: page.Items, Total: page.Total, NextOffset: page.NextOffset})
+		}
+		if err != nil {
+			logger.Log.Errorf("ошибка сериализации списка метрик, %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			logger.Log.Errorf("Ошибка записи ответа, %s", err.Error())
+		}
+	}
+}
+
+// parseListQuery, параметры выборки из строки запроса.
+func parseListQuery(r *http.Request) (listing.Query, error) {
+	values := r.URL.Query()
+	query := listing.Query{
+		Prefix: values.Get("prefix"),
+		Regex:  values.Get("regex"),
+		Sort:   values.Get("sort"),
+	}
+
+	for _, v := range values["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				query.Types = append(query.Types, t)
+			}
+		}
+	}
+
+	var err error
+	if v := values.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil {
+			return listing.Query{}, fmt.Errorf("%w: размер страницы %q", listing.ErrBadQuery, v)
+		}
+	}
+	if v := values.Get("offset"); v != "" {
+		if query.Offset, err = strconv.Atoi(v); err != nil {
+			return listing.Query{}, fmt.Errorf("%w: смещение %q", listing.ErrBadQuery, v)
+		}
+	}
+
+	return query, nil
+}
+
+// listResponseProto, страница списка метрик в формате protobuf.
+func listResponseProto(page listing.Page) *pb.ListMetricsResponse {
+	resp := &pb.ListMetricsResponse{
+		Metrics:    make([]*pb.MetricInfo, 0, len(page.Items)),
+		Total:      uint32(page.Total),
+		NextOffset: uint32(page.NextOffset),
+	}
+
+	for _, item := range page.Items {
+		info := &pb.MetricInfo{Id: item.Name, Mtype: item.MType}
+		if item.MType == internal.InCounterName {
+			info.Delta = int64(item.Value)
+		} else {
+			info.Value = item.Value
+		}
+		if !item.UpdatedAt.IsZero() {
+			info.UpdatedAt = timestamppb.New(item.UpdatedAt)
+		}
+
+		resp.Metrics = append(resp.Metrics, info)
+	}
+
+	return resp
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/http/pprof"
 
 	"github.com/go-chi/chi/middleware"
@@ -10,9 +11,14 @@ import (
 	"github.com/go-chi/httplog/v2"
 
 	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/audit"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
 	"github.com/ShvetsovYura/metrics-collector/internal/middlewares"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/pubsub"
+	"github.com/ShvetsovYura/metrics-collector/internal/ratelimit"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
 )
 
 // StorageReader, интерфейс, определяющий поддержку чтение данных из стораджа.
@@ -21,6 +27,7 @@ type StorageReader interface {
 	GetCounter(ctx context.Context, name string) (models.Counter, error)
 	Ping(ctx context.Context) error
 	ToList(ctx context.Context) ([]string, error)
+	Items(ctx context.Context) ([]models.MetricInfo, error)
 }
 
 // StorageWriter, интерфейс, определяющий поддержку запись данных из сторадж.
@@ -29,6 +36,8 @@ type StorageWriter interface {
 	SetCounter(ctx context.Context, name string, val int64) error
 	SaveGaugesBatch(context.Context, map[string]models.Gauge) error
 	SaveCountersBatch(context.Context, map[string]models.Counter) error
+	// SaveBatch, записывает gauge и counter одного пакета атомарно: сохраняются все метрики или ни одной.
+	SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error
 }
 
 // Storage, интерфейс работы со стораджем.
@@ -37,35 +46,190 @@ type Storage interface {
 	StorageWriter
 }
 
+// AgentRegistry, интерфейс реестра агентов сбора метрик.
+type AgentRegistry interface {
+	Heartbeat(info models.AgentInfo) error
+	List() []models.AgentStatus
+}
+
+type routerConfig struct {
+	agents     AgentRegistry
+	tenantKeys map[string]string
+	apiKeys    auth.Store
+	limiter    *ratelimit.Limiter
+	limitBy    string
+	auditSink  audit.Sink
+	history    HistoryReader
+	hub        *pubsub.Hub
+
+	maxBodySize         int64
+	maxDecompressedSize int64
+}
+
+// RouterOption, дополнительная настройка роутера.
+type RouterOption func(*routerConfig)
+
+// WithAgentRegistry, подключает обработчики реестра агентов (/agents).
+func WithAgentRegistry(agents AgentRegistry) RouterOption {
+	return func(c *routerConfig) {
+		c.agents = agents
+	}
+}
+
+// WithTenantKeys, задает соответствие API-ключей тенантам.
+func WithTenantKeys(keys map[string]string) RouterOption {
+	return func(c *routerConfig) {
+		c.tenantKeys = keys
+	}
+}
+
+// WithAPIKeys, включает проверку API-ключей и их областей доступа.
+func WithAPIKeys(store auth.Store) RouterOption {
+	return func(c *routerConfig) {
+		c.apiKeys = store
+	}
+}
+
+// WithRateLimit, включает ограничение частоты запросов клиентов. limitBy - способ
+// определения клиента: ratelimit.ByIP, ratelimit.ByAPIKey или ratelimit.ByAgent.
+func WithRateLimit(limiter *ratelimit.Limiter, limitBy string) RouterOption {
+	return func(c *routerConfig) {
+		c.limiter = limiter
+		c.limitBy = limitBy
+	}
+}
+
+// WithBodyLimits, задает ограничения размера тела запроса: как оно пришло по сети
+// и после распаковки gzip. Нулевое значение - ограничение по умолчанию.
+func WithBodyLimits(maxBodySize int64, maxDecompressedSize int64) RouterOption {
+	return func(c *routerConfig) {
+		if maxBodySize > 0 {
+			c.maxBodySize = maxBodySize
+		}
+		if maxDecompressedSize > 0 {
+			c.maxDecompressedSize = maxDecompressedSize
+		}
+	}
+}
+
+// WithAuditSink, включает запись обращений к административному api в журнал аудита.
+// Изменения метрик журналируются оберткой хранилища audit.NewStorage.
+func WithAuditSink(sink audit.Sink) RouterOption {
+	return func(c *routerConfig) {
+		c.auditSink = sink
+	}
+}
+
+// WithHistory, включает графики истории значений метрик на дашборде.
+func WithHistory(history HistoryReader) RouterOption {
+	return func(c *routerConfig) {
+		c.history = history
+	}
+}
+
+// WithUpdatesHub, включает подписку на обновления метрик: /stream (SSE) и /ws (WebSocket).
+func WithUpdatesHub(hub *pubsub.Hub) RouterOption {
+	return func(c *routerConfig) {
+		c.hub = hub
+	}
+}
+
 // ServerRouter, функция объявления роутинга http-запросов и их обработчиков.
-func ServerRouter(s Storage, key string, privateKeyPath string, trustedSubnet string) chi.Router {
+// filter - правила доверенных подсетей и доверенные прокси, nil - без проверки подсети.
+func ServerRouter(s Storage, key string, privateKeyPath string, filter *validator.SubnetFilter, opts ...RouterOption) chi.Router {
+	cfg := &routerConfig{
+		maxBodySize:         middlewares.DefaultMaxBodySize,
+		maxDecompressedSize: middlewares.DefaultMaxDecompressedSize,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	logger.NewHTTPLogger()
 
 	r := chi.NewRouter()
+	// по ip-адресу ограничение проверяется первым, чтобы отклоненные запросы не читали
+	// и не расшифровывали тело, по ключу или агенту - после проверки API-ключа
+	limitAfterAuth := ratelimit.Authenticated(cfg.limitBy)
+	rateLimit := func(r chi.Router) {
+		if cfg.limiter != nil {
+			r.Use(middlewares.RateLimit(cfg.limiter, cfg.limitBy, filter))
+		}
+	}
+	if !limitAfterAuth {
+		rateLimit(r)
+	}
+	// тело ограничивается до любого его чтения: проверки подписи, распаковки и расшифровки
+	r.Use(middlewares.LimitRequestBody(cfg.maxBodySize))
 	r.Use(middlewares.CheckRequestHashHeader(key))
 
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 	r.Use(httplog.RequestLogger(logger.HTTPLogger))
-	r.Use(middlewares.WithUnzipRequest)
+	r.Use(middlewares.WithUnzipRequest(cfg.maxDecompressedSize))
 	if privateKeyPath != "" {
 		r.Use(middlewares.DecryptMessage(privateKeyPath))
 	}
 	r.Use(middlewares.ResposeHeaderWithHash(key))
+	r.Use(middlewares.WithTenant(cfg.tenantKeys))
+	r.Use(middlewares.AuditCaller(filter))
+
+	// статика дашборда не содержит данных метрик и доступна без проверки ключа
+	r.Handle("/static/*", http.StripPrefix("/static/", staticHandler()))
+
+	// чтение метрик
+	r.Group(func(r chi.Router) {
+		r.Use(middlewares.RequireScope(cfg.apiKeys, auth.ScopeRead))
+		if limitAfterAuth {
+			rateLimit(r)
+		}
 
-	r.Get("/", MetricGetCurrentValuesHandler(s))
+		r.Get("/", MetricGetCurrentValuesHandler(s, cfg.history))
+		r.Get("/list", MetricListHandler(s))
 
-	pattern := fmt.Sprintf("/update/{%s}/{%s}/{%s}", internal.MetricTypePathParam, internal.MetricNamePathParam, internal.MetricValuePathParam)
-	r.With(middlewares.CheckTrustetSubnet(trustedSubnet)).Post(pattern, MetricUpdateHandler(s))
+		pattern := fmt.Sprintf("/value/{%s}/{%s}", internal.MetricTypePathParam, internal.MetricNamePathParam)
+		r.Get(pattern, MetricGetValueHandler(s))
 
-	pattern = fmt.Sprintf("/value/{%s}/{%s}", internal.MetricTypePathParam, internal.MetricNamePathParam)
-	r.Get(pattern, MetricGetValueHandler(s))
+		r.Post("/value/", MetricGetValueHandlerWithBody(s))
+		r.Get("/ping", DBPingHandler(s))
+
+		if cfg.agents != nil {
+			r.Get("/agents", AgentListHandler(cfg.agents))
+		}
+
+		if cfg.hub != nil {
+			r.Get("/stream", MetricStreamHandler(s, cfg.hub))
+			r.Get("/ws", MetricWebSocketHandler(s, cfg.hub))
+		}
+	})
 
-	r.With(middlewares.CheckTrustetSubnet(trustedSubnet)).Post("/update/", MetricUpdateHandlerWithBody(s))
-	r.With(middlewares.CheckTrustetSubnet(trustedSubnet)).Post("/updates/", MetricBatchUpdateHandler(s))
-	r.Post("/value/", MetricGetValueHandlerWithBody(s))
-	r.Get("/ping", DBPingHandler(s))
+	// запись метрик
+	r.Group(func(r chi.Router) {
+		r.Use(middlewares.RequireScope(cfg.apiKeys, auth.ScopeWrite))
+		if limitAfterAuth {
+			rateLimit(r)
+		}
+		r.Use(middlewares.CheckTrustetSubnet(filter))
+
+		pattern := fmt.Sprintf("/update/{%s}/{%s}/{%s}", internal.MetricTypePathParam, internal.MetricNamePathParam, internal.MetricValuePathParam)
+		r.Post(pattern, MetricUpdateHandler(s))
+
+		r.Post("/update/", MetricUpdateHandlerWithBody(s))
+		r.Post("/updates/", MetricBatchUpdateHandler(s))
+
+		if cfg.agents != nil {
+			r.Post("/agents/", AgentHeartbeatHandler(cfg.agents))
+		}
+	})
 
 	r.Route("/debug/pprof", func(r chi.Router) {
+		r.Use(middlewares.RequireScope(cfg.apiKeys, auth.ScopeAdmin))
+		if limitAfterAuth {
+			rateLimit(r)
+		}
+		if cfg.auditSink != nil {
+			r.Use(middlewares.AuditAdmin(cfg.auditSink))
+		}
+
 		r.Get("/", pprof.Index)
 		r.Get("/cmdline", pprof.Handler("cmdline").ServeHTTP)
 		r.Get("/profile", pprof.Handler("profile").ServeHTTP)
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/pubsub"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// streamHeartbeat, период отправки пустых сообщений, чтобы прокси не закрывали соединение.
+var streamHeartbeat = 15 * time.Second
+
+// streamWriteTimeout, сколько ждать отправки сообщения по WebSocket.
+const streamWriteTimeout = 5 * time.Second
+
+// subscribeMessage, сообщение клиента WebSocket для смены подписки.
+type subscribeMessage struct {
+	Metrics []string `json:"metrics"` // имена метрик или шаблоны, пусто - все метрики
+}
+
+// subscribe, подписывает на обновления метрик тенанта запроса по параметрам metric
+// (можно повторять или перечислять через запятую, допустимы шаблоны: Heap*).
+// Возвращает также текущие значения подходящих метрик.
+func subscribe(r *http.Request, m StorageReader, hub *pubsub.Hub) (*pubsub.Subscription, []models.MetricInfo, error) {
+	patterns, err := pubsub.ParsePatterns(r.URL.Query()["metric"]...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+
+	// подписка до чтения текущих значений, чтобы не пропустить обновления между ними
+	sub := hub.Subscribe(tenant.FromContext(r.Context()), patterns, pubsub.DefaultBuffer)
+
+	items, err := m.Items(r.Context())
+	if err != nil {
+		sub.Close()
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+
+	current := make([]models.MetricInfo, 0, len(items))
+	for _, item := range items {
+		if sub.Match(item.Name) {
+			current = append(current, item)
+		}
+	}
+
+	return sub, current, nil
+}
+
+// MetricStreamHandler, поток обновлений метрик в формате Server-Sent Events.
+// Сначала отправляются текущие значения, затем - каждое изменение (событие metric).
+func MetricStreamHandler(m StorageReader, hub *pubsub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "потоковая передача не поддерживается", http.StatusInternalServerError)
+			return
+		}
+
+		sub, current, err := subscribe(r, m, hub)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, pubsub.ErrBadPattern) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+
+			return
+		}
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		for _, item := range current {
+			if err := writeEvent(w, item); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case u, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				if err := writeEvent(w, u.MetricInfo); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, item models.MetricInfo) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if _, err := fmt.Fprintf(w, "event: metric\ndata: %s\n\n", data); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// MetricWebSocketHandler, поток обновлений метрик по WebSocket: сначала текущие значения,
+// затем каждое изменение. Клиент может сменить подписку сообщением {"metrics": ["Heap*"]}.
+func MetricWebSocketHandler(m StorageReader, hub *pubsub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, current, err := subscribe(r, m, hub)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, pubsub.ErrBadPattern) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+
+			return
+		}
+		defer sub.Close()
+
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			logger.Log.Infof("не удалось установить WebSocket-соединение, %s", err.Error())
+			return
+		}
+		defer conn.CloseNow()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		go readSubscriptions(ctx, cancel, conn, sub)
+
+		for _, item := range current {
+			if err := writeMessage(ctx, conn, item); err != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u, ok := <-sub.C():
+				if !ok {
+					conn.Close(websocket.StatusGoingAway, "сервер остановлен")
+					return
+				}
+				if err := writeMessage(ctx, conn, u.MetricInfo); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				pingCtx, pingCancel := context.WithTimeout(ctx, streamWriteTimeout)
+				err := conn.Ping(pingCtx)
+				pingCancel()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// readSubscriptions, читает сообщения клиента со сменой подписки до закрытия соединения.
+func readSubscriptions(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, sub *pubsub.Subscription) {
+	defer cancel()
+
+	for {
+		var msg subscribeMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			return
+		}
+
+		patterns, err := pubsub.ParsePatterns(msg.Metrics...)
+		if err != nil {
+			conn.Close(websocket.StatusPolicyViolation, err.Error())
+			return
+		}
+		sub.SetPatterns(patterns)
+	}
+}
+
+func writeMessage(ctx context.Context, conn *websocket.Conn, item models.MetricInfo) error {
+	ctx, cancel := context.WithTimeout(ctx, streamWriteTimeout)
+	defer cancel()
+
+	if err := wsjson.Write(ctx, conn, item); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/migrations"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// compactLockID, ключ advisory-блокировки сжатия истории: несколько серверов
+// с общей БД не агрегируют одни и те же интервалы одновременно.
+const compactLockID int64 = 0x686973746f727921
+
+// начало интервала длиной $1 секунд, в который попадает время
+const bucketExpr = "to_timestamp((floor(extract(epoch from %s) / $1::bigint) * $1::bigint)::double precision)"
+
+// агрегаты исходных значений за [$2, $3)
+var rollupRawStmt = `INSERT INTO metric_rollup (tenant, mtype, name, resolution, bucket, min, max, sum, count, last)
+SELECT tenant, mtype, name, $1::bigint, ` + fmt.Sprintf(bucketExpr, "ts") + ` AS b,
+	min(value), max(value), sum(value), count(*), (array_agg(value ORDER BY ts DESC))[1]
+FROM metric_history
+WHERE ts >= $2 AND ts < $3
+GROUP BY tenant, mtype, name, b
+ON CONFLICT (tenant, mtype, name, resolution, bucket) DO NOTHING`
+
+// агрегаты предыдущего уровня с разрешением $4 за [$2, $3)
+var rollupBucketsStmt = `INSERT INTO metric_rollup (tenant, mtype, name, resolution, bucket, min, max, sum, count, last)
+SELECT tenant, mtype, name, $1::bigint, ` + fmt.Sprintf(bucketExpr, "bucket") + ` AS b,
+	min(min), max(max), sum(sum), sum(count)::bigint, (array_agg(last ORDER BY bucket DESC))[1]
+FROM metric_rollup
+WHERE resolution = $4 AND bucket >= $2 AND bucket < $3
+GROUP BY tenant, mtype, name, b
+ON CONFLICT (tenant, mtype, name, resolution, bucket) DO NOTHING`
+
+// DBStore, хранит историю в таблицах metric_history (исходные значения)
+// и metric_rollup (агрегаты).
+type DBStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewDBStore, подключается к БД и применяет миграции схемы (таблицы истории).
+func NewDBStore(ctx context.Context, connString string) (*DBStore, error) {
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения соединения из пула, %w", err)
+	}
+
+	if err := migrations.Up(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ошибка миграции схемы БД, %w", err)
+	}
+
+	return &DBStore{pool: pool}, nil
+}
+
+func (s *DBStore) Append(ctx context.Context, samples []Sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	_, err := s.pool.CopyFrom(ctx,
+		pgx.Identifier{"metric_history"},
+		[]string{"tenant", "mtype", "name", "ts", "value"},
+		pgx.CopyFromSlice(len(samples), func(i int) ([]any, error) {
+			sm := samples[i]
+			return []any{sm.Key.Tenant, sm.Key.MType, sm.Key.Name, sm.Point.Time, sm.Point.Value}, nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка записи истории, %w", err)
+	}
+
+	return nil
+}
+
+func (s *DBStore) Points(ctx context.Context, key Key, limit int) ([]models.Point, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT ts, value FROM metric_history
+		WHERE tenant = $1 AND mtype = $2 AND name = $3
+		ORDER BY ts DESC LIMIT $4`,
+		key.Tenant, key.MType, key.Name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения истории, %w", err)
+	}
+
+	points, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Point, error) {
+		var p models.Point
+		err := row.Scan(&p.Time, &p.Value)
+		return p, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения истории, %w", err)
+	}
+	slices.Reverse(points)
+
+	return points, nil
+}
+
+func (s *DBStore) Buckets(ctx context.Context, key Key, resolution time.Duration, from time.Time) ([]models.Bucket, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT bucket, min, max, sum / count, last, count FROM metric_rollup
+		WHERE tenant = $1 AND mtype = $2 AND name = $3 AND resolution = $4 AND bucket >= $5
+		ORDER BY bucket`,
+		key.Tenant, key.MType, key.Name, seconds(resolution), from)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения агрегатов истории, %w", err)
+	}
+
+	buckets, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Bucket, error) {
+		var b models.Bucket
+		err := row.Scan(&b.Time, &b.Min, &b.Max, &b.Avg, &b.Last, &b.Count)
+		return b, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения агрегатов истории, %w", err)
+	}
+
+	return buckets, nil
+}
+
+// Compact, агрегирует и удаляет значения одной транзакцией. Время, до которого уровень
+// уже агрегирован, хранится в metric_rollup_watermark, поэтому каждый интервал
+// агрегируется один раз, в том числе после перезапуска сервера.
+func (s *DBStore) Compact(ctx context.Context, policy Policy, now time.Time) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции, %w", err)
+	}
+	defer func() {
+		if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+			err = errors.Join(err, fmt.Errorf("ошибка отката транзакции, %w", rbErr))
+		}
+	}()
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", compactLockID); err != nil {
+		return fmt.Errorf("ошибка блокировки сжатия истории, %w", err)
+	}
+
+	for i, t := range policy {
+		if i == 0 {
+			continue
+		}
+
+		res := seconds(t.Resolution)
+		var from time.Time
+		err := tx.QueryRow(ctx, "SELECT rolled_to FROM metric_rollup_watermark WHERE resolution = $1", res).Scan(&from)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("ошибка чтения границы агрегации, %w", err)
+		}
+		to := bucketStart(now, t.Resolution)
+		if !to.After(from) {
+			continue
+		}
+
+		if prev := policy[i-1].Resolution; prev == 0 {
+			_, err = tx.Exec(ctx, rollupRawStmt, res, from, to)
+		} else {
+			_, err = tx.Exec(ctx, rollupBucketsStmt, res, from, to, seconds(prev))
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка агрегации истории с разрешением %s, %w", t.Resolution, err)
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO metric_rollup_watermark (resolution, rolled_to) VALUES ($1, $2)
+			ON CONFLICT (resolution) DO UPDATE SET rolled_to = excluded.rolled_to`,
+			res, to)
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения границы агрегации, %w", err)
+		}
+	}
+
+	for i, t := range policy {
+		before := now.Add(-t.Retention)
+		if i == 0 {
+			_, err = tx.Exec(ctx, "DELETE FROM metric_history WHERE ts < $1", before)
+		} else {
+			_, err = tx.Exec(ctx, "DELETE FROM metric_rollup WHERE resolution = $1 AND bucket < $2", seconds(t.Resolution), before)
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка удаления устаревшей истории, %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("ошибка фиксации транзакции, %w", err)
+	}
+
+	return nil
+}
+
+func (s *DBStore) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+// seconds, разрешение уровня в секундах, как оно хранится в БД.
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
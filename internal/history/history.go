@@ -0,0 +1,225 @@
+// История значений метрик: последние значения каждой серии для графиков
+// на дашборде и, при заданных уровнях хранения, агрегаты значений по интервалам.
+// Реализована как обертка над хранилищем, после каждой записи сохраняет записанные
+// значения без повторного чтения: новое значение gauge и приращение counter.
+// История хранится в памяти или в PostgreSQL.
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/handlers"
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// Storage, хранилище, запоминающее значения каждой серии метрик в истории.
+type Storage struct {
+	handlers.Storage
+	store        Store
+	size         int
+	policy       Policy
+	compactEvery time.Duration
+	now          func() time.Time
+	done         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// Option, настройка истории значений.
+type Option func(*Storage)
+
+// WithStore, хранит историю в store вместо памяти.
+func WithStore(store Store) Option {
+	return func(s *Storage) {
+		s.store = store
+	}
+}
+
+// WithRetention, включает уровни хранения: раз в every (0 - CompactEveryDef) история
+// сжимается в агрегаты и очищается от значений старше срока хранения.
+func WithRetention(policy Policy, every time.Duration) Option {
+	return func(s *Storage) {
+		s.policy = policy
+		s.compactEvery = every
+	}
+}
+
+// NewStorage, оборачивает хранилище записью истории значений. Для графиков дашборда
+// возвращаются последние size значений; без WithStore в памяти хранятся только они.
+func NewStorage(inner handlers.Storage, size int, opts ...Option) *Storage {
+	s := &Storage{
+		Storage:      inner,
+		size:         size,
+		compactEvery: CompactEveryDef,
+		now:          time.Now,
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.store == nil {
+		s.store = NewMemoryStore(size)
+	}
+	if s.compactEvery <= 0 {
+		s.compactEvery = CompactEveryDef
+	}
+
+	if len(s.policy) > 0 {
+		s.wg.Add(1)
+		go s.compactLoop()
+	}
+
+	return s
+}
+
+// History, возвращает историю значений метрики тенанта из контекста, от старых к новым.
+func (s *Storage) History(ctx context.Context, mtype string, name string) []models.Point {
+	points, err := s.store.Points(ctx, Key{Tenant: tenant.FromContext(ctx), MType: mtype, Name: name}, s.size)
+	if err != nil {
+		logger.Log.Error(err.Error())
+		return nil
+	}
+
+	return points
+}
+
+// Buckets, возвращает агрегаты метрики тенанта из контекста с разрешением resolution,
+// начиная с from, от старых к новым.
+func (s *Storage) Buckets(ctx context.Context, mtype string, name string, resolution time.Duration, from time.Time) ([]models.Bucket, error) {
+	buckets, err := s.store.Buckets(ctx, Key{Tenant: tenant.FromContext(ctx), MType: mtype, Name: name}, resolution, from)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return buckets, nil
+}
+
+// Compact, сжимает историю по уровням хранения, не дожидаясь очередного периода.
+func (s *Storage) Compact(ctx context.Context) error {
+	if len(s.policy) == 0 {
+		return nil
+	}
+	if err := s.store.Compact(ctx, s.policy, s.now()); err != nil {
+		return fmt.Errorf("ошибка сжатия истории, %w", err)
+	}
+
+	return nil
+}
+
+// compactLoop, сжимает историю раз в compactEvery до вызова Close.
+func (s *Storage) compactLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.compactEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Compact(context.Background()); err != nil {
+				logger.Log.Error(err.Error())
+			}
+		}
+	}
+}
+
+// Close, останавливает сжатие истории и закрывает хранилище истории.
+func (s *Storage) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	s.wg.Wait()
+
+	return s.store.Close()
+}
+
+func (s *Storage) SetGauge(ctx context.Context, name string, val float64) error {
+	if err := s.Storage.SetGauge(ctx, name, val); err != nil {
+		return err
+	}
+	s.recordGauges(ctx, map[string]models.Gauge{name: models.Gauge(val)})
+
+	return nil
+}
+
+func (s *Storage) SetCounter(ctx context.Context, name string, val int64) error {
+	if err := s.Storage.SetCounter(ctx, name, val); err != nil {
+		return err
+	}
+	s.recordCounters(ctx, map[string]models.Counter{name: models.Counter(val)})
+
+	return nil
+}
+
+func (s *Storage) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	if err := s.Storage.SaveGaugesBatch(ctx, gauges); err != nil {
+		return err
+	}
+	s.recordGauges(ctx, gauges)
+
+	return nil
+}
+
+func (s *Storage) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	if err := s.Storage.SaveCountersBatch(ctx, counters); err != nil {
+		return err
+	}
+	s.recordCounters(ctx, counters)
+
+	return nil
+}
+
+func (s *Storage) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	if err := s.Storage.SaveBatch(ctx, gauges, counters); err != nil {
+		return err
+	}
+	s.recordGauges(ctx, gauges)
+	s.recordCounters(ctx, counters)
+
+	return nil
+}
+
+// recordGauges, сохраняет записанные значения gauge.
+func (s *Storage) recordGauges(ctx context.Context, gauges map[string]models.Gauge) {
+	samples := make([]Sample, 0, len(gauges))
+	for name, v := range gauges {
+		samples = append(samples, s.sample(ctx, internal.InGaugeName, name, float64(v)))
+	}
+	s.append(ctx, samples)
+}
+
+// recordCounters, сохраняет приращения counter из запроса: накопленное значение
+// потребовало бы повторного чтения хранилища после каждой записи.
+func (s *Storage) recordCounters(ctx context.Context, counters map[string]models.Counter) {
+	samples := make([]Sample, 0, len(counters))
+	for name, v := range counters {
+		samples = append(samples, s.sample(ctx, internal.InCounterName, name, float64(v)))
+	}
+	s.append(ctx, samples)
+}
+
+func (s *Storage) sample(ctx context.Context, mtype string, name string, val float64) Sample {
+	return Sample{
+		Key:   Key{Tenant: tenant.FromContext(ctx), MType: mtype, Name: name},
+		Point: models.Point{Time: s.now(), Value: val},
+	}
+}
+
+// append, сохраняет значения в историю. Метрики уже записаны, поэтому ошибка
+// истории только логируется.
+func (s *Storage) append(ctx context.Context, samples []Sample) {
+	if err := s.store.Append(ctx, samples); err != nil {
+		logger.Log.Error(err.Error())
+	}
+}
@@ -0,0 +1,182 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+func values(points []models.Point) []float64 {
+	out := make([]float64, 0, len(points))
+	for _, p := range points {
+		out = append(out, p.Value)
+	}
+
+	return out
+}
+
+func TestStorage_History(t *testing.T) {
+	s := NewStorage(storage.NewMemory(10), 3)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tick := 0
+	s.now = func() time.Time {
+		tick++
+		return start.Add(time.Duration(tick) * time.Second)
+	}
+
+	ctx := context.Background()
+	for _, v := range []float64{1, 2, 3, 4} {
+		require.NoError(t, s.SetGauge(ctx, "Alloc", v))
+	}
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, s.SaveCountersBatch(ctx, map[string]models.Counter{"PollCount": 3}))
+
+	// хранятся только последние значения, от старых к новым
+	gauges := s.History(ctx, internal.InGaugeName, "Alloc")
+	assert.Equal(t, []float64{2, 3, 4}, values(gauges))
+	assert.True(t, gauges[0].Time.Before(gauges[2].Time))
+
+	// для counter - приращения из запросов
+	assert.Equal(t, []float64{2, 3}, values(s.History(ctx, internal.InCounterName, "PollCount")))
+
+	// у другого тенанта своя история
+	other := tenant.WithTenant(ctx, "team-a")
+	assert.Empty(t, s.History(other, internal.InGaugeName, "Alloc"))
+	require.NoError(t, s.SaveGaugesBatch(other, map[string]models.Gauge{"Alloc": 10}))
+	assert.Equal(t, []float64{10}, values(s.History(other, internal.InGaugeName, "Alloc")))
+	assert.Nil(t, s.History(ctx, internal.InGaugeName, "Unknown"))
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Policy
+		wantErr bool
+	}{
+		{
+			name:  "три уровня",
+			value: "raw:24h, 1m:720h, 1h:8760h",
+			want: Policy{
+				{Retention: 24 * time.Hour},
+				{Resolution: time.Minute, Retention: 720 * time.Hour},
+				{Resolution: time.Hour, Retention: 8760 * time.Hour},
+			},
+		},
+		{name: "только исходные значения", value: "raw:1h", want: Policy{{Retention: time.Hour}}},
+		{name: "нет уровня raw", value: "1m:24h", wantErr: true},
+		{name: "нет срока хранения", value: "raw", wantErr: true},
+		{name: "неверная длительность", value: "raw:1d", wantErr: true},
+		{name: "дробные секунды", value: "raw:1h,1500ms:2h", wantErr: true},
+		{name: "разрешение не кратно предыдущему", value: "raw:1h,1m:2h,90s:3h", wantErr: true},
+		{name: "разрешение не растет", value: "raw:1h,1h:2h,1m:3h", wantErr: true},
+		{name: "предыдущий уровень короче интервала", value: "raw:30s,1m:2h", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadPolicy)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMemoryStore_Compact(t *testing.T) {
+	policy, err := ParsePolicy("raw:2h,1m:3h,1h:48h")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := Key{MType: internal.InGaugeName, Name: "Alloc"}
+	s := NewMemoryStore(0)
+	for _, p := range []struct {
+		at    time.Duration
+		value float64
+	}{
+		{10 * time.Second, 1},
+		{20 * time.Second, 5},
+		{50 * time.Second, 3},
+		{90 * time.Second, 7},
+		{time.Hour + 30*time.Second, 2},
+	} {
+		require.NoError(t, s.Append(ctx, []Sample{{Key: key, Point: models.Point{Time: start.Add(p.at), Value: p.value}}}))
+	}
+
+	// агрегируются только завершившиеся интервалы
+	require.NoError(t, s.Compact(ctx, policy, start.Add(time.Hour+45*time.Second)))
+	minutes, err := s.Buckets(ctx, key, time.Minute, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []models.Bucket{
+		{Time: start, Min: 1, Max: 5, Avg: 3, Last: 3, Count: 3},
+		{Time: start.Add(time.Minute), Min: 7, Max: 7, Avg: 7, Last: 7, Count: 1},
+	}, minutes)
+	hours, err := s.Buckets(ctx, key, time.Hour, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []models.Bucket{{Time: start, Min: 1, Max: 7, Avg: 4, Last: 7, Count: 4}}, hours)
+
+	// повторное сжатие не агрегирует интервалы дважды
+	require.NoError(t, s.Compact(ctx, policy, start.Add(time.Hour+2*time.Minute)))
+	minutes, err = s.Buckets(ctx, key, time.Minute, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, minutes, 3)
+	hours, err = s.Buckets(ctx, key, time.Hour, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), hours[0].Count)
+
+	// значения старше срока хранения уровня удаляются
+	require.NoError(t, s.Compact(ctx, policy, start.Add(4*time.Hour)))
+	points, err := s.Points(ctx, key, 0)
+	require.NoError(t, err)
+	assert.Empty(t, points)
+	minutes, err = s.Buckets(ctx, key, time.Minute, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []models.Bucket{{Time: start.Add(time.Hour), Min: 2, Max: 2, Avg: 2, Last: 2, Count: 1}}, minutes)
+	hours, err = s.Buckets(ctx, key, time.Hour, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []models.Bucket{{Time: start.Add(time.Hour), Min: 2, Max: 2, Avg: 2, Last: 2, Count: 1}}, hours)
+}
+
+func TestStorage_Retention(t *testing.T) {
+	policy, err := ParsePolicy("raw:1h,1m:24h")
+	require.NoError(t, err)
+
+	s := NewStorage(storage.NewMemory(10), 2, WithStore(NewMemoryStore(0)), WithRetention(policy, time.Hour))
+	defer func() { require.NoError(t, s.Close()) }()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	s.now = func() time.Time { return now }
+
+	ctx := tenant.WithTenant(context.Background(), "team-a")
+	for i := 1; i <= 4; i++ {
+		now = start.Add(time.Duration(i) * 10 * time.Second)
+		require.NoError(t, s.SetCounter(ctx, "PollCount", int64(i)))
+	}
+
+	// для дашборда - последние size значений, в хранилище - все
+	assert.Equal(t, []float64{3, 4}, values(s.History(ctx, internal.InCounterName, "PollCount")))
+
+	now = start.Add(time.Minute)
+	require.NoError(t, s.Compact(ctx))
+	buckets, err := s.Buckets(ctx, internal.InCounterName, "PollCount", time.Minute, start)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Bucket{{Time: start, Min: 1, Max: 4, Avg: 2.5, Last: 4, Count: 4}}, buckets)
+
+	// агрегаты другого тенанта не видны
+	buckets, err = s.Buckets(context.Background(), internal.InCounterName, "PollCount", time.Minute, start)
+	require.NoError(t, err)
+	assert.Empty(t, buckets)
+}
@@ -0,0 +1,165 @@
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// MemoryStore, хранилище истории в памяти.
+type MemoryStore struct {
+	limit int
+
+	mx       sync.RWMutex
+	raw      map[Key][]models.Point
+	rollups  map[time.Duration]map[Key][]models.Bucket
+	rolledTo map[time.Duration]time.Time // до какого времени значения уже агрегированы
+}
+
+// NewMemoryStore, создает хранилище истории в памяти. Для серии хранится не более limit
+// исходных значений, 0 - без ограничения: значения удаляются только по сроку хранения.
+func NewMemoryStore(limit int) *MemoryStore {
+	return &MemoryStore{
+		limit:    limit,
+		raw:      make(map[Key][]models.Point),
+		rollups:  make(map[time.Duration]map[Key][]models.Bucket),
+		rolledTo: make(map[time.Duration]time.Time),
+	}
+}
+
+func (s *MemoryStore) Append(_ context.Context, samples []Sample) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, sm := range samples {
+		points := append(s.raw[sm.Key], sm.Point)
+		// лишние значения удаляются пачкой, чтобы не копировать серию при каждой записи
+		if s.limit > 0 && len(points) >= 2*s.limit {
+			points = append([]models.Point(nil), points[len(points)-s.limit:]...)
+		}
+		s.raw[sm.Key] = points
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Points(_ context.Context, key Key, limit int) ([]models.Point, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	points, ok := s.raw[key]
+	if !ok {
+		return nil, nil
+	}
+	if limit > 0 && len(points) > limit {
+		points = points[len(points)-limit:]
+	}
+
+	return append([]models.Point(nil), points...), nil
+}
+
+func (s *MemoryStore) Buckets(_ context.Context, key Key, resolution time.Duration, from time.Time) ([]models.Bucket, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	var out []models.Bucket
+	for _, b := range s.rollups[resolution][key] {
+		if !b.Time.Before(from) {
+			out = append(out, b)
+		}
+	}
+
+	return out, nil
+}
+
+// Compact, агрегирует уровни по порядку: агрегаты каждого уровня строятся из значений
+// предыдущего, уже дополненного в этом же вызове.
+func (s *MemoryStore) Compact(_ context.Context, policy Policy, now time.Time) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for i, t := range policy {
+		if i == 0 {
+			continue
+		}
+
+		from := s.rolledTo[t.Resolution]
+		to := bucketStart(now, t.Resolution)
+		if !to.After(from) {
+			continue
+		}
+
+		dst := s.rollups[t.Resolution]
+		if dst == nil {
+			dst = make(map[Key][]models.Bucket)
+			s.rollups[t.Resolution] = dst
+		}
+
+		if prev := policy[i-1].Resolution; prev == 0 {
+			for key, points := range s.raw {
+				add := rollup(points, pointTime, (*aggregate).addPoint, t.Resolution, from, to)
+				if len(add) > 0 {
+					dst[key] = append(dst[key], add...)
+				}
+			}
+		} else {
+			for key, buckets := range s.rollups[prev] {
+				add := rollup(buckets, bucketTime, (*aggregate).addBucket, t.Resolution, from, to)
+				if len(add) > 0 {
+					dst[key] = append(dst[key], add...)
+				}
+			}
+		}
+		s.rolledTo[t.Resolution] = to
+	}
+
+	for i, t := range policy {
+		before := now.Add(-t.Retention)
+		if i == 0 {
+			for key, points := range s.raw {
+				s.raw[key] = dropBefore(points, pointTime, before)
+				if len(s.raw[key]) == 0 {
+					delete(s.raw, key)
+				}
+			}
+
+			continue
+		}
+
+		for key, buckets := range s.rollups[t.Resolution] {
+			s.rollups[t.Resolution][key] = dropBefore(buckets, bucketTime, before)
+			if len(s.rollups[t.Resolution][key]) == 0 {
+				delete(s.rollups[t.Resolution], key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func pointTime(p models.Point) time.Time {
+	return p.Time
+}
+
+func bucketTime(b models.Bucket) time.Time {
+	return b.Time
+}
+
+// dropBefore, убирает из упорядоченных по времени значений те, что раньше before.
+func dropBefore[T any](items []T, at func(T) time.Time, before time.Time) []T {
+	n := 0
+	for n < len(items) && at(items[n]).Before(before) {
+		n++
+	}
+	if n == 0 {
+		return items
+	}
+
+	return append([]T(nil), items[n:]...)
+}
@@ -0,0 +1,168 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// CompactEveryDef, период сжатия истории по умолчанию.
+const CompactEveryDef = time.Minute
+
+// rawTierName, обозначение уровня исходных значений в настройке уровней хранения.
+const rawTierName = "raw"
+
+// ErrBadPolicy, неверные уровни хранения истории.
+var ErrBadPolicy = errors.New("неверные уровни хранения истории")
+
+// Tier, уровень хранения истории: значения с разрешением Resolution хранятся Retention.
+// Resolution 0 - исходные значения без агрегации.
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Policy, уровни хранения истории: первый - исходные значения,
+// следующие - агрегаты со все более грубым разрешением.
+type Policy []Tier
+
+// ParsePolicy, разбирает уровни хранения вида "raw:24h,1m:720h,1h:8760h":
+// исходные значения хранятся сутки, минутные агрегаты - 30 дней, часовые - год.
+func ParsePolicy(s string) (Policy, error) {
+	var p Policy
+	for _, part := range strings.Split(s, ",") {
+		res, ret, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q, нужно <разрешение>:<срок хранения>", ErrBadPolicy, part)
+		}
+
+		var t Tier
+		if res != rawTierName {
+			d, err := time.ParseDuration(res)
+			if err != nil {
+				return nil, fmt.Errorf("%w: разрешение %q, %w", ErrBadPolicy, res, err)
+			}
+			t.Resolution = d
+		}
+		d, err := time.ParseDuration(ret)
+		if err != nil {
+			return nil, fmt.Errorf("%w: срок хранения %q, %w", ErrBadPolicy, ret, err)
+		}
+		t.Retention = d
+
+		p = append(p, t)
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Validate, проверяет уровни хранения. Разрешение каждого уровня агрегатов - целое число
+// секунд, кратное разрешению предыдущего уровня, а предыдущий уровень хранит значения
+// не меньше интервала следующего: иначе они удаляются раньше, чем попадут в агрегат.
+func (p Policy) Validate() error {
+	if len(p) == 0 || p[0].Resolution != 0 {
+		return fmt.Errorf("%w: первым должен быть уровень %s", ErrBadPolicy, rawTierName)
+	}
+
+	for i, t := range p {
+		if t.Retention <= 0 {
+			return fmt.Errorf("%w: срок хранения %s должен быть положительным", ErrBadPolicy, t.Retention)
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := p[i-1]
+		if t.Resolution < time.Second || t.Resolution%time.Second != 0 {
+			return fmt.Errorf("%w: разрешение %s должно быть целым числом секунд", ErrBadPolicy, t.Resolution)
+		}
+		if t.Resolution <= prev.Resolution || (prev.Resolution > 0 && t.Resolution%prev.Resolution != 0) {
+			return fmt.Errorf("%w: разрешение %s должно быть кратно предыдущему %s", ErrBadPolicy, t.Resolution, prev.Resolution)
+		}
+		if prev.Retention < t.Resolution {
+			return fmt.Errorf("%w: срок хранения %s меньше разрешения следующего уровня %s", ErrBadPolicy, prev.Retention, t.Resolution)
+		}
+	}
+
+	return nil
+}
+
+// bucketStart, начало интервала длиной res, в который попадает t. Интервалы отсчитываются
+// от начала эпохи Unix, как и в БД.
+func bucketStart(t time.Time, res time.Duration) time.Time {
+	ns := t.UnixNano()
+
+	return time.Unix(0, ns-ns%int64(res)).UTC()
+}
+
+// aggregate, агрегат, в который добавляются значения или агрегаты более мелкого разрешения.
+type aggregate struct {
+	bucket models.Bucket
+	sum    float64
+	lastAt time.Time
+}
+
+func (a *aggregate) addPoint(p models.Point) {
+	a.add(p.Time, p.Value, p.Value, p.Value, p.Value, 1)
+}
+
+func (a *aggregate) addBucket(b models.Bucket) {
+	a.add(b.Time, b.Min, b.Max, b.Avg*float64(b.Count), b.Last, b.Count)
+}
+
+func (a *aggregate) add(at time.Time, minV, maxV, sum, last float64, count int64) {
+	if a.bucket.Count == 0 || minV < a.bucket.Min {
+		a.bucket.Min = minV
+	}
+	if a.bucket.Count == 0 || maxV > a.bucket.Max {
+		a.bucket.Max = maxV
+	}
+	if a.bucket.Count == 0 || !at.Before(a.lastAt) {
+		a.bucket.Last = last
+		a.lastAt = at
+	}
+	a.sum += sum
+	a.bucket.Count += count
+}
+
+func (a *aggregate) result() models.Bucket {
+	b := a.bucket
+	b.Avg = a.sum / float64(b.Count)
+
+	return b
+}
+
+// rollup, агрегирует значения, попадающие в [from, to), в интервалы длиной res по возрастанию времени.
+func rollup[T any](items []T, at func(T) time.Time, add func(*aggregate, T), res time.Duration, from, to time.Time) []models.Bucket {
+	aggs := make(map[time.Time]*aggregate)
+	for _, it := range items {
+		t := at(it)
+		if t.Before(from) || !t.Before(to) {
+			continue
+		}
+
+		start := bucketStart(t, res)
+		a, ok := aggs[start]
+		if !ok {
+			a = &aggregate{bucket: models.Bucket{Time: start}}
+			aggs[start] = a
+		}
+		add(a, it)
+	}
+
+	out := make([]models.Bucket, 0, len(aggs))
+	for _, a := range aggs {
+		out = append(out, a.result())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+
+	return out
+}
@@ -0,0 +1,53 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// DBLocation, значение настройки хранилища истории, при котором история хранится в БД.
+const DBLocation = "db"
+
+// Key, серия метрики тенанта.
+type Key struct {
+	Tenant string
+	MType  string
+	Name   string
+}
+
+// Sample, значение серии, сохраняемое в историю.
+type Sample struct {
+	Key   Key
+	Point models.Point
+}
+
+// Store, хранилище истории: исходные значения серий и их агрегаты по уровням хранения.
+type Store interface {
+	// Append, сохраняет исходные значения.
+	Append(ctx context.Context, samples []Sample) error
+	// Points, возвращает последние limit исходных значений серии, от старых к новым.
+	Points(ctx context.Context, key Key, limit int) ([]models.Point, error)
+	// Buckets, возвращает агрегаты серии с разрешением resolution, начиная с from, от старых к новым.
+	Buckets(ctx context.Context, key Key, resolution time.Duration, from time.Time) ([]models.Bucket, error)
+	// Compact, агрегирует завершившиеся к now интервалы каждого уровня и удаляет
+	// значения и агрегаты старше срока хранения своего уровня.
+	Compact(ctx context.Context, policy Policy, now time.Time) error
+	Close() error
+}
+
+// OpenStore, открывает хранилище истории: таблицы в БД (location = "db") или в памяти
+// с не более чем limit исходными значениями серии (0 - без ограничения).
+func OpenStore(ctx context.Context, location string, dsn string, limit int) (Store, error) {
+	if location != DBLocation {
+		return NewMemoryStore(limit), nil
+	}
+
+	if dsn == "" {
+		return nil, errors.New("для истории в БД не задана строка подключения")
+	}
+
+	return NewDBStore(ctx, dsn)
+}
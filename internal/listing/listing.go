@@ -0,0 +1,179 @@
+// Выборка списка метрик: фильтрация по префиксу, регулярному выражению и типу,
+// сортировка и постраничный вывод. Используется http- и gRPC-обработчиками списка метрик.
+
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// Ограничения размера страницы.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Поля сортировки. Префикс "-" - по убыванию.
+const (
+	SortName      = "name"
+	SortType      = "type"
+	SortValue     = "value"
+	SortUpdatedAt = "updated_at"
+)
+
+// ErrBadQuery, неверные параметры выборки.
+var ErrBadQuery = errors.New("неверные параметры выборки")
+
+// Query, параметры выборки списка метрик. Нулевое значение - первая страница всех метрик по имени.
+type Query struct {
+	Prefix string   // префикс имени
+	Regex  string   // регулярное выражение для имени
+	Types  []string // типы метрик, пусто - все
+	Sort   string   // поле сортировки, по умолчанию - имя
+	Limit  int      // размер страницы, 0 - DefaultLimit
+	Offset int      // сколько метрик пропустить
+}
+
+// Page, страница списка метрик.
+type Page struct {
+	Items      []models.MetricInfo
+	Total      int // сколько метрик подходит под фильтры
+	NextOffset int // смещение следующей страницы, 0 - страница последняя
+}
+
+// Apply, выбирает из items страницу метрик по параметрам q. Исходный срез не изменяется.
+func Apply(items []models.MetricInfo, q Query) (Page, error) {
+	match, err := q.matcher()
+	if err != nil {
+		return Page{}, err
+	}
+
+	less, err := q.less()
+	if err != nil {
+		return Page{}, err
+	}
+
+	limit, err := q.limit()
+	if err != nil {
+		return Page{}, err
+	}
+	if q.Offset < 0 {
+		return Page{}, fmt.Errorf("%w: отрицательное смещение %d", ErrBadQuery, q.Offset)
+	}
+
+	selected := make([]models.MetricInfo, 0, len(items))
+	for _, item := range items {
+		if match(item) {
+			selected = append(selected, item)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return less(selected[i], selected[j]) })
+
+	page := Page{Total: len(selected), Items: []models.MetricInfo{}}
+	if q.Offset >= len(selected) {
+		return page, nil
+	}
+
+	end := min(q.Offset+limit, len(selected))
+	page.Items = selected[q.Offset:end]
+	if end < len(selected) {
+		page.NextOffset = end
+	}
+
+	return page, nil
+}
+
+func (q Query) matcher() (func(models.MetricInfo) bool, error) {
+	var re *regexp.Regexp
+	if q.Regex != "" {
+		var err error
+		if re, err = regexp.Compile(q.Regex); err != nil {
+			return nil, fmt.Errorf("%w: регулярное выражение %q, %w", ErrBadQuery, q.Regex, err)
+		}
+	}
+
+	types := make(map[string]bool, len(q.Types))
+	for _, t := range q.Types {
+		if t != internal.InGaugeName && t != internal.InCounterName {
+			return nil, fmt.Errorf("%w: неизвестный тип метрики %q", ErrBadQuery, t)
+		}
+		types[t] = true
+	}
+
+	return func(item models.MetricInfo) bool {
+		if len(types) > 0 && !types[item.MType] {
+			return false
+		}
+		if !strings.HasPrefix(item.Name, q.Prefix) {
+			return false
+		}
+
+		return re == nil || re.MatchString(item.Name)
+	}, nil
+}
+
+func (q Query) less() (func(a, b models.MetricInfo) bool, error) {
+	field, desc := strings.CutPrefix(q.Sort, "-")
+
+	var cmp func(a, b models.MetricInfo) int
+	switch field {
+	case "", SortName:
+		cmp = func(a, b models.MetricInfo) int { return strings.Compare(a.Name, b.Name) }
+	case SortType:
+		cmp = func(a, b models.MetricInfo) int { return strings.Compare(a.MType, b.MType) }
+	case SortValue:
+		cmp = func(a, b models.MetricInfo) int { return compare(a.Value, b.Value) }
+	case SortUpdatedAt:
+		cmp = func(a, b models.MetricInfo) int { return a.UpdatedAt.Compare(b.UpdatedAt) }
+	default:
+		return nil, fmt.Errorf("%w: неизвестное поле сортировки %q", ErrBadQuery, q.Sort)
+	}
+
+	return func(a, b models.MetricInfo) bool {
+		c := cmp(a, b)
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+
+		// одинаковые значения упорядочиваются по имени и типу, чтобы страницы не пересекались
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.MType < b.MType
+	}, nil
+}
+
+func (q Query) limit() (int, error) {
+	switch {
+	case q.Limit < 0:
+		return 0, fmt.Errorf("%w: отрицательный размер страницы %d", ErrBadQuery, q.Limit)
+	case q.Limit == 0:
+		return DefaultLimit, nil
+	case q.Limit > MaxLimit:
+		return MaxLimit, nil
+	default:
+		return q.Limit, nil
+	}
+}
+
+func compare(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,110 @@
+package listing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+func TestApply(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []models.MetricInfo{
+		{Name: "HeapSys", MType: "gauge", Value: 30, UpdatedAt: now.Add(2 * time.Second)},
+		{Name: "Alloc", MType: "gauge", Value: 10, UpdatedAt: now},
+		{Name: "HeapAlloc", MType: "gauge", Value: 20, UpdatedAt: now.Add(time.Second)},
+		{Name: "PollCount", MType: "counter", Value: 5},
+		{Name: "Alloc", MType: "counter", Value: 1},
+	}
+
+	names := func(page Page) []string {
+		got := make([]string, 0, len(page.Items))
+		for _, item := range page.Items {
+			got = append(got, item.Name+"/"+item.MType)
+		}
+		return got
+	}
+
+	tests := []struct {
+		name      string
+		query     Query
+		want      []string
+		wantTotal int
+		wantNext  int
+		wantErr   bool
+	}{
+		{
+			name:      "all by name",
+			query:     Query{},
+			want:      []string{"Alloc/counter", "Alloc/gauge", "HeapAlloc/gauge", "HeapSys/gauge", "PollCount/counter"},
+			wantTotal: 5,
+		},
+		{
+			name:      "prefix",
+			query:     Query{Prefix: "Heap"},
+			want:      []string{"HeapAlloc/gauge", "HeapSys/gauge"},
+			wantTotal: 2,
+		},
+		{
+			name:      "regex and type",
+			query:     Query{Regex: "Alloc$", Types: []string{"gauge"}},
+			want:      []string{"Alloc/gauge", "HeapAlloc/gauge"},
+			wantTotal: 2,
+		},
+		{
+			name:      "sort by value desc",
+			query:     Query{Types: []string{"gauge"}, Sort: "-value"},
+			want:      []string{"HeapSys/gauge", "HeapAlloc/gauge", "Alloc/gauge"},
+			wantTotal: 3,
+		},
+		{
+			name:      "sort by updated_at",
+			query:     Query{Types: []string{"gauge"}, Sort: "updated_at"},
+			want:      []string{"Alloc/gauge", "HeapAlloc/gauge", "HeapSys/gauge"},
+			wantTotal: 3,
+		},
+		{
+			name:      "first page",
+			query:     Query{Limit: 2},
+			want:      []string{"Alloc/counter", "Alloc/gauge"},
+			wantTotal: 5,
+			wantNext:  2,
+		},
+		{
+			name:      "last page",
+			query:     Query{Limit: 2, Offset: 4},
+			want:      []string{"PollCount/counter"},
+			wantTotal: 5,
+		},
+		{
+			name:      "offset out of range",
+			query:     Query{Offset: 10},
+			want:      []string{},
+			wantTotal: 5,
+		},
+		{name: "bad regex", query: Query{Regex: "Heap["}, wantErr: true},
+		{name: "bad type", query: Query{Types: []string{"histogram"}}, wantErr: true},
+		{name: "bad sort", query: Query{Sort: "size"}, wantErr: true},
+		{name: "negative limit", query: Query{Limit: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := Apply(items, tt.query)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadQuery)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, names(page))
+			assert.Equal(t, tt.wantTotal, page.Total)
+			assert.Equal(t, tt.wantNext, page.NextOffset)
+		})
+	}
+
+	// исходный срез не сортируется
+	assert.Equal(t, "HeapSys", items[0].Name)
+}
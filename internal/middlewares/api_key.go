@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// RequireScope, мидлваря проверки API-ключа из заголовка X-API-Key и его области доступа.
+// Если хранилище ключей не задано, проверка не выполняется.
+func RequireScope(store auth.Store, scope auth.Scope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if store == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := auth.Authenticate(r.Context(), store, r.Header.Get(internal.APIKeyHeader), scope)
+			switch {
+			case errors.Is(err, auth.ErrUnauthorized):
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			case errors.Is(err, auth.ErrForbidden):
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case err != nil:
+				logger.Log.Errorf("ошибка проверки API-ключа, %s", err.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			ctx := auth.WithKey(r.Context(), key)
+			if key.Tenant != "" {
+				ctx = tenant.WithTenant(ctx, key.Tenant)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/audit"
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
+)
+
+// AuditCaller, мидлваря, сохраняющая в контексте сведения о клиенте для журнала аудита:
+// ip-адрес (с учетом доверенных прокси resolver) и идентификатор агента.
+func AuditCaller(resolver *validator.SubnetFilter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP := r.RemoteAddr
+			if peer, err := validator.ParseAddrPort(r.RemoteAddr); err == nil {
+				clientIP = peer.String()
+				ip, err := resolver.ClientIP(peer, r.Header.Get("X-Real-IP"), strings.Join(r.Header.Values("X-Forwarded-For"), ","))
+				if err == nil {
+					clientIP = ip.String()
+				}
+			}
+
+			ctx := audit.WithCaller(r.Context(), audit.Caller{
+				Transport: audit.TransportHTTP,
+				IP:        clientIP,
+				AgentID:   r.Header.Get(internal.AgentIDHeader),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AuditAdmin, мидлваря, записывающая в журнал аудита обращения к административному api.
+// Обращение, которое не удалось записать в журнал, не выполняется.
+func AuditAdmin(sink audit.Sink) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := audit.NewRecord(r.Context(), audit.OpAdmin)
+			rec.Details = r.Method + " " + r.URL.Path
+			if err := audit.Log(r.Context(), sink, rec); err != nil {
+				logger.Log.Errorf("%s", err.Error())
+				http.Error(w, "журнал аудита недоступен", http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Ограничения размера тела запроса по умолчанию.
+const (
+	DefaultMaxBodySize         int64 = 1 << 20  // размер тела запроса, как оно пришло по сети
+	DefaultMaxDecompressedSize int64 = 10 << 20 // размер тела после распаковки gzip
+)
+
+// LimitRequestBody, мидлваря ограничения размера тела запроса в том виде, как оно пришло по сети.
+// Запрос с заведомо большим Content-Length отклоняется сразу, иначе чтение тела
+// сверх лимита завершается ошибкой *http.MaxBytesError.
+func LimitRequestBody(maxSize int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxSize {
+				http.Error(w, "тело запроса слишком большое", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BodyErrorStatus, код ответа для ошибки чтения тела запроса: 413, если превышен
+// размер тела, иначе fallback.
+func BodyErrorStatus(err error, fallback int) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return fallback
+}
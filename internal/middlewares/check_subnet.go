@@ -2,32 +2,41 @@ package middlewares
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
 	"github.com/ShvetsovYura/metrics-collector/internal/validator"
 )
 
-func CheckTrustetSubnet(trustedSubnet string) func(next http.Handler) http.Handler {
+// CheckTrustetSubnet, мидлваря проверки адреса клиента по правилам доверенных подсетей.
+// Адрес берется из соединения, а из заголовков X-Forwarded-For/X-Real-IP - только
+// если запрос пришел от доверенного прокси. nil - проверка выключена.
+func CheckTrustetSubnet(filter *validator.SubnetFilter) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		handler := func(resp http.ResponseWriter, req *http.Request) {
-			xRealIP := req.Header.Get("x-real-ip")
-			logger.Log.Debug("входящий ip %s", xRealIP)
-			if trustedSubnet != "" {
-				if xRealIP == "" {
-					resp.WriteHeader(http.StatusForbidden)
-					return
-				}
-				val, err := validator.IsIPInSubnet(xRealIP, trustedSubnet)
-				if err != nil {
-					resp.WriteHeader(http.StatusInternalServerError)
-					resp.Write([]byte(err.Error()))
-					return
-				}
-				if !val {
-					resp.WriteHeader(http.StatusForbidden)
-					return
-				}
+			if !filter.Enabled() {
+				next.ServeHTTP(resp, req)
+				return
 			}
+
+			peer, err := validator.ParseAddrPort(req.RemoteAddr)
+			if err != nil {
+				resp.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			clientIP, err := filter.ClientIP(peer, req.Header.Get("X-Real-IP"), strings.Join(req.Header.Values("X-Forwarded-For"), ","))
+			if err != nil {
+				http.Error(resp, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.Log.Debugf("входящий ip %s", clientIP)
+
+			if !filter.Allowed(clientIP) {
+				resp.WriteHeader(http.StatusForbidden)
+				return
+			}
+
 			next.ServeHTTP(resp, req)
 		}
 		return http.HandlerFunc(handler)
@@ -3,33 +3,46 @@ package middlewares
 import (
 	"bytes"
 	"io"
-
 	"net/http"
 
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
 	"github.com/ShvetsovYura/metrics-collector/internal/util"
 )
 
-// DecryptMessage: предназначена для расшифровки входящего тела запроса
+// DecryptMessage: предназначена для расшифровки входящего тела запроса.
+// Поддерживается конверт RSA-OAEP + AES-GCM и прежний формат RSA PKCS#1 v1.5.
+// Запросы без тела пропускаются без изменений.
 func DecryptMessage(privateKeyPath string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
+		key, keyErr := util.LoadPrivateKey(privateKeyPath)
+		if keyErr != nil {
+			logger.Log.Errorf("не удалось загрузить приватный ключ, %s", keyErr.Error())
+		}
 
 		handler := func(w http.ResponseWriter, req *http.Request) {
-			if privateKeyPath != "" {
-				data, errRead := io.ReadAll(req.Body)
-				if errRead != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					return
+			if keyErr != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 
-				}
-				decrytedMessage, err := util.DecryptData(data, privateKeyPath)
+			data, errRead := io.ReadAll(req.Body)
+			if errRead != nil {
+				http.Error(w, errRead.Error(), BodyErrorStatus(errRead, http.StatusInternalServerError))
+				return
+			}
+
+			if len(data) > 0 {
+				decrytedMessage, err := util.Decrypt(data, key)
 				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+					logger.Log.Infof("ошибка расшифровки сообщения, %s", err.Error())
+					w.WriteHeader(http.StatusBadRequest)
 					return
 				}
+				data = decrytedMessage
+			}
 
-				req.Body = io.NopCloser(bytes.NewReader(decrytedMessage))
+			req.Body = io.NopCloser(bytes.NewReader(data))
 
-			}
 			next.ServeHTTP(w, req)
 		}
 		return http.HandlerFunc(handler)
@@ -1,35 +1,52 @@
 package middlewares
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
+	"net"
 	"net/http"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
-	"github.com/ShvetsovYura/metrics-collector/internal/util"
+	"github.com/ShvetsovYura/metrics-collector/internal/signature"
 )
 
-// CheckRequestHashHeader, мидлваря для проверки хэша сообщения на основании заголовка HashSHA256.
+// CheckRequestHashHeader, мидлваря для проверки HMAC-подписи сообщения из заголовка HashSHA256.
+// Ключ подписи выбирается по заголовку HashKeyID. Если ключ задан, запросы без подписи
+// отклоняются. У запросов без тела подписываются метод и путь (signature.RequestLine),
+// без подписи пропускаются только запросы на чтение без тела.
 func CheckRequestHashHeader(key string) func(next http.Handler) http.Handler {
+	keys := signature.ParseKeys(key)
+
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !keys.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				http.Error(w, err.Error(), BodyErrorStatus(err, http.StatusInternalServerError))
 				return
 			}
 
-			hashHeader := r.Header.Get("HashSHA256")
-			if key != "" && hashHeader != "" {
-				hash := util.Hash(body, key)
-				if hashHeader != hash {
-					w.WriteHeader(http.StatusBadRequest)
-					logger.Log.Infof("key %s hashHeader: %s hash: %s", key, hashHeader, hash)
+			if len(body) > 0 || !isReadMethod(r.Method) {
+				data := body
+				if len(body) == 0 {
+					data = signature.RequestLine(r.Method, r.URL.RequestURI())
+				}
+
+				keyID, err := keys.Verify(data, r.Header.Get(signature.KeyIDHeader), r.Header.Get(signature.Header))
+				if err != nil {
+					logger.Log.Infof("ошибка проверки подписи запроса, %s", err.Error())
+					http.Error(w, err.Error(), http.StatusBadRequest)
 
 					return
 				}
+
+				r = r.WithContext(signature.WithKeyID(r.Context(), keyID))
 			}
 
 			r.Body = io.NopCloser(bytes.NewBuffer(body))
@@ -41,52 +58,122 @@ func CheckRequestHashHeader(key string) func(next http.Handler) http.Handler {
 	}
 }
 
+// isReadMethod, запрос на чтение, не изменяющий метрики.
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// hashWriter, накапливает ответ, чтобы подписать его целиком до отправки заголовков.
+// Потоковые ответы (SSE, WebSocket) подписать целиком нельзя: после Flush или Hijack
+// ответ отправляется клиенту без подписи.
 type hashWriter struct {
 	http.ResponseWriter
-	w   io.Writer
-	key string
+	buf       bytes.Buffer
+	status    int
+	streaming bool
+}
+
+func (hw *hashWriter) WriteHeader(statusCode int) {
+	if hw.streaming {
+		hw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if hw.status == 0 {
+		hw.status = statusCode
+	}
+}
+
+func (hw *hashWriter) Write(b []byte) (int, error) {
+	if hw.streaming {
+		return hw.ResponseWriter.Write(b)
+	}
+	return hw.buf.Write(b)
+}
+
+// startStreaming, переключает ответ в потоковый режим: отправляет накопленное без подписи.
+func (hw *hashWriter) startStreaming() error {
+	if hw.streaming {
+		return nil
+	}
+	hw.streaming = true
+
+	if hw.status != 0 {
+		hw.ResponseWriter.WriteHeader(hw.status)
+	}
+	if hw.buf.Len() > 0 {
+		_, err := hw.ResponseWriter.Write(hw.buf.Bytes())
+		hw.buf.Reset()
+		return err
+	}
+
+	return nil
+}
+
+// Flush, поддержка http.Flusher для потоковых ответов.
+func (hw *hashWriter) Flush() {
+	if err := hw.startStreaming(); err != nil {
+		logger.Log.Errorf("ошибка отправки ответа, %s", err.Error())
+		return
+	}
+
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
-func (hw hashWriter) Write(b []byte) (int, error) {
-	hw.Header().Add("HashSHA256", util.Hash(b, hw.key))
+// Hijack, поддержка http.Hijacker для WebSocket.
+func (hw *hashWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := hw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.ResponseWriter не поддерживает Hijack")
+	}
+	// статус 101 должен уйти клиенту до перехвата соединения
+	if err := hw.startStreaming(); err != nil {
+		return nil, nil, err
+	}
 
-	bytesCount, err := hw.w.Write(b)
-	return bytesCount, fmt.Errorf("%w", err)
+	return hj.Hijack()
 }
-func (hw *hashWriter) Close() error {
-	if c, ok := hw.w.(io.WriteCloser); ok {
-		err := c.Close()
-		return fmt.Errorf("%w", err)
+
+// flush, подписывает накопленный ответ ключом keyID и отправляет его клиенту.
+func (hw *hashWriter) flush(keys *signature.Keys, keyID string) error {
+	if hw.streaming {
+		return nil
+	}
+
+	id, hash := keys.SignWith(keyID, hw.buf.Bytes())
+	hw.ResponseWriter.Header().Set(signature.Header, hash)
+	if id != "" {
+		hw.ResponseWriter.Header().Set(signature.KeyIDHeader, id)
+	}
+
+	if hw.status != 0 {
+		hw.ResponseWriter.WriteHeader(hw.status)
 	}
 
-	err := errors.New("middlewares: io.WriteCloser is unavailable on the writer")
-	return fmt.Errorf("%w", err)
+	_, err := hw.ResponseWriter.Write(hw.buf.Bytes())
+	return err
 }
 
-// ResposeHeaderWithHash, мидлваря, которая добавляет хэш от контента в заголовок HashSHA256
+// ResposeHeaderWithHash, мидлваря, которая добавляет HMAC-подпись ответа в заголовок HashSHA256
+// и идентификатор ключа в заголовок HashKeyID. Ответ подписывается тем же ключом, что и запрос.
 func ResposeHeaderWithHash(key string) func(http.Handler) http.Handler {
+	keys := signature.ParseKeys(key)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			wr := w
+			if !keys.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			if key != "" {
-				hw := hashWriter{
-					ResponseWriter: w,
-					w:              w,
-					key:            key,
-				}
+			hw := &hashWriter{ResponseWriter: w}
 
-				wr = hw
+			next.ServeHTTP(hw, r)
 
-				defer func() {
-					err := hw.Close()
-					if err != nil {
-						logger.Log.Error("Ошибка закрытия HashWriter, %s", err.Error())
-					}
-				}()
+			if err := hw.flush(keys, signature.KeyIDFromContext(r.Context())); err != nil {
+				logger.Log.Errorf("ошибка отправки подписанного ответа, %s", err.Error())
 			}
-
-			next.ServeHTTP(wr, r)
 		})
 	}
 }
@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/ratelimit"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
+)
+
+// RateLimit, мидлваря ограничения частоты запросов клиента. Клиент определяется
+// по ip-адресу (с учетом доверенных прокси resolver), API-ключу или идентификатору агента.
+// Ключ берется из контекста, поэтому для ограничения по ключу или агенту мидлваря
+// подключается после RequireScope. При превышении лимита отвечает 429 с заголовком Retry-After.
+func RateLimit(limiter *ratelimit.Limiter, keyKind string, resolver *validator.SubnetFilter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var clientIP string
+			if peer, err := validator.ParseAddrPort(r.RemoteAddr); err == nil {
+				ip, err := resolver.ClientIP(peer, r.Header.Get("X-Real-IP"), strings.Join(r.Header.Values("X-Forwarded-For"), ","))
+				if err == nil {
+					clientIP = ip.String()
+				}
+			}
+			if clientIP == "" {
+				clientIP = r.RemoteAddr
+			}
+
+			var keyID string
+			if key, ok := auth.FromContext(r.Context()); ok {
+				keyID = key.ID
+			}
+
+			key := ratelimit.Key(keyKind, clientIP, keyID, r.Header.Get(internal.AgentIDHeader))
+			if ok, wait := limiter.Allow(key); !ok {
+				logger.Log.Debugf("превышена частота запросов клиента %s", clientIP)
+				w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(wait)))
+				http.Error(w, "слишком много запросов", http.StatusTooManyRequests)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
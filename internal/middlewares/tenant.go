@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// WithTenant, мидлваря, определяющая тенант запроса по API-ключу или заголовку X-Tenant-ID.
+func WithTenant(keys map[string]string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, err := tenant.Resolve(keys, r.Header.Get(internal.APIKeyHeader), r.Header.Get(tenant.Header))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), name)))
+		})
+	}
+}
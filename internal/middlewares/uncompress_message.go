@@ -43,29 +43,33 @@ func (c *compressReader) Close() error {
 }
 
 // WithUnzipRequest, мидлваря для распаковки принятых сжатых данных.
-func WithUnzipRequest(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// распаковка входящих сжатых данных
-		contentEncoding := r.Header.Get("Content-Encoding")
-		sendsGzip := strings.Contains(contentEncoding, "gzip")
+// Распакованные данные ограничены maxSize байтами, чтобы gzip-бомба
+// не исчерпала память сервера: чтение сверх лимита завершается ошибкой *http.MaxBytesError.
+func WithUnzipRequest(maxSize int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// распаковка входящих сжатых данных
+			contentEncoding := r.Header.Get("Content-Encoding")
+			sendsGzip := strings.Contains(contentEncoding, "gzip")
 
-		if sendsGzip {
-			cr, err := newCompressReader(r.Body)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
-			r.Body = cr
-
-			defer func() {
-				err := cr.Close()
+			if sendsGzip {
+				cr, err := newCompressReader(r.Body)
 				if err != nil {
-					logger.Log.Errorf("ошибка закрытия reader, %s", err.Error())
+					http.Error(w, err.Error(), BodyErrorStatus(err, http.StatusBadRequest))
+					return
 				}
-			}()
-		}
 
-		next.ServeHTTP(w, r)
-	})
+				r.Body = http.MaxBytesReader(w, cr, maxSize)
+
+				defer func() {
+					err := cr.Close()
+					if err != nil {
+						logger.Log.Errorf("ошибка закрытия reader, %s", err.Error())
+					}
+				}()
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
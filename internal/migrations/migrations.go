@@ -0,0 +1,368 @@
+// Версионные миграции схемы БД. Миграции встроены в бинарник (каталог sql),
+// файл миграции называется <версия>_<название>.up.sql, откат - <версия>_<название>.down.sql.
+// Примененные версии хранятся в таблице schema_version. Миграции выполняются под
+// advisory-блокировкой, поэтому несколько серверов, запущенных одновременно,
+// не применяют одну миграцию дважды.
+
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// lockID, ключ advisory-блокировки миграций.
+const lockID int64 = 0x6d69677261746521
+
+// Ошибки миграций.
+var (
+	ErrBadMigration   = errors.New("неверный файл миграции")
+	ErrUnknownVersion = errors.New("неизвестная версия схемы")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration, миграция схемы БД.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State, миграция и время ее применения. Нулевое время - миграция не применена.
+type State struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// All, встроенные миграции по возрастанию версий.
+func All() ([]Migration, error) {
+	return load(embedded)
+}
+
+// load, читает миграции из каталога sql. Для каждой версии нужны файлы применения и отката.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать каталог миграций, %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		parts := fileName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadMigration, e.Name())
+		}
+
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrBadMigration, e.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать миграцию %s, %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("%w: разные названия версии %d", ErrBadMigration, version)
+		}
+
+		if parts[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%w: у версии %d нет файла применения или отката", ErrBadMigration, m.Version)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	return list, nil
+}
+
+// Migrator, применяет и откатывает миграции.
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// New, создает мигратор встроенных миграций.
+func New(pool *pgxpool.Pool) (*Migrator, error) {
+	list, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{pool: pool, migrations: list}, nil
+}
+
+// Up, применяет встроенные миграции к БД пула.
+func Up(ctx context.Context, pool *pgxpool.Pool) error {
+	m, err := New(pool)
+	if err != nil {
+		return err
+	}
+
+	return m.Up(ctx)
+}
+
+// Latest, последняя известная версия схемы.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up, применяет все непримененные миграции. Версии новее известных (БД обновлена
+// более новой версией сервера) не откатываются.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for v := range applied {
+			if v > m.Latest() {
+				logger.Log.Warnf("в БД применена неизвестная миграция %d, возможно, БД обновлена более новой версией сервера", v)
+			}
+		}
+
+		return m.applyMissing(ctx, conn, applied, m.Latest())
+	})
+}
+
+// Down, откатывает steps последних примененных миграций.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			if err := m.revert(ctx, conn, versions[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// To, приводит схему к версии target: применяет недостающие миграции до нее
+// и откатывает примененные миграции новее нее.
+func (m *Migrator) To(ctx context.Context, target int64) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	return m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		newer := make([]int64, 0)
+		for v := range applied {
+			if v > target {
+				newer = append(newer, v)
+			}
+		}
+		sort.Slice(newer, func(i, j int) bool { return newer[i] > newer[j] })
+
+		for _, v := range newer {
+			if err := m.revert(ctx, conn, v); err != nil {
+				return err
+			}
+		}
+
+		return m.applyMissing(ctx, conn, applied, target)
+	})
+}
+
+// applyMissing, применяет по порядку непримененные миграции до версии target включительно.
+func (m *Migrator) applyMissing(ctx context.Context, conn *pgxpool.Conn, applied map[int64]time.Time, target int64) error {
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; ok || mg.Version > target {
+			continue
+		}
+		if err := m.apply(ctx, conn, mg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status, состояние встроенных миграций.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	var states []State
+
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			states = append(states, State{Migration: mg, AppliedAt: applied[mg.Version]})
+			delete(applied, mg.Version)
+		}
+		for v := range applied {
+			logger.Log.Warnf("в БД применена неизвестная миграция %d, возможно, БД обновлена более новой версией сервера", v)
+		}
+
+		return nil
+	})
+
+	return states, err
+}
+
+// locked, выполняет fn на отдельном соединении под advisory-блокировкой миграций.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения соединения из пула, %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("ошибка блокировки миграций, %w", err)
+	}
+	defer func() {
+		// блокировка сессии: снимается явно, соединение возвращается в пул
+		if _, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+			logger.Log.Errorf("ошибка снятия блокировки миграций, %s", err.Error())
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version
+		(
+			version bigint NOT NULL,
+			name TEXT NOT NULL,
+			applied_at timestamp with time zone NOT NULL DEFAULT now(),
+			CONSTRAINT schema_version_pkey PRIMARY KEY (version)
+		);
+	`); err != nil {
+		return fmt.Errorf("ошибка создания таблицы версий схемы, %w", err)
+	}
+
+	return fn(conn)
+}
+
+// apply, применяет миграцию и записывает ее версию в одной транзакции.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, mg Migration) error {
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, mg.Up); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, "INSERT INTO schema_version (version, name) VALUES ($1, $2)", mg.Version, mg.Name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка применения миграции %d_%s, %w", mg.Version, mg.Name, err)
+	}
+
+	logger.Log.Infof("применена миграция %d_%s", mg.Version, mg.Name)
+	return nil
+}
+
+// revert, откатывает примененную миграцию и удаляет ее версию в одной транзакции.
+func (m *Migrator) revert(ctx context.Context, conn *pgxpool.Conn, version int64) error {
+	mg, ok := m.find(version)
+	if !ok {
+		return fmt.Errorf("%w: нет файла отката версии %d", ErrUnknownVersion, version)
+	}
+
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, mg.Down); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, "DELETE FROM schema_version WHERE version = $1", mg.Version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отката миграции %d_%s, %w", mg.Version, mg.Name, err)
+	}
+
+	logger.Log.Infof("откачена миграция %d_%s", mg.Version, mg.Name)
+	return nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, mg := range m.migrations {
+		if mg.Version == version {
+			return mg, true
+		}
+	}
+
+	return Migration{}, false
+}
+
+// appliedVersions, примененные версии и время их применения.
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения версий схемы, %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version int64
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("ошибка чтения версий схемы, %w", err)
+		}
+		applied[version] = at
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения версий схемы, %w", err)
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAll(t *testing.T) {
+	list, err := All()
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+
+	for i, m := range list {
+		assert.Equal(t, int64(i+1), m.Version, "версии идут подряд с 1")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+
+	tests := []struct {
+		name    string
+		fs      fstest.MapFS
+		want    []int64
+		wantErr bool
+	}{
+		{
+			name: "ordered by version",
+			fs: fstest.MapFS{
+				"sql/0010_index.up.sql":   file("CREATE INDEX"),
+				"sql/0010_index.down.sql": file("DROP INDEX"),
+				"sql/0002_init.up.sql":    file("CREATE TABLE"),
+				"sql/0002_init.down.sql":  file("DROP TABLE"),
+			},
+			want: []int64{2, 10},
+		},
+		{
+			name:    "missing down",
+			fs:      fstest.MapFS{"sql/0001_init.up.sql": file("CREATE TABLE")},
+			wantErr: true,
+		},
+		{
+			name: "different names of one version",
+			fs: fstest.MapFS{
+				"sql/0001_init.up.sql":    file("CREATE TABLE"),
+				"sql/0001_other.down.sql": file("DROP TABLE"),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "bad file name",
+			fs:      fstest.MapFS{"sql/init.sql": file("CREATE TABLE")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := load(tt.fs)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadMigration)
+				return
+			}
+			require.NoError(t, err)
+
+			versions := make([]int64, 0, len(list))
+			for _, m := range list {
+				versions = append(versions, m.Version)
+			}
+			assert.Equal(t, tt.want, versions)
+		})
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS counter;
+DROP TABLE IF EXISTS gauge;
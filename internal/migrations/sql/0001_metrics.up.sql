@@ -0,0 +1,28 @@
+-- таблицы метрик; IF NOT EXISTS - для баз, созданных до появления миграций
+CREATE TABLE IF NOT EXISTS counter
+(
+    id  bigserial not null,
+    tenant TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    value bigint NOT NULL,
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT counter_pkey PRIMARY KEY (id)
+);
+CREATE TABLE IF NOT EXISTS gauge
+(
+    id bigserial NOT NULL,
+    tenant TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    value double precision NOT NULL,
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT gauge_pkey PRIMARY KEY (id)
+);
+-- метрики разных тенантов могут иметь одинаковые имена
+ALTER TABLE counter ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE counter ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE counter DROP CONSTRAINT IF EXISTS counter_metric_name;
+CREATE UNIQUE INDEX IF NOT EXISTS counter_tenant_name ON counter (tenant, name);
+ALTER TABLE gauge ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE gauge ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE gauge DROP CONSTRAINT IF EXISTS gauge_metric_name;
+CREATE UNIQUE INDEX IF NOT EXISTS gauge_tenant_name ON gauge (tenant, name);
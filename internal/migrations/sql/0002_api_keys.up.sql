@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    tenant TEXT NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    revoked_at timestamp with time zone,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT api_keys_hash UNIQUE (hash)
+);
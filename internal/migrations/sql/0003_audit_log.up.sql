@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id BIGSERIAL NOT NULL,
+    ts timestamp with time zone NOT NULL,
+    op TEXT NOT NULL,
+    transport TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    agent_id TEXT NOT NULL DEFAULT '',
+    key_id TEXT NOT NULL DEFAULT '',
+    tenant TEXT NOT NULL DEFAULT '',
+    metric TEXT NOT NULL DEFAULT '',
+    mtype TEXT NOT NULL DEFAULT '',
+    value double precision,
+    delta bigint,
+    details TEXT NOT NULL DEFAULT '',
+    CONSTRAINT audit_log_pkey PRIMARY KEY (id)
+);
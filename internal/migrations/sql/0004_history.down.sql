@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS metric_rollup_watermark;
+DROP TABLE IF EXISTS metric_rollup;
+DROP TABLE IF EXISTS metric_history;
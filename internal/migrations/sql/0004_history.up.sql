@@ -0,0 +1,34 @@
+-- исходные значения метрик для истории
+CREATE TABLE IF NOT EXISTS metric_history
+(
+    tenant TEXT NOT NULL DEFAULT '',
+    mtype TEXT NOT NULL,
+    name TEXT NOT NULL,
+    ts timestamp with time zone NOT NULL,
+    value double precision NOT NULL
+);
+CREATE INDEX IF NOT EXISTS metric_history_series_ts ON metric_history (tenant, mtype, name, ts);
+CREATE INDEX IF NOT EXISTS metric_history_ts ON metric_history (ts);
+-- агрегаты значений по интервалам, resolution - длина интервала в секундах
+CREATE TABLE IF NOT EXISTS metric_rollup
+(
+    tenant TEXT NOT NULL DEFAULT '',
+    mtype TEXT NOT NULL,
+    name TEXT NOT NULL,
+    resolution bigint NOT NULL,
+    bucket timestamp with time zone NOT NULL,
+    min double precision NOT NULL,
+    max double precision NOT NULL,
+    sum double precision NOT NULL,
+    count bigint NOT NULL,
+    last double precision NOT NULL,
+    CONSTRAINT metric_rollup_pkey PRIMARY KEY (tenant, mtype, name, resolution, bucket)
+);
+CREATE INDEX IF NOT EXISTS metric_rollup_resolution_bucket ON metric_rollup (resolution, bucket);
+-- время, до которого значения уже агрегированы, для каждого разрешения
+CREATE TABLE IF NOT EXISTS metric_rollup_watermark
+(
+    resolution bigint NOT NULL,
+    rolled_to timestamp with time zone NOT NULL,
+    CONSTRAINT metric_rollup_watermark_pkey PRIMARY KEY (resolution)
+);
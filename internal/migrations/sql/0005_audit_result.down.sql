@@ -0,0 +1,4 @@
+ALTER TABLE audit_log DROP COLUMN IF EXISTS result;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS call_id;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS old_counter;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS old_value;
@@ -0,0 +1,5 @@
+-- значения до изменения и результат изменения метрик
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS old_value double precision;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS old_counter bigint;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS call_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS result TEXT NOT NULL DEFAULT '';
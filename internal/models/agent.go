@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Статусы агента в реестре сервера.
+const (
+	AgentOnline  string = "online"
+	AgentOffline string = "offline"
+)
+
+// AgentInfo, сведения об агенте, которые он сообщает серверу при старте и периодически (heartbeat).
+type AgentInfo struct {
+	ID         string   `json:"id"`         // идентификатор агента
+	Hostname   string   `json:"hostname"`   // имя хоста, на котором запущен агент
+	Version    string   `json:"version"`    // версия сборки агента
+	IP         string   `json:"ip"`         // ip-адрес агента
+	Collectors []string `json:"collectors"` // включенные сборщики метрик
+}
+
+// AgentStatus, состояние агента в реестре сервера.
+type AgentStatus struct {
+	AgentInfo
+	LastSeen time.Time `json:"last_seen"` // время последнего heartbeat
+	Status   string    `json:"status"`    // online или offline
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // Модель коммуникации метрик.
 type MetricItem struct {
 	ID    string   `json:"id"`              // имя метрики
@@ -8,7 +10,35 @@ type MetricItem struct {
 	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
 }
 
+// Модель сохранения метрик в файл. Метрики тенантов (кроме тенанта по умолчанию)
+// сохраняются во вложенных DumpItem.
 type DumpItem struct {
-	Gauges   map[string]float64 `json:"gauges"`
-	Counters map[string]int64   `json:"counters"`
+	Gauges   map[string]float64  `json:"gauges"`
+	Counters map[string]int64    `json:"counters"`
+	Tenants  map[string]DumpItem `json:"tenants,omitempty"`
+	WALSeq   uint64              `json:"wal_seq,omitempty"` // номер последней записи журнала WAL, вошедшей в снимок
+}
+
+// MetricInfo, текущее значение метрики с временем последнего обновления.
+type MetricInfo struct {
+	Name      string    `json:"id"`
+	MType     string    `json:"type"`
+	Value     float64   `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"` // нулевое - время обновления неизвестно
+}
+
+// Point, значение метрики в момент времени.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Bucket, агрегат значений метрики за интервал, начинающийся в Time.
+type Bucket struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Last  float64   `json:"last"` // последнее значение в интервале
+	Count int64     `json:"count"`
 }
@@ -0,0 +1,383 @@
+// Внутренняя шина обновлений метрик: хранилище публикует новые значения
+// после записи, подписчики (SSE, WebSocket, gRPC) получают их по фильтру имен.
+
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// DefaultBuffer, размер очереди обновлений подписчика по умолчанию.
+const DefaultBuffer = 256
+
+// DefaultReplay, сколько последних обновлений шина хранит для возобновления подписок.
+const DefaultReplay = 1024
+
+// Ошибки шины обновлений.
+var (
+	ErrBadPattern = errors.New("неверный шаблон имени метрики")
+	ErrBadCursor  = errors.New("неверный курсор")
+)
+
+// Cursor, позиция в потоке обновлений тенанта. Epoch отличает запуски сервера:
+// курсор предыдущего запуска не используется для повтора обновлений.
+type Cursor struct {
+	Epoch int64
+	Seq   uint64
+}
+
+// String, представление курсора для клиента: epoch.seq.
+func (c Cursor) String() string {
+	return strconv.FormatInt(c.Epoch, 10) + "." + strconv.FormatUint(c.Seq, 10)
+}
+
+// IsZero, курсор не задан.
+func (c Cursor) IsZero() bool {
+	return c == Cursor{}
+}
+
+// ParseCursor, разбирает курсор из строки epoch.seq. Пустая строка - нулевой курсор.
+func ParseCursor(value string) (Cursor, error) {
+	if value == "" {
+		return Cursor{}, nil
+	}
+
+	epoch, seq, ok := strings.Cut(value, ".")
+	if !ok {
+		return Cursor{}, fmt.Errorf("%w %q", ErrBadCursor, value)
+	}
+
+	var (
+		c   Cursor
+		err error
+	)
+	if c.Epoch, err = strconv.ParseInt(epoch, 10, 64); err != nil {
+		return Cursor{}, fmt.Errorf("%w %q", ErrBadCursor, value)
+	}
+	if c.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return Cursor{}, fmt.Errorf("%w %q", ErrBadCursor, value)
+	}
+
+	return c, nil
+}
+
+// Update, новое значение метрики тенанта.
+type Update struct {
+	Tenant string
+	Cursor Cursor // позиция обновления в потоке тенанта, заполняется шиной
+	models.MetricInfo
+}
+
+// Hub, рассылает обновления метрик подписчикам и хранит последние обновления
+// для возобновления подписок с курсора. У каждого тенанта свой поток обновлений
+// со своей блокировкой: публикации разных тенантов не ждут друг друга.
+type Hub struct {
+	mx         sync.RWMutex
+	topics     map[string]*topic
+	closed     bool
+	epoch      int64
+	replaySize int
+}
+
+// topic, поток обновлений метрик тенанта.
+type topic struct {
+	mx     sync.Mutex
+	subs   map[*Subscription]struct{}
+	seq    uint64
+	replay []Update // кольцевой буфер последних обновлений, индекс - seq % len
+
+	replayFrom uint64 // seq, с которого обновления есть в буфере
+	idleSeq    uint64 // seq, на котором отписался последний подписчик
+
+	active atomic.Bool // есть подписчики или подписку еще можно возобновить: обновления нужно публиковать
+}
+
+// NewHub, создает шину обновлений, хранящую replay последних обновлений тенанта.
+// Буфер создается при возобновляемой подписке тенанта (SubscribeFrom). После ухода
+// последнего подписчика обновления сохраняются, пока подписку можно возобновить:
+// следующие replay обновлений, затем буфер удаляется и обновления без подписчиков
+// не публикуются. При replay = 0 возобновление не поддерживается.
+func NewHub(replay int) *Hub {
+	return &Hub{
+		topics:     make(map[string]*topic),
+		epoch:      time.Now().UnixNano(),
+		replaySize: replay,
+	}
+}
+
+// ParsePatterns, разбирает список имен метрик или шаблонов (path.Match: *, ?, [...]),
+// разделенных запятыми. Пустой список - все метрики.
+func ParsePatterns(values ...string) ([]string, error) {
+	var patterns []string
+	for _, value := range values {
+		for _, p := range strings.Split(value, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("%w %q", ErrBadPattern, p)
+			}
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns, nil
+}
+
+// Subscribe, подписывает на обновления метрик тенанта, имена которых подходят под шаблоны.
+// buffer - размер очереди: если подписчик не успевает читать, новые обновления отбрасываются.
+func (h *Hub) Subscribe(tenant string, patterns []string, buffer int) *Subscription {
+	sub, _, _ := h.subscribe(tenant, patterns, buffer, Cursor{}, false)
+	return sub
+}
+
+// SubscribeFrom, подписывает на обновления и возвращает подходящие под подписку обновления,
+// опубликованные после курсора from. ok = false, если повторить их нельзя: курсор
+// другого запуска сервера или обновления уже вытеснены из буфера. С этого момента
+// шина хранит последние обновления тенанта для возобновления подписок.
+func (h *Hub) SubscribeFrom(tenant string, patterns []string, buffer int, from Cursor) (sub *Subscription, missed []Update, ok bool) {
+	return h.subscribe(tenant, patterns, buffer, from, true)
+}
+
+func (h *Hub) subscribe(tenant string, patterns []string, buffer int, from Cursor, resumable bool) (sub *Subscription, missed []Update, ok bool) {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+
+	sub = &Subscription{
+		tenant:   tenant,
+		patterns: patterns,
+		ch:       make(chan Update, buffer),
+	}
+
+	// блокировка шины до регистрации подписки: Close не пропустит новую подписку
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		sub.start = Cursor{Epoch: h.epoch}
+		close(sub.ch)
+		return sub, nil, false
+	}
+	t, exists := h.topics[tenant]
+	if !exists {
+		t = &topic{subs: make(map[*Subscription]struct{})}
+		h.topics[tenant] = t
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	sub.topic = t
+	sub.start = Cursor{Epoch: h.epoch, Seq: t.seq}
+	t.subs[sub] = struct{}{}
+	if resumable && t.replay == nil && h.replaySize > 0 {
+		t.replay = make([]Update, h.replaySize)
+		t.replayFrom = t.seq
+	}
+	t.active.Store(true)
+
+	// подписка и чтение буфера под одной блокировкой: обновления не теряются и не дублируются
+	if len(t.replay) == 0 || from.Epoch != h.epoch || from.Seq > t.seq || from.Seq < t.replayFrom ||
+		t.seq-from.Seq > uint64(len(t.replay)) {
+		return sub, nil, false
+	}
+
+	for seq := from.Seq + 1; seq <= t.seq; seq++ {
+		u := t.replay[seq%uint64(len(t.replay))]
+		if sub.Match(u.Name) {
+			missed = append(missed, u)
+		}
+	}
+
+	return sub, missed, true
+}
+
+// topic, поток обновлений тенанта, nil - на обновления тенанта никто не подписывался.
+func (h *Hub) topic(tenant string) *topic {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+
+	return h.topics[tenant]
+}
+
+// wants, нужно ли публиковать обновления метрик тенанта: есть подписчики
+// или обновления сохраняются для возобновления недавно закрытых подписок.
+func (h *Hub) wants(tenant string) bool {
+	t := h.topic(tenant)
+	return t != nil && t.active.Load()
+}
+
+// Cursor, текущая позиция в потоке обновлений тенанта.
+func (h *Hub) Cursor(tenant string) Cursor {
+	c := Cursor{Epoch: h.epoch}
+	if t := h.topic(tenant); t != nil {
+		t.mx.Lock()
+		c.Seq = t.seq
+		t.mx.Unlock()
+	}
+
+	return c
+}
+
+// HasSubscribers, есть ли подписчики на обновления метрик тенанта.
+func (h *Hub) HasSubscribers(tenant string) bool {
+	t := h.topic(tenant)
+	if t == nil {
+		return false
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return len(t.subs) > 0
+}
+
+// Publish, присваивает обновлениям курсоры и отправляет их подходящим подписчикам без ожидания.
+// Обновления одного тенанта публикуются под одной блокировкой его потока.
+func (h *Hub) Publish(updates ...Update) {
+	for len(updates) > 0 {
+		n := 1
+		for n < len(updates) && updates[n].Tenant == updates[0].Tenant {
+			n++
+		}
+		if t := h.topic(updates[0].Tenant); t != nil && t.active.Load() {
+			t.publish(h.epoch, updates[:n])
+		}
+		updates = updates[n:]
+	}
+}
+
+func (t *topic) publish(epoch int64, updates []Update) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	for _, u := range updates {
+		t.seq++
+		u.Cursor = Cursor{Epoch: epoch, Seq: t.seq}
+		if len(t.replay) > 0 {
+			t.replay[t.seq%uint64(len(t.replay))] = u
+		}
+
+		for sub := range t.subs {
+			if !sub.Match(u.Name) {
+				continue
+			}
+
+			select {
+			case sub.ch <- u:
+			default:
+				sub.dropped.Add(1)
+			}
+		}
+	}
+
+	// подписку, закрытую до вытесненных из буфера обновлений, возобновить уже нельзя
+	if len(t.subs) == 0 && t.replay != nil && t.seq-t.idleSeq > uint64(len(t.replay)) {
+		t.replay = nil
+		t.active.Store(false)
+	}
+}
+
+// Close, закрывает все подписки, новые подписки сразу закрыты.
+func (h *Hub) Close() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for _, t := range h.topics {
+		t.mx.Lock()
+		for sub := range t.subs {
+			close(sub.ch)
+			delete(t.subs, sub)
+		}
+		t.active.Store(false)
+		t.mx.Unlock()
+	}
+}
+
+// Subscription, подписка на обновления метрик.
+type Subscription struct {
+	topic    *topic
+	tenant   string
+	start    Cursor // позиция шины в момент подписки
+	pmx      sync.RWMutex
+	patterns []string
+	ch       chan Update
+	dropped  atomic.Int64
+}
+
+// C, канал обновлений. Закрывается при отписке или закрытии шины.
+func (s *Subscription) C() <-chan Update {
+	return s.ch
+}
+
+// Match, подходит ли имя метрики под шаблоны подписки.
+func (s *Subscription) Match(name string) bool {
+	s.pmx.RLock()
+	defer s.pmx.RUnlock()
+
+	if len(s.patterns) == 0 {
+		return true
+	}
+
+	for _, p := range s.patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetPatterns, заменяет шаблоны имен метрик подписки.
+func (s *Subscription) SetPatterns(patterns []string) {
+	s.pmx.Lock()
+	defer s.pmx.Unlock()
+
+	s.patterns = patterns
+}
+
+// Start, позиция шины в момент подписки: обновления после нее придут в C.
+func (s *Subscription) Start() Cursor {
+	return s.start
+}
+
+// Dropped, сколько обновлений отброшено из-за переполнения очереди.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close, отписывается от обновлений.
+func (s *Subscription) Close() {
+	t := s.topic
+	if t == nil {
+		return
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if _, ok := t.subs[s]; ok {
+		delete(t.subs, s)
+		close(s.ch)
+		if len(t.subs) == 0 {
+			t.idleSeq = t.seq
+			t.active.Store(t.replay != nil)
+		}
+	}
+}
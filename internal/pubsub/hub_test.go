@@ -0,0 +1,206 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+func TestParsePatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", values: nil, want: nil},
+		{name: "comma separated", values: []string{"Alloc, Heap*", "PollCount"}, want: []string{"Alloc", "Heap*", "PollCount"}},
+		{name: "bad pattern", values: []string{"Heap["}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePatterns(tt.values...)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadPattern)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(0)
+
+	heap := hub.Subscribe(tenant.Default, []string{"Heap*"}, 1)
+	all := hub.Subscribe(tenant.Default, nil, 10)
+	other := hub.Subscribe("team-a", nil, 10)
+
+	assert.True(t, hub.HasSubscribers(tenant.Default))
+	assert.False(t, hub.HasSubscribers("team-b"))
+
+	hub.Publish(Update{Tenant: tenant.Default, MetricInfo: models.MetricInfo{Name: "HeapAlloc", Value: 1}})
+	hub.Publish(Update{Tenant: tenant.Default, MetricInfo: models.MetricInfo{Name: "HeapSys", Value: 2}})
+	hub.Publish(Update{Tenant: tenant.Default, MetricInfo: models.MetricInfo{Name: "PollCount", Value: 3}})
+
+	// очередь на одно обновление: второе отброшено, подписчик не блокирует публикацию
+	assert.Equal(t, "HeapAlloc", (<-heap.C()).Name)
+	assert.Equal(t, int64(1), heap.Dropped())
+	assert.Len(t, all.C(), 3)
+	assert.Empty(t, other.C())
+
+	heap.Close()
+	_, ok := <-heap.C()
+	assert.False(t, ok)
+	heap.Close()
+
+	hub.Close()
+	for range all.C() {
+	}
+	_, ok = <-other.C()
+	assert.False(t, ok)
+
+	// после закрытия шины подписки сразу закрыты
+	_, ok = <-hub.Subscribe(tenant.Default, nil, 1).C()
+	assert.False(t, ok)
+}
+
+func TestParseCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Cursor
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: Cursor{}},
+		{name: "valid", value: "17.42", want: Cursor{Epoch: 17, Seq: 42}},
+		{name: "no separator", value: "17", wantErr: true},
+		{name: "bad seq", value: "17.-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCursor(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadCursor)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			if !got.IsZero() {
+				assert.Equal(t, tt.value, got.String())
+			}
+		})
+	}
+}
+
+func TestHubSubscribeFrom(t *testing.T) {
+	hub := NewHub(3)
+	defer hub.Close()
+
+	publish := func(name string) {
+		hub.Publish(Update{Tenant: tenant.Default, MetricInfo: models.MetricInfo{Name: name}})
+	}
+
+	// до первой возобновляемой подписки обновления без подписчиков не хранятся
+	publish("Alloc")
+	assert.Equal(t, uint64(0), hub.Cursor(tenant.Default).Seq)
+	watch, _, _ := hub.SubscribeFrom(tenant.Default, nil, 10, Cursor{})
+	watch.Close()
+
+	publish("HeapAlloc")
+	from := hub.Cursor(tenant.Default)
+	publish("PollCount")
+	publish("HeapSys")
+
+	// повторяются только подходящие обновления после курсора
+	sub, missed, ok := hub.SubscribeFrom(tenant.Default, []string{"Heap*"}, 1, from)
+	require.True(t, ok)
+	require.Len(t, missed, 1)
+	assert.Equal(t, "HeapSys", missed[0].Name)
+	assert.Equal(t, hub.Cursor(tenant.Default), sub.Start())
+	assert.Equal(t, Cursor{Epoch: from.Epoch, Seq: from.Seq + 2}, missed[0].Cursor)
+	sub.Close()
+
+	// курсор другого запуска сервера
+	sub, _, ok = hub.SubscribeFrom(tenant.Default, nil, 1, Cursor{Epoch: from.Epoch + 1, Seq: from.Seq})
+	assert.False(t, ok)
+	sub.Close()
+
+	// обновления вытеснены из буфера
+	publish("Alloc")
+	publish("Frees")
+	sub, _, ok = hub.SubscribeFrom(tenant.Default, nil, 1, from)
+	assert.False(t, ok)
+	sub.Close()
+}
+
+func TestHubReplayExpires(t *testing.T) {
+	hub := NewHub(2)
+	defer hub.Close()
+
+	publish := func(name string) {
+		hub.Publish(Update{Tenant: tenant.Default, MetricInfo: models.MetricInfo{Name: name}})
+	}
+
+	sub, _, _ := hub.SubscribeFrom(tenant.Default, nil, 10, Cursor{})
+	publish("Alloc")
+	from := hub.Cursor(tenant.Default)
+	sub.Close()
+
+	// подписку можно возобновить, пока обновления после нее помещаются в буфер
+	publish("HeapAlloc")
+	publish("HeapSys")
+	assert.True(t, hub.wants(tenant.Default))
+	resumed, missed, ok := hub.SubscribeFrom(tenant.Default, nil, 10, from)
+	require.True(t, ok)
+	assert.Len(t, missed, 2)
+	resumed.Close()
+
+	// буфер переполнен без подписчиков: обновления больше не публикуются
+	from = hub.Cursor(tenant.Default)
+	publish("PollCount")
+	publish("Frees")
+	publish("Mallocs")
+	assert.False(t, hub.wants(tenant.Default))
+	publish("Lookups")
+	assert.Equal(t, from.Seq+3, hub.Cursor(tenant.Default).Seq)
+
+	// новый буфер не выдает обновления, опубликованные до его создания
+	sub, _, ok = hub.SubscribeFrom(tenant.Default, nil, 10, Cursor{Epoch: from.Epoch, Seq: from.Seq + 2})
+	assert.False(t, ok)
+	sub.Close()
+}
+
+func TestPublishingStorage(t *testing.T) {
+	hub := NewHub(0)
+	s := NewStorage(storage.NewMemory(10), hub)
+	sub := hub.Subscribe(tenant.Default, []string{"PollCount", "Alloc"}, 10)
+	defer sub.Close()
+
+	ctx := context.Background()
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, s.SaveCountersBatch(ctx, map[string]models.Counter{"PollCount": 3}))
+	require.NoError(t, s.SaveGaugesBatch(ctx, map[string]models.Gauge{"Alloc": 1.5, "Other": 1}))
+	// у тенанта без подписчиков ничего не публикуется
+	require.NoError(t, s.SetGauge(tenant.WithTenant(ctx, "team-a"), "Alloc", 7))
+
+	got := []models.MetricInfo{(<-sub.C()).MetricInfo, (<-sub.C()).MetricInfo, (<-sub.C()).MetricInfo}
+	assert.Empty(t, sub.C())
+
+	// для counter публикуется накопленное значение
+	assert.Equal(t, "counter", got[0].MType)
+	assert.Equal(t, float64(2), got[0].Value)
+	assert.Equal(t, float64(5), got[1].Value)
+	assert.Equal(t, "Alloc", got[2].Name)
+	assert.Equal(t, "gauge", got[2].MType)
+	assert.False(t, got[2].UpdatedAt.IsZero())
+}
@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+)
+
+// Storage, интерфейс хранилища метрик (совпадает с handlers.Storage).
+type Storage interface {
+	GetGauge(ctx context.Context, name string) (models.Gauge, error)
+	GetCounter(ctx context.Context, name string) (models.Counter, error)
+	Ping(ctx context.Context) error
+	ToList(ctx context.Context) ([]string, error)
+	Items(ctx context.Context) ([]models.MetricInfo, error)
+	SetGauge(ctx context.Context, name string, val float64) error
+	SetCounter(ctx context.Context, name string, val int64) error
+	SaveGaugesBatch(context.Context, map[string]models.Gauge) error
+	SaveCountersBatch(context.Context, map[string]models.Counter) error
+	SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error
+}
+
+// PublishingStorage, хранилище, публикующее в шину новые значения метрик после записи.
+// Если у тенанта нет подписчиков и закрытые подписки уже нельзя возобновить,
+// ничего не публикуется и значения counter не перечитываются.
+type PublishingStorage struct {
+	Storage
+	hub *Hub
+}
+
+// NewStorage, оборачивает хранилище публикацией обновлений в hub.
+func NewStorage(inner Storage, hub *Hub) *PublishingStorage {
+	return &PublishingStorage{Storage: inner, hub: hub}
+}
+
+func (s *PublishingStorage) SetGauge(ctx context.Context, name string, val float64) error {
+	if err := s.Storage.SetGauge(ctx, name, val); err != nil {
+		return err
+	}
+	s.publishGauges(ctx, map[string]models.Gauge{name: models.Gauge(val)})
+
+	return nil
+}
+
+func (s *PublishingStorage) SetCounter(ctx context.Context, name string, val int64) error {
+	if err := s.Storage.SetCounter(ctx, name, val); err != nil {
+		return err
+	}
+	s.publishCounters(ctx, []string{name})
+
+	return nil
+}
+
+func (s *PublishingStorage) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	if err := s.Storage.SaveGaugesBatch(ctx, gauges); err != nil {
+		return err
+	}
+	s.publishGauges(ctx, gauges)
+
+	return nil
+}
+
+func (s *PublishingStorage) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	if err := s.Storage.SaveCountersBatch(ctx, counters); err != nil {
+		return err
+	}
+	s.publishCounters(ctx, util.SortedKeys(counters))
+
+	return nil
+}
+
+func (s *PublishingStorage) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	if err := s.Storage.SaveBatch(ctx, gauges, counters); err != nil {
+		return err
+	}
+	s.publishGauges(ctx, gauges)
+	s.publishCounters(ctx, util.SortedKeys(counters))
+
+	return nil
+}
+
+// publishGauges, публикует записанные значения gauge: после успешной записи они совпадают с хранимыми.
+func (s *PublishingStorage) publishGauges(ctx context.Context, gauges map[string]models.Gauge) {
+	name := tenant.FromContext(ctx)
+	if !s.hub.wants(name) {
+		return
+	}
+
+	now := time.Now()
+	updates := make([]Update, 0, len(gauges))
+	for _, n := range util.SortedKeys(gauges) {
+		updates = append(updates, Update{Tenant: name, MetricInfo: models.MetricInfo{Name: n, MType: internal.InGaugeName, Value: float64(gauges[n]), UpdatedAt: now}})
+	}
+	s.hub.Publish(updates...)
+}
+
+// publishCounters, публикует накопленные значения counter, а не приращения из запроса.
+// Значения перечитываются, только если обновления тенанта нужны шине (Hub.wants).
+func (s *PublishingStorage) publishCounters(ctx context.Context, names []string) {
+	name := tenant.FromContext(ctx)
+	if !s.hub.wants(name) {
+		return
+	}
+
+	now := time.Now()
+	updates := make([]Update, 0, len(names))
+	for _, n := range names {
+		if v, err := s.Storage.GetCounter(ctx, n); err == nil {
+			updates = append(updates, Update{Tenant: name, MetricInfo: models.MetricInfo{Name: n, MType: internal.InCounterName, Value: float64(v), UpdatedAt: now}})
+		}
+	}
+	s.hub.Publish(updates...)
+}
@@ -0,0 +1,140 @@
+// Квоты тенантов на количество серий метрик. Реализована как обертка над
+// хранилищем: перед записью новой серии проверяет, сколько серий уже есть у тенанта.
+
+package quota
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/handlers"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// SeriesCounter, хранилище, умеющее считать серии метрик тенанта из контекста.
+type SeriesCounter interface {
+	SeriesCount(ctx context.Context) (int, error)
+}
+
+// Limits, квоты на количество серий: общая для всех тенантов и персональные.
+// Нулевое значение - без ограничений.
+type Limits struct {
+	Default int
+	Tenants map[string]int
+}
+
+func (l Limits) limit(name string) int {
+	if v, ok := l.Tenants[name]; ok {
+		return v
+	}
+
+	return l.Default
+}
+
+// Storage, хранилище с проверкой квот тенантов.
+//
+// Проверка не атомарна с записью: при параллельной записи новых серий
+// тенант может ненамного превысить квоту.
+type Storage struct {
+	handlers.Storage
+	counter SeriesCounter
+	limits  Limits
+}
+
+// NewStorage, оборачивает хранилище проверкой квот.
+func NewStorage(inner handlers.Storage, counter SeriesCounter, limits Limits) *Storage {
+	return &Storage{Storage: inner, counter: counter, limits: limits}
+}
+
+func (s *Storage) SetGauge(ctx context.Context, name string, val float64) error {
+	if _, err := s.Storage.GetGauge(ctx, name); err != nil {
+		if err := s.check(ctx, 1); err != nil {
+			return err
+		}
+	}
+
+	return s.Storage.SetGauge(ctx, name, val)
+}
+
+func (s *Storage) SetCounter(ctx context.Context, name string, val int64) error {
+	if _, err := s.Storage.GetCounter(ctx, name); err != nil {
+		if err := s.check(ctx, 1); err != nil {
+			return err
+		}
+	}
+
+	return s.Storage.SetCounter(ctx, name, val)
+}
+
+func (s *Storage) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	newSeries := 0
+	for k := range gauges {
+		if _, err := s.Storage.GetGauge(ctx, k); err != nil {
+			newSeries++
+		}
+	}
+
+	if err := s.check(ctx, newSeries); err != nil {
+		return err
+	}
+
+	return s.Storage.SaveGaugesBatch(ctx, gauges)
+}
+
+func (s *Storage) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	newSeries := 0
+	for k := range counters {
+		if _, err := s.Storage.GetCounter(ctx, k); err != nil {
+			newSeries++
+		}
+	}
+
+	if err := s.check(ctx, newSeries); err != nil {
+		return err
+	}
+
+	return s.Storage.SaveCountersBatch(ctx, counters)
+}
+
+// SaveBatch, проверяет квоту сразу для всех новых серий пакета: пакет сохраняется целиком или отклоняется.
+func (s *Storage) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	newSeries := 0
+	for k := range gauges {
+		if _, err := s.Storage.GetGauge(ctx, k); err != nil {
+			newSeries++
+		}
+	}
+	for k := range counters {
+		if _, err := s.Storage.GetCounter(ctx, k); err != nil {
+			newSeries++
+		}
+	}
+
+	if err := s.check(ctx, newSeries); err != nil {
+		return err
+	}
+
+	return s.Storage.SaveBatch(ctx, gauges, counters)
+}
+
+// check, проверяет, что тенант из контекста может добавить newSeries новых серий.
+func (s *Storage) check(ctx context.Context, newSeries int) error {
+	name := tenant.FromContext(ctx)
+
+	limit := s.limits.limit(name)
+	if limit <= 0 || newSeries == 0 {
+		return nil
+	}
+
+	count, err := s.counter.SeriesCount(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка подсчета метрик тенанта, %w", err)
+	}
+
+	if count+newSeries > limit {
+		return fmt.Errorf("тенант %q, лимит %d: %w", name, limit, tenant.ErrQuotaExceeded)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package quota
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+func TestStorage_Quota(t *testing.T) {
+	mem := storage.NewMemory(10)
+	s := NewStorage(mem, mem, Limits{Default: 2, Tenants: map[string]int{"big": 3}})
+
+	ctxA := tenant.WithTenant(context.Background(), "team-a")
+	ctxBig := tenant.WithTenant(context.Background(), "big")
+
+	assert.NoError(t, s.SetGauge(ctxA, "Alloc", 1))
+	assert.NoError(t, s.SetCounter(ctxA, "PollCount", 1))
+	// обновление существующих серий не ограничивается
+	assert.NoError(t, s.SetGauge(ctxA, "Alloc", 2))
+	assert.NoError(t, s.SetCounter(ctxA, "PollCount", 1))
+
+	assert.ErrorIs(t, s.SetGauge(ctxA, "HeapSys", 3), tenant.ErrQuotaExceeded)
+	assert.ErrorIs(t, s.SaveGaugesBatch(ctxA, map[string]models.Gauge{"Alloc": 1, "Frees": 2}), tenant.ErrQuotaExceeded)
+
+	// у другого тенанта своя квота
+	assert.NoError(t, s.SaveGaugesBatch(ctxBig, map[string]models.Gauge{"Alloc": 1, "Frees": 2, "HeapSys": 3}))
+	assert.ErrorIs(t, s.SaveCountersBatch(ctxBig, map[string]models.Counter{"PollCount": 1}), tenant.ErrQuotaExceeded)
+
+	v, err := s.GetGauge(ctxA, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Gauge(2), v)
+
+	// пакет проверяется целиком: gauge в пределах квоты не сохраняется, если квоту превышают counter
+	ctxC := tenant.WithTenant(context.Background(), "team-c")
+	assert.ErrorIs(t, s.SaveBatch(ctxC,
+		map[string]models.Gauge{"Alloc": 1},
+		map[string]models.Counter{"PollCount": 1, "Requests": 1}), tenant.ErrQuotaExceeded)
+	_, err = s.GetGauge(ctxC, "Alloc")
+	assert.Error(t, err)
+
+	assert.NoError(t, s.SaveBatch(ctxC, map[string]models.Gauge{"Alloc": 1}, map[string]models.Counter{"PollCount": 1}))
+}
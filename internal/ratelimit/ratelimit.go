@@ -0,0 +1,134 @@
+// Ограничение частоты запросов клиентов к серверу по алгоритму token bucket.
+// Для каждого клиента заводится отдельная корзина: она пополняется со скоростью
+// rate токенов в секунду до burst токенов, каждый запрос забирает один токен.
+
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Способы определения клиента, к которому применяется ограничение.
+const (
+	ByIP     = "ip"      // по ip-адресу клиента
+	ByAPIKey = "api_key" // по проверенному API-ключу, без ключа - по ip-адресу
+	ByAgent  = "agent"   // по идентификатору агента запроса с проверенным API-ключом, иначе - по ip-адресу
+)
+
+// ErrUnknownKeyKind, неизвестный способ определения клиента.
+var ErrUnknownKeyKind = errors.New("неизвестный способ определения клиента для ограничения частоты запросов")
+
+// корзины, не использовавшиеся дольше этого времени, удаляются
+const idleTTL = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter, ограничитель частоты запросов по ключу клиента.
+type Limiter struct {
+	mx        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLimiter, создает ограничитель на rate запросов в секунду с запасом burst запросов.
+// Если burst меньше 1, запас равен округленной вверх частоте.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow, забирает токен из корзины клиента. Если токенов нет, возвращает false
+// и время, через которое появится следующий токен.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep, удаляет давно не использовавшиеся корзины, чтобы не копить ключи ушедших клиентов.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTTL {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ValidateKeyKind, проверяет способ определения клиента. Пустое значение - по ip-адресу.
+func ValidateKeyKind(kind string) error {
+	switch kind {
+	case "", ByIP, ByAPIKey, ByAgent:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownKeyKind, kind)
+	}
+}
+
+// Key, формирует ключ клиента для ограничителя. keyID - идентификатор API-ключа,
+// которым аутентифицирован запрос, пусто - ключа нет. Заголовки клиента не проверены,
+// поэтому идентификатор агента учитывается только вместе с ключом: иначе клиент
+// получал бы новую корзину, меняя заголовок в каждом запросе. Если нужного признака
+// нет, клиент определяется по ip-адресу.
+func Key(kind string, clientIP string, keyID string, agentID string) string {
+	switch {
+	case kind == ByAPIKey && keyID != "":
+		return "key:" + keyID
+	case kind == ByAgent && keyID != "" && agentID != "":
+		return "agent:" + keyID + "/" + agentID
+	default:
+		return "ip:" + clientIP
+	}
+}
+
+// Authenticated, определяется ли клиент по API-ключу: тогда ограничение проверяется
+// после проверки ключа, иначе - до разбора запроса.
+func Authenticated(kind string) bool {
+	return kind == ByAPIKey || kind == ByAgent
+}
+
+// RetryAfterSeconds, значение заголовка Retry-After: целое число секунд, не меньше 1.
+func RetryAfterSeconds(wait time.Duration) int {
+	return int(math.Max(1, math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	// запас burst расходуется сразу
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+
+	ok, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// у другого клиента своя корзина
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	// за полсекунды при 2 rps появляется один токен
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	// простаивающие корзины удаляются
+	now = now.Add(2 * idleTTL)
+	l.Allow("c")
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		keyID   string
+		agentID string
+		want    string
+	}{
+		{name: "by ip", kind: ByIP, keyID: "k", agentID: "a", want: "ip:10.0.0.1"},
+		{name: "default is ip", want: "ip:10.0.0.1"},
+		{name: "by api key", kind: ByAPIKey, keyID: "k", want: "key:k"},
+		{name: "no api key falls back to ip", kind: ByAPIKey, want: "ip:10.0.0.1"},
+		{name: "by agent", kind: ByAgent, keyID: "k", agentID: "a", want: "agent:k/a"},
+		{name: "unauthenticated agent falls back to ip", kind: ByAgent, agentID: "a", want: "ip:10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Key(tt.kind, "10.0.0.1", tt.keyID, tt.agentID))
+		})
+	}
+
+	assert.NoError(t, ValidateKeyKind(ByAgent))
+	assert.ErrorIs(t, ValidateKeyKind("user"), ErrUnknownKeyKind)
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	assert.Equal(t, 1, RetryAfterSeconds(100*time.Millisecond))
+	assert.Equal(t, 3, RetryAfterSeconds(2100*time.Millisecond))
+}
@@ -0,0 +1,76 @@
+// Реестр агентов сбора метрик: хранит сведения, которые агенты присылают
+// при старте и периодически, и по времени последнего heartbeat определяет,
+// находится ли агент в сети.
+
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// ErrEmptyAgentID, агент не передал свой идентификатор.
+var ErrEmptyAgentID = errors.New("не указан идентификатор агента")
+
+// Registry, хранит сведения об агентах в памяти сервера.
+type Registry struct {
+	mx      sync.RWMutex
+	agents  map[string]models.AgentStatus
+	timeout time.Duration
+	now     func() time.Time
+}
+
+// NewRegistry, создает реестр агентов. Агент считается offline,
+// если от него не было heartbeat дольше offlineTimeout.
+func NewRegistry(offlineTimeout time.Duration) *Registry {
+	return &Registry{
+		agents:  make(map[string]models.AgentStatus),
+		timeout: offlineTimeout,
+		now:     time.Now,
+	}
+}
+
+// Heartbeat, регистрирует агента или обновляет сведения о нем.
+func (r *Registry) Heartbeat(info models.AgentInfo) error {
+	if info.ID == "" {
+		return ErrEmptyAgentID
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.agents[info.ID] = models.AgentStatus{
+		AgentInfo: info,
+		LastSeen:  r.now(),
+	}
+
+	return nil
+}
+
+// List, возвращает список известных агентов, отсортированный по идентификатору.
+func (r *Registry) List() []models.AgentStatus {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	now := r.now()
+	list := make([]models.AgentStatus, 0, len(r.agents))
+
+	for _, a := range r.agents {
+		a.Status = models.AgentOffline
+		if now.Sub(a.LastSeen) <= r.timeout {
+			a.Status = models.AgentOnline
+		}
+
+		list = append(list, a)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
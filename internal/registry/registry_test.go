@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+func TestRegistry_List(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+
+	r := NewRegistry(time.Minute)
+	r.now = func() time.Time { return now }
+
+	assert.NoError(t, r.Heartbeat(models.AgentInfo{ID: "b", Hostname: "host-b", Version: "1.0.0"}))
+
+	now = start.Add(2 * time.Minute)
+	assert.NoError(t, r.Heartbeat(models.AgentInfo{ID: "a", Hostname: "host-a", Collectors: []string{"runtime"}}))
+
+	now = start.Add(150 * time.Second)
+	got := r.List()
+
+	assert.Len(t, got, 2)
+	assert.Equal(t, "a", got[0].ID)
+	assert.Equal(t, models.AgentOnline, got[0].Status)
+	assert.Equal(t, []string{"runtime"}, got[0].Collectors)
+	assert.Equal(t, "b", got[1].ID)
+	assert.Equal(t, models.AgentOffline, got[1].Status)
+	assert.Equal(t, start, got[1].LastSeen)
+}
+
+func TestRegistry_HeartbeatWithoutID(t *testing.T) {
+	r := NewRegistry(time.Minute)
+
+	err := r.Heartbeat(models.AgentInfo{Hostname: "host"})
+	assert.ErrorIs(t, err, ErrEmptyAgentID)
+	assert.Empty(t, r.List())
+}
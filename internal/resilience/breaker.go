@@ -0,0 +1,90 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+)
+
+type breakerState int
+
+const (
+	stateClosed   breakerState = iota // запросы выполняются
+	stateOpen                         // запросы отклоняются до истечения timeout
+	stateHalfOpen                     // выполняется один пробный запрос
+)
+
+// breaker, размыкатель цепи: после threshold неудачных запросов подряд отклоняет запросы
+// в течение timeout, затем пропускает один пробный запрос. Успешный пробный запрос
+// замыкает цепь, неудачный - снова размыкает. threshold <= 0 - цепь не размыкается.
+type breaker struct {
+	mx        sync.Mutex
+	threshold int
+	timeout   time.Duration
+	now       func() time.Time
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+}
+
+// allow, можно ли выполнить запрос.
+func (b *breaker) allow() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch b.state {
+	case stateClosed:
+		return true
+	case stateOpen:
+		if b.now().Sub(b.openedAt) < b.timeout {
+			return false
+		}
+		b.state = stateHalfOpen
+
+		return true
+	default:
+		// пробный запрос уже выполняется
+		return false
+	}
+}
+
+// success, запрос дошел до хранилища: цепь замыкается.
+func (b *breaker) success() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state != stateClosed {
+		logger.Log.Info("хранилище снова доступно, цепь замкнута")
+	}
+	b.state = stateClosed
+	b.failures = 0
+}
+
+// failure, хранилище недоступно: после threshold сбоев подряд или неудачного пробного запроса цепь размыкается.
+func (b *breaker) failure() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.failures++
+	if b.threshold <= 0 || (b.state == stateClosed && b.failures < b.threshold) {
+		return
+	}
+
+	if b.state == stateClosed {
+		logger.Log.Warnf("хранилище недоступно после %d сбоев подряд, цепь разомкнута на %s", b.failures, b.timeout)
+	}
+	b.state = stateOpen
+	b.openedAt = b.now()
+}
+
+// cancel, запрос отменен до получения результата: пробный запрос не состоялся,
+// следующий запрос снова будет пробным.
+func (b *breaker) cancel() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state == stateHalfOpen {
+		b.state = stateOpen
+	}
+}
@@ -0,0 +1,130 @@
+package resilience
+
+import (
+	"sort"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+// buffer, записи, отложенные до восстановления хранилища: последнее значение
+// каждого gauge и сумма приращений каждого counter по тенантам.
+type buffer struct {
+	gauges   map[string]map[string]models.Gauge
+	counters map[string]map[string]models.Counter
+	series   int
+}
+
+func newBuffer() *buffer {
+	return &buffer{
+		gauges:   make(map[string]map[string]models.Gauge),
+		counters: make(map[string]map[string]models.Counter),
+	}
+}
+
+func (b *buffer) empty() bool {
+	return b.series == 0
+}
+
+// newSeries, сколько серий добавит в буфер запись тенанта name.
+func (b *buffer) newSeries(name string, gauges map[string]models.Gauge, counters map[string]models.Counter) int {
+	n := 0
+	for k := range gauges {
+		if _, ok := b.gauges[name][k]; !ok {
+			n++
+		}
+	}
+	for k := range counters {
+		if _, ok := b.counters[name][k]; !ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+// add, откладывает запись тенанта name: gauge перезаписываются, приращения counter суммируются.
+func (b *buffer) add(name string, gauges map[string]models.Gauge, counters map[string]models.Counter) {
+	b.series += b.newSeries(name, gauges, counters)
+
+	if len(gauges) > 0 && b.gauges[name] == nil {
+		b.gauges[name] = make(map[string]models.Gauge, len(gauges))
+	}
+	for k, v := range gauges {
+		b.gauges[name][k] = v
+	}
+
+	if len(counters) > 0 && b.counters[name] == nil {
+		b.counters[name] = make(map[string]models.Counter, len(counters))
+	}
+	for k, v := range counters {
+		b.counters[name][k] += v
+	}
+}
+
+// gauge, отложенное значение gauge тенанта name.
+func (b *buffer) gauge(name string, metric string) (models.Gauge, bool) {
+	v, ok := b.gauges[name][metric]
+
+	return v, ok
+}
+
+// tenants, тенанты с отложенными записями по имени.
+func (b *buffer) tenants() []string {
+	names := make([]string, 0, len(b.gauges)+len(b.counters))
+	for name := range b.gauges {
+		names = append(names, name)
+	}
+	for name := range b.counters {
+		if _, ok := b.gauges[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// pending, копия отложенных записей тенанта name.
+func (b *buffer) pending(name string) (map[string]models.Gauge, map[string]models.Counter) {
+	gauges := make(map[string]models.Gauge, len(b.gauges[name]))
+	for k, v := range b.gauges[name] {
+		gauges[k] = v
+	}
+
+	counters := make(map[string]models.Counter, len(b.counters[name]))
+	for k, v := range b.counters[name] {
+		counters[k] = v
+	}
+
+	return gauges, counters
+}
+
+// ack, убирает из буфера записи тенанта name, сохраненные в хранилище. Записи,
+// отложенные во время сброса, остаются: новое значение gauge и остаток приращения counter.
+func (b *buffer) ack(name string, gauges map[string]models.Gauge, counters map[string]models.Counter) {
+	for k, v := range gauges {
+		if cur, ok := b.gauges[name][k]; ok && cur == v {
+			delete(b.gauges[name], k)
+			b.series--
+		}
+	}
+	if len(b.gauges[name]) == 0 {
+		delete(b.gauges, name)
+	}
+
+	for k, v := range counters {
+		cur, ok := b.counters[name][k]
+		if !ok {
+			continue
+		}
+		if cur == v {
+			delete(b.counters[name], k)
+			b.series--
+		} else {
+			b.counters[name][k] = cur - v
+		}
+	}
+	if len(b.counters[name]) == 0 {
+		delete(b.counters, name)
+	}
+}
@@ -0,0 +1,467 @@
+// Устойчивость хранилища к временным сбоям БД. Реализована как обертка над
+// хранилищем: повторяет запросы при временных ошибках с нарастающей паузой,
+// после серии неудачных запросов размыкает цепь и сразу отклоняет запросы,
+// а при включенном буфере откладывает записи в памяти и сбрасывает их
+// в хранилище после его восстановления.
+
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// ErrUnavailable, хранилище временно недоступно: повторы не помогли или цепь разомкнута.
+var ErrUnavailable = errors.New("хранилище временно недоступно")
+
+const (
+	AttemptsDef    = 3                      // попыток выполнения запроса по умолчанию
+	BackoffDef     = 100 * time.Millisecond // пауза перед первым повтором, далее удваивается
+	MaxBackoffDef  = 2 * time.Second        // наибольшая пауза между повторами
+	ThresholdDef   = 5                      // неудачных запросов подряд до размыкания цепи
+	OpenTimeoutDef = 5 * time.Second        // время, на которое размыкается цепь
+	FlushEveryDef  = time.Second            // период сброса отложенных записей
+)
+
+// Storage, хранилище, запросы к которому могут временно не выполняться.
+type Storage interface {
+	GetGauge(ctx context.Context, name string) (models.Gauge, error)
+	GetCounter(ctx context.Context, name string) (models.Counter, error)
+	Ping(ctx context.Context) error
+	ToList(ctx context.Context) ([]string, error)
+	Items(ctx context.Context) ([]models.MetricInfo, error)
+	SeriesCount(ctx context.Context) (int, error)
+	SetGauge(ctx context.Context, name string, val float64) error
+	SetCounter(ctx context.Context, name string, val int64) error
+	SaveGaugesBatch(context.Context, map[string]models.Gauge) error
+	SaveCountersBatch(context.Context, map[string]models.Counter) error
+	SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error
+	Save() error
+	Restore(ctx context.Context) error
+}
+
+// ResilientStorage, хранилище с повтором запросов, размыканием цепи и буфером записей.
+//
+// Отложенные записи видны при чтении gauge, приращения counter - только после сброса буфера.
+type ResilientStorage struct {
+	inner      Storage
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	retriable  func(error) bool
+	// retriableWrite, какие ошибки записи можно повторить: запись точно не выполнена
+	retriableWrite func(error) bool
+	breaker        *breaker
+
+	// буфер записей, nil - записи не откладываются
+	bufMx      sync.Mutex
+	buf        *buffer
+	maxSeries  int
+	flushMx    sync.Mutex // сброс буфера выполняется не параллельно
+	flushEvery time.Duration
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// Option, дополнительная настройка хранилища.
+type Option func(*ResilientStorage)
+
+// WithRetry, задает число попыток выполнения запроса и паузы между ними:
+// первая пауза backoff, далее удваивается до maxBackoff. attempts <= 1 - без повторов.
+func WithRetry(attempts int, backoff, maxBackoff time.Duration) Option {
+	return func(s *ResilientStorage) {
+		s.attempts = max(attempts, 1)
+		s.backoff = backoff
+		s.maxBackoff = max(maxBackoff, backoff)
+	}
+}
+
+// WithBreaker, задает число неудачных запросов подряд до размыкания цепи и время,
+// на которое она размыкается. threshold <= 0 - цепь не размыкается.
+func WithBreaker(threshold int, timeout time.Duration) Option {
+	return func(s *ResilientStorage) {
+		s.breaker.threshold = threshold
+		s.breaker.timeout = timeout
+	}
+}
+
+// WithWriteBuffer, откладывает в памяти записи не более чем в maxSeries сериях, пока хранилище
+// недоступно, и раз в flushEvery пытается сбросить их в хранилище. maxSeries <= 0 - без буфера.
+func WithWriteBuffer(maxSeries int, flushEvery time.Duration) Option {
+	return func(s *ResilientStorage) {
+		if maxSeries <= 0 {
+			s.buf = nil
+			return
+		}
+		s.buf = newBuffer()
+		s.maxSeries = maxSeries
+		s.flushEvery = flushEvery
+		if s.flushEvery <= 0 {
+			s.flushEvery = FlushEveryDef
+		}
+	}
+}
+
+// WithRetriable, задает, какие ошибки считаются временными. По умолчанию - IsRetriable.
+func WithRetriable(retriable func(error) bool) Option {
+	return func(s *ResilientStorage) {
+		s.retriable = retriable
+	}
+}
+
+// WithRetriableWrite, задает, после каких временных ошибок запись можно повторить.
+// По умолчанию - IsRetriableWrite.
+func WithRetriableWrite(retriable func(error) bool) Option {
+	return func(s *ResilientStorage) {
+		s.retriableWrite = retriable
+	}
+}
+
+// NewStorage, оборачивает хранилище повтором запросов и размыканием цепи.
+// С буфером записей запускает фоновый сброс, который останавливает Close.
+func NewStorage(inner Storage, opts ...Option) *ResilientStorage {
+	s := &ResilientStorage{
+		inner:          inner,
+		attempts:       AttemptsDef,
+		backoff:        BackoffDef,
+		maxBackoff:     MaxBackoffDef,
+		retriable:      IsRetriable,
+		retriableWrite: IsRetriableWrite,
+		breaker:        &breaker{threshold: ThresholdDef, timeout: OpenTimeoutDef, now: time.Now},
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.buf != nil {
+		s.wg.Add(1)
+		go s.flushLoop()
+	}
+
+	return s
+}
+
+// IsRetriable, является ли ошибка PostgreSQL временной: ошибка соединения, сбой сериализации,
+// взаимная блокировка, остановка или перегрузка сервера.
+func IsRetriable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"): // connection_exception
+			return true
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization_failure, deadlock_detected
+			return true
+		case pgErr.Code == "53300", pgErr.Code == "57P01", pgErr.Code == "57P02", pgErr.Code == "57P03":
+			// too_many_connections, admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+
+		return false
+	}
+
+	var connErr *pgconn.ConnectError
+	var netErr net.Error
+
+	return pgconn.SafeToRetry(err) || errors.As(err, &connErr) || errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsRetriableWrite, можно ли повторить запись после ошибки: запрос точно не был выполнен
+// (pgconn.SafeToRetry) или транзакция откатана из-за сбоя сериализации или взаимной блокировки.
+// После обрыва соединения во время запроса запись могла выполниться, и повтор применил бы
+// приращения counter дважды.
+func IsRetriableWrite(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || pgErr.Code == "40P01" // serialization_failure, deadlock_detected
+	}
+
+	return pgconn.SafeToRetry(err)
+}
+
+// call, выполняет запрос с повторами при временных ошибках, которые допускает retry.
+// Временная ошибка после всех попыток или разомкнутая цепь возвращаются как ErrUnavailable.
+// Временная ошибка, после которой повтор небезопасен, возвращается как есть: запрос мог выполниться.
+func call[T any](ctx context.Context, s *ResilientStorage, retry func(error) bool, op func() (T, error)) (T, error) {
+	var zero T
+
+	if !s.breaker.allow() {
+		return zero, fmt.Errorf("%w: цепь разомкнута после серии сбоев", ErrUnavailable)
+	}
+
+	pause := s.backoff
+	for attempt := 1; ; attempt++ {
+		v, err := op()
+		if err != nil && ctx.Err() != nil {
+			s.breaker.cancel()
+			return zero, fmt.Errorf("%w", err)
+		}
+		if err == nil || !s.retriable(err) {
+			// хранилище ответило, даже если запрос завершился ошибкой
+			s.breaker.success()
+			return v, err
+		}
+		if !retry(err) {
+			s.breaker.failure()
+			return zero, fmt.Errorf("запрос мог быть выполнен, повтор небезопасен, %w", err)
+		}
+
+		if attempt >= s.attempts {
+			s.breaker.failure()
+			return zero, fmt.Errorf("%w после %d попыток, %w", ErrUnavailable, attempt, err)
+		}
+
+		logger.Log.Warnf("временная ошибка хранилища, попытка %d из %d, повтор через %s, %s", attempt, s.attempts, pause, err.Error())
+
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.breaker.cancel()
+			return zero, fmt.Errorf("%w", ctx.Err())
+		case <-timer.C:
+		}
+		pause = min(2*pause, s.maxBackoff)
+	}
+}
+
+// do, выполняет запрос без результата с повторами при ошибках, которые допускает retry.
+func (s *ResilientStorage) do(ctx context.Context, retry func(error) bool, op func() error) error {
+	_, err := call(ctx, s, retry, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+
+	return err
+}
+
+// write, выполняет запись с повторами. С буфером записей откладывает запись, если хранилище
+// недоступно или в буфере уже есть несброшенные записи.
+func (s *ResilientStorage) write(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter, op func() error) error {
+	if s.buf == nil {
+		return s.do(ctx, s.retriableWrite, op)
+	}
+
+	// пока буфер не сброшен, новые записи тоже откладываются:
+	// иначе сброс буфера перезапишет новые значения gauge старыми
+	if queued, err := s.enqueue(ctx, gauges, counters, false); queued || err != nil {
+		return err
+	}
+
+	err := s.do(ctx, s.retriableWrite, op)
+	if errors.Is(err, ErrUnavailable) {
+		if queued, _ := s.enqueue(ctx, gauges, counters, true); queued {
+			logger.Log.Debugf("хранилище недоступно, запись отложена, %s", err.Error())
+			return nil
+		}
+	}
+
+	return err
+}
+
+// enqueue, откладывает запись в буфер. always - откладывать и в пустой буфер (хранилище недоступно),
+// иначе запись откладывается, только пока в буфере есть записи. Возвращает, отложена ли запись;
+// если буфер не пуст и заполнен - ErrUnavailable.
+func (s *ResilientStorage) enqueue(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter, always bool) (bool, error) {
+	s.bufMx.Lock()
+	defer s.bufMx.Unlock()
+
+	if !always && s.buf.empty() {
+		return false, nil
+	}
+
+	name := tenant.FromContext(ctx)
+	if s.buf.series+s.buf.newSeries(name, gauges, counters) > s.maxSeries {
+		return false, fmt.Errorf("%w: буфер записей заполнен (%d серий)", ErrUnavailable, s.maxSeries)
+	}
+	s.buf.add(name, gauges, counters)
+
+	return true, nil
+}
+
+// Pending, количество серий с отложенными записями.
+func (s *ResilientStorage) Pending() int {
+	if s.buf == nil {
+		return 0
+	}
+
+	s.bufMx.Lock()
+	defer s.bufMx.Unlock()
+
+	return s.buf.series
+}
+
+// flush, сбрасывает отложенные записи в хранилище одним пакетом на тенант.
+func (s *ResilientStorage) flush(ctx context.Context) error {
+	if s.buf == nil {
+		return nil
+	}
+
+	s.flushMx.Lock()
+	defer s.flushMx.Unlock()
+
+	s.bufMx.Lock()
+	names := s.buf.tenants()
+	s.bufMx.Unlock()
+
+	for _, name := range names {
+		s.bufMx.Lock()
+		gauges, counters := s.buf.pending(name)
+		s.bufMx.Unlock()
+
+		tctx := tenant.WithTenant(ctx, name)
+		if err := s.do(tctx, s.retriableWrite, func() error { return s.inner.SaveBatch(tctx, gauges, counters) }); err != nil {
+			return fmt.Errorf("не удалось сбросить отложенные записи, %w", err)
+		}
+
+		s.bufMx.Lock()
+		s.buf.ack(name, gauges, counters)
+		s.bufMx.Unlock()
+	}
+
+	if len(names) > 0 {
+		logger.Log.Infof("отложенные записи сброшены в хранилище, тенантов: %d", len(names))
+	}
+
+	return nil
+}
+
+// flushLoop, периодически сбрасывает отложенные записи до вызова Close.
+func (s *ResilientStorage) flushLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.flushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.Pending() == 0 {
+				continue
+			}
+			if err := s.flush(context.Background()); err != nil {
+				logger.Log.Debug(err.Error())
+			}
+		}
+	}
+}
+
+// Close, останавливает фоновый сброс и сбрасывает оставшиеся отложенные записи.
+func (s *ResilientStorage) Close() error {
+	if s.buf == nil {
+		return nil
+	}
+
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	s.wg.Wait()
+
+	if err := s.flush(context.Background()); err != nil {
+		return fmt.Errorf("потеряны отложенные записи (%d серий), %w", s.Pending(), err)
+	}
+
+	return nil
+}
+
+func (s *ResilientStorage) SetGauge(ctx context.Context, name string, val float64) error {
+	return s.write(ctx, map[string]models.Gauge{name: models.Gauge(val)}, nil, func() error {
+		return s.inner.SetGauge(ctx, name, val)
+	})
+}
+
+func (s *ResilientStorage) SetCounter(ctx context.Context, name string, val int64) error {
+	return s.write(ctx, nil, map[string]models.Counter{name: models.Counter(val)}, func() error {
+		return s.inner.SetCounter(ctx, name, val)
+	})
+}
+
+func (s *ResilientStorage) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	return s.write(ctx, gauges, nil, func() error {
+		return s.inner.SaveGaugesBatch(ctx, gauges)
+	})
+}
+
+func (s *ResilientStorage) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	return s.write(ctx, nil, counters, func() error {
+		return s.inner.SaveCountersBatch(ctx, counters)
+	})
+}
+
+func (s *ResilientStorage) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	return s.write(ctx, gauges, counters, func() error {
+		return s.inner.SaveBatch(ctx, gauges, counters)
+	})
+}
+
+// GetGauge, возвращает отложенное значение gauge, если оно есть, иначе - значение из хранилища.
+func (s *ResilientStorage) GetGauge(ctx context.Context, name string) (models.Gauge, error) {
+	if s.buf != nil {
+		s.bufMx.Lock()
+		v, ok := s.buf.gauge(tenant.FromContext(ctx), name)
+		s.bufMx.Unlock()
+		if ok {
+			return v, nil
+		}
+	}
+
+	return call(ctx, s, s.retriable, func() (models.Gauge, error) { return s.inner.GetGauge(ctx, name) })
+}
+
+func (s *ResilientStorage) GetCounter(ctx context.Context, name string) (models.Counter, error) {
+	return call(ctx, s, s.retriable, func() (models.Counter, error) { return s.inner.GetCounter(ctx, name) })
+}
+
+func (s *ResilientStorage) Ping(ctx context.Context) error {
+	return s.do(ctx, s.retriable, func() error { return s.inner.Ping(ctx) })
+}
+
+func (s *ResilientStorage) ToList(ctx context.Context) ([]string, error) {
+	return call(ctx, s, s.retriable, func() ([]string, error) { return s.inner.ToList(ctx) })
+}
+
+func (s *ResilientStorage) Items(ctx context.Context) ([]models.MetricInfo, error) {
+	return call(ctx, s, s.retriable, func() ([]models.MetricInfo, error) { return s.inner.Items(ctx) })
+}
+
+func (s *ResilientStorage) SeriesCount(ctx context.Context) (int, error) {
+	return call(ctx, s, s.retriable, func() (int, error) { return s.inner.SeriesCount(ctx) })
+}
+
+// Save, сбрасывает отложенные записи и сохраняет хранилище.
+func (s *ResilientStorage) Save() error {
+	if err := s.flush(context.Background()); err != nil {
+		return err
+	}
+
+	return s.inner.Save()
+}
+
+func (s *ResilientStorage) Restore(ctx context.Context) error {
+	return s.do(ctx, s.retriable, func() error { return s.inner.Restore(ctx) })
+}
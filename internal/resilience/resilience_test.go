@@ -0,0 +1,294 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// flaky, хранилище в памяти, запросы к которому завершаются ошибкой err, пока fails не обнулится.
+// fails < 0 - всегда с ошибкой.
+type flaky struct {
+	*storage.Memory
+	mx    sync.Mutex
+	fails int
+	err   error
+	calls int
+}
+
+func newFlaky() *flaky {
+	return &flaky{Memory: storage.NewMemory(10), err: &pgconn.PgError{Code: "40001"}}
+}
+
+func (f *flaky) setFails(n int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.fails = n
+}
+
+func (f *flaky) callCount() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.calls
+}
+
+func (f *flaky) fail() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.calls++
+	if f.fails == 0 {
+		return nil
+	}
+	if f.fails > 0 {
+		f.fails--
+	}
+
+	return f.err
+}
+
+func (f *flaky) SetGauge(ctx context.Context, name string, val float64) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Memory.SetGauge(ctx, name, val)
+}
+
+func (f *flaky) SetCounter(ctx context.Context, name string, val int64) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Memory.SetCounter(ctx, name, val)
+}
+
+func (f *flaky) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Memory.SaveBatch(ctx, gauges, counters)
+}
+
+func (f *flaky) GetGauge(ctx context.Context, name string) (models.Gauge, error) {
+	if err := f.fail(); err != nil {
+		return 0, err
+	}
+	return f.Memory.GetGauge(ctx, name)
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{name: "wrapped", err: fmt.Errorf("ошибка выполнения запроса, %w", &pgconn.PgError{Code: "08003"}), want: true},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "broken connection", err: io.ErrUnexpectedEOF, want: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}, want: false},
+		{name: "no rows", err: pgx.ErrNoRows, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "other", err: errors.New("NotFound Alloc"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetriable(tt.err))
+		})
+	}
+}
+
+func TestIsRetriableWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "deadlock", err: fmt.Errorf("ошибка выполнения запроса, %w", &pgconn.PgError{Code: "40P01"}), want: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: false},
+		{name: "network", err: &net.OpError{Op: "read", Err: errors.New("connection reset")}, want: false},
+		{name: "broken connection", err: io.ErrUnexpectedEOF, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetriableWrite(tt.err))
+		})
+	}
+}
+
+func TestResilientStorage_Retry(t *testing.T) {
+	ctx := context.Background()
+	inner := newFlaky()
+	s := NewStorage(inner, WithRetry(3, time.Millisecond, time.Millisecond), WithBreaker(0, 0))
+
+	t.Run("recovers within attempts", func(t *testing.T) {
+		inner.setFails(2)
+		require.NoError(t, s.SetGauge(ctx, "Alloc", 1))
+		assert.Equal(t, 3, inner.callCount())
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		inner.setFails(3)
+		err := s.SetGauge(ctx, "Alloc", 2)
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, 6, inner.callCount())
+	})
+
+	t.Run("not retriable", func(t *testing.T) {
+		_, err := s.GetCounter(ctx, "Unknown")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrUnavailable)
+	})
+
+	t.Run("ambiguous write is not retried", func(t *testing.T) {
+		broken := newFlaky()
+		broken.err = io.ErrUnexpectedEOF
+		bs := NewStorage(broken, WithRetry(3, time.Millisecond, time.Millisecond), WithWriteBuffer(10, time.Hour))
+		defer bs.Close()
+
+		broken.setFails(1)
+		err := bs.SetCounter(ctx, "PollCount", 1)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.NotErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, 1, broken.callCount())
+		// запись не отложена: при сбросе буфера приращение применилось бы повторно
+		assert.Equal(t, 0, bs.Pending())
+
+		// чтение после той же ошибки повторяется
+		broken.setFails(1)
+		_, err = bs.GetGauge(ctx, "Alloc")
+		assert.Error(t, err)
+		assert.Equal(t, 3, broken.callCount())
+	})
+
+	t.Run("canceled during backoff", func(t *testing.T) {
+		slow := NewStorage(inner, WithRetry(3, time.Hour, time.Hour))
+		inner.setFails(-1)
+		defer inner.setFails(0)
+
+		cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, slow.SetGauge(cctx, "Alloc", 3), context.DeadlineExceeded)
+	})
+}
+
+func TestResilientStorage_Breaker(t *testing.T) {
+	ctx := context.Background()
+	inner := newFlaky()
+	s := NewStorage(inner, WithRetry(1, 0, 0), WithBreaker(2, time.Minute))
+
+	now := time.Now()
+	s.breaker.now = func() time.Time { return now }
+
+	inner.setFails(-1)
+	assert.ErrorIs(t, s.SetGauge(ctx, "Alloc", 1), ErrUnavailable)
+	assert.ErrorIs(t, s.SetGauge(ctx, "Alloc", 1), ErrUnavailable)
+	require.Equal(t, 2, inner.callCount())
+
+	// цепь разомкнута: запросы отклоняются без обращения к хранилищу
+	assert.ErrorIs(t, s.SetGauge(ctx, "Alloc", 1), ErrUnavailable)
+	assert.Equal(t, 2, inner.callCount())
+
+	// неудачный пробный запрос снова размыкает цепь
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, s.SetGauge(ctx, "Alloc", 1), ErrUnavailable)
+	assert.Equal(t, 3, inner.callCount())
+	assert.ErrorIs(t, s.SetGauge(ctx, "Alloc", 1), ErrUnavailable)
+	assert.Equal(t, 3, inner.callCount())
+
+	// успешный пробный запрос замыкает цепь
+	now = now.Add(time.Minute)
+	inner.setFails(0)
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 2))
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 3))
+	assert.Equal(t, 5, inner.callCount())
+}
+
+func TestResilientStorage_WriteBuffer(t *testing.T) {
+	ctx := context.Background()
+	teamA := tenant.WithTenant(ctx, "team-a")
+	inner := newFlaky()
+	s := NewStorage(inner, WithRetry(1, 0, 0), WithBreaker(1, time.Minute), WithWriteBuffer(3, time.Hour))
+	defer s.Close()
+
+	inner.setFails(-1)
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, s.SaveBatch(teamA, nil, map[string]models.Counter{"PollCount": 5}))
+	require.NoError(t, s.SaveBatch(ctx, map[string]models.Gauge{"Alloc": 4}, map[string]models.Counter{"PollCount": 3}))
+	assert.Equal(t, 3, s.Pending())
+
+	// отложенное значение gauge видно при чтении
+	g, err := s.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, models.Gauge(4), g)
+
+	// буфер заполнен
+	assert.ErrorIs(t, s.SetGauge(ctx, "HeapSys", 1), ErrUnavailable)
+
+	// хранилище восстановилось, но новые записи откладываются до сброса буфера
+	inner.setFails(0)
+	s.breaker.now = func() time.Time { return time.Now().Add(time.Hour) }
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 5))
+	_, err = inner.Memory.GetGauge(ctx, "Alloc")
+	assert.Error(t, err)
+
+	require.NoError(t, s.Save())
+	assert.Equal(t, 0, s.Pending())
+
+	g, err = inner.Memory.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, models.Gauge(5), g)
+
+	c, err := inner.Memory.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(5), c)
+
+	c, err = inner.Memory.GetCounter(teamA, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(5), c)
+
+	// после сброса буфера записи идут в хранилище
+	require.NoError(t, s.SetGauge(ctx, "HeapSys", 1))
+	_, err = inner.Memory.GetGauge(ctx, "HeapSys")
+	assert.NoError(t, err)
+}
+
+func TestBuffer_AckKeepsNewerWrites(t *testing.T) {
+	b := newBuffer()
+	b.add("", map[string]models.Gauge{"Alloc": 1}, map[string]models.Counter{"PollCount": 2})
+
+	gauges, counters := b.pending("")
+
+	// записи, отложенные во время сброса
+	b.add("", map[string]models.Gauge{"Alloc": 2}, map[string]models.Counter{"PollCount": 3})
+	b.ack("", gauges, counters)
+
+	g, ok := b.gauge("", "Alloc")
+	require.True(t, ok)
+	assert.Equal(t, models.Gauge(2), g)
+
+	_, counters = b.pending("")
+	assert.Equal(t, map[string]models.Counter{"PollCount": 3}, counters)
+	assert.Equal(t, 2, b.series)
+}
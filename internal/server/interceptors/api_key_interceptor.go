@@ -0,0 +1,99 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// methodScopes, области доступа, необходимые для вызова методов сервиса.
+// Для методов, не указанных здесь, требуется admin.
+var methodScopes = map[string]auth.Scope{
+	pb.Metrics_ListMetricsValues_FullMethodName:  auth.ScopeRead,
+	pb.Metrics_ListMetrics_FullMethodName:        auth.ScopeRead,
+	pb.Metrics_GetMetric_FullMethodName:          auth.ScopeRead,
+	pb.Metrics_DbPing_FullMethodName:             auth.ScopeRead,
+	pb.Metrics_ListAgents_FullMethodName:         auth.ScopeRead,
+	pb.Metrics_Watch_FullMethodName:              auth.ScopeRead,
+	pb.Metrics_UpdateMetric_FullMethodName:       auth.ScopeWrite,
+	pb.Metrics_BatchUpdateMetrics_FullMethodName: auth.ScopeWrite,
+	pb.Metrics_RegisterAgent_FullMethodName:      auth.ScopeWrite,
+	pb.Metrics_StreamMetrics_FullMethodName:      auth.ScopeWrite,
+}
+
+func methodScope(method string) auth.Scope {
+	if scope, ok := methodScopes[method]; ok {
+		return scope
+	}
+
+	return auth.ScopeAdmin
+}
+
+// authenticate, проверяет API-ключ из метаданных x-api-key на доступ к методу
+// и возвращает контекст с ключом и его тенантом.
+func authenticate(ctx context.Context, store auth.Store, method string) (context.Context, error) {
+	var secret string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(internal.APIKeyHeader); len(values) > 0 {
+			secret = values[0]
+		}
+	}
+
+	key, err := auth.Authenticate(ctx, store, secret, methodScope(method))
+	switch {
+	case errors.Is(err, auth.ErrUnauthorized):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, auth.ErrForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	ctx = auth.WithKey(ctx, key)
+	if key.Tenant != "" {
+		ctx = tenant.WithTenant(ctx, key.Tenant)
+	}
+
+	return ctx, nil
+}
+
+// APIKeyInterceptorWrapper, проверяет API-ключ из метаданных x-api-key и его область доступа.
+// Если хранилище ключей не задано, проверка не выполняется.
+func APIKeyInterceptorWrapper(store auth.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if store == nil {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticate(ctx, store, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// APIKeyStreamInterceptorWrapper, проверяет API-ключ потокового вызова при его открытии.
+func APIKeyStreamInterceptorWrapper(store auth.Store) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if store == nil {
+			return handler(srv, ss)
+		}
+
+		ctx, err := authenticate(ss.Context(), store, info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, withContext(ss, ctx))
+	}
+}
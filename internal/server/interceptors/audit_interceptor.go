@@ -0,0 +1,51 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/audit"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// callerContext, возвращает контекст со сведениями о клиенте для журнала аудита.
+func callerContext(ctx context.Context, resolver *validator.SubnetFilter) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var clientIP string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientIP = p.Addr.String()
+		if peerIP, err := validator.ParseAddrPort(clientIP); err == nil {
+			clientIP = peerIP.String()
+			ip, err := resolver.ClientIP(peerIP, first(md.Get("x-real-ip")), strings.Join(md.Get("x-forwarded-for"), ","))
+			if err == nil {
+				clientIP = ip.String()
+			}
+		}
+	}
+
+	return audit.WithCaller(ctx, audit.Caller{
+		Transport: audit.TransportGRPC,
+		IP:        clientIP,
+		AgentID:   first(md.Get(internal.AgentIDHeader)),
+	})
+}
+
+// AuditCallerInterceptorWrapper, сохраняет в контексте сведения о клиенте для журнала аудита:
+// ip-адрес (с учетом доверенных прокси resolver) и идентификатор агента.
+func AuditCallerInterceptorWrapper(resolver *validator.SubnetFilter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(callerContext(ctx, resolver), req)
+	}
+}
+
+// AuditCallerStreamInterceptorWrapper, сохраняет в контексте потока сведения о клиенте для журнала аудита.
+func AuditCallerStreamInterceptorWrapper(resolver *validator.SubnetFilter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, withContext(ss, callerContext(ss.Context(), resolver)))
+	}
+}
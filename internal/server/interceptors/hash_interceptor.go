@@ -2,9 +2,10 @@ package interceptors
 
 import (
 	"context"
+	"sync"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
-	"github.com/ShvetsovYura/metrics-collector/internal/util"
+	"github.com/ShvetsovYura/metrics-collector/internal/signature"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -12,31 +13,149 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// HashInterceptorWrapper, проверяет HMAC-подпись запроса из метаданных HashSHA256 и подписывает ответ.
+// Если ключ задан, непустые запросы без подписи отклоняются.
 func HashInterceptorWrapper(key string) grpc.UnaryServerInterceptor {
+	keys := signature.ParseKeys(key)
+
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		md, ok := metadata.FromIncomingContext(ctx)
-		if ok {
-			values := md.Get("HashSHA256")
-			if len(values) > 0 {
-				hashHeader := values[0]
-				if key != "" && hashHeader != "" {
-					body, _ := proto.Marshal(req.(proto.Message))
-					hash := util.Hash(body, key)
-					if hashHeader != hash {
-						logger.Log.Infof("key %s hashHeader: %s hash: %s", key, hashHeader, hash)
-						return nil, status.Error(codes.InvalidArgument, "hashes not equal")
-					}
-				}
+		if !keys.Enabled() {
+			return handler(ctx, req)
+		}
+
+		body, err := proto.Marshal(req.(proto.Message))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "unable to marshal request")
+		}
+
+		var keyID string
+		if len(body) > 0 {
+			var reqKeyID, hash string
+			if md, ok := metadata.FromIncomingContext(ctx); ok {
+				reqKeyID = first(md.Get(signature.KeyIDHeader))
+				hash = first(md.Get(signature.Header))
+			}
+
+			keyID, err = keys.Verify(body, reqKeyID, hash)
+			if err != nil {
+				logger.Log.Infof("ошибка проверки подписи запроса, %s", err.Error())
+				return nil, status.Error(codes.InvalidArgument, err.Error())
 			}
 		}
+
 		res, err := handler(ctx, req)
-		body, _ := proto.Marshal(res.(proto.Message))
-		hash := util.Hash(body, key)
-		respMd := metadata.New(map[string]string{"HashSHA256": hash})
+		if err != nil {
+			return res, err
+		}
+
+		resBody, err := proto.Marshal(res.(proto.Message))
+		if err != nil {
+			return nil, status.Error(codes.Internal, "unable to marshal response")
+		}
+
+		id, hash := keys.SignWith(keyID, resBody)
+		respMd := metadata.Pairs(signature.Header, hash)
+		if id != "" {
+			respMd.Append(signature.KeyIDHeader, id)
+		}
 		if err := grpc.SendHeader(ctx, respMd); err != nil {
 			return nil, status.Error(codes.Internal, "unable to send 'HashSHA256' header")
 		}
 
-		return res, err
+		return res, nil
+	}
+}
+
+// HashStreamInterceptorWrapper, проверяет подписи сообщений потока и подписывает ответы.
+// Сообщения с полями hash и key_id подписываются каждое отдельно, ответы подписываются
+// тем же ключом, что и последнее полученное сообщение. Остальные входящие сообщения
+// проверяются по подписи из метаданных HashSHA256, как в обычных вызовах.
+func HashStreamInterceptorWrapper(key string) grpc.StreamServerInterceptor {
+	keys := signature.ParseKeys(key)
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !keys.Enabled() {
+			return handler(srv, ss)
+		}
+
+		return handler(srv, &signedServerStream{ServerStream: ss, keys: keys})
+	}
+}
+
+// signedServerStream, поток с проверкой подписи входящих и подписью исходящих сообщений.
+type signedServerStream struct {
+	grpc.ServerStream
+	keys *signature.Keys
+
+	mx    sync.Mutex
+	keyID string // ключ подписи последнего полученного сообщения
+}
+
+func (s *signedServerStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Error(codes.InvalidArgument, "unable to marshal request")
+	}
+
+	var (
+		keyID string
+		err   error
+	)
+	if signature.Signable(msg) {
+		keyID, err = s.keys.VerifyMessage(msg)
+	} else {
+		keyID, err = s.verifyMetadata(msg)
+	}
+	if err != nil {
+		logger.Log.Infof("ошибка проверки подписи сообщения потока, %s", err.Error())
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.mx.Lock()
+	s.keyID = keyID
+	s.mx.Unlock()
+
+	return nil
+}
+
+func (s *signedServerStream) SendMsg(m any) error {
+	if msg, ok := m.(proto.Message); ok && signature.Signable(msg) {
+		s.mx.Lock()
+		keyID := s.keyID
+		s.mx.Unlock()
+
+		if err := s.keys.SignMessage(keyID, msg); err != nil {
+			return status.Error(codes.Internal, "unable to marshal response")
+		}
+	}
+
+	return s.ServerStream.SendMsg(m)
+}
+
+// verifyMetadata, проверяет сообщение без полей подписи по подписи из метаданных потока.
+func (s *signedServerStream) verifyMetadata(msg proto.Message) (string, error) {
+	body, err := proto.Marshal(msg)
+	if err != nil || len(body) == 0 {
+		return "", err
+	}
+
+	var keyID, hash string
+	if md, ok := metadata.FromIncomingContext(s.Context()); ok {
+		keyID = first(md.Get(signature.KeyIDHeader))
+		hash = first(md.Get(signature.Header))
+	}
+
+	return s.keys.Verify(body, keyID, hash)
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
 	}
+
+	return values[0]
 }
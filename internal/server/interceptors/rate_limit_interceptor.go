@@ -0,0 +1,143 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
+	"github.com/ShvetsovYura/metrics-collector/internal/ratelimit"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+)
+
+// rateLimiter, проверка частоты вызовов клиента, общая для обычных и потоковых вызовов.
+type rateLimiter struct {
+	limiter  *ratelimit.Limiter
+	keyKind  string
+	resolver *validator.SubnetFilter
+}
+
+// check, проверяет лимит клиента. Если лимит превышен, возвращает, через сколько повторить запрос.
+func (l *rateLimiter) check(ctx context.Context) (bool, time.Duration) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var clientIP string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientIP = p.Addr.String()
+		if peerIP, err := validator.ParseAddrPort(clientIP); err == nil {
+			ip, err := l.resolver.ClientIP(peerIP, first(md.Get("x-real-ip")), strings.Join(md.Get("x-forwarded-for"), ","))
+			if err == nil {
+				clientIP = ip.String()
+			}
+		}
+	}
+
+	var keyID string
+	if key, ok := auth.FromContext(ctx); ok {
+		keyID = key.ID
+	}
+
+	return l.limiter.Allow(ratelimit.Key(l.keyKind, clientIP, keyID, first(md.Get(internal.AgentIDHeader))))
+}
+
+// allow, возвращает ошибку ResourceExhausted с деталью RetryInfo, если лимит превышен.
+func (l *rateLimiter) allow(ctx context.Context) error {
+	if ok, wait := l.check(ctx); !ok {
+		return tooManyRequests(wait)
+	}
+
+	return nil
+}
+
+// errTooManyRequests, сообщение об ошибке при превышении лимита.
+const errTooManyRequests = "слишком много запросов"
+
+// tooManyRequests, ошибка ResourceExhausted с деталью RetryInfo - через сколько повторить запрос.
+func tooManyRequests(wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, errTooManyRequests)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = detailed
+	}
+
+	return st.Err()
+}
+
+// limitedServerStream, поток, в котором лимит проверяется для каждого принятого сообщения.
+type limitedServerStream struct {
+	grpc.ServerStream
+	limiter *rateLimiter
+}
+
+// RecvMsg, принимает сообщение, если лимит клиента не превышен. Порция StreamMetrics сверх
+// лимита не передается обработчику: клиент получает подтверждение ResourceExhausted
+// с паузой retry_after, поток остается открытым. Остальные сообщения сверх лимита
+// завершают поток ошибкой ResourceExhausted с деталью RetryInfo.
+func (s *limitedServerStream) RecvMsg(m any) error {
+	for {
+		if err := s.ServerStream.RecvMsg(m); err != nil {
+			return err
+		}
+
+		ok, wait := s.limiter.check(s.Context())
+		if ok {
+			return nil
+		}
+
+		chunk, isChunk := m.(*pb.MetricsChunk)
+		if !isChunk {
+			return tooManyRequests(wait)
+		}
+
+		ack := &pb.ChunkAck{
+			Seq:        chunk.GetSeq(),
+			Code:       uint32(codes.ResourceExhausted),
+			Error:      errTooManyRequests,
+			RetryAfter: durationpb.New(wait),
+		}
+		if err := s.ServerStream.SendMsg(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// RateLimitInterceptorWrapper, ограничивает частоту запросов клиента. При превышении
+// лимита возвращает ResourceExhausted с деталью RetryInfo - через сколько повторить запрос.
+func RateLimitInterceptorWrapper(limiter *ratelimit.Limiter, keyKind string, resolver *validator.SubnetFilter) grpc.UnaryServerInterceptor {
+	l := &rateLimiter{limiter: limiter, keyKind: keyKind, resolver: resolver}
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if limiter == nil {
+			return handler(ctx, req)
+		}
+
+		if err := l.allow(ctx); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// RateLimitStreamInterceptorWrapper, ограничивает частоту сообщений клиента в потоках:
+// лимит проверяется для каждого принятого сообщения, в том числе каждой порции StreamMetrics.
+func RateLimitStreamInterceptorWrapper(limiter *ratelimit.Limiter, keyKind string, resolver *validator.SubnetFilter) grpc.StreamServerInterceptor {
+	l := &rateLimiter{limiter: limiter, keyKind: keyKind, resolver: resolver}
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if limiter == nil {
+			return handler(srv, ss)
+		}
+
+		return handler(srv, &limitedServerStream{ServerStream: ss, limiter: l})
+	}
+}
@@ -0,0 +1,26 @@
+package interceptors
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// serverStream, поток с контекстом, дополненным интерсепторами.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// withContext, возвращает поток с контекстом ctx.
+func withContext(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	if ctx == ss.Context() {
+		return ss
+	}
+
+	return &serverStream{ServerStream: ss, ctx: ctx}
+}
@@ -2,44 +2,70 @@ package interceptors
 
 import (
 	"context"
+	"strings"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/validator"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
-func TrustedSubnetInterceptorWrapper(trustedSubnet string) grpc.UnaryServerInterceptor {
+// TrustedSubnetInterceptorWrapper, проверяет адрес клиента по правилам доверенных подсетей.
+// Адрес берется из соединения (peer), а из метаданных x-forwarded-for/x-real-ip - только
+// если запрос пришел от доверенного прокси. nil - проверка выключена.
+func TrustedSubnetInterceptorWrapper(filter *validator.SubnetFilter) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		if trustedSubnet == "" {
-			return handler(ctx, req)
+		if err := checkSubnet(ctx, filter); err != nil {
+			return nil, err
 		}
 
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.InvalidArgument, "в метаданных запроса не найдено значений. x-real-ip обязателен")
-		}
+		return handler(ctx, req)
+	}
+}
 
-		values := md.Get("x-real-ip")
-		if len(values) < 1 {
-			return nil, status.Error(codes.InvalidArgument, "не укзаан x-real-ip в метаданных запроса")
+// TrustedSubnetStreamInterceptorWrapper, проверяет адрес клиента при открытии потока.
+func TrustedSubnetStreamInterceptorWrapper(filter *validator.SubnetFilter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSubnet(ss.Context(), filter); err != nil {
+			return err
 		}
 
-		xRealIP := values[0]
-		if xRealIP == "" {
-			return nil, status.Error(codes.InvalidArgument, "клиент находистя вне доверенной подсети")
-		}
+		return handler(srv, ss)
+	}
+}
 
-		val, err := validator.IsIPInSubnet(xRealIP, trustedSubnet)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "ошибка при прасинге подсети %s", err)
-		}
-		if !val {
-			return nil, status.Error(codes.PermissionDenied, "доступ запрещен")
-		}
+// checkSubnet, возвращает ошибку PermissionDenied, если адрес клиента не входит в доверенные подсети.
+func checkSubnet(ctx context.Context, filter *validator.SubnetFilter) error {
+	if !filter.Enabled() {
+		return nil
+	}
 
-		return handler(ctx, req)
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return status.Error(codes.PermissionDenied, "не удалось определить адрес клиента")
+	}
 
+	peerIP, err := validator.ParseAddrPort(p.Addr.String())
+	if err != nil {
+		return status.Error(codes.PermissionDenied, "не удалось определить адрес клиента")
 	}
+
+	var xRealIP, xForwardedFor string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		xRealIP = first(md.Get("x-real-ip"))
+		xForwardedFor = strings.Join(md.Get("x-forwarded-for"), ",")
+	}
+
+	clientIP, err := filter.ClientIP(peerIP, xRealIP, xForwardedFor)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !filter.Allowed(clientIP) {
+		return status.Error(codes.PermissionDenied, "доступ запрещен")
+	}
+
+	return nil
 }
@@ -0,0 +1,57 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tenantContext, возвращает контекст с тенантом запроса.
+func tenantContext(ctx context.Context, keys map[string]string) (context.Context, error) {
+	var apiKey, name string
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(internal.APIKeyHeader); len(values) > 0 {
+			apiKey = values[0]
+		}
+		if values := md.Get(tenant.Header); len(values) > 0 {
+			name = values[0]
+		}
+	}
+
+	t, err := tenant.Resolve(keys, apiKey, name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return tenant.WithTenant(ctx, t), nil
+}
+
+// TenantInterceptorWrapper, определяет тенант запроса по API-ключу или метаданным x-tenant-id.
+func TenantInterceptorWrapper(keys map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := tenantContext(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// TenantStreamInterceptorWrapper, определяет тенант потока по API-ключу или метаданным x-tenant-id.
+func TenantStreamInterceptorWrapper(keys map[string]string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := tenantContext(ss.Context(), keys)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, withContext(ss, ctx))
+	}
+}
@@ -20,20 +20,63 @@ const (
 	StoreIntervalDef = time.Duration(300 * time.Second)
 	RestoreDef       = true
 	LogLevelDef      = "info"
+	AgentTimeoutDef  = time.Duration(60 * time.Second)
+	HistorySizeDef   = 0
 )
 
 // ServerOptions, хранит опции сервера сбора метрик.
 type Options struct {
-	ServerType      string        `env:"SERVR_TYPE" json:"server_type"`        // ServerType: тип запускаемого сервера метрик (http, grpc)
-	EndpointAddr    string        `env:"ADDRESS" json:"address"`               // адрес запуска сервера сбора метрик
-	StoreInterval   time.Duration `env:"STORE_INTERVAL" json:"store_interval"` // интервал сохранения метрик в хранилище
-	FileStoragePath string        `env:"STORE_FILE" json:"store_file"`         // путь до сохранения метрик в файл
-	Restore         bool          `env:"RESTORE" json:"restore"`               // восстанавливать метрики при старте приложения
-	DBDSN           string        `env:"DATABASE_DSN" json:"database_dsn"`     // строка подключения к БД
-	Key             string        `env:"KEY" json:"key"`                       // ключ хеширования сообщения
-	CryptoKey       string        `env:"CRYPTO_KEY" json:"crypto_key"`         // путь до файла с приватным ключом
-	TrustedSubnet   string        `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	LogLevel        string        `env:"LOG_LEVEL" json:"log_level"`
+	ServerType      string            `env:"SERVR_TYPE" json:"server_type"`          // ServerType: тип запускаемого сервера метрик (http, grpc)
+	EndpointAddr    string            `env:"ADDRESS" json:"address"`                 // адрес запуска сервера сбора метрик
+	StoreInterval   time.Duration     `env:"STORE_INTERVAL" json:"store_interval"`   // интервал сохранения метрик в хранилище
+	FileStoragePath string            `env:"STORE_FILE" json:"store_file"`           // путь до сохранения метрик в файл
+	Restore         bool              `env:"RESTORE" json:"restore"`                 // восстанавливать метрики при старте приложения
+	DBDSN           string            `env:"DATABASE_DSN" json:"database_dsn"`       // строка подключения к БД: PostgreSQL или sqlite:путь/к/файлу.db
+	Key             string            `env:"KEY" json:"key"`                         // ключ подписи HMAC или набор ключей "ring:id1:key1,id2:key2"
+	CryptoKey       string            `env:"CRYPTO_KEY" json:"crypto_key"`           // путь до файла с приватным ключом
+	TrustedSubnet   string            `env:"TRUSTED_SUBNET" json:"trusted_subnet"`   // доверенные подсети через запятую, "!" перед подсетью - запрет
+	TrustedProxies  string            `env:"TRUSTED_PROXIES" json:"trusted_proxies"` // подсети прокси, которым можно доверять X-Forwarded-For/X-Real-IP
+	LogLevel        string            `env:"LOG_LEVEL" json:"log_level"`
+	AgentTimeout    time.Duration     `env:"AGENT_TIMEOUT" json:"agent_timeout"`        // через сколько после последнего heartbeat агент считается offline
+	TenantKeys      map[string]string `json:"tenant_keys"`                              // соответствие API-ключей тенантам
+	TenantQuota     int               `env:"TENANT_QUOTA" json:"tenant_quota"`          // квота тенанта на количество серий метрик, 0 - без ограничений
+	TenantQuotas    map[string]int    `json:"tenant_quotas"`                            // персональные квоты тенантов
+	APIKeys         string            `env:"API_KEYS" json:"api_keys"`                  // путь до файла API-ключей или "db", пусто - проверка ключей выключена
+	TLSCert         string            `env:"TLS_CERT" json:"tls_cert"`                  // путь до сертификата сервера, пусто - без TLS
+	TLSKey          string            `env:"TLS_KEY" json:"tls_key"`                    // путь до приватного ключа сертификата сервера
+	TLSClientCA     string            `env:"TLS_CLIENT_CA" json:"tls_client_ca"`        // путь до CA клиентских сертификатов, если задан - включается mTLS
+	RateLimit       float64           `env:"SERVER_RATE_LIMIT" json:"rate_limit"`       // допустимое число запросов клиента в секунду, 0 - без ограничений
+	RateBurst       int               `env:"SERVER_RATE_BURST" json:"rate_burst"`       // запас запросов клиента сверх частоты
+	RateLimitBy     string            `env:"SERVER_RATE_LIMIT_BY" json:"rate_limit_by"` // способ определения клиента: ip, api_key, agent
+	MaxBodySize     int64             `env:"MAX_BODY_SIZE" json:"max_body_size"`        // максимальный размер тела запроса в байтах, 0 - по умолчанию (1 МиБ)
+	MaxUnzipSize    int64             `env:"MAX_UNZIP_SIZE" json:"max_unzip_size"`      // максимальный размер тела после распаковки, 0 - по умолчанию (10 МиБ)
+	AuditLog        string            `env:"AUDIT_LOG" json:"audit_log"`                // путь до файла журнала аудита или "db", пусто - без журнала
+	HistorySize     int               `env:"HISTORY_SIZE" json:"history_size"`          // сколько последних значений метрики хранить для графиков дашборда, 0 - без истории
+
+	// снимки и журнал обновлений файлового хранилища
+	StoreKeep      int    `env:"STORE_KEEP" json:"store_keep"`             // сколько последних снимков метрик хранить, 0 - по умолчанию (3)
+	StoreFormat    string `env:"STORE_FORMAT" json:"store_format"`         // формат снимков метрик: json (по умолчанию), proto
+	StoreCompress  string `env:"STORE_COMPRESS" json:"store_compress"`     // сжатие снимков метрик: none (по умолчанию), gzip
+	StoreWAL       bool   `env:"STORE_WAL" json:"store_wal"`               // вести журнал обновлений (WAL) рядом с файлом метрик
+	WALFsync       string `env:"WAL_FSYNC" json:"wal_fsync"`               // сброс журнала на диск: always, interval (по умолчанию), never
+	WALCompactSize int64  `env:"WAL_COMPACT_SIZE" json:"wal_compact_size"` // размер журнала в байтах, после которого он сворачивается в снимок, 0 - 16 МиБ
+
+	MemoryShards int `env:"MEMORY_SHARDS" json:"memory_shards"` // число сегментов хранилища в памяти, 0 - по умолчанию (32)
+
+	// повтор запросов и размыкание цепи при сбоях PostgreSQL
+	DBRetries          int           `env:"DB_RETRIES" json:"db_retries"`                     // попыток выполнения запроса к БД, 0 - по умолчанию (3)
+	DBBreakerThreshold int           `env:"DB_BREAKER_THRESHOLD" json:"db_breaker_threshold"` // сбоев подряд до размыкания цепи, 0 - по умолчанию (5), отрицательное - не размыкать
+	DBBreakerTimeout   time.Duration `env:"DB_BREAKER_TIMEOUT" json:"db_breaker_timeout"`     // на сколько размыкается цепь, 0 - по умолчанию (5s)
+	DBWriteBuffer      int           `env:"DB_WRITE_BUFFER" json:"db_write_buffer"`           // сколько серий записей копить в памяти, пока БД недоступна, 0 - не копить
+
+	// кэш метрик в памяти перед PostgreSQL
+	DBCache         bool          `env:"DB_CACHE" json:"db_cache"`                   // читать метрики из памяти и сохранять изменения в БД пакетами
+	DBFlushInterval time.Duration `env:"DB_FLUSH_INTERVAL" json:"db_flush_interval"` // период сохранения изменений из кэша в БД, 0 - по умолчанию (1s)
+
+	// уровни хранения истории
+	HistoryStorage         string        `env:"HISTORY_STORAGE" json:"history_storage"`                   // где хранить историю: пусто - в памяти, db - в PostgreSQL
+	HistoryRetention       string        `env:"HISTORY_RETENTION" json:"history_retention"`               // уровни хранения, например raw:24h,1m:720h,1h:8760h; пусто - только последние значения
+	HistoryCompactInterval time.Duration `env:"HISTORY_COMPACT_INTERVAL" json:"history_compact_interval"` // период сжатия истории, 0 - по умолчанию (1m)
 }
 
 func ReadOptions() *Options {
@@ -58,7 +101,11 @@ func (o *Options) UnmarshalJSON(data []byte) error {
 
 	optionsValue := &struct {
 		*OptionsAlias
-		StoreInterval string `json:"store_interval"`
+		StoreInterval    string `json:"store_interval"`
+		AgentTimeout     string `json:"agent_timeout"`
+		DBBreakerTimeout string `json:"db_breaker_timeout"`
+		DBFlushInterval  string `json:"db_flush_interval"`
+		HistoryCompact   string `json:"history_compact_interval"`
 	}{
 		OptionsAlias: (*OptionsAlias)(o),
 	}
@@ -70,6 +117,30 @@ func (o *Options) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return fmt.Errorf("ошибка преобразования поля StoreInterval %w", err)
 	}
+	if optionsValue.AgentTimeout != "" {
+		o.AgentTimeout, err = time.ParseDuration(optionsValue.AgentTimeout)
+		if err != nil {
+			return fmt.Errorf("ошибка преобразования поля AgentTimeout %w", err)
+		}
+	}
+	if optionsValue.DBBreakerTimeout != "" {
+		o.DBBreakerTimeout, err = time.ParseDuration(optionsValue.DBBreakerTimeout)
+		if err != nil {
+			return fmt.Errorf("ошибка преобразования поля DBBreakerTimeout %w", err)
+		}
+	}
+	if optionsValue.DBFlushInterval != "" {
+		o.DBFlushInterval, err = time.ParseDuration(optionsValue.DBFlushInterval)
+		if err != nil {
+			return fmt.Errorf("ошибка преобразования поля DBFlushInterval %w", err)
+		}
+	}
+	if optionsValue.HistoryCompact != "" {
+		o.HistoryCompactInterval, err = time.ParseDuration(optionsValue.HistoryCompact)
+		if err != nil {
+			return fmt.Errorf("ошибка преобразования поля HistoryCompactInterval %w", err)
+		}
+	}
 
 	return nil
 }
@@ -106,6 +177,9 @@ func (o *Options) applyDefaultParams() {
 	if o.LogLevel == "" {
 		o.LogLevel = LogLevelDef
 	}
+	if o.AgentTimeout == 0 {
+		o.AgentTimeout = AgentTimeoutDef
+	}
 }
 
 func (o *Options) applyConfig(path string) {
@@ -144,10 +218,40 @@ func (o *Options) parseArgs() {
 	flag.DurationVar(&o.StoreInterval, "i", -1, "interval to store data on file. 0 for immediately")
 	flag.StringVar(&o.FileStoragePath, "f", "/tmp/metrics-db.json", "path to save metrics values")
 	flag.BoolVar(&o.Restore, "r", false, "restoring metrics values on start")
-	flag.StringVar(&o.DBDSN, "d", "", "database connection DSN")
-	flag.StringVar(&o.Key, "k", "", "Secret key value")
+	flag.IntVar(&o.StoreKeep, "store-keep", 0, "number of recent metrics snapshots to keep for fallback on restore")
+	flag.StringVar(&o.StoreFormat, "store-format", "", "metrics snapshot encoding: json, proto")
+	flag.StringVar(&o.StoreCompress, "store-compress", "", "metrics snapshot compression: none, gzip")
+	flag.BoolVar(&o.StoreWAL, "wal", false, "append metric updates to write-ahead log next to the store file")
+	flag.StringVar(&o.WALFsync, "wal-fsync", "", "write-ahead log fsync policy: always, interval, never")
+	flag.Int64Var(&o.WALCompactSize, "wal-compact-size", 0, "write-ahead log size in bytes to compact it into the store file")
+	flag.IntVar(&o.MemoryShards, "memory-shards", 0, "number of lock shards of in-memory metrics storage")
+	flag.IntVar(&o.DBRetries, "db-retries", 0, "attempts of a database query on transient errors")
+	flag.IntVar(&o.DBBreakerThreshold, "db-breaker-threshold", 0, "consecutive database failures to open the circuit, negative to never open")
+	flag.DurationVar(&o.DBBreakerTimeout, "db-breaker-timeout", 0, "time the circuit stays open before a probe query")
+	flag.IntVar(&o.DBWriteBuffer, "db-write-buffer", 0, "max metric series to buffer in memory while the database is unavailable")
+	flag.BoolVar(&o.DBCache, "db-cache", false, "serve metrics from memory and persist changes to the database in batches")
+	flag.DurationVar(&o.DBFlushInterval, "db-flush-interval", 0, "interval of persisting cached changes to the database")
+	flag.StringVar(&o.HistoryStorage, "history-storage", "", "metric history storage: empty for memory, \"db\" for PostgreSQL")
+	flag.StringVar(&o.HistoryRetention, "history-retention", "", "history retention tiers, e.g. raw:24h,1m:720h,1h:8760h")
+	flag.DurationVar(&o.HistoryCompactInterval, "history-compact-interval", 0, "interval of history downsampling and cleanup")
+	flag.StringVar(&o.DBDSN, "d", "", "database connection DSN (PostgreSQL or sqlite:path/to/file.db, sqlite requires a cgo build)")
+	flag.StringVar(&o.Key, "k", "", "hmac key or key ring ring:id1:key1,id2:key2, first key signs responses")
 	flag.StringVar(&o.CryptoKey, "crypto-key", "", "path to private key")
-	flag.StringVar(&o.TrustedSubnet, "t", "", "verify client in trusted subnet")
+	flag.StringVar(&o.TrustedSubnet, "t", "", "trusted subnets, comma separated, '!' prefix denies subnet")
+	flag.StringVar(&o.TrustedProxies, "trusted-proxies", "", "trusted proxy subnets allowed to set X-Forwarded-For/X-Real-IP")
+	flag.DurationVar(&o.AgentTimeout, "agent-timeout", 0, "agent is offline after this time without heartbeat")
+	flag.IntVar(&o.TenantQuota, "tenant-quota", 0, "max metric series per tenant, 0 for unlimited")
+	flag.StringVar(&o.APIKeys, "api-keys", "", "path to API keys file or 'db' to store keys in database")
+	flag.Float64Var(&o.RateLimit, "rate-limit", 0, "requests per second per client, 0 for unlimited")
+	flag.IntVar(&o.RateBurst, "rate-burst", 0, "requests burst per client")
+	flag.StringVar(&o.RateLimitBy, "rate-limit-by", "", "identify client for rate limit by: ip, api_key, agent")
+	flag.Int64Var(&o.MaxBodySize, "max-body-size", 0, "max request body size in bytes")
+	flag.Int64Var(&o.MaxUnzipSize, "max-unzip-size", 0, "max request body size after gzip decompression in bytes")
+	flag.StringVar(&o.TLSCert, "tls-cert", "", "path to TLS certificate")
+	flag.StringVar(&o.TLSKey, "tls-key", "", "path to TLS certificate key")
+	flag.StringVar(&o.TLSClientCA, "tls-client-ca", "", "path to CA bundle to verify client certificates (mTLS)")
+	flag.IntVar(&o.HistorySize, "history-size", HistorySizeDef, "number of recent values per metric kept for dashboard charts, 0 to disable")
+	flag.StringVar(&o.AuditLog, "audit-log", "", "path to audit log file (json lines) or \"db\" for database table")
 
 	flag.Parse()
 }
@@ -193,4 +297,100 @@ func reassignOptions(curOpt *Options, tempOpt *Options) {
 	if curOpt.TrustedSubnet == "" && tempOpt.TrustedSubnet != "" {
 		curOpt.TrustedSubnet = tempOpt.TrustedSubnet
 	}
+	if curOpt.TrustedProxies == "" && tempOpt.TrustedProxies != "" {
+		curOpt.TrustedProxies = tempOpt.TrustedProxies
+	}
+	if curOpt.AgentTimeout == 0 && tempOpt.AgentTimeout != 0 {
+		curOpt.AgentTimeout = tempOpt.AgentTimeout
+	}
+	if curOpt.TenantKeys == nil && tempOpt.TenantKeys != nil {
+		curOpt.TenantKeys = tempOpt.TenantKeys
+	}
+	if curOpt.TenantQuota == 0 && tempOpt.TenantQuota != 0 {
+		curOpt.TenantQuota = tempOpt.TenantQuota
+	}
+	if curOpt.TenantQuotas == nil && tempOpt.TenantQuotas != nil {
+		curOpt.TenantQuotas = tempOpt.TenantQuotas
+	}
+	if curOpt.APIKeys == "" && tempOpt.APIKeys != "" {
+		curOpt.APIKeys = tempOpt.APIKeys
+	}
+	if curOpt.RateLimit == 0 && tempOpt.RateLimit != 0 {
+		curOpt.RateLimit = tempOpt.RateLimit
+	}
+	if curOpt.RateBurst == 0 && tempOpt.RateBurst != 0 {
+		curOpt.RateBurst = tempOpt.RateBurst
+	}
+	if curOpt.RateLimitBy == "" && tempOpt.RateLimitBy != "" {
+		curOpt.RateLimitBy = tempOpt.RateLimitBy
+	}
+	if curOpt.MaxBodySize == 0 && tempOpt.MaxBodySize != 0 {
+		curOpt.MaxBodySize = tempOpt.MaxBodySize
+	}
+	if curOpt.MaxUnzipSize == 0 && tempOpt.MaxUnzipSize != 0 {
+		curOpt.MaxUnzipSize = tempOpt.MaxUnzipSize
+	}
+	if curOpt.TLSCert == "" && tempOpt.TLSCert != "" {
+		curOpt.TLSCert = tempOpt.TLSCert
+	}
+	if curOpt.TLSKey == "" && tempOpt.TLSKey != "" {
+		curOpt.TLSKey = tempOpt.TLSKey
+	}
+	if curOpt.TLSClientCA == "" && tempOpt.TLSClientCA != "" {
+		curOpt.TLSClientCA = tempOpt.TLSClientCA
+	}
+	if curOpt.AuditLog == "" && tempOpt.AuditLog != "" {
+		curOpt.AuditLog = tempOpt.AuditLog
+	}
+	if curOpt.HistorySize == 0 && tempOpt.HistorySize != 0 {
+		curOpt.HistorySize = tempOpt.HistorySize
+	}
+	if curOpt.StoreKeep == 0 && tempOpt.StoreKeep != 0 {
+		curOpt.StoreKeep = tempOpt.StoreKeep
+	}
+	if curOpt.StoreFormat == "" && tempOpt.StoreFormat != "" {
+		curOpt.StoreFormat = tempOpt.StoreFormat
+	}
+	if curOpt.StoreCompress == "" && tempOpt.StoreCompress != "" {
+		curOpt.StoreCompress = tempOpt.StoreCompress
+	}
+	if !curOpt.StoreWAL && tempOpt.StoreWAL {
+		curOpt.StoreWAL = tempOpt.StoreWAL
+	}
+	if curOpt.WALFsync == "" && tempOpt.WALFsync != "" {
+		curOpt.WALFsync = tempOpt.WALFsync
+	}
+	if curOpt.WALCompactSize == 0 && tempOpt.WALCompactSize != 0 {
+		curOpt.WALCompactSize = tempOpt.WALCompactSize
+	}
+	if curOpt.MemoryShards == 0 && tempOpt.MemoryShards != 0 {
+		curOpt.MemoryShards = tempOpt.MemoryShards
+	}
+	if curOpt.DBRetries == 0 && tempOpt.DBRetries != 0 {
+		curOpt.DBRetries = tempOpt.DBRetries
+	}
+	if curOpt.DBBreakerThreshold == 0 && tempOpt.DBBreakerThreshold != 0 {
+		curOpt.DBBreakerThreshold = tempOpt.DBBreakerThreshold
+	}
+	if curOpt.DBBreakerTimeout == 0 && tempOpt.DBBreakerTimeout != 0 {
+		curOpt.DBBreakerTimeout = tempOpt.DBBreakerTimeout
+	}
+	if curOpt.DBWriteBuffer == 0 && tempOpt.DBWriteBuffer != 0 {
+		curOpt.DBWriteBuffer = tempOpt.DBWriteBuffer
+	}
+	if !curOpt.DBCache && tempOpt.DBCache {
+		curOpt.DBCache = tempOpt.DBCache
+	}
+	if curOpt.DBFlushInterval == 0 && tempOpt.DBFlushInterval != 0 {
+		curOpt.DBFlushInterval = tempOpt.DBFlushInterval
+	}
+	if curOpt.HistoryStorage == "" && tempOpt.HistoryStorage != "" {
+		curOpt.HistoryStorage = tempOpt.HistoryStorage
+	}
+	if curOpt.HistoryRetention == "" && tempOpt.HistoryRetention != "" {
+		curOpt.HistoryRetention = tempOpt.HistoryRetention
+	}
+	if curOpt.HistoryCompactInterval == 0 && tempOpt.HistoryCompactInterval != 0 {
+		curOpt.HistoryCompactInterval = tempOpt.HistoryCompactInterval
+	}
 }
@@ -17,12 +17,15 @@ func TestReadOptions(t *testing.T) {
 	pathToConfig := path.Join(basePath, "test-server-config.json")
 
 	want := &Options{
+		ServerType:      ServerTypeDef,
 		EndpointAddr:    "localhost:6789",
 		Restore:         true,
 		StoreInterval:   time.Duration(600 * time.Second),
 		CryptoKey:       "hoho.pem",
 		LogLevel:        "debug",
 		FileStoragePath: "/tmp/metrics-db.json",
+		AgentTimeout:    time.Duration(90 * time.Second),
+		HistorySize:     HistorySizeDef,
 	}
 	errSetEnv := os.Setenv("CONFIG", pathToConfig)
 	assert.NoError(t, errSetEnv)
@@ -2,18 +2,34 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/ShvetsovYura/metrics-collector/internal/audit"
+	"github.com/ShvetsovYura/metrics-collector/internal/auth"
+	"github.com/ShvetsovYura/metrics-collector/internal/cryptocodec"
 	"github.com/ShvetsovYura/metrics-collector/internal/handlers"
+	"github.com/ShvetsovYura/metrics-collector/internal/history"
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/pubsub"
+	"github.com/ShvetsovYura/metrics-collector/internal/quota"
+	"github.com/ShvetsovYura/metrics-collector/internal/ratelimit"
+	"github.com/ShvetsovYura/metrics-collector/internal/registry"
+	"github.com/ShvetsovYura/metrics-collector/internal/resilience"
 	"github.com/ShvetsovYura/metrics-collector/internal/server/interceptors"
 	"github.com/ShvetsovYura/metrics-collector/internal/storage"
+	"github.com/ShvetsovYura/metrics-collector/internal/tlsconfig"
+	"github.com/ShvetsovYura/metrics-collector/internal/validator"
 	pb "github.com/ShvetsovYura/metrics-collector/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
 )
 
 type StorageCloser interface {
@@ -23,16 +39,19 @@ type StorageCloser interface {
 type IServer interface {
 	StartListen() error
 	Shutdown(ctx context.Context) error
-	RegisterHandlers(handlers.Storage, *Options)
+	RegisterHandlers(handlers.Storage, *Options, ...handlers.RouterOption)
 }
 
 // Server, хранит информации о сервере сбора метрик.
 type Server struct {
 	// можно было бы вообще без этого интерфейса
 	// но тогда не понятно - как сохранять метрики в файл в `Run`
-	storage StorageCloser
-	server  IServer
-	options *Options
+	storage   StorageCloser
+	server    IServer
+	options   *Options
+	auditSink audit.Sink
+	hub       *pubsub.Hub
+	history   *history.Storage
 }
 
 // NewServer, создает новый сервер работы с метриками.
@@ -45,32 +64,227 @@ func NewServer(server IServer, metricsCount int, opt *Options) *Server {
 	)
 	// TODO: Подумать над упрощением
 	if opt.DBDSN == "" {
-		m := storage.NewMemory(metricsCount)
+		m := storage.NewShardedMemory(metricsCount, opt.MemoryShards)
 		if opt.FileStoragePath == "" {
 			saverStorage = m
 			targetStorage = m
 		} else {
-			f := storage.NewFile(opt.FileStoragePath, m, opt.Restore, opt.StoreInterval)
+			f := storage.NewFile(opt.FileStoragePath, m, opt.Restore, opt.StoreInterval, fileOptions(opt)...)
 			saverStorage = f
 			targetStorage = f
 		}
+	} else if storage.IsSQLiteDSN(opt.DBDSN) {
+		d, err := storage.NewSQLite(dbCtx, opt.DBDSN)
+		if err != nil {
+			logger.Log.Fatalf("Не удалось открыть БД SQLite, %s", err.Error())
+		}
+
+		targetStorage = d
+		saverStorage = d
 	} else {
 		d, err := storage.NewDBPool(dbCtx, opt.DBDSN)
 		if err != nil {
 			logger.Log.Fatal("Не удалось подключиться к БД!")
 		}
 
-		targetStorage = d
-		saverStorage = d
+		if opt.DBCache {
+			// кэш сам повторяет сохранение изменений, которые не удалось записать в БД
+			c, err := storage.NewCached(dbCtx, storage.NewShardedMemory(metricsCount, opt.MemoryShards), d, opt.DBFlushInterval)
+			if err != nil {
+				logger.Log.Fatalf("Не удалось загрузить метрики из БД, %s", err.Error())
+			}
+
+			targetStorage = c
+			saverStorage = c
+		} else {
+			r := resilience.NewStorage(d, resilienceOptions(opt)...)
+			targetStorage = r
+			saverStorage = r
+		}
+	}
+
+	if opt.TenantQuota > 0 || len(opt.TenantQuotas) > 0 {
+		if counter, ok := targetStorage.(quota.SeriesCounter); ok {
+			targetStorage = quota.NewStorage(targetStorage, counter, quota.Limits{
+				Default: opt.TenantQuota,
+				Tenants: opt.TenantQuotas,
+			})
+		}
 	}
-	server.RegisterHandlers(targetStorage, opt)
+	var (
+		routerOpts []handlers.RouterOption
+		h          *history.Storage
+	)
+	if opt.HistorySize > 0 {
+		h = newHistory(targetStorage, opt)
+		targetStorage = h
+		routerOpts = append(routerOpts, handlers.WithHistory(h))
+	}
+
+	// шина обновлений для подписок /stream и /ws
+	hub := pubsub.NewHub(pubsub.DefaultReplay)
+	targetStorage = pubsub.NewStorage(targetStorage, hub)
+	routerOpts = append(routerOpts, handlers.WithUpdatesHub(hub))
+
+	auditSink := openAuditSink(opt)
+	if auditSink != nil {
+		// снаружи квот: изменение записывается в журнал до проверок, а его результат
+		// (в том числе отказ квоты) - отдельной записью после
+		targetStorage = audit.NewStorage(targetStorage, auditSink)
+		routerOpts = append(routerOpts, handlers.WithAuditSink(auditSink))
+	}
+	server.RegisterHandlers(targetStorage, opt, routerOpts...)
 	return &Server{
 		// из-за того, что удалил методы Save и Restore из интерфейса Storage
 		// приходится костылить такое - дублирование стораджа, но с другим интерфейсом
-		storage: saverStorage,
-		server:  server,
-		options: opt,
+		storage:   saverStorage,
+		server:    server,
+		options:   opt,
+		auditSink: auditSink,
+		hub:       hub,
+		history:   h,
+	}
+}
+
+// fileOptions, настройки файлового хранилища метрик.
+func fileOptions(opt *Options) []storage.FileOption {
+	keep := opt.StoreKeep
+	if keep == 0 {
+		keep = storage.SnapshotKeepDef
+	}
+	format, err := storage.ParseSnapshotFormat(opt.StoreFormat, opt.StoreCompress)
+	if err != nil {
+		logger.Log.Fatal(err.Error())
+	}
+	opts := []storage.FileOption{storage.WithSnapshotKeep(keep), storage.WithSnapshotFormat(format)}
+
+	if !opt.StoreWAL {
+		return opts
+	}
+
+	fsync, err := storage.ParseFsyncPolicy(opt.WALFsync)
+	if err != nil {
+		logger.Log.Fatal(err.Error())
+	}
+
+	return append(opts, storage.WithWAL(storage.WALOptions{Fsync: fsync, CompactSize: opt.WALCompactSize}))
+}
+
+// resilienceOptions, настройки повтора запросов и размыкания цепи для PostgreSQL.
+func resilienceOptions(opt *Options) []resilience.Option {
+	attempts := opt.DBRetries
+	if attempts == 0 {
+		attempts = resilience.AttemptsDef
+	}
+	threshold := opt.DBBreakerThreshold
+	if threshold == 0 {
+		threshold = resilience.ThresholdDef
+	}
+	timeout := opt.DBBreakerTimeout
+	if timeout == 0 {
+		timeout = resilience.OpenTimeoutDef
+	}
+
+	return []resilience.Option{
+		resilience.WithRetry(attempts, resilience.BackoffDef, resilience.MaxBackoffDef),
+		resilience.WithBreaker(threshold, timeout),
+		resilience.WithWriteBuffer(opt.DBWriteBuffer, resilience.FlushEveryDef),
+	}
+}
+
+// newHistory, оборачивает хранилище записью истории значений. С уровнями хранения
+// в памяти хранятся все исходные значения за срок хранения, а не только последние.
+func newHistory(inner handlers.Storage, opt *Options) *history.Storage {
+	var (
+		policy history.Policy
+		err    error
+	)
+	if opt.HistoryRetention != "" {
+		policy, err = history.ParsePolicy(opt.HistoryRetention)
+		if err != nil {
+			logger.Log.Fatal(err.Error())
+		}
+	}
+
+	if opt.HistoryStorage == history.DBLocation {
+		if storage.IsSQLiteDSN(opt.DBDSN) {
+			logger.Log.Fatal("История в БД поддерживается только для PostgreSQL")
+		}
+		if len(policy) == 0 {
+			logger.Log.Fatal("Для истории в БД нужно задать уровни хранения")
+		}
+	}
+
+	limit := opt.HistorySize
+	if len(policy) > 0 {
+		limit = 0
+	}
+	store, err := history.OpenStore(context.Background(), opt.HistoryStorage, opt.DBDSN, limit)
+	if err != nil {
+		logger.Log.Fatalf("Не удалось открыть хранилище истории, %s", err.Error())
+	}
+
+	return history.NewStorage(inner, opt.HistorySize, history.WithStore(store), history.WithRetention(policy, opt.HistoryCompactInterval))
+}
+
+// openAuditSink, открывает журнал аудита, если он включен.
+func openAuditSink(opt *Options) audit.Sink {
+	if opt.AuditLog == "" {
+		return nil
+	}
+
+	if opt.AuditLog == audit.DBLocation && storage.IsSQLiteDSN(opt.DBDSN) {
+		logger.Log.Fatal("Журнал аудита в БД поддерживается только для PostgreSQL")
+	}
+
+	sink, err := audit.OpenSink(context.Background(), opt.AuditLog, opt.DBDSN)
+	if err != nil {
+		logger.Log.Fatalf("Не удалось открыть журнал аудита, %s", err.Error())
+	}
+
+	return sink
+}
+
+// openAPIKeys, открывает хранилище API-ключей, если проверка ключей включена.
+func openAPIKeys(opt *Options) auth.Store {
+	if opt.APIKeys == "" {
+		return nil
+	}
+
+	if opt.APIKeys == auth.DBLocation && storage.IsSQLiteDSN(opt.DBDSN) {
+		logger.Log.Fatal("Хранение API-ключей в БД поддерживается только для PostgreSQL")
+	}
+
+	store, err := auth.OpenStore(context.Background(), opt.APIKeys, opt.DBDSN)
+	if err != nil {
+		logger.Log.Fatalf("Не удалось открыть хранилище API-ключей, %s", err.Error())
+	}
+
+	return store
+}
+
+// subnetFilter, разбирает правила доверенных подсетей и подсети доверенных прокси.
+// Неверные правила - ошибка конфигурации, сервер с ними не запускается.
+func subnetFilter(opt *Options) *validator.SubnetFilter {
+	filter, err := validator.NewSubnetFilter(opt.TrustedSubnet, opt.TrustedProxies)
+	if err != nil {
+		logger.Log.Fatalf("Неверные правила доверенных подсетей или прокси, %s", err.Error())
 	}
+
+	return filter
+}
+
+// newRateLimiter, создает ограничитель частоты запросов, если он включен.
+func newRateLimiter(opt *Options) *ratelimit.Limiter {
+	if opt.RateLimit <= 0 {
+		return nil
+	}
+
+	if err := ratelimit.ValidateKeyKind(opt.RateLimitBy); err != nil {
+		logger.Log.Fatal(err.Error())
+	}
+
+	return ratelimit.NewLimiter(opt.RateLimit, opt.RateBurst)
 }
 
 // Run, запускает сервер.
@@ -86,6 +300,8 @@ func (s *Server) Run(ctx context.Context) error {
 			select {
 			case <-ctx.Done():
 				logger.Log.Info("Останавливаю сервер...")
+				// закрытие подписок завершает потоковые ответы, иначе остановка будет их ждать
+				s.hub.Close()
 				// сначала - остановка сервера, чтобы не принимал новые запросы
 				if err := s.server.Shutdown(ctx); err != nil {
 					logger.Log.Fatalf("не удалось остановить сервер %s", err.Error())
@@ -97,6 +313,21 @@ func (s *Server) Run(ctx context.Context) error {
 				if err := s.storage.Save(); err != nil {
 					logger.Log.Error(err)
 				}
+				if c, ok := s.storage.(io.Closer); ok {
+					if err := c.Close(); err != nil {
+						logger.Log.Error(err)
+					}
+				}
+				if s.history != nil {
+					if err := s.history.Close(); err != nil {
+						logger.Log.Error(err)
+					}
+				}
+				if s.auditSink != nil {
+					if err := s.auditSink.Close(); err != nil {
+						logger.Log.Error(err)
+					}
+				}
 				logger.Log.Info("http сервер остановлен!")
 				return
 			case <-ticker.C:
@@ -109,7 +340,7 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}()
 
-	if err := s.server.StartListen(); err != http.ErrServerClosed {
+	if err := s.server.StartListen(); err != nil && err != http.ErrServerClosed {
 		logger.Log.Fatalf("не удалось запусить web сервер, %s", err.Error())
 	}
 
@@ -121,19 +352,57 @@ type HTTPServer struct {
 	webserver *http.Server
 }
 
+// serverTLSConfig, формирует настройки TLS сервера, если задан сертификат.
+func serverTLSConfig(opt *Options) (*tls.Config, error) {
+	if opt.TLSCert == "" {
+		return nil, nil
+	}
+
+	cfg, err := tlsconfig.ServerConfig(opt.TLSCert, opt.TLSKey, opt.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось настроить TLS, %w", err)
+	}
+
+	return cfg, nil
+}
+
 func NewHTTPServer() *HTTPServer {
 	return &HTTPServer{}
 }
 
 func (s *HTTPServer) StartListen() error {
+	if s.webserver.TLSConfig != nil {
+		// сертификат берется из TLSConfig.GetCertificate
+		return s.webserver.ListenAndServeTLS("", "")
+	}
 	err := s.webserver.ListenAndServe()
 	return err
 }
 
-func (s *HTTPServer) RegisterHandlers(targetStorage handlers.Storage, opt *Options) {
+func (s *HTTPServer) RegisterHandlers(targetStorage handlers.Storage, opt *Options, extraOpts ...handlers.RouterOption) {
+	routerOpts := []handlers.RouterOption{
+		handlers.WithAgentRegistry(registry.NewRegistry(opt.AgentTimeout)),
+		handlers.WithTenantKeys(opt.TenantKeys),
+		handlers.WithBodyLimits(opt.MaxBodySize, opt.MaxUnzipSize),
+	}
+	routerOpts = append(routerOpts, extraOpts...)
+	if keys := openAPIKeys(opt); keys != nil {
+		routerOpts = append(routerOpts, handlers.WithAPIKeys(keys))
+	}
+	if limiter := newRateLimiter(opt); limiter != nil {
+		routerOpts = append(routerOpts, handlers.WithRateLimit(limiter, opt.RateLimitBy))
+	}
+
+	tlsConfig, err := serverTLSConfig(opt)
+	if err != nil {
+		logger.Log.Fatal(err.Error())
+	}
+
+	router := handlers.ServerRouter(targetStorage, opt.Key, opt.CryptoKey, subnetFilter(opt), routerOpts...)
 	s.webserver = &http.Server{
-		Addr:    opt.EndpointAddr,
-		Handler: handlers.ServerRouter(targetStorage, opt.Key, opt.CryptoKey, opt.TrustedSubnet),
+		Addr:      opt.EndpointAddr,
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
 }
 
@@ -147,22 +416,64 @@ type GRPCServer struct {
 	addr       string
 }
 
-func NewGRPCServer(trustedSubnet string, hashKey string) *GRPCServer {
+func NewGRPCServer(opt *Options) (*GRPCServer, error) {
+	// ограничитель и хранилище ключей общие для обычных и потоковых вызовов
+	limiter := newRateLimiter(opt)
+	apiKeys := openAPIKeys(opt)
+	filter := subnetFilter(opt)
+	// ограничение проверяется после API-ключа: клиент определяется по проверенному ключу.
+	// Сообщение к этому моменту уже разобрано, поэтому раньше проверять нет смысла
 	opts := []grpc.ServerOption{
 		grpc.ChainUnaryInterceptor(
-			interceptors.HashInterceptorWrapper(hashKey),
-			interceptors.TrustedSubnetInterceptorWrapper(trustedSubnet),
+			interceptors.HashInterceptorWrapper(opt.Key),
+			interceptors.TrustedSubnetInterceptorWrapper(filter),
+			interceptors.TenantInterceptorWrapper(opt.TenantKeys),
+			interceptors.APIKeyInterceptorWrapper(apiKeys),
+			interceptors.RateLimitInterceptorWrapper(limiter, opt.RateLimitBy, filter),
+			interceptors.AuditCallerInterceptorWrapper(filter),
 		),
+		grpc.ChainStreamInterceptor(
+			interceptors.HashStreamInterceptorWrapper(opt.Key),
+			interceptors.TrustedSubnetStreamInterceptorWrapper(filter),
+			interceptors.TenantStreamInterceptorWrapper(opt.TenantKeys),
+			interceptors.APIKeyStreamInterceptorWrapper(apiKeys),
+			interceptors.RateLimitStreamInterceptorWrapper(limiter, opt.RateLimitBy, filter),
+			interceptors.AuditCallerStreamInterceptorWrapper(filter),
+		),
+	}
+	// агенты проверяют соединение раз в 30 секунд, в том числе без открытых вызовов
+	opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             20 * time.Second,
+		PermitWithoutStream: true,
+	}))
+	if opt.MaxUnzipSize > 0 {
+		// gRPC ограничивает размер сообщения после распаковки
+		opts = append(opts, grpc.MaxRecvMsgSize(int(opt.MaxUnzipSize)))
+	}
+	tlsConfig, err := serverTLSConfig(opt)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if opt.CryptoKey != "" {
+		codec, err := cryptocodec.NewServerCodec(opt.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.ForceServerCodec(codec))
 	}
 	return &GRPCServer{
 		grpcServer: *grpc.NewServer(opts...),
-	}
+	}, nil
 }
 
-func (s *GRPCServer) RegisterHandlers(targetStorage handlers.Storage, opt *Options) {
+// RegisterHandlers, регистрирует gRPC-сервис метрик.
+func (s *GRPCServer) RegisterHandlers(targetStorage handlers.Storage, opt *Options, extraOpts ...handlers.RouterOption) {
 	pb.RegisterMetricsServer(
 		&s.grpcServer,
-		handlers.NewMetricServer(targetStorage),
+		handlers.NewMetricServer(targetStorage, registry.NewRegistry(opt.AgentTimeout), extraOpts...),
 	)
 	s.addr = opt.EndpointAddr
 }
@@ -0,0 +1,97 @@
+package signature
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Поля сообщения gRPC-потока с подписью. В потоке метаданные передаются один раз
+// при открытии, поэтому каждое сообщение несет свою подпись в полях hash и key_id.
+const (
+	hashField  = "hash"
+	keyIDField = "key_id"
+)
+
+// ErrNotSignable, в сообщении нет полей для подписи.
+var ErrNotSignable = errors.New("сообщение не содержит полей подписи")
+
+// Signable, есть ли в сообщении поля для подписи (hash и key_id).
+func Signable(msg proto.Message) bool {
+	_, _, ok := signatureFields(msg)
+	return ok
+}
+
+// SignMessage, подписывает сообщение ключом keyID (или основным) и записывает подпись в его поля.
+func (k *Keys) SignMessage(keyID string, msg proto.Message) error {
+	if !k.Enabled() {
+		return nil
+	}
+
+	data, err := unsignedBytes(msg)
+	if err != nil {
+		return err
+	}
+
+	hashFd, keyIDFd, _ := signatureFields(msg)
+	id, hash := k.SignWith(keyID, data)
+
+	m := msg.ProtoReflect()
+	m.Set(hashFd, protoreflect.ValueOfString(hash))
+	m.Set(keyIDFd, protoreflect.ValueOfString(id))
+
+	return nil
+}
+
+// VerifyMessage, проверяет подпись из полей сообщения и возвращает идентификатор ключа, которым она сделана.
+func (k *Keys) VerifyMessage(msg proto.Message) (string, error) {
+	if !k.Enabled() {
+		return "", nil
+	}
+
+	data, err := unsignedBytes(msg)
+	if err != nil {
+		return "", err
+	}
+
+	hashFd, keyIDFd, _ := signatureFields(msg)
+	m := msg.ProtoReflect()
+
+	return k.Verify(data, m.Get(keyIDFd).String(), m.Get(hashFd).String())
+}
+
+// unsignedBytes, сериализует сообщение без полей подписи.
+func unsignedBytes(msg proto.Message) ([]byte, error) {
+	hashFd, keyIDFd, ok := signatureFields(msg)
+	if !ok {
+		return nil, ErrNotSignable
+	}
+
+	clone := proto.Clone(msg).ProtoReflect()
+	clone.Clear(hashFd)
+	clone.Clear(keyIDFd)
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(clone.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("не удалось сериализовать сообщение, %w", err)
+	}
+
+	return data, nil
+}
+
+func signatureFields(msg proto.Message) (protoreflect.FieldDescriptor, protoreflect.FieldDescriptor, bool) {
+	if msg == nil {
+		return nil, nil, false
+	}
+
+	fields := msg.ProtoReflect().Descriptor().Fields()
+	hashFd := fields.ByName(hashField)
+	keyIDFd := fields.ByName(keyIDField)
+	if hashFd == nil || keyIDFd == nil || hashFd.Kind() != protoreflect.StringKind || keyIDFd.Kind() != protoreflect.StringKind {
+		return nil, nil, false
+	}
+
+	return hashFd, keyIDFd, true
+}
@@ -0,0 +1,153 @@
+// Подпись сообщений HMAC-SHA256 с поддержкой нескольких действующих ключей.
+// Ключи задаются строкой "ring:id1:secret1,id2:secret2": первым ключом подписываются
+// исходящие сообщения, входящие проверяются любым из ключей. Это позволяет
+// менять ключ без простоя: сначала новый ключ добавляется на сервер вторым,
+// затем агенты переводятся на него, после чего старый ключ удаляется.
+// Строка без префикса "ring:" - единственный ключ без идентификатора, даже если
+// в ней есть двоеточия и запятые.
+
+package signature
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"strings"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+)
+
+const (
+	Header      = "HashSHA256" // заголовок (и ключ метаданных gRPC) с подписью сообщения
+	KeyIDHeader = "HashKeyID"  // заголовок (и ключ метаданных gRPC) с идентификатором ключа подписи
+)
+
+var (
+	// ErrMissing, сообщение не подписано.
+	ErrMissing = errors.New("сообщение не подписано")
+	// ErrInvalid, подпись не совпадает.
+	ErrInvalid = errors.New("неверная подпись сообщения")
+	// ErrUnknownKey, неизвестный идентификатор ключа подписи.
+	ErrUnknownKey = errors.New("неизвестный ключ подписи")
+)
+
+// Keys, набор ключей подписи. Нулевой указатель - подпись выключена.
+type Keys struct {
+	primary string
+	order   []string
+	secrets map[string]string
+}
+
+// RingPrefix, префикс строки с набором ключей "id:secret" через запятую.
+const RingPrefix = "ring:"
+
+// ParseKeys, разбирает строку ключей. Для пустой строки возвращает nil.
+func ParseKeys(value string) *Keys {
+	if value == "" {
+		return nil
+	}
+
+	list, ok := strings.CutPrefix(value, RingPrefix)
+	if !ok {
+		return &Keys{order: []string{""}, secrets: map[string]string{"": value}}
+	}
+
+	k := &Keys{secrets: make(map[string]string)}
+	for _, e := range strings.Split(list, ",") {
+		id, secret, _ := strings.Cut(e, ":")
+		id = strings.TrimSpace(id)
+		if _, ok := k.secrets[id]; ok {
+			continue
+		}
+		k.order = append(k.order, id)
+		k.secrets[id] = secret
+	}
+	k.primary = k.order[0]
+
+	return k
+}
+
+// Enabled, задан ли хотя бы один ключ.
+func (k *Keys) Enabled() bool {
+	return k != nil && len(k.secrets) > 0
+}
+
+// Sign, подписывает данные основным ключом. Возвращает идентификатор ключа и подпись.
+func (k *Keys) Sign(data []byte) (string, string) {
+	if !k.Enabled() {
+		return "", ""
+	}
+
+	return k.SignWith(k.primary, data)
+}
+
+// SignWith, подписывает данные ключом keyID, а если такого ключа нет - основным.
+// Сервер отвечает тем же ключом, которым подписан запрос, чтобы агент
+// мог проверить ответ в процессе смены ключей.
+func (k *Keys) SignWith(keyID string, data []byte) (string, string) {
+	if !k.Enabled() {
+		return "", ""
+	}
+
+	if _, ok := k.secrets[keyID]; !ok {
+		keyID = k.primary
+	}
+
+	return keyID, util.Hash(data, k.secrets[keyID])
+}
+
+// Verify, проверяет подпись данных и возвращает идентификатор ключа, которым она сделана.
+// Если идентификатор ключа не передан, подпись проверяется всеми ключами по очереди.
+func (k *Keys) Verify(data []byte, keyID string, hash string) (string, error) {
+	if !k.Enabled() {
+		return "", nil
+	}
+
+	if hash == "" {
+		return "", ErrMissing
+	}
+
+	if keyID != "" {
+		secret, ok := k.secrets[keyID]
+		if !ok {
+			return "", ErrUnknownKey
+		}
+
+		if !equal(util.Hash(data, secret), hash) {
+			return "", ErrInvalid
+		}
+
+		return keyID, nil
+	}
+
+	for _, id := range k.order {
+		if equal(util.Hash(data, k.secrets[id]), hash) {
+			return id, nil
+		}
+	}
+
+	return "", ErrInvalid
+}
+
+// RequestLine, подписываемые данные запроса без тела: метод и путь с параметрами,
+// например "POST /update/gauge/Alloc/1.5".
+func RequestLine(method string, uri string) []byte {
+	return []byte(method + " " + uri)
+}
+
+type ctxKey struct{}
+
+// WithKeyID, возвращает контекст с идентификатором ключа, которым подписан запрос.
+func WithKeyID(ctx context.Context, keyID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, keyID)
+}
+
+// KeyIDFromContext, возвращает идентификатор ключа, которым подписан запрос.
+func KeyIDFromContext(ctx context.Context) string {
+	keyID, _ := ctx.Value(ctxKey{}).(string)
+	return keyID
+}
+
+func equal(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
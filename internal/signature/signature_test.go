@@ -0,0 +1,101 @@
+package signature
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+)
+
+func TestParseKeys(t *testing.T) {
+	assert.False(t, ParseKeys("").Enabled())
+
+	single := ParseKeys("secret")
+	id, hash := single.Sign([]byte("data"))
+	assert.Equal(t, "", id)
+	assert.Equal(t, util.Hash([]byte("data"), "secret"), hash)
+
+	ring := ParseKeys("ring:v2:new,v1:old")
+	id, hash = ring.Sign([]byte("data"))
+	assert.Equal(t, "v2", id)
+	assert.Equal(t, util.Hash([]byte("data"), "new"), hash)
+
+	// ключ без префикса набора - один ключ, даже с двоеточием и запятой
+	for _, key := range []string{"abc:def", "abc:def,ghi:jkl"} {
+		legacy := ParseKeys(key)
+		id, hash = legacy.Sign([]byte("data"))
+		assert.Equal(t, "", id, key)
+		assert.Equal(t, util.Hash([]byte("data"), key), hash, key)
+	}
+}
+
+func TestKeys_Verify(t *testing.T) {
+	data := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+	keys := ParseKeys("ring:v2:new,v1:old")
+
+	tests := []struct {
+		name    string
+		keyID   string
+		hash    string
+		wantID  string
+		wantErr error
+	}{
+		{name: "primary key", keyID: "v2", hash: util.Hash(data, "new"), wantID: "v2"},
+		{name: "previous key during rotation", keyID: "v1", hash: util.Hash(data, "old"), wantID: "v1"},
+		{name: "no key id", hash: util.Hash(data, "old"), wantID: "v1"},
+		{name: "wrong key id", keyID: "v2", hash: util.Hash(data, "old"), wantErr: ErrInvalid},
+		{name: "unknown key id", keyID: "v3", hash: util.Hash(data, "new"), wantErr: ErrUnknownKey},
+		{name: "plain sha256 is rejected", hash: util.Hash(data, ""), wantErr: ErrInvalid},
+		{name: "unsigned", wantErr: ErrMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := keys.Verify(data, tt.keyID, tt.hash)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantID, id)
+		})
+	}
+}
+
+func TestKeys_SignWith(t *testing.T) {
+	keys := ParseKeys("ring:v2:new,v1:old")
+
+	id, hash := keys.SignWith("v1", []byte("data"))
+	assert.Equal(t, "v1", id)
+	assert.Equal(t, util.Hash([]byte("data"), "old"), hash)
+
+	id, _ = keys.SignWith("unknown", []byte("data"))
+	assert.Equal(t, "v2", id)
+}
+
+func TestKeys_SignMessage(t *testing.T) {
+	agentKeys := ParseKeys("ring:v1:old")
+	serverKeys := ParseKeys("ring:v2:new,v1:old")
+
+	chunk := &pb.MetricsChunk{Seq: 1, Metrics: []*pb.Metric{{Id: "Alloc", Mtype: "gauge", Value: 1}}}
+	require.NoError(t, agentKeys.SignMessage("", chunk))
+	assert.Equal(t, "v1", chunk.KeyId)
+	assert.NotEmpty(t, chunk.Hash)
+
+	// подпись не зависит от полей подписи, сообщение можно подписать повторно
+	id, err := serverKeys.VerifyMessage(chunk)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", id)
+	hash := chunk.Hash
+	require.NoError(t, agentKeys.SignMessage("", chunk))
+	assert.Equal(t, hash, chunk.Hash)
+
+	chunk.Metrics[0].Value = 2
+	_, err = serverKeys.VerifyMessage(chunk)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = serverKeys.VerifyMessage(&pb.MetricsChunk{Seq: 2})
+	assert.ErrorIs(t, err, ErrMissing)
+
+	assert.False(t, Signable(&pb.WatchRequest{}))
+	assert.ErrorIs(t, serverKeys.SignMessage("", &pb.WatchRequest{}), ErrNotSignable)
+}
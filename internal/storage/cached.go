@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+)
+
+// FlushIntervalDef, период сохранения изменений из кэша в БД по умолчанию.
+const FlushIntervalDef = time.Second
+
+// CacheBackend, БД, в которую кэш сохраняет изменения и из которой загружает метрики.
+type CacheBackend interface {
+	GetGauge(ctx context.Context, name string) (models.Gauge, error)
+	GetCounter(ctx context.Context, name string) (models.Counter, error)
+	GetGauges(ctx context.Context) (map[string]models.Gauge, error)
+	GetCounters(ctx context.Context) (map[string]models.Counter, error)
+	Tenants(ctx context.Context) ([]string, error)
+	SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error
+	Ping(ctx context.Context) error
+}
+
+// Cached, хранит текущие значения метрик в памяти перед БД. Чтение - из памяти,
+// при промахе - из БД с сохранением значения в памяти. Запись - в память и в очередь
+// изменений, которая раз в flushEvery сохраняется в БД одним пакетом на тенант.
+// При создании загружает в память метрики всех тенантов из БД.
+//
+// Рассчитан на единственный сервер, пишущий в БД: записи других серверов
+// в уже загруженные серии не видны до перезапуска.
+type Cached struct {
+	mem        MemoryStore
+	db         CacheBackend
+	flushEvery time.Duration
+
+	// изменения, еще не сохраненные в БД, по тенантам
+	mx      sync.Mutex
+	pending map[string]*cacheChanges
+
+	flushMx sync.Mutex // сохранение изменений выполняется не параллельно
+	loadMx  sync.Mutex // промахи чтения загружаются из БД по одному
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// cacheChanges, изменения метрик тенанта: имена измененных gauge (значения берутся из памяти
+// при сохранении, поэтому в БД попадает последнее значение) и суммы приращений counter.
+type cacheChanges struct {
+	gauges   map[string]struct{}
+	counters map[string]models.Counter
+}
+
+// NewCached, загружает метрики из db в mem и запускает сохранение изменений в БД раз в flushEvery
+// (0 - FlushIntervalDef). Сохранение останавливает Close.
+func NewCached(ctx context.Context, mem MemoryStore, db CacheBackend, flushEvery time.Duration) (*Cached, error) {
+	if flushEvery <= 0 {
+		flushEvery = FlushIntervalDef
+	}
+
+	c := &Cached{
+		mem:        mem,
+		db:         db,
+		flushEvery: flushEvery,
+		pending:    make(map[string]*cacheChanges),
+		done:       make(chan struct{}),
+	}
+
+	if err := c.warm(ctx); err != nil {
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go c.flushLoop()
+
+	return c, nil
+}
+
+// warm, загружает в память метрики всех тенантов из БД. Память должна быть пустой:
+// значения counter прибавляются к имеющимся.
+func (c *Cached) warm(ctx context.Context) error {
+	names, err := c.db.Tenants(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки тенантов из БД, %w", err)
+	}
+
+	series := 0
+	for _, name := range names {
+		tctx := tenant.WithTenant(ctx, name)
+		// значения counter читаются без преобразования во float64, искажающего значения больше 2^53
+		gauges, err := c.db.GetGauges(tctx)
+		if err != nil {
+			return fmt.Errorf("ошибка загрузки метрик тенанта %q из БД, %w", name, err)
+		}
+		counters, err := c.db.GetCounters(tctx)
+		if err != nil {
+			return fmt.Errorf("ошибка загрузки метрик тенанта %q из БД, %w", name, err)
+		}
+
+		if err := c.mem.SaveBatch(tctx, gauges, counters); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		series += len(gauges) + len(counters)
+	}
+
+	logger.Log.Infof("метрики загружены из БД в кэш: тенантов %d, серий %d", len(names), series)
+
+	return nil
+}
+
+// changes, изменения тенанта name. Вызывается под c.mx.
+func (c *Cached) changes(name string) *cacheChanges {
+	ch, ok := c.pending[name]
+	if !ok {
+		ch = &cacheChanges{gauges: make(map[string]struct{}), counters: make(map[string]models.Counter)}
+		c.pending[name] = ch
+	}
+
+	return ch
+}
+
+// enqueue, ставит изменения тенанта из контекста в очередь на сохранение в БД.
+// Вызывается после записи в память.
+func (c *Cached) enqueue(ctx context.Context, gauges []string, counters map[string]models.Counter) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	ch := c.changes(tenant.FromContext(ctx))
+	for _, k := range gauges {
+		ch.gauges[k] = struct{}{}
+	}
+	for k, v := range counters {
+		ch.counters[k] += v
+	}
+}
+
+// flush, сохраняет накопленные изменения в БД. Изменения тенанта, которые не удалось
+// сохранить, возвращаются в очередь и сохраняются при следующем вызове.
+func (c *Cached) flush(ctx context.Context) error {
+	c.flushMx.Lock()
+	defer c.flushMx.Unlock()
+
+	c.mx.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*cacheChanges)
+	c.mx.Unlock()
+
+	var errs []error
+	for _, name := range util.SortedKeys(pending) {
+		ch := pending[name]
+		tctx := tenant.WithTenant(ctx, name)
+
+		gauges := make(map[string]models.Gauge, len(ch.gauges))
+		for k := range ch.gauges {
+			if v, err := c.mem.GetGauge(tctx, k); err == nil {
+				gauges[k] = v
+			}
+		}
+
+		if err := c.db.SaveBatch(tctx, gauges, ch.counters); err != nil {
+			c.requeue(name, ch)
+			errs = append(errs, fmt.Errorf("тенант %q, %w", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("ошибка сохранения изменений из кэша в БД, %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// requeue, возвращает несохраненные изменения тенанта name в очередь.
+func (c *Cached) requeue(name string, ch *cacheChanges) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	cur := c.changes(name)
+	for k := range ch.gauges {
+		cur.gauges[k] = struct{}{}
+	}
+	for k, v := range ch.counters {
+		cur.counters[k] += v
+	}
+}
+
+// flushLoop, сохраняет изменения в БД раз в flushEvery до вызова Close.
+func (c *Cached) flushLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.flushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.flush(context.Background()); err != nil {
+				logger.Log.Error(err.Error())
+			}
+		}
+	}
+}
+
+// Close, останавливает периодическое сохранение и сохраняет оставшиеся изменения в БД.
+func (c *Cached) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+		close(c.done)
+	}
+	c.wg.Wait()
+
+	return c.flush(context.Background())
+}
+
+// Save, сохраняет накопленные изменения в БД, не дожидаясь очередного периода.
+func (c *Cached) Save() error {
+	return c.flush(context.Background())
+}
+
+// Restore, ничего не делает: метрики загружаются из БД при создании.
+func (c *Cached) Restore(_ context.Context) error {
+	return nil
+}
+
+func (c *Cached) SetGauge(ctx context.Context, name string, val float64) error {
+	if err := c.mem.SetGauge(ctx, name, val); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	c.enqueue(ctx, []string{name}, nil)
+
+	return nil
+}
+
+func (c *Cached) SetCounter(ctx context.Context, name string, val int64) error {
+	if err := c.mem.SetCounter(ctx, name, val); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	c.enqueue(ctx, nil, map[string]models.Counter{name: models.Counter(val)})
+
+	return nil
+}
+
+func (c *Cached) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	return c.SaveBatch(ctx, gauges, nil)
+}
+
+func (c *Cached) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	return c.SaveBatch(ctx, nil, counters)
+}
+
+// SaveBatch, записывает пакет в память и ставит его в очередь: в БД он сохраняется
+// одной транзакцией вместе с остальными изменениями тенанта.
+func (c *Cached) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	if err := c.mem.SaveBatch(ctx, gauges, counters); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	c.enqueue(ctx, util.SortedKeys(gauges), counters)
+
+	return nil
+}
+
+// GetGauge, возвращает значение gauge из памяти, при промахе - из БД.
+func (c *Cached) GetGauge(ctx context.Context, name string) (models.Gauge, error) {
+	if v, err := c.mem.GetGauge(ctx, name); err == nil {
+		return v, nil
+	}
+
+	c.loadMx.Lock()
+	defer c.loadMx.Unlock()
+
+	if v, err := c.mem.GetGauge(ctx, name); err == nil {
+		return v, nil
+	}
+
+	v, err := c.db.GetGauge(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	// пока значение читалось из БД, метрику могли записать: записанное значение новее
+	return c.mem.SetGaugeIfAbsent(ctx, name, v), nil
+}
+
+// GetCounter, возвращает значение counter из памяти, при промахе - из БД.
+func (c *Cached) GetCounter(ctx context.Context, name string) (models.Counter, error) {
+	if v, err := c.mem.GetCounter(ctx, name); err == nil {
+		return v, nil
+	}
+
+	c.loadMx.Lock()
+	defer c.loadMx.Unlock()
+
+	if v, err := c.mem.GetCounter(ctx, name); err == nil {
+		return v, nil
+	}
+
+	v, err := c.db.GetCounter(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	// пока значение читалось из БД, в память могли записать приращение, уже сохраненное
+	// в БД: значение из БД не прибавляется к нему, иначе приращение учлось бы дважды
+	return c.mem.SetCounterIfAbsent(ctx, name, v), nil
+}
+
+func (c *Cached) ToList(ctx context.Context) ([]string, error) {
+	return c.mem.ToList(ctx)
+}
+
+func (c *Cached) Items(ctx context.Context) ([]models.MetricInfo, error) {
+	return c.mem.Items(ctx)
+}
+
+func (c *Cached) SeriesCount(ctx context.Context) (int, error) {
+	return c.mem.SeriesCount(ctx)
+}
+
+// Tenants, возвращает список тенантов, для которых есть метрики (включая тенант по умолчанию).
+func (c *Cached) Tenants(ctx context.Context) []string {
+	return c.mem.Tenants(ctx)
+}
+
+// Ping, проверяет доступность БД.
+func (c *Cached) Ping(ctx context.Context) error {
+	return c.db.Ping(ctx)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// failingBackend, БД, сохранение в которую завершается ошибкой, пока fail = true.
+type failingBackend struct {
+	*SQLite
+	fail atomic.Bool
+}
+
+func (b *failingBackend) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	if b.fail.Load() {
+		return errors.New("БД недоступна")
+	}
+
+	return b.SQLite.SaveBatch(ctx, gauges, counters)
+}
+
+// racingBackend, БД, при чтении counter из которой сначала выполняется beforeGet:
+// запись в кэш, попавшая между промахом кэша и чтением из БД.
+type racingBackend struct {
+	*SQLite
+	beforeGet func()
+}
+
+func (b *racingBackend) GetCounter(ctx context.Context, name string) (models.Counter, error) {
+	if b.beforeGet != nil {
+		b.beforeGet()
+	}
+
+	return b.SQLite.GetCounter(ctx, name)
+}
+
+func TestCached(t *testing.T) {
+	ctx := context.Background()
+	teamA := tenant.WithTenant(ctx, "team-a")
+	db := openSQLite(t, SQLiteScheme+filepath.Join(t.TempDir(), "metrics.db"))
+
+	require.NoError(t, db.SetCounter(ctx, "PollCount", 10))
+	require.NoError(t, db.SetGauge(teamA, "Alloc", 1))
+
+	c, err := NewCached(ctx, NewShardedMemory(10, 4), db, time.Hour)
+	require.NoError(t, err)
+
+	t.Run("warm from db", func(t *testing.T) {
+		poll, err := c.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(10), poll)
+
+		alloc, err := c.GetGauge(teamA, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(1), alloc)
+		assert.Equal(t, []string{tenant.Default, "team-a"}, c.Tenants(ctx))
+	})
+
+	t.Run("write behind", func(t *testing.T) {
+		require.NoError(t, c.SetCounter(ctx, "PollCount", 2))
+		require.NoError(t, c.SaveBatch(ctx, map[string]models.Gauge{"Alloc": 3}, map[string]models.Counter{"PollCount": 3}))
+		require.NoError(t, c.SetGauge(ctx, "Alloc", 4))
+
+		poll, err := c.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(15), poll)
+
+		// в БД изменения попадают при сохранении
+		_, err = db.GetGauge(ctx, "Alloc")
+		assert.Error(t, err)
+
+		require.NoError(t, c.Save())
+		dbPoll, err := db.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(15), dbPoll)
+		dbAlloc, err := db.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(4), dbAlloc, "в БД - последнее значение gauge")
+	})
+
+	t.Run("large counter from db", func(t *testing.T) {
+		const big = int64(1)<<53 + 1
+		teamB := tenant.WithTenant(ctx, "team-b")
+		require.NoError(t, db.SetCounter(teamB, "PollCount", big))
+
+		warm, err := NewCached(ctx, NewShardedMemory(10, 4), db, time.Hour)
+		require.NoError(t, err)
+		defer warm.Close()
+
+		v, err := warm.GetCounter(teamB, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(big), v, "значение больше 2^53 не искажается")
+	})
+
+	t.Run("read through on miss", func(t *testing.T) {
+		require.NoError(t, db.SetGauge(ctx, "HeapSys", 7))
+
+		g, err := c.GetGauge(ctx, "HeapSys")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(7), g)
+
+		_, err = c.GetCounter(ctx, "Unknown")
+		assert.Error(t, err)
+	})
+
+	require.NoError(t, c.Close())
+
+	t.Run("consistent after restart", func(t *testing.T) {
+		restarted, err := NewCached(ctx, NewShardedMemory(10, 4), db, time.Hour)
+		require.NoError(t, err)
+		defer restarted.Close()
+
+		poll, err := restarted.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(15), poll)
+
+		count, err := restarted.SeriesCount(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+	})
+}
+
+func TestCached_FlushRetry(t *testing.T) {
+	ctx := context.Background()
+	db := &failingBackend{SQLite: openSQLite(t, SQLiteScheme+filepath.Join(t.TempDir(), "metrics.db"))}
+
+	c, err := NewCached(ctx, NewShardedMemory(10, 4), db, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer c.Close()
+
+	db.fail.Store(true)
+	require.NoError(t, c.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, c.SetGauge(ctx, "Alloc", 1))
+	assert.Error(t, c.Save())
+
+	// изменения, не сохраненные из-за ошибки, не теряются и не дублируются
+	require.NoError(t, c.SetCounter(ctx, "PollCount", 3))
+	db.fail.Store(false)
+
+	assert.Eventually(t, func() bool {
+		v, err := db.GetCounter(ctx, "PollCount")
+		return err == nil && v == 5
+	}, time.Second, 10*time.Millisecond, "изменения сохраняются в фоне")
+
+	require.NoError(t, c.Save())
+	v, err := db.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(5), v)
+
+	g, err := db.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, models.Gauge(1), g)
+}
+
+func TestCached_ReadThroughRace(t *testing.T) {
+	ctx := context.Background()
+	db := &racingBackend{SQLite: openSQLite(t, SQLiteScheme+filepath.Join(t.TempDir(), "metrics.db"))}
+
+	c, err := NewCached(ctx, NewShardedMemory(10, 4), db, time.Hour)
+	require.NoError(t, err)
+	defer c.Close()
+
+	// приращение записано и сохранено в БД, пока промах чтения загружал значение из БД
+	db.beforeGet = func() {
+		db.beforeGet = nil
+		require.NoError(t, c.SetCounter(ctx, "PollCount", 1))
+		require.NoError(t, c.Save())
+	}
+
+	v, err := c.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(1), v, "значение из БД не прибавляется к записанному")
+
+	v, err = c.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(1), v)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	sq "github.com/Masterminds/squirrel"
@@ -9,13 +10,17 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/migrations"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
 )
 
 type DB struct {
 	pool *pgxpool.Pool
 }
 
+// NewDBPool, подключается к БД и применяет миграции схемы.
 func NewDBPool(ctx context.Context, connString string) (*DB, error) {
 	connPool, err := pgxpool.New(ctx, connString)
 	if err != nil {
@@ -23,45 +28,20 @@ func NewDBPool(ctx context.Context, connString string) (*DB, error) {
 		return nil, fmt.Errorf("ошибка получения соединения из пула, %w", err)
 	}
 
-	err = createTables(ctx, connPool)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка создания таблиц в БД, %w", err)
+	if err := migrations.Up(ctx, connPool); err != nil {
+		connPool.Close()
+		return nil, fmt.Errorf("ошибка миграции схемы БД, %w", err)
 	}
 
 	return &DB{pool: connPool}, nil
 }
 
-func createTables(ctx context.Context, connectionPool *pgxpool.Pool) error {
-	_, err := connectionPool.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS counter
-		(
-			id  bigserial not null,
-			name TEXT NOT NULL,
-			value bigint NOT NULL,
-			updated_at timestamp with time zone NOT NULL DEFAULT now(),
-			CONSTRAINT counter_pkey PRIMARY KEY (id),
-			CONSTRAINT counter_metric_name UNIQUE (name)
-		);
-		CREATE TABLE IF NOT EXISTS gauge
-		(
-			id bigserial NOT NULL,
-			name TEXT NOT NULL,
-			value double precision NOT NULL,
-			updated_at timestamp with time zone NOT NULL DEFAULT now(),
-			CONSTRAINT gauge_pkey PRIMARY KEY (id),
-			CONSTRAINT gauge_metric_name UNIQUE (name)
-		);
-	`)
-
-	return fmt.Errorf("ошибка выполнения запроса, %w", err)
-}
-
 func (db *DB) SetGauge(ctx context.Context, name string, value float64) error {
 	tag, err := db.pool.Exec(ctx,
 		`
-		insert into gauge (name, value) values($1, $2)
-		on conflict (name) do update set value = $2
-		`, name, value)
+		insert into gauge (tenant, name, value) values($1, $2, $3)
+		on conflict (tenant, name) do update set value = $3, updated_at = now()
+		`, tenant.FromContext(ctx), name, value)
 
 	if err != nil {
 		return fmt.Errorf("ошибка выполнения запроса, %w", err)
@@ -74,19 +54,22 @@ func (db *DB) SetGauge(ctx context.Context, name string, value float64) error {
 
 func (db *DB) SetCounter(ctx context.Context, name string, value int64) error {
 	stmt, args, _ := sq.Insert("counter").
-		Columns("name", "value").
-		Values(name, value).
-		Suffix("on conflict (name) do update set value=EXCLUDED.value + counter.value").
+		Columns("tenant", "name", "value").
+		Values(tenant.FromContext(ctx), name, value).
+		Suffix("on conflict (tenant, name) do update set value=EXCLUDED.value + counter.value, updated_at = now()").
 		PlaceholderFormat(sq.Dollar).
 		ToSql()
 
-	_, err := db.pool.Exec(ctx, stmt, args...)
+	if _, err := db.pool.Exec(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("ошибка выполнения запроса, %w", err)
+	}
 
-	return fmt.Errorf("ошибка выполнения запроса, %w", err)
+	return nil
 }
 
 func (db *DB) GetCounter(ctx context.Context, metricName string) (models.Counter, error) {
-	stmt, args, _ := sq.Select("name", "value").From("counter").Where(sq.Eq{"name": metricName}).PlaceholderFormat(sq.Dollar).ToSql()
+	stmt, args, _ := sq.Select("name", "value").From("counter").
+		Where(sq.Eq{"tenant": tenant.FromContext(ctx), "name": metricName}).PlaceholderFormat(sq.Dollar).ToSql()
 	row := db.pool.QueryRow(ctx, stmt, args...)
 
 	var (
@@ -103,7 +86,8 @@ func (db *DB) GetCounter(ctx context.Context, metricName string) (models.Counter
 }
 
 func (db *DB) GetGauge(ctx context.Context, metricName string) (models.Gauge, error) {
-	stmt, args, _ := sq.Select("name", "value").From("gauge").Where(sq.Eq{"name": metricName}).PlaceholderFormat(sq.Dollar).ToSql()
+	stmt, args, _ := sq.Select("name", "value").From("gauge").
+		Where(sq.Eq{"tenant": tenant.FromContext(ctx), "name": metricName}).PlaceholderFormat(sq.Dollar).ToSql()
 	row := db.pool.QueryRow(ctx, stmt, args...)
 
 	var (
@@ -120,12 +104,13 @@ func (db *DB) GetGauge(ctx context.Context, metricName string) (models.Gauge, er
 }
 
 func (db *DB) GetGauges(ctx context.Context) (map[string]models.Gauge, error) {
-	stmt, _, err := sq.Select("name", "value").From("gauge").ToSql()
+	stmt, args, err := sq.Select("name", "value").From("gauge").
+		Where(sq.Eq{"tenant": tenant.FromContext(ctx)}).PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("ошибка создания запроса к БД, %w", err)
 	}
 
-	rows, err := db.pool.Query(ctx, stmt)
+	rows, err := db.pool.Query(ctx, stmt, args...)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
 	}
@@ -150,12 +135,13 @@ func (db *DB) GetGauges(ctx context.Context) (map[string]models.Gauge, error) {
 }
 
 func (db *DB) GetCounters(ctx context.Context) (map[string]models.Counter, error) {
-	stmt, _, err := sq.Select("name", "value").From("couter").ToSql()
+	stmt, args, err := sq.Select("name", "value").From("counter").
+		Where(sq.Eq{"tenant": tenant.FromContext(ctx)}).PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 
-	rows, err := db.pool.Query(ctx, stmt)
+	rows, err := db.pool.Query(ctx, stmt, args...)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
@@ -203,6 +189,65 @@ func (db *DB) ToList(ctx context.Context) ([]string, error) {
 	return list, nil
 }
 
+// Items, возвращает метрики тенанта из контекста с временем их обновления:
+// сначала gauge, затем counter, внутри типа - по имени.
+func (db *DB) Items(ctx context.Context) ([]models.MetricInfo, error) {
+	rows, err := db.pool.Query(ctx, `
+		select 'gauge', name, value, updated_at from gauge where tenant = $1
+		union all
+		select 'counter', name, value::double precision, updated_at from counter where tenant = $1
+		order by 1 desc, 2
+	`, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.MetricInfo
+	for rows.Next() {
+		var item models.MetricInfo
+		if err := rows.Scan(&item.MType, &item.Name, &item.Value, &item.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return items, nil
+}
+
+// Tenants, возвращает тенантов, для которых есть метрики, по имени.
+func (db *DB) Tenants(ctx context.Context) ([]string, error) {
+	rows, err := db.pool.Query(ctx, "select tenant from gauge union select tenant from counter order by 1")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return names, nil
+}
+
+// SeriesCount, возвращает количество серий метрик тенанта из контекста.
+func (db *DB) SeriesCount(ctx context.Context) (int, error) {
+	var count int
+
+	err := db.pool.QueryRow(ctx, `
+		select (select count(*) from gauge where tenant = $1) + (select count(*) from counter where tenant = $1)
+	`, tenant.FromContext(ctx)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return count, nil
+}
+
 func (db *DB) Ping(ctx context.Context) error {
 	err := db.pool.Ping(ctx)
 	if err != nil {
@@ -213,69 +258,121 @@ func (db *DB) Ping(ctx context.Context) error {
 }
 
 func (db *DB) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
-	logger.Log.Info("save metrics in DBStorage GAUGES")
+	return db.SaveBatch(ctx, gauges, nil)
+}
 
-	stmt := "insert into gauge(name, value) values(@name, @value) on conflict (name) do update set value=@value"
-	batch := &pgx.Batch{}
+func (db *DB) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	return db.SaveBatch(ctx, nil, counters)
+}
 
-	for k, v := range gauges {
-		args := pgx.NamedArgs{
-			"name":  k,
-			"value": v.GetRawValue(),
-		}
-		batch.Queue(stmt, args)
+// copyBatchMin, размер пакета, начиная с которого метрики загружаются через COPY
+// во временную таблицу, а не многострочной вставкой.
+const copyBatchMin = 1000
+
+// SaveBatch, записывает gauge и counter пакета в одной транзакции. Небольшие пакеты
+// записываются многострочной вставкой, большие - через COPY во временную таблицу.
+// Строки упорядочены по имени, чтобы параллельные пакеты блокировали строки в одном порядке.
+func (db *DB) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	if len(gauges) == 0 && len(counters) == 0 {
+		return nil
 	}
 
-	results := db.pool.SendBatch(ctx, batch)
+	gaugeRows := make([][]any, 0, len(gauges))
+	for _, k := range util.SortedKeys(gauges) {
+		gaugeRows = append(gaugeRows, []any{k, *gauges[k].GetRawValue()})
+	}
+	counterRows := make([][]any, 0, len(counters))
+	for _, k := range util.SortedKeys(counters) {
+		counterRows = append(counterRows, []any{k, *counters[k].GetRawValue()})
+	}
 
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции, %w", err)
+	}
 	defer func() {
-		err := results.Close()
-		if err != nil {
-			logger.Log.Errorf("не удалось закрыть запрос, %s", err.Error())
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.Log.Errorf("ошибка отката транзакции, %s", err.Error())
 		}
 	}()
 
-	_, err := results.Exec()
-	if err != nil {
-		return fmt.Errorf("ошибка выполнения запроса, %s", err.Error())
+	name := tenant.FromContext(ctx)
+	if err := upsertRows(ctx, tx, name, pgGaugeTable, gaugeRows); err != nil {
+		return err
+	}
+	if err := upsertRows(ctx, tx, name, pgCounterTable, counterRows); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("ошибка фиксации транзакции, %w", err)
 	}
 
 	return nil
 }
 
-func (db *DB) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
-	logger.Log.Info("save metrics in DBStorage COUNTERS")
+// pgTable, таблица метрик одного типа и обновление значения при конфликте.
+type pgTable struct {
+	name      string
+	valueType string
+	onUpdate  string
+}
 
-	insertStmt := sq.Insert("counter").Columns("name", "value").
-		Suffix("on conflict (name) do update set value = counter.value + EXCLUDED.value").
-		PlaceholderFormat(sq.Dollar)
+var (
+	pgGaugeTable   = pgTable{name: "gauge", valueType: "double precision", onUpdate: "value = excluded.value"}
+	pgCounterTable = pgTable{name: "counter", valueType: "bigint", onUpdate: "value = counter.value + excluded.value"}
+)
 
-	tx, err := db.pool.Begin(ctx)
+func (t pgTable) conflict() string {
+	return "on conflict (tenant, name) do update set " + t.onUpdate + ", updated_at = now()"
+}
+
+// upsertRows, записывает строки (имя, значение) тенанта name в таблицу t в транзакции tx.
+func upsertRows(ctx context.Context, tx pgx.Tx, name string, t pgTable, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if len(rows) >= copyBatchMin {
+		return copyRows(ctx, tx, name, t, rows)
+	}
+
+	insert := sq.Insert(t.name).Columns("tenant", "name", "value").Suffix(t.conflict()).PlaceholderFormat(sq.Dollar)
+	for _, row := range rows {
+		insert = insert.Values(name, row[0], row[1])
+	}
+
+	stmt, args, err := insert.ToSql()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return fmt.Errorf("ошибка создания запроса к БД, %w", err)
+	}
+	if _, err := tx.Exec(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("ошибка выполнения запроса, %w", err)
 	}
 
-	defer func() {
-		err := tx.Rollback(ctx)
-		if err != nil {
-			logger.Log.Errorf("ошибка отката транзакции, %s", err.Error())
-		}
-	}()
+	return nil
+}
 
-	for k, v := range counters {
-		stmt, args, err := insertStmt.Values(k, *v.GetRawValue()).ToSql()
-		if err != nil {
-			return fmt.Errorf("%w", err)
-		}
+// copyRows, загружает строки через COPY во временную таблицу, удаляемую при завершении
+// транзакции, и переносит их в таблицу t одним запросом.
+func copyRows(ctx context.Context, tx pgx.Tx, name string, t pgTable, rows [][]any) error {
+	staging := t.name + "_staging"
 
-		_, err = db.pool.Exec(ctx, stmt, args...)
-		if err != nil {
-			return fmt.Errorf("%w", err)
-		}
+	_, err := tx.Exec(ctx, fmt.Sprintf("create temp table %s (name text not null, value %s not null) on commit drop", staging, t.valueType))
+	if err != nil {
+		return fmt.Errorf("ошибка создания временной таблицы, %w", err)
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{staging}, []string{"name", "value"}, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("ошибка загрузки метрик в БД, %w", err)
 	}
 
-	commitErr := tx.Commit(ctx)
-	return fmt.Errorf("%w", commitErr)
+	stmt := fmt.Sprintf("insert into %s (tenant, name, value) select $1::text, name, value from %s order by name %s", t.name, staging, t.conflict())
+	if _, err := tx.Exec(ctx, stmt, name); err != nil {
+		return fmt.Errorf("ошибка выполнения запроса, %w", err)
+	}
+
+	return nil
 }
 
 func (db *DB) Save() error {
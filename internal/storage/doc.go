@@ -1,6 +1,6 @@
 // Содержит реализацию работы с различными типами хранилищ:
-// - в памяти
-// - в файле
-// - в БД
+// - в памяти (с общей блокировкой или разделенное на сегменты)
+// - в файле (снимок метрик и, при необходимости, журнал обновлений WAL)
+// - в БД (PostgreSQL или встроенная SQLite), в том числе с кэшем метрик в памяти
 
 package storage
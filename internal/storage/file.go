@@ -1,16 +1,15 @@
 package storage
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
 )
 
 type MemoryStore interface {
@@ -18,20 +17,65 @@ type MemoryStore interface {
 	GetGauges(ctx context.Context) map[string]models.Gauge
 	SetGauge(ctx context.Context, name string, val float64) error
 	SetGauges(ctx context.Context, gauges map[string]float64)
+	SetGaugeIfAbsent(ctx context.Context, name string, val models.Gauge) models.Gauge
 	GetCounter(ctx context.Context, name string) (models.Counter, error)
 	GetCounters(ctx context.Context) map[string]models.Counter
 	SetCounter(ctx context.Context, name string, value int64) error
 	SetCounters(ctx context.Context, gauges map[string]int64)
+	SetCounterIfAbsent(ctx context.Context, name string, val models.Counter) models.Counter
+	SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error
+	SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error
+	SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error
 	ToList(ctx context.Context) ([]string, error)
+	Items(ctx context.Context) ([]models.MetricInfo, error)
+	Tenants(ctx context.Context) []string
+	SeriesCount(ctx context.Context) (int, error)
 }
 
 type File struct {
 	path        string
 	immediately bool
 	memStorage  MemoryStore
+
+	keep   int            // сколько последних снимков хранить, 0 - только текущий
+	format SnapshotFormat // формат записи снимков, читаются снимки любого формата
+
+	// mx упорядочивает запись в журнал WAL с изменением метрик и сворачивание журнала в снимок
+	mx      sync.Mutex
+	walOpts *WALOptions
+	wal     *wal
+}
+
+// FileOption, дополнительная настройка файлового хранилища.
+type FileOption func(*File)
+
+// WithWAL, включает журнал обновлений (WAL) рядом с файлом снимка (<путь>.wal).
+// Каждое изменение дописывается в журнал, снимок записывается при Save и при
+// превышении журналом размера opts.CompactSize, после чего журнал очищается.
+// При восстановлении к снимку применяются записи журнала, сделанные после него.
+func WithWAL(opts WALOptions) FileOption {
+	return func(f *File) {
+		f.walOpts = &opts
+	}
 }
 
-func NewFile(pathToFile string, memStorage MemoryStore, restore bool, storeInterval time.Duration) *File {
+// WithSnapshotKeep, хранить keep последних снимков (path, path.1, ...): если текущий
+// снимок поврежден, метрики восстанавливаются из самого нового неповрежденного.
+func WithSnapshotKeep(keep int) FileOption {
+	return func(f *File) {
+		f.keep = keep
+	}
+}
+
+// WithSnapshotFormat, записывать снимки в формате format (кодирование и сжатие).
+// Формат снимка при восстановлении определяется по его заголовку.
+func WithSnapshotFormat(format SnapshotFormat) FileOption {
+	return func(f *File) {
+		f.format = format
+	}
+}
+
+func NewFile(pathToFile string, memStorage MemoryStore, restore bool, storeInterval time.Duration, opts ...FileOption) *File {
 	immediatelySave := false
 	if storeInterval == 0 {
 		immediatelySave = true
@@ -42,6 +86,9 @@ func NewFile(pathToFile string, memStorage MemoryStore, restore bool, storeInter
 		immediately: immediatelySave,
 		memStorage:  memStorage,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	if restore {
 		err := s.Restore(context.Background())
@@ -50,35 +97,94 @@ func NewFile(pathToFile string, memStorage MemoryStore, restore bool, storeInter
 		}
 	}
 
+	if s.walOpts != nil {
+		// номера записей журнала продолжают номер, вошедший в снимок, даже если метрики не восстанавливались
+		w, err := openWAL(walPath(pathToFile), *s.walOpts, s.snapshotSeq())
+		if err != nil {
+			logger.Log.Errorf("Ошибка открытия журнала WAL, метрики сохраняются только снимками, %s", err.Error())
+		} else {
+			s.wal = w
+		}
+	}
+
 	return s
 }
 
-func (fs *File) GetGauge(ctx context.Context, name string) (models.Gauge, error) {
-	val, err := fs.memStorage.GetGauge(ctx, name)
+// Close, сбрасывает журнал WAL на диск и закрывает его.
+func (fs *File) Close() error {
+	if fs.wal == nil {
+		return nil
+	}
+
+	return fs.wal.close()
+}
+
+// snapshotSeq, номер последней записи журнала, вошедшей в снимок. 0 - снимка нет или он без журнала.
+func (fs *File) snapshotSeq() uint64 {
+	di, err := readSnapshot(fs.path, fs.snapshotKeep())
 	if err != nil {
-		return 0, fmt.Errorf("%w", err)
+		return 0
 	}
-	return val, nil
+
+	return di.WALSeq
 }
 
-func (fs *File) SetGauge(ctx context.Context, name string, value float64) error {
-	if err := fs.memStorage.SetGauge(ctx, name, value); err != nil {
+// logged, дописывает изменение в журнал WAL и применяет его к метрикам в памяти.
+// Без журнала - применяет изменение и сохраняет снимок по настройке хранилища.
+func (fs *File) logged(ctx context.Context, rec walRecord, apply func() error) error {
+	if fs.wal == nil {
+		if err := apply(); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		fs.SaveNow()
+
+		return nil
+	}
+
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+
+	rec.Tenant = tenant.FromContext(ctx)
+	if err := fs.wal.append(rec); err != nil {
+		// поврежденный журнал очищается снимком, изменения в памяти в нем уже есть
+		if fs.wal.needCompact() {
+			if err := fs.saveSnapshot(); err != nil {
+				logger.Log.Errorf("ошибка сворачивания журнала в снимок, %s", err.Error())
+			}
+		}
+		return err
+	}
+	if err := apply(); err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
-	fs.SaveNow()
+	if fs.wal.needCompact() {
+		if err := fs.saveSnapshot(); err != nil {
+			logger.Log.Errorf("ошибка сворачивания журнала в снимок, %s", err.Error())
+		}
+	}
 
 	return nil
 }
 
-func (fs *File) SetCounter(ctx context.Context, name string, value int64) error {
-	if err := fs.memStorage.SetCounter(ctx, name, value); err != nil {
-		return fmt.Errorf("%w", err)
+func (fs *File) GetGauge(ctx context.Context, name string) (models.Gauge, error) {
+	val, err := fs.memStorage.GetGauge(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
 	}
+	return val, nil
+}
 
-	fs.SaveNow()
+func (fs *File) SetGauge(ctx context.Context, name string, value float64) error {
+	return fs.logged(ctx, walRecord{Gauges: map[string]float64{name: value}}, func() error {
+		return fs.memStorage.SetGauge(ctx, name, value)
+	})
+}
 
-	return nil
+func (fs *File) SetCounter(ctx context.Context, name string, value int64) error {
+	return fs.logged(ctx, walRecord{Counters: map[string]int64{name: value}}, func() error {
+		return fs.memStorage.SetCounter(ctx, name, value)
+	})
 }
 
 func (fs *File) GetCounter(ctx context.Context, name string) (models.Counter, error) {
@@ -97,61 +203,70 @@ func (fs *File) ToList(ctx context.Context) ([]string, error) {
 	return val, nil
 }
 
-func (fs *File) Dump(gauges map[string]float64, counters map[string]int64) error {
-	f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+// Items, возвращает метрики тенанта из контекста с временем их обновления.
+func (fs *File) Items(ctx context.Context) ([]models.MetricInfo, error) {
+	val, err := fs.memStorage.Items(ctx)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
+	return val, nil
+}
 
-	defer func() {
-		closeErr := f.Close()
-		if closeErr != nil {
-			logger.Log.Errorf("ошибка закрытия файла, %s", closeErr.Error())
-		}
-	}()
-
-	di := models.DumpItem{Gauges: gauges, Counters: counters}
-
-	data, err := json.MarshalIndent(di, "", "  ")
+// SeriesCount, возвращает количество серий метрик тенанта из контекста.
+func (fs *File) SeriesCount(ctx context.Context) (int, error) {
+	val, err := fs.memStorage.SeriesCount(ctx)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return 0, fmt.Errorf("%w", err)
 	}
+	return val, nil
+}
+
+func (fs *File) Dump(gauges map[string]float64, counters map[string]int64) error {
+	return fs.dumpItem(models.DumpItem{Gauges: gauges, Counters: counters})
+}
 
-	_, err = f.Write(data)
+// dumpItem, записывает в файл снимок метрик всех тенантов. Файл заменяется атомарно:
+// при сбое во время записи остается предыдущий снимок.
+func (fs *File) dumpItem(di models.DumpItem) error {
+	data, err := encodeSnapshot(di, fs.format)
 	if err != nil {
-		return fmt.Errorf("ошибка записи в файл, %w", err)
+		return err
 	}
 
-	return nil
+	return writeSnapshot(fs.path, data, fs.snapshotKeep())
 }
 
 func (fs *File) RestoreNow() (map[string]float64, map[string]int64, error) {
-	var buf bytes.Buffer
-
-	f, err := os.OpenFile(fs.path, os.O_RDONLY|os.O_CREATE, 0666)
+	di, err := fs.restoreItem()
 	if err != nil {
-		return nil, nil, fmt.Errorf("ошибка открытия файла, %w", err)
+		return nil, nil, err
 	}
 
-	_, err = buf.ReadFrom(f)
-	if err != nil {
-		return nil, nil, fmt.Errorf("ошибка чтения файла, %w", err)
-	}
-
-	di := models.DumpItem{}
+	return di.Gauges, di.Counters, nil
+}
 
-	err = json.Unmarshal(buf.Bytes(), &di)
+// restoreItem, читает из файла метрики всех тенантов. Если текущий снимок поврежден,
+// читается самый новый неповрежденный из хранимых.
+func (fs *File) restoreItem() (models.DumpItem, error) {
+	di, err := readSnapshot(fs.path, fs.snapshotKeep())
 	if err != nil {
-		return nil, nil, fmt.Errorf("ошибка преборазования из json, %w", err)
+		return di, err
 	}
 
 	logger.Log.Info(di)
 
-	return di.Gauges, di.Counters, nil
+	return di, nil
+}
+
+// snapshotKeep, сколько последних снимков хранить.
+func (fs *File) snapshotKeep() int {
+	return max(fs.keep, 1)
 }
 
+// SaveNow, сохраняет снимок, если метрики сохраняются сразу. С журналом WAL
+// изменения уже сохранены в журнале, снимок не записывается.
 func (fs *File) SaveNow() {
-	if fs.immediately {
+	if fs.immediately && fs.wal == nil {
 		err := fs.Save()
 		if err != nil {
 			logger.Log.Errorf("ошибка сохранения метрик в файл, %s", err.Error())
@@ -183,32 +298,100 @@ func (fs *File) ExtractCounters(ctx context.Context) map[string]int64 {
 	return c
 }
 
+// Save, записывает снимок метрик всех тенантов в файл. С журналом WAL снимок
+// включает все записи журнала, после записи снимка журнал очищается.
 func (fs *File) Save() error {
-	logger.Log.Info("Начало сохранения метрик в файл ...")
+	if fs.wal != nil {
+		// изменения ждут записи снимка: иначе в снимок попадут изменения, которых еще нет в журнале
+		fs.mx.Lock()
+		defer fs.mx.Unlock()
+	}
+
+	return fs.saveSnapshot()
+}
 
-	ctx := context.Background()
+// saveSnapshot, записывает снимок и очищает журнал WAL. С журналом вызывается под fs.mx.
+func (fs *File) saveSnapshot() error {
+	logger.Log.Info("Начало сохранения метрик в файл ...")
 
-	g := fs.ExtractGauges(ctx)
-	c := fs.ExtractCounters(ctx)
-	err := fs.Dump(g, c)
+	di := fs.snapshot(context.Background())
+	if fs.wal != nil {
+		di.WALSeq = fs.wal.lastSeq()
+	}
 
+	err := fs.dumpItem(di)
 	if err != nil {
 		return err
 	}
 
+	if fs.wal != nil {
+		if err := fs.wal.reset(); err != nil {
+			return err
+		}
+	}
+
 	logger.Log.Info("Значения метрик успешно сохранены в файл")
 
 	return nil
 }
 
+// snapshot, метрики всех тенантов для записи в файл.
+func (fs *File) snapshot(ctx context.Context) models.DumpItem {
+	di := models.DumpItem{
+		Gauges:   fs.ExtractGauges(ctx),
+		Counters: fs.ExtractCounters(ctx),
+	}
+
+	for _, name := range fs.memStorage.Tenants(ctx) {
+		if name == tenant.Default {
+			continue
+		}
+
+		if di.Tenants == nil {
+			di.Tenants = make(map[string]models.DumpItem)
+		}
+
+		tenantCtx := tenant.WithTenant(ctx, name)
+		di.Tenants[name] = models.DumpItem{
+			Gauges:   fs.ExtractGauges(tenantCtx),
+			Counters: fs.ExtractCounters(tenantCtx),
+		}
+	}
+
+	return di
+}
+
+// Restore, восстанавливает метрики из снимка, затем - из записей журнала WAL, сделанных после снимка.
 func (fs *File) Restore(ctx context.Context) error {
-	g, c, err := fs.RestoreNow()
+	di, err := fs.restoreItem()
 	if err != nil {
 		return err
 	}
 
-	fs.memStorage.SetGauges(ctx, g)
-	fs.memStorage.SetCounters(ctx, c)
+	fs.memStorage.SetGauges(ctx, di.Gauges)
+	fs.memStorage.SetCounters(ctx, di.Counters)
+
+	for name, t := range di.Tenants {
+		tenantCtx := tenant.WithTenant(ctx, name)
+		fs.memStorage.SetGauges(tenantCtx, t.Gauges)
+		fs.memStorage.SetCounters(tenantCtx, t.Counters)
+	}
+
+	if fs.walOpts == nil {
+		return nil
+	}
+
+	var replayed int
+	_, _, err = replayWAL(walPath(fs.path), di.WALSeq, func(rec walRecord) {
+		tenantCtx := tenant.WithTenant(ctx, rec.Tenant)
+		fs.memStorage.SetGauges(tenantCtx, rec.Gauges)
+		fs.memStorage.SetCounters(tenantCtx, rec.Counters)
+		replayed++
+	})
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("из журнала WAL восстановлено записей: %d", replayed)
 
 	return nil
 }
@@ -217,12 +400,41 @@ func (fs *File) Ping(_ context.Context) error {
 	return errors.New("it's not db. filestorage")
 }
 
-func (fs *File) SaveGaugesBatch(_ context.Context, _ map[string]models.Gauge) error {
+func (fs *File) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
 	logger.Log.Info("save metrics in FILE GAUGES")
-	return nil
+	rec := walRecord{Gauges: make(map[string]float64, len(gauges))}
+	for k, v := range gauges {
+		rec.Gauges[k] = *v.GetRawValue()
+	}
+
+	return fs.logged(ctx, rec, func() error {
+		return fs.memStorage.SaveGaugesBatch(ctx, gauges)
+	})
 }
 
-func (fs *File) SaveCountersBatch(_ context.Context, _ map[string]models.Counter) error {
+func (fs *File) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
 	logger.Log.Info("save metrics in FILE COUNTERS")
-	return nil
+	rec := walRecord{Counters: make(map[string]int64, len(counters))}
+	for k, v := range counters {
+		rec.Counters[k] = *v.GetRawValue()
+	}
+
+	return fs.logged(ctx, rec, func() error {
+		return fs.memStorage.SaveCountersBatch(ctx, counters)
+	})
+}
+
+// SaveBatch, записывает пакет одной записью журнала WAL: после сбоя восстанавливается весь пакет или ничего.
+func (fs *File) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	rec := walRecord{Gauges: make(map[string]float64, len(gauges)), Counters: make(map[string]int64, len(counters))}
+	for k, v := range gauges {
+		rec.Gauges[k] = *v.GetRawValue()
+	}
+	for k, v := range counters {
+		rec.Counters[k] = *v.GetRawValue()
+	}
+
+	return fs.logged(ctx, rec, func() error {
+		return fs.memStorage.SaveBatch(ctx, gauges, counters)
+	})
 }
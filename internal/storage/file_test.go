@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
 	"github.com/ShvetsovYura/metrics-collector/mocks"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -223,3 +224,36 @@ func TestFile_Restore(t *testing.T) {
 		})
 	}
 }
+
+func TestFile_RestoreTenants(t *testing.T) {
+	path := "test_tenants.txt"
+
+	defer func() {
+		err := os.Remove(path)
+		if err != nil {
+			fmt.Printf("Не удается удалить файл, %s", err.Error())
+		}
+	}()
+
+	ctx := context.Background()
+	ctxA := tenant.WithTenant(ctx, "team-a")
+
+	fs := NewFile(path, NewMemory(10), false, 0)
+	assert.NoError(t, fs.SetGauge(ctx, "Alloc", 1))
+	assert.NoError(t, fs.SetGauge(ctxA, "Alloc", 2))
+	assert.NoError(t, fs.SetCounter(ctxA, "PollCount", 3))
+
+	restored := NewFile(path, NewMemory(10), true, 0)
+
+	g, err := restored.GetGauge(ctx, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Gauge(1), g)
+
+	g, err = restored.GetGauge(ctxA, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Gauge(2), g)
+
+	c, err := restored.GetCounter(ctxA, "PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Counter(3), c)
+}
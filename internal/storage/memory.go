@@ -5,65 +5,188 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/logger"
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
 )
 
 type Metric interface {
 	ToString() string
 }
 
+// Memory, хранит метрики тенанта по умолчанию в своих мапах,
+// метрики остальных тенантов - в отдельных вложенных Memory.
 type Memory struct {
-	mx            sync.Mutex
-	gaugeMetrics  map[string]models.Gauge
-	counterMetric map[string]models.Counter
+	mx             sync.Mutex
+	gaugeMetrics   map[string]models.Gauge
+	counterMetric  map[string]models.Counter
+	gaugeUpdated   map[string]time.Time // время последнего обновления gauge
+	counterUpdated map[string]time.Time // время последнего обновления counter
+	tenantsMx      sync.Mutex
+	tenants        map[string]*Memory
+	metricsCount   int
 }
 
 func NewMemory(metricsCount int) *Memory {
 	m := Memory{
-		gaugeMetrics:  make(map[string]models.Gauge, metricsCount),
-		counterMetric: make(map[string]models.Counter, 1),
+		gaugeMetrics:   make(map[string]models.Gauge, metricsCount),
+		counterMetric:  make(map[string]models.Counter, 1),
+		gaugeUpdated:   make(map[string]time.Time, metricsCount),
+		counterUpdated: make(map[string]time.Time, 1),
+		metricsCount:   metricsCount,
 	}
 
 	return &m
 }
 
-func (m *Memory) SetGauge(_ context.Context, name string, val float64) error {
+// namespace, возвращает хранилище метрик тенанта из контекста. create - создать хранилище,
+// если его нет, иначе для неизвестного тенанта возвращается пустое хранилище, которое
+// не запоминается: чтение не добавляет тенантов.
+func (m *Memory) namespace(ctx context.Context, create bool) *Memory {
+	name := tenant.FromContext(ctx)
+	if name == tenant.Default {
+		return m
+	}
+
+	m.tenantsMx.Lock()
+	defer m.tenantsMx.Unlock()
+
+	ns, ok := m.tenants[name]
+	if ok {
+		return ns
+	}
+
+	if !create {
+		return NewMemory(0)
+	}
+
+	if m.tenants == nil {
+		m.tenants = make(map[string]*Memory)
+	}
+	ns = NewMemory(m.metricsCount)
+	m.tenants[name] = ns
+
+	return ns
+}
+
+// Tenants, возвращает список тенантов, для которых есть метрики (включая тенант по умолчанию).
+func (m *Memory) Tenants(_ context.Context) []string {
+	m.tenantsMx.Lock()
+	defer m.tenantsMx.Unlock()
+
+	list := make([]string, 0, len(m.tenants)+1)
+	list = append(list, tenant.Default)
+
+	for name := range m.tenants {
+		list = append(list, name)
+	}
+
+	sort.Strings(list[1:])
+
+	return list
+}
+
+// SeriesCount, возвращает количество серий метрик тенанта из контекста.
+func (m *Memory) SeriesCount(ctx context.Context) (int, error) {
+	ns := m.namespace(ctx, false)
+
+	ns.mx.Lock()
+	defer ns.mx.Unlock()
+
+	return len(ns.gaugeMetrics) + len(ns.counterMetric), nil
+}
+
+func (m *Memory) SetGauge(ctx context.Context, name string, val float64) error {
+	m = m.namespace(ctx, true)
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	m.gaugeMetrics[name] = models.Gauge(val)
+	m.touchGauge(name, time.Now())
 
 	return nil
 }
 
-func (m *Memory) SetGauges(_ context.Context, gauges map[string]float64) {
+// touchGauge, запоминает время обновления gauge. Вызывается под m.mx.
+func (m *Memory) touchGauge(name string, t time.Time) {
+	if m.gaugeUpdated == nil {
+		m.gaugeUpdated = make(map[string]time.Time, len(m.gaugeMetrics))
+	}
+	m.gaugeUpdated[name] = t
+}
+
+// touchCounter, запоминает время обновления counter. Вызывается под m.mx.
+func (m *Memory) touchCounter(name string, t time.Time) {
+	if m.counterUpdated == nil {
+		m.counterUpdated = make(map[string]time.Time, len(m.counterMetric))
+	}
+	m.counterUpdated[name] = t
+}
+
+func (m *Memory) SetGauges(ctx context.Context, gauges map[string]float64) {
 	for k, v := range gauges {
-		err := m.SetGauge(context.TODO(), k, v)
+		err := m.SetGauge(ctx, k, v)
 		if err != nil {
 			logger.Log.Errorf("ошибка при записи gauge: %s:%d", k, v)
 		}
 	}
 }
 
-func (m *Memory) SetCounters(_ context.Context, counters map[string]int64) {
+func (m *Memory) SetCounters(ctx context.Context, counters map[string]int64) {
 	for k, v := range counters {
-		err := m.SetCounter(context.TODO(), k, v)
+		err := m.SetCounter(ctx, k, v)
 		if err != nil {
 			logger.Log.Errorf("ошибка при записи counter: %s:%d", k, v)
 		}
 	}
 }
 
-func (m *Memory) SetCounter(_ context.Context, name string, val int64) error {
+func (m *Memory) SetCounter(ctx context.Context, name string, val int64) error {
+	m = m.namespace(ctx, true)
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	m.counterMetric[name] += models.Counter(val)
+	m.touchCounter(name, time.Now())
 
 	return nil
 }
 
-func (m *Memory) GetGauge(_ context.Context, name string) (models.Gauge, error) {
+// SetGaugeIfAbsent, сохраняет значение gauge, если метрики еще нет, и возвращает хранимое значение.
+func (m *Memory) SetGaugeIfAbsent(ctx context.Context, name string, val models.Gauge) models.Gauge {
+	m = m.namespace(ctx, true)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if cur, ok := m.gaugeMetrics[name]; ok {
+		return cur
+	}
+	m.gaugeMetrics[name] = val
+	m.touchGauge(name, time.Now())
+
+	return val
+}
+
+// SetCounterIfAbsent, сохраняет значение counter, если метрики еще нет, и возвращает хранимое значение.
+// В отличие от SetCounter значение не прибавляется к имеющемуся.
+func (m *Memory) SetCounterIfAbsent(ctx context.Context, name string, val models.Counter) models.Counter {
+	m = m.namespace(ctx, true)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if cur, ok := m.counterMetric[name]; ok {
+		return cur
+	}
+	m.counterMetric[name] = val
+	m.touchCounter(name, time.Now())
+
+	return val
+}
+
+func (m *Memory) GetGauge(ctx context.Context, name string) (models.Gauge, error) {
+	m = m.namespace(ctx, false)
 	m.mx.Lock()
 	defer m.mx.Unlock()
 
@@ -74,7 +197,8 @@ func (m *Memory) GetGauge(_ context.Context, name string) (models.Gauge, error)
 	return 0, fmt.Errorf("NotFound %s", name)
 }
 
-func (m *Memory) GetCounter(_ context.Context, name string) (models.Counter, error) {
+func (m *Memory) GetCounter(ctx context.Context, name string) (models.Counter, error) {
+	m = m.namespace(ctx, false)
 	m.mx.Lock()
 	defer m.mx.Unlock()
 
@@ -85,17 +209,41 @@ func (m *Memory) GetCounter(_ context.Context, name string) (models.Counter, err
 	return 0, fmt.Errorf("NotFound %s", name)
 }
 
-func (m *Memory) GetGauges(_ context.Context) map[string]models.Gauge {
-	return m.gaugeMetrics
+// GetGauges, возвращает копию значений gauge тенанта из контекста.
+func (m *Memory) GetGauges(ctx context.Context) map[string]models.Gauge {
+	m = m.namespace(ctx, false)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	gauges := make(map[string]models.Gauge, len(m.gaugeMetrics))
+	for k, v := range m.gaugeMetrics {
+		gauges[k] = v
+	}
+
+	return gauges
 }
 
-func (m *Memory) GetCounters(_ context.Context) map[string]models.Counter {
-	return m.counterMetric
+// GetCounters, возвращает копию значений counter тенанта из контекста.
+func (m *Memory) GetCounters(ctx context.Context) map[string]models.Counter {
+	m = m.namespace(ctx, false)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	counters := make(map[string]models.Counter, len(m.counterMetric))
+	for k, v := range m.counterMetric {
+		counters[k] = v
+	}
+
+	return counters
 }
 
-func (m *Memory) ToList(_ context.Context) ([]string, error) {
+func (m *Memory) ToList(ctx context.Context) ([]string, error) {
 	var list []string
 
+	m = m.namespace(ctx, false)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
 	gaugeKeys := make([]string, 0, len(m.gaugeMetrics))
 	counterKeys := make([]string, 0, len(m.counterMetric))
 
@@ -125,16 +273,85 @@ func (m *Memory) ToList(_ context.Context) ([]string, error) {
 	return list, nil
 }
 
+// Items, возвращает метрики тенанта из контекста с временем их обновления:
+// сначала gauge, затем counter, внутри типа - по имени.
+func (m *Memory) Items(ctx context.Context) ([]models.MetricInfo, error) {
+	m = m.namespace(ctx, false)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	gauges := make([]models.MetricInfo, 0, len(m.gaugeMetrics))
+	for k, v := range m.gaugeMetrics {
+		gauges = append(gauges, models.MetricInfo{Name: k, MType: internal.InGaugeName, Value: float64(v), UpdatedAt: m.gaugeUpdated[k]})
+	}
+
+	counters := make([]models.MetricInfo, 0, len(m.counterMetric))
+	for k, v := range m.counterMetric {
+		counters = append(counters, models.MetricInfo{Name: k, MType: internal.InCounterName, Value: float64(v), UpdatedAt: m.counterUpdated[k]})
+	}
+
+	sortByName(gauges)
+	sortByName(counters)
+
+	return append(gauges, counters...), nil
+}
+
+func sortByName(items []models.MetricInfo) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+}
+
 func (m *Memory) Ping(_ context.Context) error {
 	return nil
 }
 
-func (m *Memory) SaveGaugesBatch(_ context.Context, _ map[string]models.Gauge) error {
+func (m *Memory) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	m = m.namespace(ctx, true)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	now := time.Now()
+	for k, v := range gauges {
+		m.gaugeMetrics[k] = v
+		m.touchGauge(k, now)
+	}
+
+	return nil
+}
+func (m *Memory) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	m = m.namespace(ctx, true)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	now := time.Now()
+	for k, v := range counters {
+		m.counterMetric[k] += v
+		m.touchCounter(k, now)
+	}
+
 	return nil
 }
-func (m *Memory) SaveCountersBatch(_ context.Context, _ map[string]models.Counter) error {
+
+// SaveBatch, записывает gauge и counter пакета под одной блокировкой.
+func (m *Memory) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	m = m.namespace(ctx, true)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	now := time.Now()
+	for k, v := range gauges {
+		m.gaugeMetrics[k] = v
+		m.touchGauge(k, now)
+	}
+	for k, v := range counters {
+		m.counterMetric[k] += v
+		m.touchCounter(k, now)
+	}
+
 	return nil
 }
+
 func (m *Memory) Save() error {
 	return nil
 }
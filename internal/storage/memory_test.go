@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -348,3 +349,68 @@ func TestMemory_SetCounters(t *testing.T) {
 		})
 	}
 }
+
+func TestMemory_Tenants(t *testing.T) {
+	m := NewMemory(10)
+	ctx := context.Background()
+	ctxA := tenant.WithTenant(ctx, "team-a")
+
+	assert.NoError(t, m.SetGauge(ctx, "Alloc", 1))
+	assert.NoError(t, m.SetGauge(ctxA, "Alloc", 2))
+	assert.NoError(t, m.SaveCountersBatch(ctxA, map[string]models.Counter{"PollCount": 5}))
+
+	g, err := m.GetGauge(ctx, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Gauge(1), g)
+
+	g, err = m.GetGauge(ctxA, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Gauge(2), g)
+
+	_, err = m.GetCounter(ctx, "PollCount")
+	assert.Error(t, err)
+
+	count, err := m.SeriesCount(ctxA)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// чтение метрик неизвестного тенанта не добавляет его
+	ctxB := tenant.WithTenant(ctx, "team-b")
+	_, err = m.GetGauge(ctxB, "Alloc")
+	assert.Error(t, err)
+	items, err := m.Items(ctxB)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+	count, err = m.SeriesCount(ctxB)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+	assert.Equal(t, []string{tenant.Default, "team-a"}, m.Tenants(ctx))
+}
+
+func TestMemStorage_Items(t *testing.T) {
+	m := NewMemory(10)
+	ctx := context.Background()
+
+	assert.NoError(t, m.SetGauge(ctx, "b", 2.5))
+	assert.NoError(t, m.SaveGaugesBatch(ctx, map[string]models.Gauge{"a": 1}))
+	assert.NoError(t, m.SetCounter(ctx, "c", 3))
+	assert.NoError(t, m.SaveCountersBatch(ctx, map[string]models.Counter{"c": 4}))
+
+	items, err := m.Items(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+
+	// сначала gauge, затем counter, внутри типа - по имени
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.MType+"/"+item.Name)
+		assert.False(t, item.UpdatedAt.IsZero())
+	}
+	assert.Equal(t, []string{"gauge/a", "gauge/b", "counter/c"}, names)
+	assert.Equal(t, float64(7), items[2].Value)
+
+	// метрики другого тенанта не видны
+	other, err := m.Items(tenant.WithTenant(ctx, "team-a"))
+	assert.NoError(t, err)
+	assert.Empty(t, other)
+}
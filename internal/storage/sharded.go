@@ -0,0 +1,460 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+)
+
+// ShardsDef, число сегментов хранилища ShardedMemory по умолчанию.
+const ShardsDef = 32
+
+// ShardedMemory, хранит метрики в памяти, разделенные на сегменты по хешу имени.
+// У каждого сегмента своя блокировка RWMutex: запросы к разным сегментам не ждут друг друга.
+// Значения метрик изменяются атомарно под блокировкой на чтение, блокировка на запись
+// нужна только при появлении новой серии. Метрики каждого тенанта - в своем наборе сегментов.
+type ShardedMemory struct {
+	seed      maphash.Seed
+	shards    int
+	capacity  int // начальная емкость сегмента
+	tenantsMx sync.RWMutex
+	tenants   map[string]shardSet
+}
+
+// shardSet, сегменты метрик одного тенанта.
+type shardSet []*memShard
+
+type memShard struct {
+	mx       sync.RWMutex
+	gauges   map[string]*gaugeCell
+	counters map[string]*counterCell
+}
+
+// gaugeCell, значение gauge (биты float64) и время обновления в наносекундах unix.
+type gaugeCell struct {
+	bits    atomic.Uint64
+	updated atomic.Int64
+}
+
+// counterCell, значение counter и время обновления в наносекундах unix.
+type counterCell struct {
+	value   atomic.Int64
+	updated atomic.Int64
+}
+
+// NewShardedMemory, создает хранилище из shards сегментов (0 - ShardsDef)
+// с начальной емкостью на metricsCount метрик.
+func NewShardedMemory(metricsCount int, shards int) *ShardedMemory {
+	if shards <= 0 {
+		shards = ShardsDef
+	}
+
+	return &ShardedMemory{
+		seed:     maphash.MakeSeed(),
+		shards:   shards,
+		capacity: metricsCount/shards + 1,
+		tenants:  make(map[string]shardSet),
+	}
+}
+
+// namespace, возвращает сегменты тенанта из контекста. create - создать сегменты, если их нет,
+// иначе для неизвестного тенанта возвращается nil.
+func (m *ShardedMemory) namespace(ctx context.Context, create bool) shardSet {
+	name := tenant.FromContext(ctx)
+
+	m.tenantsMx.RLock()
+	ns, ok := m.tenants[name]
+	m.tenantsMx.RUnlock()
+	if ok || !create {
+		return ns
+	}
+
+	m.tenantsMx.Lock()
+	defer m.tenantsMx.Unlock()
+
+	if ns, ok = m.tenants[name]; !ok {
+		ns = make(shardSet, m.shards)
+		for i := range ns {
+			ns[i] = &memShard{
+				gauges:   make(map[string]*gaugeCell, m.capacity),
+				counters: make(map[string]*counterCell),
+			}
+		}
+		m.tenants[name] = ns
+	}
+
+	return ns
+}
+
+// shard, сегмент метрики name.
+func (m *ShardedMemory) shard(ns shardSet, name string) *memShard {
+	return ns[m.shardIndex(ns, name)]
+}
+
+// shardIndex, номер сегмента метрики name.
+func (m *ShardedMemory) shardIndex(ns shardSet, name string) int {
+	return int(maphash.String(m.seed, name) % uint64(len(ns)))
+}
+
+// setGauge, записывает значение gauge. Существующая серия изменяется под блокировкой на чтение.
+func (s *memShard) setGauge(name string, val float64, now int64) {
+	s.mx.RLock()
+	c, ok := s.gauges[name]
+	if ok {
+		c.store(val, now)
+	}
+	s.mx.RUnlock()
+	if ok {
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	// серию мог создать параллельный запрос
+	s.gaugeLocked(name).store(val, now)
+}
+
+// addCounter, увеличивает counter на delta. Существующая серия изменяется под блокировкой на чтение.
+func (s *memShard) addCounter(name string, delta int64, now int64) {
+	s.mx.RLock()
+	c, ok := s.counters[name]
+	if ok {
+		c.add(delta, now)
+	}
+	s.mx.RUnlock()
+	if ok {
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.counterLocked(name).add(delta, now)
+}
+
+// gaugeLocked, возвращает серию gauge, создавая ее при необходимости. Вызывается под s.mx.
+func (s *memShard) gaugeLocked(name string) *gaugeCell {
+	c, ok := s.gauges[name]
+	if !ok {
+		c = &gaugeCell{}
+		s.gauges[name] = c
+	}
+
+	return c
+}
+
+// counterLocked, возвращает серию counter, создавая ее при необходимости. Вызывается под s.mx.
+func (s *memShard) counterLocked(name string) *counterCell {
+	c, ok := s.counters[name]
+	if !ok {
+		c = &counterCell{}
+		s.counters[name] = c
+	}
+
+	return c
+}
+
+func (c *gaugeCell) store(val float64, now int64) {
+	c.bits.Store(math.Float64bits(val))
+	c.updated.Store(now)
+}
+
+func (c *gaugeCell) load() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (c *counterCell) add(delta int64, now int64) {
+	c.value.Add(delta)
+	c.updated.Store(now)
+}
+
+func (m *ShardedMemory) SetGauge(ctx context.Context, name string, val float64) error {
+	ns := m.namespace(ctx, true)
+	m.shard(ns, name).setGauge(name, val, time.Now().UnixNano())
+
+	return nil
+}
+
+func (m *ShardedMemory) SetCounter(ctx context.Context, name string, val int64) error {
+	ns := m.namespace(ctx, true)
+	m.shard(ns, name).addCounter(name, val, time.Now().UnixNano())
+
+	return nil
+}
+
+// SetGaugeIfAbsent, сохраняет значение gauge, если метрики еще нет, и возвращает хранимое значение.
+func (m *ShardedMemory) SetGaugeIfAbsent(ctx context.Context, name string, val models.Gauge) models.Gauge {
+	s := m.shard(m.namespace(ctx, true), name)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if c, ok := s.gauges[name]; ok {
+		return models.Gauge(c.load())
+	}
+	s.gaugeLocked(name).store(float64(val), time.Now().UnixNano())
+
+	return val
+}
+
+// SetCounterIfAbsent, сохраняет значение counter, если метрики еще нет, и возвращает хранимое значение.
+// В отличие от SetCounter значение не прибавляется к имеющемуся.
+func (m *ShardedMemory) SetCounterIfAbsent(ctx context.Context, name string, val models.Counter) models.Counter {
+	s := m.shard(m.namespace(ctx, true), name)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if c, ok := s.counters[name]; ok {
+		return models.Counter(c.value.Load())
+	}
+	s.counterLocked(name).add(int64(val), time.Now().UnixNano())
+
+	return val
+}
+
+func (m *ShardedMemory) SetGauges(ctx context.Context, gauges map[string]float64) {
+	ns := m.namespace(ctx, true)
+	now := time.Now().UnixNano()
+	for k, v := range gauges {
+		m.shard(ns, k).setGauge(k, v, now)
+	}
+}
+
+func (m *ShardedMemory) SetCounters(ctx context.Context, counters map[string]int64) {
+	ns := m.namespace(ctx, true)
+	now := time.Now().UnixNano()
+	for k, v := range counters {
+		m.shard(ns, k).addCounter(k, v, now)
+	}
+}
+
+func (m *ShardedMemory) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	ns := m.namespace(ctx, true)
+	now := time.Now().UnixNano()
+	for k, v := range gauges {
+		m.shard(ns, k).setGauge(k, float64(v), now)
+	}
+
+	return nil
+}
+
+func (m *ShardedMemory) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	ns := m.namespace(ctx, true)
+	now := time.Now().UnixNano()
+	for k, v := range counters {
+		m.shard(ns, k).addCounter(k, int64(v), now)
+	}
+
+	return nil
+}
+
+// SaveBatch, записывает пакет под блокировкой на запись всех затронутых сегментов:
+// чтение всех метрик тенанта (GetGauges, GetCounters, Items, ToList) не видит пакет частично.
+// Сегменты блокируются по возрастанию номера, поэтому параллельные пакеты и чтения
+// не блокируют друг друга взаимно.
+func (m *ShardedMemory) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	ns := m.namespace(ctx, true)
+
+	touched := make([]bool, len(ns))
+	for k := range gauges {
+		touched[m.shardIndex(ns, k)] = true
+	}
+	for k := range counters {
+		touched[m.shardIndex(ns, k)] = true
+	}
+
+	for i, ok := range touched {
+		if ok {
+			ns[i].mx.Lock()
+			defer ns[i].mx.Unlock()
+		}
+	}
+
+	now := time.Now().UnixNano()
+	for k, v := range gauges {
+		m.shard(ns, k).gaugeLocked(k).store(float64(v), now)
+	}
+	for k, v := range counters {
+		m.shard(ns, k).counterLocked(k).add(int64(v), now)
+	}
+
+	return nil
+}
+
+func (m *ShardedMemory) GetGauge(ctx context.Context, name string) (models.Gauge, error) {
+	if ns := m.namespace(ctx, false); ns != nil {
+		s := m.shard(ns, name)
+		s.mx.RLock()
+		c, ok := s.gauges[name]
+		s.mx.RUnlock()
+		if ok {
+			return models.Gauge(c.load()), nil
+		}
+	}
+
+	return 0, fmt.Errorf("NotFound %s", name)
+}
+
+func (m *ShardedMemory) GetCounter(ctx context.Context, name string) (models.Counter, error) {
+	if ns := m.namespace(ctx, false); ns != nil {
+		s := m.shard(ns, name)
+		s.mx.RLock()
+		c, ok := s.counters[name]
+		s.mx.RUnlock()
+		if ok {
+			return models.Counter(c.value.Load()), nil
+		}
+	}
+
+	return 0, fmt.Errorf("NotFound %s", name)
+}
+
+// rlockAll, блокирует на чтение все сегменты тенанта по возрастанию номера и возвращает
+// функцию снятия блокировок. Пока блокировки удерживаются, SaveBatch не выполняется.
+func (ns shardSet) rlockAll() func() {
+	for _, s := range ns {
+		s.mx.RLock()
+	}
+
+	return func() {
+		for _, s := range ns {
+			s.mx.RUnlock()
+		}
+	}
+}
+
+// GetGauges, возвращает копию значений gauge тенанта из контекста.
+func (m *ShardedMemory) GetGauges(ctx context.Context) map[string]models.Gauge {
+	ns := m.namespace(ctx, false)
+	gauges := make(map[string]models.Gauge, m.capacity*len(ns))
+
+	defer ns.rlockAll()()
+	for _, s := range ns {
+		for k, c := range s.gauges {
+			gauges[k] = models.Gauge(c.load())
+		}
+	}
+
+	return gauges
+}
+
+// GetCounters, возвращает копию значений counter тенанта из контекста.
+func (m *ShardedMemory) GetCounters(ctx context.Context) map[string]models.Counter {
+	ns := m.namespace(ctx, false)
+	counters := make(map[string]models.Counter)
+
+	defer ns.rlockAll()()
+	for _, s := range ns {
+		for k, c := range s.counters {
+			counters[k] = models.Counter(c.value.Load())
+		}
+	}
+
+	return counters
+}
+
+// Items, возвращает метрики тенанта из контекста с временем их обновления:
+// сначала gauge, затем counter, внутри типа - по имени.
+func (m *ShardedMemory) Items(ctx context.Context) ([]models.MetricInfo, error) {
+	var gauges, counters []models.MetricInfo
+
+	ns := m.namespace(ctx, false)
+	unlock := ns.rlockAll()
+	for _, s := range ns {
+		for k, c := range s.gauges {
+			gauges = append(gauges, models.MetricInfo{Name: k, MType: internal.InGaugeName, Value: c.load(), UpdatedAt: time.Unix(0, c.updated.Load())})
+		}
+		for k, c := range s.counters {
+			counters = append(counters, models.MetricInfo{Name: k, MType: internal.InCounterName, Value: float64(c.value.Load()), UpdatedAt: time.Unix(0, c.updated.Load())})
+		}
+	}
+	unlock()
+
+	sortByName(gauges)
+	sortByName(counters)
+
+	return append(gauges, counters...), nil
+}
+
+// ToList, возвращает значения метрик тенанта из контекста: сначала gauge, затем counter, по имени.
+// Значения counter берутся без преобразования во float64, которое искажает значения больше 2^53.
+func (m *ShardedMemory) ToList(ctx context.Context) ([]string, error) {
+	ns := m.namespace(ctx, false)
+
+	unlock := ns.rlockAll()
+	gauges := make(map[string]models.Gauge, m.capacity*len(ns))
+	counters := make(map[string]models.Counter)
+	for _, s := range ns {
+		for k, c := range s.gauges {
+			gauges[k] = models.Gauge(c.load())
+		}
+		for k, c := range s.counters {
+			counters[k] = models.Counter(c.value.Load())
+		}
+	}
+	unlock()
+
+	list := make([]string, 0, len(gauges)+len(counters))
+	for _, k := range util.SortedKeys(gauges) {
+		list = append(list, gauges[k].ToString())
+	}
+	for _, k := range util.SortedKeys(counters) {
+		list = append(list, counters[k].ToString())
+	}
+
+	return list, nil
+}
+
+// SeriesCount, возвращает количество серий метрик тенанта из контекста.
+func (m *ShardedMemory) SeriesCount(ctx context.Context) (int, error) {
+	var count int
+
+	for _, s := range m.namespace(ctx, false) {
+		s.mx.RLock()
+		count += len(s.gauges) + len(s.counters)
+		s.mx.RUnlock()
+	}
+
+	return count, nil
+}
+
+// Tenants, возвращает список тенантов, для которых есть метрики (включая тенант по умолчанию).
+func (m *ShardedMemory) Tenants(_ context.Context) []string {
+	m.tenantsMx.RLock()
+	defer m.tenantsMx.RUnlock()
+
+	list := make([]string, 0, len(m.tenants)+1)
+	list = append(list, tenant.Default)
+
+	for name := range m.tenants {
+		if name != tenant.Default {
+			list = append(list, name)
+		}
+	}
+
+	sort.Strings(list[1:])
+
+	return list
+}
+
+func (m *ShardedMemory) Ping(_ context.Context) error {
+	return nil
+}
+
+func (m *ShardedMemory) Save() error {
+	return nil
+}
+
+func (m *ShardedMemory) Restore(_ context.Context) error {
+	return nil
+}
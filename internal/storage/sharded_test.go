@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+func TestShardedMemory_ConcurrentCounters(t *testing.T) {
+	ctx := context.Background()
+	m := NewShardedMemory(0, 8)
+
+	const workers, increments = 16, 1000
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				assert.NoError(t, m.SetCounter(ctx, "PollCount", 1))
+				assert.NoError(t, m.SaveCountersBatch(ctx, map[string]models.Counter{"c" + strconv.Itoa(i%10): 2}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, err := m.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(workers*increments), c, "приращения не теряются")
+
+	var total models.Counter
+	for k, v := range m.GetCounters(ctx) {
+		if k != "PollCount" {
+			total += v
+		}
+	}
+	assert.Equal(t, models.Counter(workers*increments*2), total)
+}
+
+func TestShardedMemory_SnapshotIteration(t *testing.T) {
+	ctx := context.Background()
+	m := NewShardedMemory(0, 4)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, m.SetGauge(ctx, "g"+strconv.Itoa(i), 0))
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			assert.NoError(t, m.SetGauge(ctx, "g"+strconv.Itoa(i%100), float64(i)))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		gauges := m.GetGauges(ctx)
+		// копия не меняется при последующих записях
+		gauges["local"] = 1
+		_, err := m.GetGauge(ctx, "local")
+		require.Error(t, err)
+
+		_, err = m.Items(ctx)
+		require.NoError(t, err)
+	}
+	close(done)
+	wg.Wait()
+
+	count, err := m.SeriesCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 100, count)
+}
+
+func TestShardedMemory_BatchNotHalfVisible(t *testing.T) {
+	ctx := context.Background()
+	m := NewShardedMemory(0, 8)
+
+	const series = 64
+	batch := func(v int) map[string]models.Gauge {
+		gauges := make(map[string]models.Gauge, series)
+		for i := 0; i < series; i++ {
+			gauges["g"+strconv.Itoa(i)] = models.Gauge(v)
+		}
+		return gauges
+	}
+	require.NoError(t, m.SaveBatch(ctx, batch(0), nil))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for v := 1; ; v++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			assert.NoError(t, m.SaveBatch(ctx, batch(v), map[string]models.Counter{"c": 1}))
+		}
+	}()
+
+	// все значения пакета одинаковы: чтение видит пакет целиком или не видит совсем
+	for i := 0; i < 200; i++ {
+		gauges := m.GetGauges(ctx)
+		for k, v := range gauges {
+			require.Equal(t, gauges["g0"], v, k)
+		}
+
+		items, err := m.Items(ctx)
+		require.NoError(t, err)
+		for _, item := range items[:series] {
+			require.Equal(t, items[0].Value, item.Value, item.Name)
+		}
+
+		list, err := m.ToList(ctx)
+		require.NoError(t, err)
+		for _, v := range list[:series] {
+			require.Equal(t, list[0], v)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestShardedMemory_LargeCounter(t *testing.T) {
+	ctx := context.Background()
+	m := NewShardedMemory(0, 4)
+
+	const big = int64(1)<<53 + 1
+	require.NoError(t, m.SetCounter(ctx, "PollCount", big))
+
+	list, err := m.ToList(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{strconv.FormatInt(big, 10)}, list, "значение больше 2^53 не искажается")
+}
+
+func TestShardedMemory_Tenants(t *testing.T) {
+	m := NewShardedMemory(0, 0)
+	ctx := context.Background()
+	teamCtx := tenant.WithTenant(ctx, "team-a")
+
+	require.NoError(t, m.SetGauge(teamCtx, "Alloc", 2))
+	require.NoError(t, m.SetGauge(ctx, "Alloc", 1))
+
+	_, err := m.GetGauge(tenant.WithTenant(ctx, "team-b"), "Alloc")
+	assert.Error(t, err)
+
+	g, err := m.GetGauge(teamCtx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, models.Gauge(2), g)
+	assert.Equal(t, []string{tenant.Default, "team-a"}, m.Tenants(ctx))
+}
+
+// BenchmarkConcurrentBatch, пакетные обновления из параллельных горутин:
+// сравнение хранилища с общей блокировкой и сегментированного хранилища.
+func BenchmarkConcurrentBatch(b *testing.B) {
+	const series, batch = 10000, 100
+
+	names := make([]string, series)
+	for i := range names {
+		names[i] = "metric_" + strconv.Itoa(i)
+	}
+
+	stores := []struct {
+		name  string
+		store func() suiteStorage
+	}{
+		{name: "memory", store: func() suiteStorage { return NewMemory(series) }},
+		{name: "sharded", store: func() suiteStorage { return NewShardedMemory(series, ShardsDef) }},
+	}
+
+	for _, s := range stores {
+		b.Run(s.name+" batch updates", func(b *testing.B) {
+			ms := s.store()
+			ctx := context.Background()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				gauges := make(map[string]models.Gauge, batch)
+				counters := make(map[string]models.Counter, batch)
+				var i int
+				for pb.Next() {
+					clear(gauges)
+					clear(counters)
+					for j := 0; j < batch; j++ {
+						name := names[(i*batch+j)%series]
+						gauges[name] = models.Gauge(j)
+						counters[name] = 1
+					}
+					i++
+
+					if err := ms.SaveGaugesBatch(ctx, gauges); err != nil {
+						b.Fatal(err)
+					}
+					if err := ms.SaveCountersBatch(ctx, counters); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+
+		b.Run(s.name+" mixed reads and writes", func(b *testing.B) {
+			ms := s.store()
+			ctx := context.Background()
+			for _, name := range names {
+				_ = ms.SetGauge(ctx, name, 1)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					name := names[i%series]
+					if i%4 == 0 {
+						_ = ms.SetGauge(ctx, name, float64(i))
+					} else if _, err := ms.GetGauge(ctx, name); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
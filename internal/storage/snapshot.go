@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	pb "github.com/ShvetsovYura/metrics-collector/proto"
+)
+
+// Формат файла снимка метрик: строка заголовка в json, затем тело снимка.
+// Заголовок задает кодирование и сжатие тела, по нему формат определяется при чтении.
+// Файлы без заголовка (записанные до появления формата) читаются как тело в json без проверки.
+const (
+	snapshotFormat  = "metrics-snapshot"
+	snapshotVersion = 2 // версия 1 - тело только в json без сжатия
+)
+
+// SnapshotKeepDef, сколько последних снимков хранить по умолчанию (вместе с текущим).
+const SnapshotKeepDef = 3
+
+// ErrBadSnapshot, снимок поврежден или записан в неизвестном формате.
+var ErrBadSnapshot = errors.New("поврежденный снимок метрик")
+
+// SnapshotEncoding, кодирование тела снимка.
+type SnapshotEncoding string
+
+const (
+	EncodingJSON  SnapshotEncoding = "json"  // models.DumpItem в json, читается человеком
+	EncodingProto SnapshotEncoding = "proto" // pb.Snapshot: компактнее и быстрее для большого числа серий
+)
+
+// SnapshotCompression, сжатие тела снимка.
+type SnapshotCompression string
+
+const (
+	CompressionNone SnapshotCompression = "none"
+	CompressionGzip SnapshotCompression = "gzip"
+)
+
+// SnapshotFormat, кодирование и сжатие снимка. Нулевое значение - json без сжатия.
+type SnapshotFormat struct {
+	Encoding    SnapshotEncoding
+	Compression SnapshotCompression
+}
+
+// ParseSnapshotFormat, разбирает кодирование (json, proto) и сжатие (none, gzip) снимка.
+// Пустые строки - json и без сжатия.
+func ParseSnapshotFormat(encoding string, compression string) (SnapshotFormat, error) {
+	f := SnapshotFormat{Encoding: EncodingJSON, Compression: CompressionNone}
+
+	switch e := SnapshotEncoding(encoding); e {
+	case "":
+	case EncodingJSON, EncodingProto:
+		f.Encoding = e
+	default:
+		return f, fmt.Errorf("неизвестный формат снимка %q, допустимы: json, proto", encoding)
+	}
+
+	switch c := SnapshotCompression(compression); c {
+	case "":
+	case CompressionNone, CompressionGzip:
+		f.Compression = c
+	default:
+		return f, fmt.Errorf("неизвестное сжатие снимка %q, допустимы: none, gzip", compression)
+	}
+
+	return f, nil
+}
+
+// snapshotHeader, заголовок снимка: формат, его версия, кодирование и сжатие,
+// размер и контрольная сумма тела в том виде, в котором оно записано.
+type snapshotHeader struct {
+	Format      string              `json:"format"`
+	Version     int                 `json:"version"`
+	Encoding    SnapshotEncoding    `json:"encoding,omitempty"`
+	Compression SnapshotCompression `json:"compression,omitempty"`
+	Size        int                 `json:"size"`
+	CRC32       uint32              `json:"crc32"`
+}
+
+// encodeSnapshot, снимок метрик с заголовком в формате format.
+func encodeSnapshot(di models.DumpItem, format SnapshotFormat) ([]byte, error) {
+	var (
+		body []byte
+		err  error
+	)
+
+	switch format.Encoding {
+	case EncodingProto:
+		body, err = proto.Marshal(snapshotToProto(di))
+	default:
+		format.Encoding = EncodingJSON
+		body, err = json.MarshalIndent(di, "", "  ")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка кодирования снимка в %s, %w", format.Encoding, err)
+	}
+
+	if format.Compression == CompressionGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, fmt.Errorf("ошибка сжатия снимка, %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("ошибка сжатия снимка, %w", err)
+		}
+		body = buf.Bytes()
+	} else {
+		format.Compression = CompressionNone
+	}
+
+	header, err := json.Marshal(snapshotHeader{
+		Format:      snapshotFormat,
+		Version:     snapshotVersion,
+		Encoding:    format.Encoding,
+		Compression: format.Compression,
+		Size:        len(body),
+		CRC32:       crc32.ChecksumIEEE(body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка преобразования заголовка снимка в json, %w", err)
+	}
+
+	data := make([]byte, 0, len(header)+1+len(body))
+	data = append(data, header...)
+	data = append(data, '\n')
+
+	return append(data, body...), nil
+}
+
+// decodeSnapshot, читает снимок метрик, проверяя размер и контрольную сумму тела.
+// Формат определяется по заголовку. Пустые данные - пустой снимок.
+func decodeSnapshot(data []byte) (models.DumpItem, error) {
+	di, _, err := decodeSnapshotFormat(data)
+	return di, err
+}
+
+// decodeSnapshotFormat, читает снимок метрик и возвращает формат, в котором он записан.
+func decodeSnapshotFormat(data []byte) (models.DumpItem, SnapshotFormat, error) {
+	var di models.DumpItem
+	format := SnapshotFormat{Encoding: EncodingJSON, Compression: CompressionNone}
+	if len(data) == 0 {
+		return di, format, nil
+	}
+
+	body := data
+	line, rest, found := bytes.Cut(data, []byte{'\n'})
+
+	var header snapshotHeader
+	if found && json.Unmarshal(line, &header) == nil && header.Format == snapshotFormat {
+		if header.Version > snapshotVersion {
+			return di, format, fmt.Errorf("%w: неизвестная версия формата %d", ErrBadSnapshot, header.Version)
+		}
+		if len(rest) != header.Size {
+			return di, format, fmt.Errorf("%w: размер %d вместо %d", ErrBadSnapshot, len(rest), header.Size)
+		}
+		if crc32.ChecksumIEEE(rest) != header.CRC32 {
+			return di, format, fmt.Errorf("%w: не совпадает контрольная сумма", ErrBadSnapshot)
+		}
+		body = rest
+
+		if header.Encoding != "" {
+			format.Encoding = header.Encoding
+		}
+		if header.Compression != "" {
+			format.Compression = header.Compression
+		}
+	}
+
+	switch format.Compression {
+	case CompressionNone:
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return di, format, fmt.Errorf("%w: %w", ErrBadSnapshot, err)
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			return di, format, fmt.Errorf("%w: %w", ErrBadSnapshot, err)
+		}
+	default:
+		return di, format, fmt.Errorf("%w: неизвестное сжатие %q", ErrBadSnapshot, format.Compression)
+	}
+
+	switch format.Encoding {
+	case EncodingJSON:
+		if err := json.Unmarshal(body, &di); err != nil {
+			return di, format, fmt.Errorf("%w: %w", ErrBadSnapshot, err)
+		}
+	case EncodingProto:
+		var snap pb.Snapshot
+		if err := proto.Unmarshal(body, &snap); err != nil {
+			return di, format, fmt.Errorf("%w: %w", ErrBadSnapshot, err)
+		}
+		var err error
+		if di, err = snapshotFromProto(&snap); err != nil {
+			return di, format, err
+		}
+	default:
+		return di, format, fmt.Errorf("%w: неизвестный формат %q", ErrBadSnapshot, format.Encoding)
+	}
+
+	return di, format, nil
+}
+
+// snapshotToProto, снимок метрик в двоичном формате.
+func snapshotToProto(di models.DumpItem) *pb.Snapshot {
+	snap := &pb.Snapshot{
+		Tenants: make([]*pb.TenantSnapshot, 0, len(di.Tenants)+1),
+		WalSeq:  di.WALSeq,
+	}
+	snap.Tenants = append(snap.Tenants, tenantToProto(tenant.Default, di))
+
+	names := make([]string, 0, len(di.Tenants))
+	for name := range di.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		snap.Tenants = append(snap.Tenants, tenantToProto(name, di.Tenants[name]))
+	}
+
+	return snap
+}
+
+func tenantToProto(name string, di models.DumpItem) *pb.TenantSnapshot {
+	t := &pb.TenantSnapshot{
+		Tenant:        name,
+		GaugeNames:    make([]string, 0, len(di.Gauges)),
+		GaugeValues:   make([]float64, 0, len(di.Gauges)),
+		CounterNames:  make([]string, 0, len(di.Counters)),
+		CounterValues: make([]int64, 0, len(di.Counters)),
+	}
+
+	for k, v := range di.Gauges {
+		t.GaugeNames = append(t.GaugeNames, k)
+		t.GaugeValues = append(t.GaugeValues, v)
+	}
+	for k, v := range di.Counters {
+		t.CounterNames = append(t.CounterNames, k)
+		t.CounterValues = append(t.CounterValues, v)
+	}
+
+	return t
+}
+
+// snapshotFromProto, снимок метрик из двоичного формата.
+func snapshotFromProto(snap *pb.Snapshot) (models.DumpItem, error) {
+	di := models.DumpItem{
+		Gauges:   map[string]float64{},
+		Counters: map[string]int64{},
+		WALSeq:   snap.GetWalSeq(),
+	}
+
+	for _, t := range snap.GetTenants() {
+		if len(t.GetGaugeNames()) != len(t.GetGaugeValues()) || len(t.GetCounterNames()) != len(t.GetCounterValues()) {
+			return di, fmt.Errorf("%w: число имен и значений метрик тенанта %q не совпадает", ErrBadSnapshot, t.GetTenant())
+		}
+
+		item := models.DumpItem{
+			Gauges:   make(map[string]float64, len(t.GetGaugeNames())),
+			Counters: make(map[string]int64, len(t.GetCounterNames())),
+		}
+		for i, name := range t.GetGaugeNames() {
+			item.Gauges[name] = t.GetGaugeValues()[i]
+		}
+		for i, name := range t.GetCounterNames() {
+			item.Counters[name] = t.GetCounterValues()[i]
+		}
+
+		if t.GetTenant() == tenant.Default {
+			di.Gauges, di.Counters = item.Gauges, item.Counters
+			continue
+		}
+
+		if di.Tenants == nil {
+			di.Tenants = make(map[string]models.DumpItem)
+		}
+		di.Tenants[t.GetTenant()] = item
+	}
+
+	return di, nil
+}
+
+// ConvertSnapshot, перекодирует файл снимка src в формат format и атомарно записывает в dst.
+// Возвращает формат исходного снимка.
+func ConvertSnapshot(src string, dst string, format SnapshotFormat) (SnapshotFormat, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return SnapshotFormat{}, fmt.Errorf("ошибка чтения файла, %w", err)
+	}
+
+	di, from, err := decodeSnapshotFormat(data)
+	if err != nil {
+		return from, err
+	}
+
+	out, err := encodeSnapshot(di, format)
+	if err != nil {
+		return from, err
+	}
+
+	return from, writeSnapshot(dst, out, 1)
+}
+
+// snapshotPaths, пути до снимков от текущего к самому старому: path, path.1, ..., path.<keep-1>.
+func snapshotPaths(path string, keep int) []string {
+	paths := []string{path}
+	for i := 1; i < keep; i++ {
+		paths = append(paths, path+"."+strconv.Itoa(i))
+	}
+
+	return paths
+}
+
+// writeSnapshot, атомарно записывает снимок: во временный файл рядом, сброс на диск,
+// затем переименование в path. Предыдущие снимки сдвигаются (path -> path.1 -> path.2 ...),
+// хранится не больше keep снимков.
+func writeSnapshot(path string, data []byte, keep int) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("ошибка создания временного файла снимка, %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ошибка записи снимка, %w", err)
+	}
+
+	paths := snapshotPaths(path, keep)
+	for i := len(paths) - 1; i > 0; i-- {
+		err := os.Rename(paths[i-1], paths[i])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmpPath)
+			return fmt.Errorf("ошибка ротации снимков, %w", err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ошибка переименования снимка, %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir, сбрасывает на диск каталог, чтобы переименования в нем пережили сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия каталога снимков, %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("ошибка сброса каталога снимков на диск, %w", err)
+	}
+
+	return nil
+}
+
+// readSnapshot, читает самый новый неповрежденный снимок из keep последних.
+// Нет ни одного снимка - пустой снимок, все снимки повреждены - ошибка.
+func readSnapshot(path string, keep int) (models.DumpItem, error) {
+	var lastErr error
+
+	for _, p := range snapshotPaths(path, keep) {
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("ошибка чтения файла, %w", err)
+			logger.Log.Warnf("снимок %s не прочитан, %s", p, err.Error())
+			continue
+		}
+
+		di, err := decodeSnapshot(data)
+		if err != nil {
+			lastErr = err
+			logger.Log.Warnf("снимок %s пропущен, %s", p, err.Error())
+			continue
+		}
+
+		if p != path {
+			logger.Log.Warnf("метрики восстановлены из предыдущего снимка %s", p)
+		}
+
+		return di, nil
+	}
+
+	return models.DumpItem{}, lastErr
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+)
+
+func TestDecodeSnapshot(t *testing.T) {
+	di := models.DumpItem{
+		Gauges:   map[string]float64{"Alloc": 1.5},
+		Counters: map[string]int64{"PollCount": 3},
+		Tenants:  map[string]models.DumpItem{"team-a": {Gauges: map[string]float64{"Alloc": 2}}},
+		WALSeq:   7,
+	}
+	data, err := encodeSnapshot(di, SnapshotFormat{})
+	require.NoError(t, err)
+
+	legacy, err := json.MarshalIndent(models.DumpItem{Gauges: map[string]float64{"Alloc": 1.5}}, "", "  ")
+	require.NoError(t, err)
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-5] ^= 0xff
+
+	newer := []byte(`{"format":"metrics-snapshot","version":99,"size":2,"crc32":0}` + "\n{}")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    models.DumpItem
+		wantErr bool
+	}{
+		{name: "with header", data: data, want: di},
+		{name: "legacy without header", data: legacy, want: models.DumpItem{Gauges: map[string]float64{"Alloc": 1.5}}},
+		{name: "empty", data: nil, want: models.DumpItem{}},
+		{name: "truncated", data: data[:len(data)-10], wantErr: true},
+		{name: "checksum mismatch", data: corrupted, wantErr: true},
+		{name: "unknown version", data: newer, wantErr: true},
+		{name: "truncated legacy", data: legacy[:len(legacy)-3], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSnapshot(tt.data)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadSnapshot)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	write := func(seq uint64) {
+		data, err := encodeSnapshot(models.DumpItem{WALSeq: seq}, SnapshotFormat{})
+		require.NoError(t, err)
+		require.NoError(t, writeSnapshot(path, data, 3))
+	}
+	seqOf := func(p string) uint64 {
+		data, err := os.ReadFile(p)
+		require.NoError(t, err)
+		di, err := decodeSnapshot(data)
+		require.NoError(t, err)
+		return di.WALSeq
+	}
+
+	for seq := uint64(1); seq <= 4; seq++ {
+		write(seq)
+	}
+
+	t.Run("retention", func(t *testing.T) {
+		assert.Equal(t, uint64(4), seqOf(path))
+		assert.Equal(t, uint64(3), seqOf(path+".1"))
+		assert.Equal(t, uint64(2), seqOf(path+".2"))
+		assert.NoFileExists(t, path+".3")
+
+		tmp, err := filepath.Glob(path + ".tmp-*")
+		require.NoError(t, err)
+		assert.Empty(t, tmp, "временные файлы удаляются")
+	})
+
+	t.Run("fallback to previous", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`{"format":"metrics-snapshot","version":1,"size":100,"crc32":1}`+"\n{\"gau"), 0666))
+
+		di, err := readSnapshot(path, 3)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), di.WALSeq)
+
+		// снимок потерян между ротацией и переименованием
+		require.NoError(t, os.Remove(path))
+		di, err = readSnapshot(path, 3)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), di.WALSeq)
+	})
+
+	t.Run("all corrupted", func(t *testing.T) {
+		for _, p := range snapshotPaths(path, 3) {
+			require.NoError(t, os.WriteFile(p, []byte("{"), 0666))
+		}
+
+		_, err := readSnapshot(path, 3)
+		assert.ErrorIs(t, err, ErrBadSnapshot)
+	})
+
+	t.Run("no snapshots", func(t *testing.T) {
+		di, err := readSnapshot(filepath.Join(t.TempDir(), "metrics.json"), 3)
+		require.NoError(t, err)
+		assert.Equal(t, models.DumpItem{}, di)
+	})
+}
+
+func TestFile_RestoreFallback(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	f := NewFile(path, NewMemory(10), false, 0, WithSnapshotKeep(2))
+	require.NoError(t, f.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, f.SetGauge(ctx, "Alloc", 2))
+
+	// сбой при записи текущего снимка в обход атомарной записи
+	require.NoError(t, os.WriteFile(path, []byte(`{"gauges":{"Al`), 0666))
+
+	restored := NewFile(path, NewMemory(10), true, 0, WithSnapshotKeep(2))
+	g, err := restored.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, models.Gauge(1), g)
+}
+
+func TestSnapshotFormats(t *testing.T) {
+	di := models.DumpItem{
+		Gauges:   map[string]float64{"Alloc": 1.5, "HeapSys": 2},
+		Counters: map[string]int64{"PollCount": 3},
+		Tenants: map[string]models.DumpItem{
+			"team-a": {Gauges: map[string]float64{"Alloc": 2}, Counters: map[string]int64{"PollCount": 4}},
+		},
+		WALSeq: 7,
+	}
+
+	for _, encoding := range []string{"json", "proto"} {
+		for _, compression := range []string{"none", "gzip"} {
+			t.Run(encoding+"+"+compression, func(t *testing.T) {
+				format, err := ParseSnapshotFormat(encoding, compression)
+				require.NoError(t, err)
+
+				data, err := encodeSnapshot(di, format)
+				require.NoError(t, err)
+
+				got, gotFormat, err := decodeSnapshotFormat(data)
+				require.NoError(t, err)
+				assert.Equal(t, di, got)
+				assert.Equal(t, format, gotFormat, "формат определяется по заголовку")
+
+				// повреждение тела обнаруживается в любом формате
+				data[len(data)-1] ^= 0xff
+				_, err = decodeSnapshot(data)
+				assert.ErrorIs(t, err, ErrBadSnapshot)
+			})
+		}
+	}
+
+	t.Run("bad format", func(t *testing.T) {
+		_, err := ParseSnapshotFormat("xml", "")
+		assert.Error(t, err)
+		_, err = ParseSnapshotFormat("", "zstd")
+		assert.Error(t, err)
+	})
+}
+
+func TestConvertSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "metrics.json")
+	dst := filepath.Join(dir, "metrics.bin")
+
+	di := models.DumpItem{Gauges: map[string]float64{}, Counters: map[string]int64{}, WALSeq: 3}
+	for i := 0; i < 1000; i++ {
+		di.Gauges[fmt.Sprintf("gauge_%d", i)] = float64(i) / 3
+		di.Counters[fmt.Sprintf("counter_%d", i)] = int64(i)
+	}
+
+	// снимок без заголовка в json, как до появления формата
+	legacy, err := json.MarshalIndent(di, "", "  ")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(src, legacy, 0666))
+
+	from, err := ConvertSnapshot(src, dst, SnapshotFormat{Encoding: EncodingProto, Compression: CompressionGzip})
+	require.NoError(t, err)
+	assert.Equal(t, SnapshotFormat{Encoding: EncodingJSON, Compression: CompressionNone}, from)
+
+	srcInfo, err := os.Stat(src)
+	require.NoError(t, err)
+	dstInfo, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.Less(t, dstInfo.Size(), srcInfo.Size()/4)
+
+	// обратное преобразование на месте
+	from, err = ConvertSnapshot(dst, dst, SnapshotFormat{})
+	require.NoError(t, err)
+	assert.Equal(t, SnapshotFormat{Encoding: EncodingProto, Compression: CompressionGzip}, from)
+
+	got, err := readSnapshot(dst, 1)
+	require.NoError(t, err)
+	assert.Equal(t, di, got)
+
+	t.Run("file storage restores any format", func(t *testing.T) {
+		f := NewFile(dst, NewMemory(10), true, 0, WithSnapshotFormat(SnapshotFormat{Encoding: EncodingProto}))
+		g, err := f.GetGauge(context.Background(), "gauge_3")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(1), g)
+
+		require.NoError(t, f.Save())
+		_, format, err := decodeSnapshotFormat(mustReadFile(t, dst))
+		require.NoError(t, err)
+		assert.Equal(t, EncodingProto, format.Encoding)
+	})
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return data
+}
+
+func BenchmarkEncodeSnapshot(b *testing.B) {
+	di := models.DumpItem{Gauges: make(map[string]float64), Counters: make(map[string]int64)}
+	for i := 0; i < 50000; i++ {
+		di.Gauges["gauge_"+strconv.Itoa(i)] = float64(i) * 1.5
+		di.Counters["counter_"+strconv.Itoa(i)] = int64(i)
+	}
+
+	formats := []SnapshotFormat{
+		{Encoding: EncodingJSON, Compression: CompressionNone},
+		{Encoding: EncodingJSON, Compression: CompressionGzip},
+		{Encoding: EncodingProto, Compression: CompressionNone},
+		{Encoding: EncodingProto, Compression: CompressionGzip},
+	}
+
+	for _, format := range formats {
+		data, err := encodeSnapshot(di, format)
+		require.NoError(b, err)
+		name := fmt.Sprintf("%s+%s", format.Encoding, format.Compression)
+
+		b.Run("encode "+name, func(b *testing.B) {
+			b.ReportMetric(float64(len(data)), "bytes/snapshot")
+			for i := 0; i < b.N; i++ {
+				if _, err := encodeSnapshot(di, format); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("decode "+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeSnapshot(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	_ "github.com/mattn/go-sqlite3" // драйвер sqlite3 для database/sql
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+	"github.com/ShvetsovYura/metrics-collector/internal/util"
+)
+
+// SQLiteScheme, схема DSN встроенной БД SQLite: sqlite:///abs/path.db или sqlite:rel/path.db.
+const SQLiteScheme = "sqlite:"
+
+// sqliteParams, параметры подключения по умолчанию: журнал WAL, ожидание блокировки
+// вместо ошибки SQLITE_BUSY и блокировка на запись в начале транзакции.
+const sqliteParams = "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// ErrSQLiteUnsupported, сервер собран без cgo, а драйвер SQLite (go-sqlite3) без него не работает.
+var ErrSQLiteUnsupported = errors.New("встроенная БД SQLite недоступна: сервер собран без cgo, " +
+	"соберите его с CGO_ENABLED=1 и компилятором C или используйте PostgreSQL")
+
+// IsSQLiteDSN, задает ли DSN встроенную БД SQLite.
+func IsSQLiteDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, SQLiteScheme)
+}
+
+// SQLite, хранит метрики во встроенной БД SQLite (один файл, без отдельного сервера БД).
+// Драйвер go-sqlite3 использует cgo: сервер с поддержкой SQLite собирается с CGO_ENABLED=1,
+// в сборке без cgo NewSQLite возвращает ErrSQLiteUnsupported.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite, открывает (или создает) файл БД SQLite из DSN вида sqlite:path[?параметры] и создает таблицы.
+func NewSQLite(ctx context.Context, dsn string) (*SQLite, error) {
+	if !sqliteSupported {
+		return nil, ErrSQLiteUnsupported
+	}
+
+	path, params, _ := strings.Cut(strings.TrimPrefix(dsn, SQLiteScheme), "?")
+	// sqlite:///abs/path.db - абсолютный путь, как в URL
+	path = strings.TrimPrefix(path, "//")
+	if path == "" {
+		return nil, fmt.Errorf("не задан путь к файлу БД SQLite в DSN %q", dsn)
+	}
+
+	if params == "" {
+		params = sqliteParams
+	} else {
+		params = sqliteParams + "&" + params
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия БД SQLite, %w", err)
+	}
+	// SQLite допускает одного писателя: одно соединение исключает ошибки блокировки
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS gauge
+		(
+			tenant TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL,
+			value REAL NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (tenant, name)
+		);
+		CREATE TABLE IF NOT EXISTS counter
+		(
+			tenant TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL,
+			value INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (tenant, name)
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ошибка создания таблиц в БД, %w", err)
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// Close, закрывает БД.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+func gaugeUpsert() sq.InsertBuilder {
+	return sq.Insert("gauge").Columns("tenant", "name", "value", "updated_at").
+		Suffix("on conflict (tenant, name) do update set value = excluded.value, updated_at = excluded.updated_at")
+}
+
+func counterUpsert() sq.InsertBuilder {
+	return sq.Insert("counter").Columns("tenant", "name", "value", "updated_at").
+		Suffix("on conflict (tenant, name) do update set value = counter.value + excluded.value, updated_at = excluded.updated_at")
+}
+
+func (s *SQLite) SetGauge(ctx context.Context, name string, value float64) error {
+	stmt, args, err := gaugeUpsert().Values(tenant.FromContext(ctx), name, value, time.Now().UnixNano()).ToSql()
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса к БД, %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("ошибка выполнения запроса, %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLite) SetCounter(ctx context.Context, name string, value int64) error {
+	stmt, args, err := counterUpsert().Values(tenant.FromContext(ctx), name, value, time.Now().UnixNano()).ToSql()
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса к БД, %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("ошибка выполнения запроса, %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLite) GetGauge(ctx context.Context, metricName string) (models.Gauge, error) {
+	var value float64
+	if err := s.getValue(ctx, "gauge", metricName, &value); err != nil {
+		return models.Gauge(0), err
+	}
+
+	return models.Gauge(value), nil
+}
+
+func (s *SQLite) GetCounter(ctx context.Context, metricName string) (models.Counter, error) {
+	var value int64
+	if err := s.getValue(ctx, "counter", metricName, &value); err != nil {
+		return models.Counter(0), err
+	}
+
+	return models.Counter(value), nil
+}
+
+// getValue, читает значение метрики тенанта из таблицы table.
+func (s *SQLite) getValue(ctx context.Context, table string, metricName string, dest any) error {
+	stmt, args, err := sq.Select("value").From(table).
+		Where(sq.Eq{"tenant": tenant.FromContext(ctx), "name": metricName}).ToSql()
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса к БД, %w", err)
+	}
+
+	if err := s.db.QueryRowContext(ctx, stmt, args...).Scan(dest); err != nil {
+		return fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return nil
+}
+
+// GetGauges, возвращает значения gauge тенанта из контекста.
+func (s *SQLite) GetGauges(ctx context.Context) (map[string]models.Gauge, error) {
+	gauges := make(map[string]models.Gauge)
+	err := s.scanValues(ctx, "gauge", func(rows *sql.Rows) error {
+		var (
+			name  string
+			value float64
+		)
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		gauges[name] = models.Gauge(value)
+		return nil
+	})
+
+	return gauges, err
+}
+
+// GetCounters, возвращает значения counter тенанта из контекста без преобразования во float64.
+func (s *SQLite) GetCounters(ctx context.Context) (map[string]models.Counter, error) {
+	counters := make(map[string]models.Counter)
+	err := s.scanValues(ctx, "counter", func(rows *sql.Rows) error {
+		var (
+			name  string
+			value int64
+		)
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		counters[name] = models.Counter(value)
+		return nil
+	})
+
+	return counters, err
+}
+
+// scanValues, читает имена и значения метрик тенанта из таблицы table, каждая строка передается в scan.
+func (s *SQLite) scanValues(ctx context.Context, table string, scan func(rows *sql.Rows) error) error {
+	stmt, args, err := sq.Select("name", "value").From(table).
+		Where(sq.Eq{"tenant": tenant.FromContext(ctx)}).ToSql()
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса к БД, %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return fmt.Errorf("ошибка получения данных из БД, %w", err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return nil
+}
+
+// ToList, возвращает значения метрик тенанта из контекста: сначала gauge, затем counter, по имени.
+func (s *SQLite) ToList(ctx context.Context) ([]string, error) {
+	gauges, err := s.GetGauges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := s.GetCounters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]string, 0, len(gauges)+len(counters))
+	for _, k := range util.SortedKeys(gauges) {
+		list = append(list, gauges[k].ToString())
+	}
+	for _, k := range util.SortedKeys(counters) {
+		list = append(list, counters[k].ToString())
+	}
+
+	return list, nil
+}
+
+// Items, возвращает метрики тенанта из контекста с временем их обновления:
+// сначала gauge, затем counter, внутри типа - по имени.
+func (s *SQLite) Items(ctx context.Context) ([]models.MetricInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		select 'gauge', name, value, updated_at from gauge where tenant = ?1
+		union all
+		select 'counter', name, cast(value as real), updated_at from counter where tenant = ?1
+		order by 1 desc, 2
+	`, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.MetricInfo
+	for rows.Next() {
+		var (
+			item      models.MetricInfo
+			updatedAt int64
+		)
+		if err := rows.Scan(&item.MType, &item.Name, &item.Value, &updatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+		}
+		item.UpdatedAt = time.Unix(0, updatedAt)
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return items, nil
+}
+
+// Tenants, возвращает тенантов, для которых есть метрики, по имени.
+func (s *SQLite) Tenants(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "select tenant from gauge union select tenant from counter order by 1")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+		}
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return names, nil
+}
+
+// SeriesCount, возвращает количество серий метрик тенанта из контекста.
+func (s *SQLite) SeriesCount(ctx context.Context) (int, error) {
+	var count int
+
+	err := s.db.QueryRowContext(ctx, `
+		select (select count(*) from gauge where tenant = ?1) + (select count(*) from counter where tenant = ?1)
+	`, tenant.FromContext(ctx)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения данных из БД, %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *SQLite) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ошибка опроса БД (ping), %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLite) SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error {
+	return s.SaveBatch(ctx, gauges, nil)
+}
+
+func (s *SQLite) SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error {
+	return s.SaveBatch(ctx, nil, counters)
+}
+
+// sqliteBatchRows, строк в одном запросе многострочной вставки: число параметров запроса в SQLite ограничено.
+const sqliteBatchRows = 1000
+
+// SaveBatch, записывает gauge и counter пакета в одной транзакции
+// многострочными вставками по sqliteBatchRows строк.
+func (s *SQLite) SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error {
+	if len(gauges) == 0 && len(counters) == 0 {
+		return nil
+	}
+
+	name := tenant.FromContext(ctx)
+	now := time.Now().UnixNano()
+
+	gaugeRows := make([][]any, 0, len(gauges))
+	for k, v := range gauges {
+		gaugeRows = append(gaugeRows, []any{name, k, *v.GetRawValue(), now})
+	}
+	counterRows := make([][]any, 0, len(counters))
+	for k, v := range counters {
+		counterRows = append(counterRows, []any{name, k, *v.GetRawValue(), now})
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции, %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logger.Log.Errorf("ошибка отката транзакции, %s", err.Error())
+		}
+	}()
+
+	if err := execUpserts(ctx, tx, gaugeUpsert, gaugeRows); err != nil {
+		return err
+	}
+	if err := execUpserts(ctx, tx, counterUpsert, counterRows); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации транзакции, %w", err)
+	}
+
+	return nil
+}
+
+// execUpserts, выполняет многострочные вставки rows в транзакции порциями по sqliteBatchRows строк.
+func execUpserts(ctx context.Context, tx *sql.Tx, upsert func() sq.InsertBuilder, rows [][]any) error {
+	for len(rows) > 0 {
+		n := min(len(rows), sqliteBatchRows)
+
+		insert := upsert()
+		for _, row := range rows[:n] {
+			insert = insert.Values(row...)
+		}
+		rows = rows[n:]
+
+		stmt, args, err := insert.ToSql()
+		if err != nil {
+			return fmt.Errorf("ошибка создания запроса к БД, %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return fmt.Errorf("ошибка выполнения запроса, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Save, переносит журнал WAL в основной файл БД: данные уже сохранены при записи.
+func (s *SQLite) Save() error {
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("ошибка сохранения журнала БД, %w", err)
+	}
+
+	logger.Log.Info("журнал БД SQLite перенесен в основной файл")
+
+	return nil
+}
+
+// Restore, ничего не делает: метрики читаются из файла БД при каждом запросе.
+func (s *SQLite) Restore(_ context.Context) error {
+	return nil
+}
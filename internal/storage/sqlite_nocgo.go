@@ -0,0 +1,6 @@
+//go:build !cgo
+
+package storage
+
+// sqliteSupported, доступна ли встроенная БД SQLite: драйвер go-sqlite3 работает только с cgo.
+const sqliteSupported = false
@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSQLite_NoCGO(t *testing.T) {
+	_, err := NewSQLite(context.Background(), SQLiteScheme+filepath.Join(t.TempDir(), "metrics.db"))
+	assert.ErrorIs(t, err, ErrSQLiteUnsupported)
+}
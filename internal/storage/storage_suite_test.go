@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// suiteStorage, методы хранилища, общие для всех реализаций.
+type suiteStorage interface {
+	SetGauge(ctx context.Context, name string, val float64) error
+	SetCounter(ctx context.Context, name string, val int64) error
+	GetGauge(ctx context.Context, name string) (models.Gauge, error)
+	GetCounter(ctx context.Context, name string) (models.Counter, error)
+	SaveGaugesBatch(ctx context.Context, gauges map[string]models.Gauge) error
+	SaveCountersBatch(ctx context.Context, counters map[string]models.Counter) error
+	SaveBatch(ctx context.Context, gauges map[string]models.Gauge, counters map[string]models.Counter) error
+	ToList(ctx context.Context) ([]string, error)
+	Items(ctx context.Context) ([]models.MetricInfo, error)
+	SeriesCount(ctx context.Context) (int, error)
+	Save() error
+	Restore(ctx context.Context) error
+}
+
+// suiteBackend, реализация хранилища для общего набора тестов. reopen - открыть
+// хранилище заново после Save (проверка сохранности данных), nil - данные не сохраняются.
+type suiteBackend struct {
+	name   string
+	open   func(t *testing.T) suiteStorage
+	reopen func(t *testing.T) suiteStorage
+}
+
+func suiteBackends(t *testing.T) []suiteBackend {
+	backends := []suiteBackend{
+		{
+			name: "memory",
+			open: func(t *testing.T) suiteStorage { return NewMemory(10) },
+		},
+		{
+			name: "sharded",
+			open: func(t *testing.T) suiteStorage { return NewShardedMemory(10, 4) },
+		},
+	}
+
+	filePath := func(t *testing.T) string { return filepath.Join(t.TempDir(), "metrics.json") }
+	var path string
+	backends = append(backends, suiteBackend{
+		name: "file",
+		open: func(t *testing.T) suiteStorage {
+			path = filePath(t)
+			return NewFile(path, NewMemory(10), false, 0)
+		},
+		// метрики восстанавливаются вызовом Restore в тесте
+		reopen: func(t *testing.T) suiteStorage { return NewFile(path, NewMemory(10), false, 0) },
+	})
+
+	var shardedFile string
+	backends = append(backends, suiteBackend{
+		name: "file-sharded",
+		open: func(t *testing.T) suiteStorage {
+			shardedFile = filePath(t)
+			return NewFile(shardedFile, NewShardedMemory(10, 4), false, 0)
+		},
+		reopen: func(t *testing.T) suiteStorage { return NewFile(shardedFile, NewShardedMemory(10, 4), false, 0) },
+	})
+
+	var walFile string
+	backends = append(backends, suiteBackend{
+		name: "file-wal",
+		open: func(t *testing.T) suiteStorage {
+			walFile = filePath(t)
+			return openWALFile(t, walFile)
+		},
+		reopen: func(t *testing.T) suiteStorage { return openWALFile(t, walFile) },
+	})
+
+	var dsn string
+	backends = append(backends, suiteBackend{
+		name: "sqlite",
+		open: func(t *testing.T) suiteStorage {
+			dsn = SQLiteScheme + filepath.Join(t.TempDir(), "metrics.db")
+			return openSQLite(t, dsn)
+		},
+		reopen: func(t *testing.T) suiteStorage { return openSQLite(t, dsn) },
+	})
+
+	var cacheDSN string
+	backends = append(backends, suiteBackend{
+		name: "cached-sqlite",
+		open: func(t *testing.T) suiteStorage {
+			cacheDSN = SQLiteScheme + filepath.Join(t.TempDir(), "metrics.db")
+			return openCached(t, openSQLite(t, cacheDSN))
+		},
+		reopen: func(t *testing.T) suiteStorage { return openCached(t, openSQLite(t, cacheDSN)) },
+	})
+
+	// PostgreSQL проверяется, если задана тестовая БД: таблицы очищаются перед каждым тестом
+	if pgDSN := os.Getenv("TEST_DATABASE_DSN"); pgDSN != "" {
+		openDB := func(t *testing.T) *DB {
+			db, err := NewDBPool(context.Background(), pgDSN)
+			require.NoError(t, err)
+			t.Cleanup(db.pool.Close)
+			return db
+		}
+		backends = append(backends, suiteBackend{
+			name: "postgres",
+			open: func(t *testing.T) suiteStorage {
+				db := openDB(t)
+				_, err := db.pool.Exec(context.Background(), "TRUNCATE gauge, counter")
+				require.NoError(t, err)
+				return db
+			},
+			reopen: func(t *testing.T) suiteStorage { return openDB(t) },
+		})
+	}
+
+	return backends
+}
+
+func openWALFile(t *testing.T, path string) *File {
+	f := NewFile(path, NewMemory(10), false, 0, WithWAL(WALOptions{Fsync: FsyncNever}))
+	t.Cleanup(func() { f.Close() })
+
+	return f
+}
+
+func openCached(t *testing.T, db CacheBackend) *Cached {
+	c, err := NewCached(context.Background(), NewShardedMemory(10, 4), db, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func openSQLite(t *testing.T, dsn string) *SQLite {
+	if !sqliteSupported {
+		t.Skip(ErrSQLiteUnsupported.Error())
+	}
+
+	s, err := NewSQLite(context.Background(), dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// TestStorageSuite, общий набор тестов для всех реализаций хранилища.
+func TestStorageSuite(t *testing.T) {
+	for _, b := range suiteBackends(t) {
+		t.Run(b.name, func(t *testing.T) {
+			testStorage(t, b)
+		})
+	}
+}
+
+func testStorage(t *testing.T, b suiteBackend) {
+	ctx := context.Background()
+
+	t.Run("gauge", func(t *testing.T) {
+		s := b.open(t)
+		require.NoError(t, s.SetGauge(ctx, "Alloc", 1.5))
+		require.NoError(t, s.SetGauge(ctx, "Alloc", 2.5))
+
+		got, err := s.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(2.5), got)
+
+		_, err = s.GetGauge(ctx, "Unknown")
+		assert.Error(t, err)
+		_, err = s.GetCounter(ctx, "Alloc")
+		assert.Error(t, err, "gauge и counter - разные серии")
+	})
+
+	t.Run("counter", func(t *testing.T) {
+		s := b.open(t)
+		require.NoError(t, s.SetCounter(ctx, "PollCount", 2))
+		require.NoError(t, s.SetCounter(ctx, "PollCount", 3))
+
+		got, err := s.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(5), got)
+
+		_, err = s.GetCounter(ctx, "Unknown")
+		assert.Error(t, err)
+	})
+
+	t.Run("batches", func(t *testing.T) {
+		s := b.open(t)
+		require.NoError(t, s.SetCounter(ctx, "PollCount", 1))
+		require.NoError(t, s.SaveGaugesBatch(ctx, map[string]models.Gauge{"Alloc": 1, "HeapSys": 2}))
+		require.NoError(t, s.SaveGaugesBatch(ctx, map[string]models.Gauge{"Alloc": 3}))
+		require.NoError(t, s.SaveCountersBatch(ctx, map[string]models.Counter{"PollCount": 4, "Requests": 7}))
+		require.NoError(t, s.SaveGaugesBatch(ctx, map[string]models.Gauge{}))
+
+		alloc, err := s.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(3), alloc)
+
+		poll, err := s.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(5), poll)
+
+		count, err := s.SeriesCount(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 4, count)
+	})
+
+	t.Run("save batch", func(t *testing.T) {
+		s := b.open(t)
+		require.NoError(t, s.SetCounter(ctx, "PollCount", 1))
+		require.NoError(t, s.SaveBatch(ctx,
+			map[string]models.Gauge{"Alloc": 1, "HeapSys": 2},
+			map[string]models.Counter{"PollCount": 4, "Requests": 7}))
+		require.NoError(t, s.SaveBatch(ctx, nil, nil))
+
+		alloc, err := s.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(1), alloc)
+
+		poll, err := s.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(5), poll)
+
+		// большой пакет записывается порциями (SQLite) или через COPY (PostgreSQL)
+		gauges := make(map[string]models.Gauge, 2500)
+		counters := make(map[string]models.Counter, 2500)
+		for i := 0; i < 2500; i++ {
+			gauges[fmt.Sprintf("gauge_%d", i)] = models.Gauge(i)
+			counters[fmt.Sprintf("counter_%d", i)] = models.Counter(i)
+		}
+		counters["PollCount"] = 10
+		require.NoError(t, s.SaveBatch(ctx, gauges, counters))
+
+		count, err := s.SeriesCount(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 5004, count)
+
+		poll, err = s.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(15), poll)
+
+		g, err := s.GetGauge(ctx, "gauge_2499")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(2499), g)
+	})
+
+	t.Run("items", func(t *testing.T) {
+		s := b.open(t)
+		require.NoError(t, s.SetCounter(ctx, "PollCount", 7))
+		require.NoError(t, s.SetGauge(ctx, "HeapSys", 2))
+		require.NoError(t, s.SetGauge(ctx, "Alloc", 1.25))
+
+		items, err := s.Items(ctx)
+		require.NoError(t, err)
+		require.Len(t, items, 3)
+
+		// сначала gauge, затем counter, внутри типа - по имени
+		assert.Equal(t, "Alloc", items[0].Name)
+		assert.Equal(t, "gauge", items[0].MType)
+		assert.Equal(t, 1.25, items[0].Value)
+		assert.Equal(t, "HeapSys", items[1].Name)
+		assert.Equal(t, "PollCount", items[2].Name)
+		assert.Equal(t, "counter", items[2].MType)
+		assert.Equal(t, 7.0, items[2].Value)
+		for _, item := range items {
+			assert.False(t, item.UpdatedAt.IsZero(), item.Name)
+		}
+
+		list, err := s.ToList(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"1.25", "2", "7"}, list)
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		s := b.open(t)
+		teamA := tenant.WithTenant(ctx, "team-a")
+		require.NoError(t, s.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, s.SetGauge(teamA, "Alloc", 2))
+		require.NoError(t, s.SaveCountersBatch(teamA, map[string]models.Counter{"PollCount": 3}))
+
+		got, err := s.GetGauge(teamA, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(2), got)
+
+		got, err = s.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(1), got)
+
+		_, err = s.GetCounter(ctx, "PollCount")
+		assert.Error(t, err)
+
+		count, err := s.SeriesCount(teamA)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("save and restore", func(t *testing.T) {
+		if b.reopen == nil {
+			t.Skip("хранилище не сохраняет данные")
+		}
+
+		s := b.open(t)
+		require.NoError(t, s.SetGauge(ctx, "Alloc", 1.5))
+		require.NoError(t, s.SetCounter(tenant.WithTenant(ctx, "team-a"), "PollCount", 3))
+		require.NoError(t, s.Save())
+
+		restored := b.reopen(t)
+		require.NoError(t, restored.Restore(ctx))
+
+		g, err := restored.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(1.5), g)
+
+		c, err := restored.GetCounter(tenant.WithTenant(ctx, "team-a"), "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(3), c)
+	})
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/logger"
+)
+
+// FsyncPolicy, когда записи журнала WAL сбрасываются на диск.
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"   // после каждой записи: обновление не теряется при сбое
+	FsyncInterval FsyncPolicy = "interval" // раз в WALOptions.FsyncEvery: при сбое теряются обновления за интервал
+	FsyncNever    FsyncPolicy = "never"    // сброс на диск остается на усмотрение ОС
+)
+
+// Значения по умолчанию журнала WAL.
+const (
+	FsyncEveryDef  = time.Second
+	CompactSizeDef = 16 << 20
+)
+
+// ParseFsyncPolicy, разбирает политику сброса журнала на диск. Пустая строка - FsyncInterval.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch p := FsyncPolicy(s); p {
+	case "":
+		return FsyncInterval, nil
+	case FsyncAlways, FsyncInterval, FsyncNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("неизвестная политика сброса журнала на диск %q, допустимы: always, interval, never", s)
+	}
+}
+
+// WALOptions, настройки журнала WAL файлового хранилища.
+type WALOptions struct {
+	Fsync       FsyncPolicy   // политика сброса записей на диск
+	FsyncEvery  time.Duration // интервал сброса для FsyncInterval, 0 - FsyncEveryDef
+	CompactSize int64         // размер журнала в байтах, после которого он сворачивается в снимок, 0 - CompactSizeDef
+}
+
+// walRecord, запись журнала: изменения метрик одного тенанта, применяемые целиком.
+// Gauges - новые значения, Counters - приращения.
+type walRecord struct {
+	Seq      uint64             `json:"seq"`
+	Tenant   string             `json:"tenant,omitempty"`
+	Gauges   map[string]float64 `json:"gauges,omitempty"`
+	Counters map[string]int64   `json:"counters,omitempty"`
+}
+
+// walFile, файл журнала.
+type walFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// wal, журнал обновлений файлового хранилища: одна запись json на строку, только дозапись.
+type wal struct {
+	mx     sync.Mutex
+	f      walFile
+	path   string
+	seq    uint64
+	size   int64
+	dirty  bool  // есть записи, не сброшенные на диск
+	broken error // недописанную запись не удалось отрезать: запись в журнал невозможна до очистки
+
+	opts WALOptions
+	stop chan struct{}
+	done chan struct{}
+}
+
+// walPath, путь до журнала WAL файла снимка метрик.
+func walPath(snapshotPath string) string {
+	return snapshotPath + ".wal"
+}
+
+// openWAL, открывает (или создает) журнал для дозаписи. Недописанная при сбое
+// последняя запись отбрасывается. Номера новых записей продолжают номера журнала и не меньше minSeq.
+func openWAL(path string, opts WALOptions, minSeq uint64) (*wal, error) {
+	if opts.FsyncEvery <= 0 {
+		opts.FsyncEvery = FsyncEveryDef
+	}
+	if opts.CompactSize <= 0 {
+		opts.CompactSize = CompactSizeDef
+	}
+
+	lastSeq, size, err := replayWAL(path, 0, func(walRecord) {})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия журнала, %w", err)
+	}
+	// отбрасывание недописанного хвоста, дальше - дозапись
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("ошибка восстановления журнала, %w", err)
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("ошибка восстановления журнала, %w", err)
+	}
+
+	w := &wal{
+		f:    f,
+		path: path,
+		seq:  max(lastSeq, minSeq),
+		size: size,
+		opts: opts,
+	}
+
+	if opts.Fsync == FsyncInterval {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop()
+	}
+
+	return w, nil
+}
+
+// replayWAL, читает записи журнала с номерами больше afterSeq и передает их в apply.
+// Возвращает номер последней записи и размер журнала без недописанного хвоста.
+func replayWAL(path string, afterSeq uint64, apply func(walRecord)) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка открытия журнала, %w", err)
+	}
+	defer f.Close()
+
+	var (
+		lastSeq uint64
+		size    int64
+	)
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				logger.Log.Warnf("в журнале %s отброшена недописанная запись", path)
+			}
+			return lastSeq, size, nil
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("ошибка чтения журнала, %w", err)
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			// запись повреждена при сбое: последующие записи не применяются
+			logger.Log.Warnf("в журнале %s отброшены записи после поврежденной, %s", path, err.Error())
+			return lastSeq, size, nil
+		}
+
+		size += int64(len(line))
+		lastSeq = rec.Seq
+		if rec.Seq > afterSeq {
+			apply(rec)
+		}
+	}
+}
+
+// append, дописывает запись в журнал, назначая ей следующий номер.
+func (w *wal) append(rec walRecord) error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	if w.broken != nil {
+		return fmt.Errorf("журнал поврежден до сворачивания в снимок, %w", w.broken)
+	}
+
+	rec.Seq = w.seq + 1
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("ошибка преобразования записи журнала в json, %w", err)
+	}
+
+	if _, err := w.f.Write(append(data, '\n')); err != nil {
+		// недописанная запись отрезается: иначе при восстановлении чтение журнала
+		// остановилось бы на ней и все последующие записи были бы потеряны
+		w.rollback()
+		return fmt.Errorf("ошибка записи в журнал, %w", err)
+	}
+	w.size += int64(len(data)) + 1
+	w.seq = rec.Seq
+
+	if w.opts.Fsync == FsyncAlways {
+		if err := w.f.Sync(); err != nil {
+			return fmt.Errorf("ошибка сброса журнала на диск, %w", err)
+		}
+		return nil
+	}
+	w.dirty = true
+
+	return nil
+}
+
+// rollback, отрезает недописанную запись после ошибки записи. Если это не удалось,
+// журнал помечается поврежденным. Вызывается под w.mx.
+func (w *wal) rollback() {
+	if err := w.f.Truncate(w.size); err != nil {
+		w.broken = err
+		return
+	}
+	if _, err := w.f.Seek(w.size, io.SeekStart); err != nil {
+		w.broken = err
+	}
+}
+
+// lastSeq, номер последней записи журнала.
+func (w *wal) lastSeq() uint64 {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	return w.seq
+}
+
+// needCompact, превысил ли журнал размер, после которого он сворачивается в снимок, или поврежден.
+func (w *wal) needCompact() bool {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	return w.broken != nil || w.size >= w.opts.CompactSize
+}
+
+// reset, очищает журнал после записи снимка. Номера записей продолжаются.
+func (w *wal) reset() error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	if err := w.f.Truncate(0); err != nil {
+		return fmt.Errorf("ошибка очистки журнала, %w", err)
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("ошибка очистки журнала, %w", err)
+	}
+	w.size = 0
+	w.broken = nil
+
+	return w.syncLocked()
+}
+
+// syncLocked, сбрасывает журнал на диск. Вызывается под w.mx.
+func (w *wal) syncLocked() error {
+	if w.opts.Fsync == FsyncNever {
+		w.dirty = false
+		return nil
+	}
+
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("ошибка сброса журнала на диск, %w", err)
+	}
+	w.dirty = false
+
+	return nil
+}
+
+// syncLoop, периодически сбрасывает журнал на диск для FsyncInterval.
+func (w *wal) syncLoop() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.FsyncEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mx.Lock()
+			if w.dirty {
+				if err := w.syncLocked(); err != nil {
+					logger.Log.Errorf("%s", err.Error())
+				}
+			}
+			w.mx.Unlock()
+		}
+	}
+}
+
+// close, сбрасывает журнал на диск и закрывает его.
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("ошибка сброса журнала на диск, %w", err)
+	}
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("ошибка закрытия журнала, %w", err)
+	}
+
+	return nil
+}
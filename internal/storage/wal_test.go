@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ShvetsovYura/metrics-collector/internal/models"
+	"github.com/ShvetsovYura/metrics-collector/internal/tenant"
+)
+
+// shortWriteFile, файл журнала, очередная запись в который обрывается на половине.
+type shortWriteFile struct {
+	walFile
+	fail bool
+}
+
+func (f *shortWriteFile) Write(p []byte) (int, error) {
+	if f.fail {
+		f.fail = false
+		n, _ := f.walFile.Write(p[:len(p)/2])
+		return n, io.ErrShortWrite
+	}
+
+	return f.walFile.Write(p)
+}
+
+func newWALFile(t *testing.T, path string, restore bool, opts WALOptions) *File {
+	f := NewFile(path, NewMemory(10), restore, 0, WithWAL(opts))
+	require.NotNil(t, f.wal, "журнал не открыт")
+	t.Cleanup(func() { f.Close() })
+
+	return f
+}
+
+func TestFile_WALRecovery(t *testing.T) {
+	ctx := context.Background()
+	teamA := tenant.WithTenant(ctx, "team-a")
+	opts := WALOptions{Fsync: FsyncAlways}
+
+	assertMetrics := func(t *testing.T, f *File, alloc float64, poll int64) {
+		g, err := f.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, models.Gauge(alloc), g)
+
+		c, err := f.GetCounter(teamA, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, models.Counter(poll), c)
+	}
+
+	t.Run("replay without snapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		f := newWALFile(t, path, false, opts)
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 2))
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 3))
+		require.NoError(t, f.SaveCountersBatch(teamA, map[string]models.Counter{"PollCount": 4}))
+
+		// снимок не записывался: при записи изменений файл снимка не переписывается
+		_, err := os.Stat(path)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		assertMetrics(t, newWALFile(t, path, true, opts), 2, 7)
+	})
+
+	t.Run("snapshot and tail", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		f := newWALFile(t, path, false, opts)
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 3))
+		require.NoError(t, f.Save())
+
+		info, err := os.Stat(walPath(path))
+		require.NoError(t, err)
+		assert.Zero(t, info.Size(), "после снимка журнал очищается")
+
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 5))
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 2))
+
+		restored := newWALFile(t, path, true, opts)
+		assertMetrics(t, restored, 5, 5)
+
+		// номера записей продолжаются после перезапуска
+		require.NoError(t, restored.SetCounter(teamA, "PollCount", 1))
+		assertMetrics(t, newWALFile(t, path, true, opts), 5, 6)
+	})
+
+	t.Run("crash after snapshot before wal reset", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		f := newWALFile(t, path, false, opts)
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 3))
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 1))
+
+		// снимок записан, журнал очистить не успели
+		di := f.snapshot(ctx)
+		di.WALSeq = f.wal.lastSeq()
+		require.NoError(t, f.dumpItem(di))
+
+		assertMetrics(t, newWALFile(t, path, true, opts), 1, 3)
+	})
+
+	t.Run("torn tail", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		f := newWALFile(t, path, false, opts)
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 3))
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, f.Close())
+
+		wf, err := os.OpenFile(walPath(path), os.O_APPEND|os.O_WRONLY, 0666)
+		require.NoError(t, err)
+		_, err = wf.WriteString(`{"seq":3,"gauges":{"Al`)
+		require.NoError(t, err)
+		require.NoError(t, wf.Close())
+
+		restored := newWALFile(t, path, true, opts)
+		assertMetrics(t, restored, 1, 3)
+
+		// недописанная запись отброшена, новые записи читаются
+		require.NoError(t, restored.SetGauge(ctx, "Alloc", 7))
+		assertMetrics(t, newWALFile(t, path, true, opts), 7, 3)
+	})
+
+	t.Run("short write", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		f := newWALFile(t, path, false, opts)
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 3))
+
+		sf := &shortWriteFile{walFile: f.wal.f, fail: true}
+		f.wal.f = sf
+		assert.True(t, errors.Is(f.SetGauge(ctx, "Alloc", 9), io.ErrShortWrite))
+
+		// недописанная запись отрезана: последующие записи восстанавливаются
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 2))
+		assertMetrics(t, newWALFile(t, path, true, opts), 1, 5)
+	})
+
+	t.Run("compaction by size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		f := newWALFile(t, path, false, WALOptions{Fsync: FsyncNever, CompactSize: 1})
+		require.NoError(t, f.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, f.SetCounter(teamA, "PollCount", 3))
+
+		info, err := os.Stat(walPath(path))
+		require.NoError(t, err)
+		assert.Zero(t, info.Size())
+
+		// журнал пуст, метрики восстанавливаются из снимка
+		assertMetrics(t, NewFile(path, NewMemory(10), true, 0), 1, 3)
+	})
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    FsyncPolicy
+		wantErr bool
+	}{
+		{in: "", want: FsyncInterval},
+		{in: "always", want: FsyncAlways},
+		{in: "interval", want: FsyncInterval},
+		{in: "never", want: FsyncNever},
+		{in: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFsyncPolicy(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
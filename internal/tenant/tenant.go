@@ -0,0 +1,61 @@
+// Пространства имен (тенанты) метрик: несколько команд могут писать
+// метрики с одинаковыми именами в один сервер, не пересекаясь друг с другом.
+// Тенант запроса определяется по API-ключу или заголовку и передается
+// в хранилище через контекст.
+
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+const (
+	Default string = ""            // тенант по умолчанию (без пространства имен)
+	Header  string = "X-Tenant-ID" // заголовок (и ключ метаданных gRPC) с именем тенанта
+)
+
+var (
+	// ErrQuotaExceeded, превышена квота тенанта на количество серий метрик.
+	ErrQuotaExceeded = errors.New("превышена квота тенанта на количество метрик")
+	// ErrInvalidName, недопустимое имя тенанта.
+	ErrInvalidName = errors.New("недопустимое имя тенанта")
+)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+type ctxKey struct{}
+
+// WithTenant, возвращает контекст с указанным тенантом.
+func WithTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, name)
+}
+
+// FromContext, возвращает тенант из контекста или тенант по умолчанию.
+func FromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(ctxKey{}).(string); ok {
+		return name
+	}
+
+	return Default
+}
+
+// Resolve, определяет тенант по API-ключу (если ключ известен) или по явно указанному имени.
+func Resolve(keys map[string]string, apiKey string, name string) (string, error) {
+	if apiKey != "" {
+		if t, ok := keys[apiKey]; ok {
+			return t, nil
+		}
+	}
+
+	if name == "" {
+		return Default, nil
+	}
+
+	if !nameRe.MatchString(name) {
+		return "", ErrInvalidName
+	}
+
+	return name, nil
+}
@@ -0,0 +1,39 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	keys := map[string]string{"secret-a": "team-a"}
+
+	tests := []struct {
+		name    string
+		apiKey  string
+		header  string
+		want    string
+		wantErr error
+	}{
+		{name: "by api key", apiKey: "secret-a", header: "team-b", want: "team-a"},
+		{name: "unknown key falls back to header", apiKey: "unknown", header: "team-b", want: "team-b"},
+		{name: "no tenant", want: Default},
+		{name: "invalid name", header: "team/../b", wantErr: ErrInvalidName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(keys, tt.apiKey, tt.header)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, Default, FromContext(ctx))
+	assert.Equal(t, "team-a", FromContext(WithTenant(ctx, "team-a")))
+}
@@ -0,0 +1,256 @@
+// Настройки TLS для http- и gRPC-транспорта: сертификат сервера и клиента
+// с перечитыванием при изменении файлов, проверка клиентских сертификатов (mTLS).
+// CA сертификаты тоже перечитываются при изменении файла: смена CA не требует перезапуска.
+
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader, отдает пару сертификат/ключ и перечитывает ее с диска,
+// когда меняется время модификации одного из файлов. Это позволяет
+// обновлять сертификаты без перезапуска сервера и агента.
+type CertReloader struct {
+	certPath string
+	keyPath  string
+
+	mx          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// NewCertReloader, создает загрузчик сертификата и сразу читает пару с диска.
+func NewCertReloader(certPath string, keyPath string) (*CertReloader, error) {
+	r := &CertReloader{certPath: certPath, keyPath: keyPath}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate, для tls.Config.GetCertificate на стороне сервера.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate()
+}
+
+// GetClientCertificate, для tls.Config.GetClientCertificate на стороне клиента.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate()
+}
+
+func (r *CertReloader) certificate() (*tls.Certificate, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	certInfo, certErr := os.Stat(r.certPath)
+	keyInfo, keyErr := os.Stat(r.keyPath)
+	if err := errors.Join(certErr, keyErr); err != nil {
+		if r.cert != nil {
+			// файлы могут быть временно недоступны во время замены, отдаем прежний сертификат
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("не удалось прочитать сертификат, %w", err)
+	}
+
+	if r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		if r.cert != nil {
+			// сертификат и ключ могут быть записаны не одновременно
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("не удалось загрузить сертификат, %w", err)
+	}
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+
+	return r.cert, nil
+}
+
+// verifyServer, проверяет цепочку сертификатов сервера по текущему пулу CA и имя сервера:
+// из соединения, а для ip-адреса, которого в соединении нет, - host.
+func verifyServer(cas *CAReloader, cs tls.ConnectionState, host string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("сервер не предъявил сертификат")
+	}
+	name := cs.ServerName
+	if name == "" {
+		name = host
+	}
+	if name == "" {
+		return errors.New("не задано имя сервера для проверки сертификата")
+	}
+
+	pool, err := cas.Pool()
+	if err != nil {
+		return err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		DNSName:       name,
+	})
+	if err != nil {
+		return fmt.Errorf("сертификат сервера не прошел проверку, %w", err)
+	}
+
+	return nil
+}
+
+// LoadCAPool, читает пул корневых сертификатов из pem-файла.
+func LoadCAPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать CA сертификаты, %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("в файле %s нет сертификатов", path)
+	}
+
+	return pool, nil
+}
+
+// CAReloader, отдает пул CA сертификатов и перечитывает его с диска,
+// когда меняется время модификации файла.
+type CAReloader struct {
+	path string
+
+	mx      sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// NewCAReloader, создает загрузчик CA сертификатов и сразу читает пул с диска.
+func NewCAReloader(path string) (*CAReloader, error) {
+	r := &CAReloader{path: path}
+	if _, err := r.Pool(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Pool, возвращает текущий пул CA сертификатов. Если файл недоступен или не читается,
+// например во время замены, отдается прежний пул.
+func (r *CAReloader) Pool() (*x509.CertPool, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("не удалось прочитать CA сертификаты, %w", err)
+	}
+
+	if r.pool != nil && info.ModTime().Equal(r.modTime) {
+		return r.pool, nil
+	}
+
+	pool, err := LoadCAPool(r.path)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, err
+	}
+
+	r.pool = pool
+	r.modTime = info.ModTime()
+
+	return r.pool, nil
+}
+
+// ServerConfig, формирует настройки TLS сервера. Если задан clientCAPath,
+// сервер требует от клиентов сертификат, подписанный одним из этих CA.
+func ServerConfig(certPath string, keyPath string, clientCAPath string) (*tls.Config, error) {
+	reloader, err := NewCertReloader(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if clientCAPath != "" {
+		cas, err := NewCAReloader(clientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+		// пул CA подставляется в настройки каждого соединения
+		base := cfg.Clone()
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool, err := cas.Pool()
+			if err != nil {
+				return nil, err
+			}
+			conn := base.Clone()
+			conn.ClientCAs = pool
+			return conn, nil
+		}
+	}
+
+	return cfg, nil
+}
+
+// ClientConfig, формирует настройки TLS клиента. caPath - CA для проверки сервера
+// (пусто - системные), certPath и keyPath - сертификат клиента для mTLS (необязательно),
+// serverAddr - адрес сервера host:port, с именем из которого сверяется сертификат сервера.
+func ClientConfig(caPath string, certPath string, keyPath string, serverAddr string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caPath != "" {
+		cas, err := NewCAReloader(caPath)
+		if err != nil {
+			return nil, err
+		}
+		// RootCAs задается один раз, поэтому сертификат сервера проверяется
+		// в VerifyConnection по текущему пулу CA
+		cfg.InsecureSkipVerify = true
+		host := serverAddr
+		if h, _, err := net.SplitHostPort(serverAddr); err == nil {
+			host = h
+		}
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyServer(cas, cs, host)
+		}
+	}
+
+	if certPath != "" || keyPath != "" {
+		reloader, err := NewCertReloader(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,210 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue, выпускает сертификат и записывает его с ключом в файлы dir/name.crt и dir/name.key.
+func (ca *testCA) issue(t *testing.T, dir string, name string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return certPath, keyPath
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caPath := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, ca.pem, 0o600))
+
+	serverCert, serverKey := ca.issue(t, dir, "server", 2)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3)
+
+	serverCfg, err := ServerConfig(serverCert, serverKey, caPath)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	// httptest.StartTLS подставляет свой сертификат, поэтому TLS включается на уровне listener
+	ts.Listener = tls.NewListener(ts.Listener, serverCfg)
+	ts.Start()
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+	url := "https://" + addr
+
+	tests := []struct {
+		name     string
+		certPath string
+		keyPath  string
+		wantErr  bool
+	}{
+		{name: "client certificate", certPath: clientCert, keyPath: clientKey},
+		{name: "no client certificate", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientCfg, err := ClientConfig(caPath, tt.certPath, tt.keyPath, addr)
+			require.NoError(t, err)
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+			resp, err := client.Get(url)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certPath, keyPath := ca.issue(t, dir, "server", 10)
+
+	reloader, err := NewCertReloader(certPath, keyPath)
+	require.NoError(t, err)
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), leaf.SerialNumber.Int64())
+
+	// выпуск нового сертификата поверх старого
+	ca.issue(t, dir, "server", 11)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, later, later))
+	require.NoError(t, os.Chtimes(keyPath, later, later))
+
+	cert, err = reloader.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), leaf.SerialNumber.Int64())
+
+	// пока файлы недоступны, отдается прежний сертификат
+	require.NoError(t, os.Remove(keyPath))
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestCARotation(t *testing.T) {
+	dir := t.TempDir()
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	serverCAPath := filepath.Join(dir, "server-ca.pem")
+	clientCAPath := filepath.Join(dir, "client-ca.pem")
+	require.NoError(t, os.WriteFile(serverCAPath, oldCA.pem, 0o600))
+	require.NoError(t, os.WriteFile(clientCAPath, oldCA.pem, 0o600))
+
+	// сертификаты уже выпущены новым CA, который еще не добавлен в пулы
+	serverCert, serverKey := newCA.issue(t, dir, "server", 2)
+	clientCert, clientKey := newCA.issue(t, dir, "client", 3)
+
+	serverCfg, err := ServerConfig(serverCert, serverKey, clientCAPath)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Listener = tls.NewListener(ts.Listener, serverCfg)
+	ts.Start()
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+	url := "https://" + addr
+
+	clientCfg, err := ClientConfig(serverCAPath, clientCert, clientKey, addr)
+	require.NoError(t, err)
+	get := func() error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg.Clone(), DisableKeepAlives: true}}
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	assert.Error(t, get())
+
+	// новый CA добавляется в файлы: пулы перечитываются без перезапуска
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(serverCAPath, append(oldCA.pem, newCA.pem...), 0o600))
+	require.NoError(t, os.Chtimes(serverCAPath, later, later))
+	assert.Error(t, get(), "клиентский сертификат еще не доверен серверу")
+
+	require.NoError(t, os.WriteFile(clientCAPath, newCA.pem, 0o600))
+	require.NoError(t, os.Chtimes(clientCAPath, later, later))
+	assert.NoError(t, get())
+
+	// имя сервера по-прежнему проверяется
+	wrongName := clientCfg.Clone()
+	wrongName.ServerName = "other.example"
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: wrongName}}
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
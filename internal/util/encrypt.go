@@ -1,47 +1,238 @@
 package util
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sync"
 )
 
-func EncryptData(msg []byte, pubKeyPath string) ([]byte, error) {
-	publicKeyBytes, err := os.ReadFile(pubKeyPath)
+// Формат зашифрованного сообщения (конверт), версия 1:
+//
+//	magic "MCE" | версия (1 байт) | длина ключа (2 байта, big endian) |
+//	AES-ключ, зашифрованный RSA-OAEP(SHA-256) | nonce (12 байт) | AES-256-GCM(сообщение)
+//
+// Заголовок конверта (magic, версия, длина) участвует в аутентификации GCM как дополнительные данные.
+// Размер сообщения не ограничен размером RSA-ключа.
+const (
+	envelopeMagic     = "MCE"
+	envelopeVersion   = byte(1)
+	envelopeHeaderLen = len(envelopeMagic) + 1 + 2
+	aesKeyLen         = 32
+)
+
+var (
+	// ErrEnvelopeVersion, неподдерживаемая версия конверта.
+	ErrEnvelopeVersion = errors.New("неподдерживаемая версия зашифрованного сообщения")
+	// ErrEnvelopeCorrupted, конверт поврежден.
+	ErrEnvelopeCorrupted = errors.New("зашифрованное сообщение повреждено")
+)
+
+var (
+	publicKeys  sync.Map // путь до файла -> *rsa.PublicKey
+	privateKeys sync.Map // путь до файла -> *rsa.PrivateKey
+)
+
+// LoadPublicKey, читает публичный ключ из pem-файла. Ключ читается один раз и кэшируется.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	if key, ok := publicKeys.Load(path); ok {
+		return key.(*rsa.PublicKey), nil
+	}
+
+	block, err := readPEM(path)
 	if err != nil {
-		return nil, fmt.Errorf("error on read public key file %w", err)
+		return nil, err
 	}
-	publicKeyBlock, _ := pem.Decode(publicKeyBytes)
-	publicKey, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
+
+	var key *rsa.PublicKey
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err == nil {
+		rsaKey, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("публичный ключ не является RSA-ключом")
+		}
+		key = rsaKey
+	} else {
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error on parsed public key %w", err)
+		}
+	}
+
+	publicKeys.Store(path, key)
+	return key, nil
+}
+
+// LoadPrivateKey, читает приватный ключ из pem-файла. Ключ читается один раз и кэшируется.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	if key, ok := privateKeys.Load(path); ok {
+		return key.(*rsa.PrivateKey), nil
+	}
+
+	block, err := readPEM(path)
 	if err != nil {
-		return nil, fmt.Errorf("error on parsed public key %w", err)
+		return nil, err
 	}
-	cipherText, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey.(*rsa.PublicKey), msg)
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("error on encrypt message %w", err)
+		parsed, errPKCS8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if errPKCS8 != nil {
+			return nil, fmt.Errorf("error on parse private key %w", err)
+		}
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("приватный ключ не является RSA-ключом")
+		}
+		key = rsaKey
 	}
 
-	return cipherText, nil
+	privateKeys.Store(path, key)
+	return key, nil
 }
 
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error on read key file %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("в файле %s нет pem-блока", path)
+	}
+
+	return block, nil
+}
+
+// EncryptData, шифрует сообщение публичным ключом из файла pubKeyPath в конверт текущей версии.
+func EncryptData(msg []byte, pubKeyPath string) ([]byte, error) {
+	key, err := LoadPublicKey(pubKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return Encrypt(msg, key)
+}
+
+// DecryptData, расшифровывает сообщение приватным ключом из файла privateKeyPath.
 func DecryptData(cipherMsg []byte, privateKeyPath string) ([]byte, error) {
-	privateKeyBytes, err := os.ReadFile(privateKeyPath)
+	key, err := LoadPrivateKey(privateKeyPath)
 	if err != nil {
-		return nil, fmt.Errorf("error on read private key file %w", err)
+		return nil, err
+	}
+
+	return Decrypt(cipherMsg, key)
+}
+
+// Encrypt, шифрует сообщение: случайный AES-ключ шифруется RSA-OAEP, сообщение - AES-GCM.
+func Encrypt(msg []byte, key *rsa.PublicKey) ([]byte, error) {
+	aesKey := make([]byte, aesKeyLen)
+	if _, err := io.ReadFull(rand.Reader, aesKey); err != nil {
+		return nil, fmt.Errorf("error on generate message key %w", err)
 	}
-	privateKeyBlock, _ := pem.Decode(privateKeyBytes)
-	privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, aesKey, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error on parse private key %w", err)
+		return nil, fmt.Errorf("error on encrypt message key %w", err)
 	}
-	msg, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, cipherMsg)
+
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error on generate nonce %w", err)
+	}
+
+	out := make([]byte, envelopeHeaderLen, envelopeHeaderLen+len(wrappedKey)+len(nonce)+len(msg)+gcm.Overhead())
+	copy(out, envelopeMagic)
+	out[len(envelopeMagic)] = envelopeVersion
+	binary.BigEndian.PutUint16(out[len(envelopeMagic)+1:], uint16(len(wrappedKey)))
+	header := out[:envelopeHeaderLen]
+
+	out = append(out, wrappedKey...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, msg, header)
+
+	return out, nil
+}
+
+// Decrypt, расшифровывает конверт. Сообщения без заголовка конверта считаются
+// зашифрованными целиком RSA PKCS#1 v1.5 (формат агентов предыдущих версий).
+func Decrypt(cipherMsg []byte, key *rsa.PrivateKey) ([]byte, error) {
+	if !IsEnvelope(cipherMsg) {
+		msg, err := rsa.DecryptPKCS1v15(rand.Reader, key, cipherMsg)
+		if err != nil {
+			return nil, fmt.Errorf("error on decrypt message %w", err)
+		}
+		return msg, nil
+	}
+
+	if cipherMsg[len(envelopeMagic)] != envelopeVersion {
+		return nil, fmt.Errorf("%w: %d", ErrEnvelopeVersion, cipherMsg[len(envelopeMagic)])
+	}
+
+	if len(cipherMsg) < envelopeHeaderLen {
+		return nil, ErrEnvelopeCorrupted
+	}
+	header := cipherMsg[:envelopeHeaderLen]
+	keyLen := int(binary.BigEndian.Uint16(cipherMsg[len(envelopeMagic)+1:]))
+	rest := cipherMsg[envelopeHeaderLen:]
+	if len(rest) < keyLen {
+		return nil, ErrEnvelopeCorrupted
+	}
+
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, rest[:keyLen], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error on decrypt message key %w", err)
+	}
+	rest = rest[keyLen:]
+
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrEnvelopeCorrupted
+	}
+
+	msg, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
 	if err != nil {
 		return nil, fmt.Errorf("error on decrypt message %w", err)
 	}
 
 	return msg, nil
+}
+
+// IsEnvelope, начинается ли сообщение с заголовка конверта.
+func IsEnvelope(data []byte) bool {
+	return len(data) > len(envelopeMagic) && bytes.HasPrefix(data, []byte(envelopeMagic))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error on create cipher %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error on create cipher %w", err)
+	}
 
+	return gcm, nil
 }
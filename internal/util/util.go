@@ -1,9 +1,11 @@
 package util
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"net"
+	"sort"
 )
 
 // Contains, проверяет содержится ли указанная строка в слайсе строк.
@@ -17,11 +19,20 @@ func Contains(s []string, v string) bool {
 	return false
 }
 
-// Hash, вычисляет хэш-строку для переданного значени
-//
-// BUG(ShvetsovYura): ключ не используется
-func Hash(value []byte, _ string) string {
-	h := sha256.New()
+// SortedKeys, возвращает ключи словаря по возрастанию.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Hash, вычисляет HMAC-SHA256 переданного значения на ключе key в виде hex-строки.
+func Hash(value []byte, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
 	h.Write(value)
 	res := h.Sum(nil)
 
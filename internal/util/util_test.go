@@ -1,11 +1,15 @@
 package util
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"os"
 	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestContains(t *testing.T) {
@@ -32,6 +36,11 @@ func TestContains(t *testing.T) {
 	}
 }
 
+func TestSortedKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, SortedKeys(map[string]int{"c": 3, "a": 1, "b": 2}))
+	assert.Empty(t, SortedKeys(map[string]int(nil)))
+}
+
 func TestHash(t *testing.T) {
 	cases := []struct {
 		name       string
@@ -42,12 +51,12 @@ func TestHash(t *testing.T) {
 		name:       "case1",
 		inputBytes: []byte("myinputstring"),
 		inputKey:   "private_key",
-		outHash:    "3ed15a8efa23d4c73682b9b0e5b953e362b317acbc0d3e242746be625ec03cf7",
+		outHash:    "1152f585525abe1a373123130052fadf199483250ebc4809d9cbc77ba8f3d337",
 	}, {
 		name:       "case2",
 		inputBytes: []byte(""),
 		inputKey:   "",
-		outHash:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		outHash:    "b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad",
 	}}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -72,3 +81,68 @@ func TestEncryptData(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, testMessage, decryptedMessage)
 }
+
+func TestEncryptLargeMessage(t *testing.T) {
+	cwd, err := os.Getwd()
+	assert.NoError(t, err)
+	basePath := path.Join(cwd, "..", "..", "testdata")
+
+	// сообщение заметно больше размера RSA-ключа
+	message := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5},`), 1000)
+
+	encrypted, err := EncryptData(message, path.Join(basePath, "public.pem"))
+	require.NoError(t, err)
+	assert.True(t, IsEnvelope(encrypted))
+
+	decrypted, err := DecryptData(encrypted, path.Join(basePath, "private.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, message, decrypted)
+}
+
+func TestDecrypt(t *testing.T) {
+	cwd, err := os.Getwd()
+	assert.NoError(t, err)
+	basePath := path.Join(cwd, "..", "..", "testdata")
+
+	publicKey, err := LoadPublicKey(path.Join(basePath, "public.pem"))
+	require.NoError(t, err)
+	privateKey, err := LoadPrivateKey(path.Join(basePath, "private.pem"))
+	require.NoError(t, err)
+
+	message := []byte("this test message")
+
+	legacy, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, message)
+	require.NoError(t, err)
+
+	envelope, err := Encrypt(message, publicKey)
+	require.NoError(t, err)
+
+	tampered := bytes.Clone(envelope)
+	tampered[len(tampered)-1] ^= 0xff
+
+	unknownVersion := bytes.Clone(envelope)
+	unknownVersion[3] = 99
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr error
+	}{
+		{name: "envelope", data: envelope, want: message},
+		{name: "legacy pkcs1v15", data: legacy, want: message},
+		{name: "unknown version", data: unknownVersion, wantErr: ErrEnvelopeVersion},
+		{name: "truncated", data: envelope[:10], wantErr: ErrEnvelopeCorrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.data, privateKey)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err = Decrypt(tampered, privateKey)
+	assert.Error(t, err)
+}
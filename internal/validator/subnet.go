@@ -0,0 +1,178 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Rule, правило фильтра подсетей: разрешить или запретить адреса из подсети.
+type Rule struct {
+	Allow  bool
+	Prefix netip.Prefix
+}
+
+// ErrNoClientIP, не удалось определить адрес клиента.
+var ErrNoClientIP = errors.New("не удалось определить ip-адрес клиента")
+
+// SubnetFilter, проверяет адрес клиента по списку правил и определяет этот адрес
+// с учетом доверенных прокси. Правила проверяются по порядку, срабатывает первое
+// подходящее; адрес, не попавший ни под одно правило, запрещен.
+type SubnetFilter struct {
+	rules   []Rule
+	proxies []netip.Prefix
+}
+
+// ParseRules, разбирает список правил через запятую. Подсеть с префиксом "!" запрещает
+// адреса, без префикса - разрешает, например "!10.0.0.13/32,10.0.0.0/8,fd00::/8".
+// Одиночный адрес считается подсетью из одного адреса.
+func ParseRules(value string) ([]Rule, error) {
+	var rules []Rule
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		rule := Rule{Allow: true}
+		if strings.HasPrefix(item, "!") {
+			rule.Allow = false
+			item = strings.TrimSpace(item[1:])
+		}
+
+		prefix, err := parsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		rule.Prefix = prefix
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// NewSubnetFilter, создает фильтр по списку правил и списку доверенных прокси (подсети через запятую).
+func NewSubnetFilter(rules string, trustedProxies string) (*SubnetFilter, error) {
+	parsed, err := ParseRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &SubnetFilter{rules: parsed}
+	for _, item := range strings.Split(trustedProxies, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		prefix, err := parsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		f.proxies = append(f.proxies, prefix)
+	}
+
+	return f, nil
+}
+
+// Enabled, заданы ли правила фильтра.
+func (f *SubnetFilter) Enabled() bool {
+	return f != nil && len(f.rules) > 0
+}
+
+// Allowed, разрешен ли адрес правилами фильтра.
+func (f *SubnetFilter) Allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, r := range f.rules {
+		if r.Prefix.Contains(ip) {
+			return r.Allow
+		}
+	}
+
+	return false
+}
+
+// ClientIP, определяет адрес клиента. Заголовкам X-Forwarded-For и X-Real-IP верим,
+// только если соединение пришло от доверенного прокси, иначе используется адрес соединения.
+// В X-Forwarded-For адрес ищется справа налево до первого недоверенного узла.
+func (f *SubnetFilter) ClientIP(peer netip.Addr, xRealIP string, xForwardedFor string) (netip.Addr, error) {
+	peer = peer.Unmap()
+	if !peer.IsValid() {
+		return netip.Addr{}, ErrNoClientIP
+	}
+
+	if !f.isTrustedProxy(peer) {
+		return peer, nil
+	}
+
+	if xForwardedFor != "" {
+		hops := strings.Split(xForwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				return netip.Addr{}, fmt.Errorf("неверный адрес в X-Forwarded-For, %w", err)
+			}
+
+			ip = ip.Unmap()
+			if i == 0 || !f.isTrustedProxy(ip) {
+				return ip, nil
+			}
+		}
+	}
+
+	if xRealIP != "" {
+		ip, err := netip.ParseAddr(strings.TrimSpace(xRealIP))
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("неверный адрес в X-Real-IP, %w", err)
+		}
+
+		return ip.Unmap(), nil
+	}
+
+	return peer, nil
+}
+
+func (f *SubnetFilter) isTrustedProxy(ip netip.Addr) bool {
+	if f == nil {
+		return false
+	}
+
+	for _, p := range f.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseAddrPort, извлекает ip-адрес из строки вида "host:port" (http.Request.RemoteAddr, peer.Addr).
+func ParseAddrPort(addr string) (netip.Addr, error) {
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("неверный адрес соединения %s, %w", addr, err)
+	}
+
+	return addrPort.Addr().Unmap(), nil
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	if !strings.Contains(value, "/") {
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("неверный адрес %s, %w", value, err)
+		}
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("неверная подсеть %s, %w", value, err)
+	}
+
+	return prefix.Masked(), nil
+}
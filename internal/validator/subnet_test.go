@@ -0,0 +1,74 @@
+package validator
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubnetFilter_Allowed(t *testing.T) {
+	f, err := NewSubnetFilter("!10.0.0.13, 10.0.0.0/8, fd00::/8", "")
+	require.NoError(t, err)
+	require.True(t, f.Enabled())
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "10.1.2.3", want: true},
+		{ip: "10.0.0.13", want: false},
+		{ip: "192.168.0.1", want: false},
+		{ip: "fd00::1", want: true},
+		{ip: "::ffff:10.1.2.3", want: true},
+		{ip: "2001:db8::1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, f.Allowed(netip.MustParseAddr(tt.ip)))
+		})
+	}
+}
+
+func TestSubnetFilter_ClientIP(t *testing.T) {
+	f, err := NewSubnetFilter("10.0.0.0/8", "192.168.1.0/24, 192.168.2.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		peer          string
+		xRealIP       string
+		xForwardedFor string
+		want          string
+		wantErr       bool
+	}{
+		{name: "direct client ignores headers", peer: "203.0.113.5", xRealIP: "10.0.0.1", xForwardedFor: "10.0.0.1", want: "203.0.113.5"},
+		{name: "proxy with x-real-ip", peer: "192.168.1.10", xRealIP: "10.0.0.1", want: "10.0.0.1"},
+		{name: "proxy chain", peer: "192.168.1.10", xForwardedFor: "1.2.3.4, 10.0.0.7, 192.168.2.1", want: "10.0.0.7"},
+		{name: "forwarded for wins over real ip", peer: "192.168.1.10", xRealIP: "10.0.0.1", xForwardedFor: "10.0.0.2", want: "10.0.0.2"},
+		{name: "proxy without headers", peer: "192.168.1.10", want: "192.168.1.10"},
+		{name: "garbage from proxy", peer: "192.168.1.10", xForwardedFor: "not-an-ip", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.ClientIP(netip.MustParseAddr(tt.peer), tt.xRealIP, tt.xForwardedFor)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func TestParseRules_Invalid(t *testing.T) {
+	_, err := ParseRules("10.0.0.0/33")
+	assert.Error(t, err)
+
+	_, err = NewSubnetFilter("10.0.0.0/8", "proxy")
+	assert.Error(t, err)
+}
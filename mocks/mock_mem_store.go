@@ -98,6 +98,78 @@ func (mr *MockMemoryStoreMockRecorder) GetGauges(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGauges", reflect.TypeOf((*MockMemoryStore)(nil).GetGauges), arg0)
 }
 
+// Items mocks base method.
+func (m *MockMemoryStore) Items(arg0 context.Context) ([]models.MetricInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Items", arg0)
+	ret0, _ := ret[0].([]models.MetricInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Items indicates an expected call of Items.
+func (mr *MockMemoryStoreMockRecorder) Items(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Items", reflect.TypeOf((*MockMemoryStore)(nil).Items), arg0)
+}
+
+// SaveBatch mocks base method.
+func (m *MockMemoryStore) SaveBatch(arg0 context.Context, arg1 map[string]models.Gauge, arg2 map[string]models.Counter) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveBatch", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveBatch indicates an expected call of SaveBatch.
+func (mr *MockMemoryStoreMockRecorder) SaveBatch(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatch", reflect.TypeOf((*MockMemoryStore)(nil).SaveBatch), arg0, arg1, arg2)
+}
+
+// SaveCountersBatch mocks base method.
+func (m *MockMemoryStore) SaveCountersBatch(arg0 context.Context, arg1 map[string]models.Counter) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveCountersBatch", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveCountersBatch indicates an expected call of SaveCountersBatch.
+func (mr *MockMemoryStoreMockRecorder) SaveCountersBatch(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCountersBatch", reflect.TypeOf((*MockMemoryStore)(nil).SaveCountersBatch), arg0, arg1)
+}
+
+// SaveGaugesBatch mocks base method.
+func (m *MockMemoryStore) SaveGaugesBatch(arg0 context.Context, arg1 map[string]models.Gauge) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveGaugesBatch", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveGaugesBatch indicates an expected call of SaveGaugesBatch.
+func (mr *MockMemoryStoreMockRecorder) SaveGaugesBatch(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveGaugesBatch", reflect.TypeOf((*MockMemoryStore)(nil).SaveGaugesBatch), arg0, arg1)
+}
+
+// SeriesCount mocks base method.
+func (m *MockMemoryStore) SeriesCount(arg0 context.Context) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SeriesCount", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SeriesCount indicates an expected call of SeriesCount.
+func (mr *MockMemoryStoreMockRecorder) SeriesCount(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SeriesCount", reflect.TypeOf((*MockMemoryStore)(nil).SeriesCount), arg0)
+}
+
 // SetCounter mocks base method.
 func (m *MockMemoryStore) SetCounter(arg0 context.Context, arg1 string, arg2 int64) error {
 	m.ctrl.T.Helper()
@@ -112,6 +184,20 @@ func (mr *MockMemoryStoreMockRecorder) SetCounter(arg0, arg1, arg2 any) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCounter", reflect.TypeOf((*MockMemoryStore)(nil).SetCounter), arg0, arg1, arg2)
 }
 
+// SetCounterIfAbsent mocks base method.
+func (m *MockMemoryStore) SetCounterIfAbsent(arg0 context.Context, arg1 string, arg2 models.Counter) models.Counter {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetCounterIfAbsent", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.Counter)
+	return ret0
+}
+
+// SetCounterIfAbsent indicates an expected call of SetCounterIfAbsent.
+func (mr *MockMemoryStoreMockRecorder) SetCounterIfAbsent(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCounterIfAbsent", reflect.TypeOf((*MockMemoryStore)(nil).SetCounterIfAbsent), arg0, arg1, arg2)
+}
+
 // SetCounters mocks base method.
 func (m *MockMemoryStore) SetCounters(arg0 context.Context, arg1 map[string]int64) {
 	m.ctrl.T.Helper()
@@ -138,6 +224,20 @@ func (mr *MockMemoryStoreMockRecorder) SetGauge(arg0, arg1, arg2 any) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetGauge", reflect.TypeOf((*MockMemoryStore)(nil).SetGauge), arg0, arg1, arg2)
 }
 
+// SetGaugeIfAbsent mocks base method.
+func (m *MockMemoryStore) SetGaugeIfAbsent(arg0 context.Context, arg1 string, arg2 models.Gauge) models.Gauge {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetGaugeIfAbsent", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.Gauge)
+	return ret0
+}
+
+// SetGaugeIfAbsent indicates an expected call of SetGaugeIfAbsent.
+func (mr *MockMemoryStoreMockRecorder) SetGaugeIfAbsent(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetGaugeIfAbsent", reflect.TypeOf((*MockMemoryStore)(nil).SetGaugeIfAbsent), arg0, arg1, arg2)
+}
+
 // SetGauges mocks base method.
 func (m *MockMemoryStore) SetGauges(arg0 context.Context, arg1 map[string]float64) {
 	m.ctrl.T.Helper()
@@ -150,6 +250,20 @@ func (mr *MockMemoryStoreMockRecorder) SetGauges(arg0, arg1 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetGauges", reflect.TypeOf((*MockMemoryStore)(nil).SetGauges), arg0, arg1)
 }
 
+// Tenants mocks base method.
+func (m *MockMemoryStore) Tenants(arg0 context.Context) []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tenants", arg0)
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// Tenants indicates an expected call of Tenants.
+func (mr *MockMemoryStoreMockRecorder) Tenants(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tenants", reflect.TypeOf((*MockMemoryStore)(nil).Tenants), arg0)
+}
+
 // ToList mocks base method.
 func (m *MockMemoryStore) ToList(arg0 context.Context) ([]string, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,228 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.35.1
+// 	protoc        v3.21.12
+// source: proto/snapshot.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TenantSnapshot struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Tenant        string    `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	GaugeNames    []string  `protobuf:"bytes,2,rep,name=gauge_names,json=gaugeNames,proto3" json:"gauge_names,omitempty"`
+	GaugeValues   []float64 `protobuf:"fixed64,3,rep,packed,name=gauge_values,json=gaugeValues,proto3" json:"gauge_values,omitempty"`
+	CounterNames  []string  `protobuf:"bytes,4,rep,name=counter_names,json=counterNames,proto3" json:"counter_names,omitempty"`
+	CounterValues []int64   `protobuf:"varint,5,rep,packed,name=counter_values,json=counterValues,proto3" json:"counter_values,omitempty"`
+}
+
+func (x *TenantSnapshot) Reset() {
+	*x = TenantSnapshot{}
+	mi := &file_proto_snapshot_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TenantSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TenantSnapshot) ProtoMessage() {}
+
+func (x *TenantSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_snapshot_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TenantSnapshot.ProtoReflect.Descriptor instead.
+func (*TenantSnapshot) Descriptor() ([]byte, []int) {
+	return file_proto_snapshot_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TenantSnapshot) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *TenantSnapshot) GetGaugeNames() []string {
+	if x != nil {
+		return x.GaugeNames
+	}
+	return nil
+}
+
+func (x *TenantSnapshot) GetGaugeValues() []float64 {
+	if x != nil {
+		return x.GaugeValues
+	}
+	return nil
+}
+
+func (x *TenantSnapshot) GetCounterNames() []string {
+	if x != nil {
+		return x.CounterNames
+	}
+	return nil
+}
+
+func (x *TenantSnapshot) GetCounterValues() []int64 {
+	if x != nil {
+		return x.CounterValues
+	}
+	return nil
+}
+
+type Snapshot struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Tenants []*TenantSnapshot `protobuf:"bytes,1,rep,name=tenants,proto3" json:"tenants,omitempty"`
+	WalSeq  uint64            `protobuf:"varint,2,opt,name=wal_seq,json=walSeq,proto3" json:"wal_seq,omitempty"`
+}
+
+func (x *Snapshot) Reset() {
+	*x = Snapshot{}
+	mi := &file_proto_snapshot_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Snapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Snapshot) ProtoMessage() {}
+
+func (x *Snapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_snapshot_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Snapshot.ProtoReflect.Descriptor instead.
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return file_proto_snapshot_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Snapshot) GetTenants() []*TenantSnapshot {
+	if x != nil {
+		return x.Tenants
+	}
+	return nil
+}
+
+func (x *Snapshot) GetWalSeq() uint64 {
+	if x != nil {
+		return x.WalSeq
+	}
+	return 0
+}
+
+var File_proto_snapshot_proto protoreflect.FileDescriptor
+
+var file_proto_snapshot_proto_rawDesc = []byte{
+	0x0a, 0x14, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x02, 0x70, 0x72, 0x22, 0xb8, 0x01, 0x0a, 0x0e, 0x54,
+	0x65, 0x6e, 0x61, 0x6e, 0x74, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x16, 0x0a,
+	0x06, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74,
+	0x65, 0x6e, 0x61, 0x6e, 0x74, 0x12, 0x1f, 0x0a, 0x0b, 0x67, 0x61, 0x75, 0x67, 0x65, 0x5f, 0x6e,
+	0x61, 0x6d, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x67, 0x61, 0x75, 0x67,
+	0x65, 0x4e, 0x61, 0x6d, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x67, 0x61, 0x75, 0x67, 0x65, 0x5f,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x01, 0x52, 0x0b, 0x67, 0x61,
+	0x75, 0x67, 0x65, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x63, 0x6f, 0x75,
+	0x6e, 0x74, 0x65, 0x72, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x0c, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x73, 0x12, 0x25,
+	0x0a, 0x0e, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73,
+	0x18, 0x05, 0x20, 0x03, 0x28, 0x03, 0x52, 0x0d, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x72, 0x56,
+	0x61, 0x6c, 0x75, 0x65, 0x73, 0x22, 0x51, 0x0a, 0x08, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f,
+	0x74, 0x12, 0x2c, 0x0a, 0x07, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x72, 0x2e, 0x54, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x53, 0x6e,
+	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x52, 0x07, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x73, 0x12,
+	0x17, 0x0a, 0x07, 0x77, 0x61, 0x6c, 0x5f, 0x73, 0x65, 0x71, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x06, 0x77, 0x61, 0x6c, 0x53, 0x65, 0x71, 0x42, 0x18, 0x5a, 0x16, 0x6d, 0x65, 0x74, 0x72,
+	0x69, 0x63, 0x2d, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x2f, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_proto_snapshot_proto_rawDescOnce sync.Once
+	file_proto_snapshot_proto_rawDescData = file_proto_snapshot_proto_rawDesc
+)
+
+func file_proto_snapshot_proto_rawDescGZIP() []byte {
+	file_proto_snapshot_proto_rawDescOnce.Do(func() {
+		file_proto_snapshot_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_snapshot_proto_rawDescData)
+	})
+	return file_proto_snapshot_proto_rawDescData
+}
+
+var file_proto_snapshot_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_snapshot_proto_goTypes = []any{
+	(*TenantSnapshot)(nil), // 0: pr.TenantSnapshot
+	(*Snapshot)(nil),       // 1: pr.Snapshot
+}
+var file_proto_snapshot_proto_depIdxs = []int32{
+	0, // 0: pr.Snapshot.tenants:type_name -> pr.TenantSnapshot
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_proto_snapshot_proto_init() }
+func file_proto_snapshot_proto_init() {
+	if File_proto_snapshot_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_proto_snapshot_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_proto_snapshot_proto_goTypes,
+		DependencyIndexes: file_proto_snapshot_proto_depIdxs,
+		MessageInfos:      file_proto_snapshot_proto_msgTypes,
+	}.Build()
+	File_proto_snapshot_proto = out.File
+	file_proto_snapshot_proto_rawDesc = nil
+	file_proto_snapshot_proto_goTypes = nil
+	file_proto_snapshot_proto_depIdxs = nil
+}